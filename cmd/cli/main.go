@@ -3,16 +3,30 @@ package main
 
 import (
 	"fmt"
+	"math"
+	"net/netip"
 	"os"
+	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/spf13/cobra"
 
 	"network-app/pkg/core/conntrack"
+	"network-app/pkg/core/detect"
+	"network-app/pkg/core/dhcp"
+	"network-app/pkg/core/dns"
+	"network-app/pkg/core/http"
+	"network-app/pkg/core/icmp"
+	"network-app/pkg/core/mtu"
+	"network-app/pkg/core/neighbor"
 	"network-app/pkg/core/pcap"
+	"network-app/pkg/core/quic"
 	"network-app/pkg/core/report"
 	"network-app/pkg/core/tcp"
+	"network-app/pkg/core/tls"
+	"network-app/pkg/core/udp"
 )
 
 var (
@@ -33,7 +47,7 @@ and generates human‑readable reports (JSON/Markdown).`,
 		Version: fmt.Sprintf("%s (commit %s, built %s)", version, commit, date),
 	}
 
-	rootCmd.AddCommand(diagnoseCmd, interfacesCmd, versionCmd)
+	rootCmd.AddCommand(diagnoseCmd, analyzeCmd, interfacesCmd, versionCmd)
 
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Fprintln(os.Stderr, err)
@@ -46,14 +60,30 @@ and generates human‑readable reports (JSON/Markdown).`,
 // -----------------------------------------------------------------------------
 
 var diagnoseFlags = struct {
-	interfaceName string
+	interfaces    []string
 	duration      int
 	output        string
 	format        string
 	filter        string
+	backend       string
+	blockSize     int
+	numBlocks     int
+	fanoutGroup   int
+	fanoutMode    string
+	write         string
+	writeMaxSize  int64
+	writeInterval time.Duration
+	writeMaxFiles int
+	writeComment  string
+	dropThreshold float64
+	detect        bool
+	dumpStreams   string
 }{
 	duration: 30,
 	format:   "markdown",
+	backend:  "auto",
+	// above this drop rate (percent) the handshake ratios are unreliable
+	dropThreshold: 1.0,
 }
 
 var diagnoseCmd = &cobra.Command{
@@ -62,10 +92,18 @@ var diagnoseCmd = &cobra.Command{
 	Long: `Capture packets, analyse TCP handshakes, read conntrack data,
 and generate a diagnostic report (JSON or Markdown).
 
+Packets are read through libpcap by default. --backend afpacket uses a
+native AF_PACKET TPACKET_V3 ring instead (Linux only, no libpcap needed).
+
 Example:
-  network-app diagnose -i eth0 -d 60 -f json -o result.json`,
+  network-app diagnose -i eth0 -d 60 -f json -o result.json
+  network-app diagnose -i eth0 -i eth1 -d 60
+  network-app diagnose -i eth0 --backend afpacket --fanout-group 42
+  network-app diagnose -i eth0 -w evidence.pcapng --write-max-size 100 --write-max-files 5
+  network-app diagnose -i eth0 --detect
+  network-app diagnose -i eth0 --filter 'tcp port 80' --dump-streams streams/`,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		if diagnoseFlags.interfaceName == "" {
+		if len(diagnoseFlags.interfaces) == 0 {
 			return fmt.Errorf("required flag --interface/-i not set")
 		}
 		if diagnoseFlags.duration <= 0 {
@@ -74,104 +112,1427 @@ Example:
 		if diagnoseFlags.format != "json" && diagnoseFlags.format != "markdown" {
 			return fmt.Errorf("format must be 'json' or 'markdown', got %q", diagnoseFlags.format)
 		}
+		names, err := pcap.ExpandInterfaces(diagnoseFlags.interfaces)
+		if err != nil {
+			return err
+		}
+		opts, err := newAnalysisOptions(diagnoseFlags.detect, diagnoseFlags.dumpStreams)
+		if err != nil {
+			return err
+		}
+		opts.conntrack = true
+		opts.duration = time.Duration(diagnoseFlags.duration) * time.Second
 
 		// Capture packets
-		fmt.Printf("Capturing on %s for %d seconds...\n", diagnoseFlags.interfaceName, diagnoseFlags.duration)
+		fmt.Printf("Capturing on %s for %d seconds...\n", strings.Join(names, ", "), diagnoseFlags.duration)
 
-		// Create capture source
-		handle, err := pcap.NewCapture(diagnoseFlags.interfaceName, diagnoseFlags.filter, time.Duration(diagnoseFlags.duration)*time.Second)
-		if err != nil {
-			// Friendly hint for permission errors
-			if strings.Contains(err.Error(), "permission") || strings.Contains(err.Error(), "Operation not permitted") {
-				return fmt.Errorf("permission denied while opening interface %q – packet capture usually requires root privileges.\n"+
-					"Try running with sudo or give the binary the required capabilities:\n"+
-					"  sudo setcap cap_net_raw,cap_net_admin=eip ./bin/network-app",
-					diagnoseFlags.interfaceName)
+		// Create one capture source per interface
+		var handles []*pcap.CaptureHandle
+		for i, name := range names {
+			handle, err := openCapture(name, i)
+			if err != nil {
+				// Friendly hint for permission errors
+				if strings.Contains(err.Error(), "permission") || strings.Contains(err.Error(), "Operation not permitted") {
+					return fmt.Errorf("permission denied while opening interface %q – packet capture usually requires root privileges.\n"+
+						"Try running with sudo or give the binary the required capabilities:\n"+
+						"  sudo setcap cap_net_raw,cap_net_admin=eip ./bin/network-app",
+						name)
+				}
+				return fmt.Errorf("failed to open interface %s: %w", name, err)
 			}
-			return fmt.Errorf("failed to open interface: %w", err)
+			defer handle.Close()
+			handles = append(handles, handle)
+		}
+
+		// Keep the raw packets as evidence, one file (ring) per interface
+		var writers []*pcap.RotatingWriter
+		if diagnoseFlags.write != "" {
+			for _, handle := range handles {
+				writer, err := pcap.NewRotatingWriter(pcap.RotateConfig{
+					Path:      writePath(diagnoseFlags.write, handle.Name(), len(handles) > 1),
+					MaxSize:   diagnoseFlags.writeMaxSize << 20,
+					MaxAge:    diagnoseFlags.writeInterval,
+					MaxFiles:  diagnoseFlags.writeMaxFiles,
+					LinkType:  handle.LinkType(),
+					Interface: handle.Name(),
+					Filter:    diagnoseFlags.filter,
+					Comment:   diagnoseFlags.writeComment,
+				})
+				if err != nil {
+					return fmt.Errorf("failed to create capture file: %w", err)
+				}
+				defer writer.Close()
+				handle.Tee(writer)
+				writers = append(writers, writer)
+			}
+		}
+
+		result := runAnalysis(handles, opts)
+		result.DurationSecs = diagnoseFlags.duration
+		for _, writer := range writers {
+			if err := writer.Close(); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: could not close capture file: %v\n", err)
+			}
+			result.CaptureFiles = append(result.CaptureFiles, writer.Files()...)
+		}
+		warnDrops(&result, diagnoseFlags.dropThreshold)
+		summarize(&result)
+
+		return writeReport(&result, diagnoseFlags.format, diagnoseFlags.output)
+	},
+}
+
+func init() {
+	diagnoseCmd.Flags().StringSliceVarP(&diagnoseFlags.interfaces, "interface", "i", nil, "Network interface to capture on; repeat for several, 'any' for all that are up (required)")
+	diagnoseCmd.Flags().IntVarP(&diagnoseFlags.duration, "duration", "d", 30, "Capture duration in seconds")
+	diagnoseCmd.Flags().StringVarP(&diagnoseFlags.output, "output", "o", "report.md", "Output file path")
+	diagnoseCmd.Flags().StringVarP(&diagnoseFlags.format, "format", "f", "markdown", "Output format (json or markdown)")
+	diagnoseCmd.Flags().StringVar(&diagnoseFlags.filter, "filter", "", "BPF filter (e.g., 'tcp port 80')")
+	diagnoseCmd.Flags().StringVar(&diagnoseFlags.backend, "backend", "auto", "Capture backend (auto, libpcap or afpacket)")
+	diagnoseCmd.Flags().IntVar(&diagnoseFlags.blockSize, "afpacket-block-size", pcap.DefaultAFPacketConfig.BlockSize, "AF_PACKET ring block size in bytes")
+	diagnoseCmd.Flags().IntVar(&diagnoseFlags.numBlocks, "afpacket-blocks", pcap.DefaultAFPacketConfig.NumBlocks, "Number of AF_PACKET ring blocks")
+	diagnoseCmd.Flags().IntVar(&diagnoseFlags.fanoutGroup, "fanout-group", 0, "AF_PACKET fanout group id (0 disables fanout)")
+	diagnoseCmd.Flags().StringVar(&diagnoseFlags.fanoutMode, "fanout-mode", string(pcap.FanoutHash), "AF_PACKET fanout mode (hash, lb, cpu or rollover)")
+	diagnoseCmd.Flags().StringVarP(&diagnoseFlags.write, "write", "w", "", "Also write captured packets to this pcapng file")
+	diagnoseCmd.Flags().Int64Var(&diagnoseFlags.writeMaxSize, "write-max-size", 0, "Rotate the pcapng file after this many MiB (0 disables)")
+	diagnoseCmd.Flags().DurationVar(&diagnoseFlags.writeInterval, "write-interval", 0, "Rotate the pcapng file after this much capture time, e.g. 10m (0 disables)")
+	diagnoseCmd.Flags().IntVar(&diagnoseFlags.writeMaxFiles, "write-max-files", 0, "Keep at most this many rotated pcapng files (0 keeps all)")
+	diagnoseCmd.Flags().StringVar(&diagnoseFlags.writeComment, "write-comment", "", "Comment stored in the pcapng section header")
+	diagnoseCmd.Flags().Float64Var(&diagnoseFlags.dropThreshold, "drop-threshold", 1.0, "Warn when more than this percentage of packets is dropped")
+	diagnoseCmd.Flags().BoolVar(&diagnoseFlags.detect, "detect", false, "Flag SYN floods and port scans as findings")
+	diagnoseCmd.Flags().StringVar(&diagnoseFlags.dumpStreams, "dump-streams", "", "Write every reassembled TCP stream to this directory, one file per direction")
+}
+
+// pollTimeout bounds how long a live read waits for a packet, and so how
+// late a capture notices it was stopped
+const pollTimeout = 100 * time.Millisecond
+
+// openCapture opens iface with the backend selected on the command line.
+// index is the position of iface on the command line; AF_PACKET fanout
+// groups are per device, so each interface gets its own group id.
+func openCapture(iface string, index int) (*pcap.CaptureHandle, error) {
+	backend := diagnoseFlags.backend
+	if backend == "auto" {
+		backend = "libpcap"
+		if !pcap.LibpcapAvailable {
+			backend = "afpacket"
+		}
+	}
+
+	var (
+		src pcap.PacketSource
+		err error
+	)
+	switch backend {
+	case "libpcap":
+		src, err = pcap.NewLiveSource(iface, diagnoseFlags.filter, pollTimeout)
+	case "afpacket":
+		if diagnoseFlags.filter != "" {
+			return nil, fmt.Errorf("--filter requires the libpcap backend")
+		}
+		cfg := pcap.DefaultAFPacketConfig
+		cfg.BlockSize = diagnoseFlags.blockSize
+		cfg.NumBlocks = diagnoseFlags.numBlocks
+		cfg.Timeout = pollTimeout
+		if diagnoseFlags.fanoutGroup != 0 {
+			cfg.FanoutGroup = diagnoseFlags.fanoutGroup + index
+		}
+		if cfg.FanoutMode, err = pcap.ParseFanoutMode(diagnoseFlags.fanoutMode); err != nil {
+			return nil, err
+		}
+		src, err = pcap.NewAFPacketSource(iface, cfg)
+	default:
+		return nil, fmt.Errorf("backend must be 'auto', 'libpcap' or 'afpacket', got %q", diagnoseFlags.backend)
+	}
+	if err != nil {
+		return nil, err
+	}
+	handle := pcap.NewHandle(src, iface)
+	handle.SetNonBlocking(true)
+	return handle, nil
+}
+
+// writePath derives the pcapng path for iface. With several interfaces
+// capture.pcapng becomes capture-eth0.pcapng, capture-eth1.pcapng, ...
+func writePath(path, iface string, multi bool) string {
+	if !multi {
+		return path
+	}
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "-" + iface + ext
+}
+
+// -----------------------------------------------------------------------------
+// analyze command
+// -----------------------------------------------------------------------------
+
+var analyzeFlags = struct {
+	read        string
+	output      string
+	format      string
+	detect      bool
+	dumpStreams string
+}{
+	format: "markdown",
+}
+
+var analyzeCmd = &cobra.Command{
+	Use:   "analyze",
+	Short: "Analyse a saved capture file",
+	Long: `Read packets from a pcap or pcapng file (optionally gzip-compressed),
+analyse TCP handshakes and generate a diagnostic report (JSON or Markdown).
+No live interface or root privileges are required.
+
+Example:
+  network-app analyze -r capture.pcapng -f json -o result.json
+  network-app analyze -r capture.pcapng --dump-streams streams/`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if analyzeFlags.read == "" {
+			return fmt.Errorf("required flag --read/-r not set")
+		}
+		if analyzeFlags.format != "json" && analyzeFlags.format != "markdown" {
+			return fmt.Errorf("format must be 'json' or 'markdown', got %q", analyzeFlags.format)
+		}
+
+		opts, err := newAnalysisOptions(analyzeFlags.detect, analyzeFlags.dumpStreams)
+		if err != nil {
+			return err
+		}
+
+		handle, err := pcap.OpenFile(analyzeFlags.read)
+		if err != nil {
+			return fmt.Errorf("failed to open capture file: %w", err)
 		}
 		defer handle.Close()
 
-		// Packet channel for TCP analysis
-		packets := make(chan interface{})
+		fmt.Printf("Analysing %s...\n", analyzeFlags.read)
+
+		result := runAnalysis([]*pcap.CaptureHandle{handle}, opts)
+		result.DurationSecs = int(math.Ceil(handle.Span().Seconds()))
+		summarize(&result)
+
+		return writeReport(&result, analyzeFlags.format, analyzeFlags.output)
+	},
+}
+
+func init() {
+	analyzeCmd.Flags().StringVarP(&analyzeFlags.read, "read", "r", "", "Capture file to read (pcap, pcapng, optionally .gz) (required)")
+	analyzeCmd.Flags().StringVarP(&analyzeFlags.output, "output", "o", "report.md", "Output file path")
+	analyzeCmd.Flags().StringVarP(&analyzeFlags.format, "format", "f", "markdown", "Output format (json or markdown)")
+	analyzeCmd.Flags().BoolVar(&analyzeFlags.detect, "detect", false, "Flag SYN floods and port scans as findings")
+	analyzeCmd.Flags().StringVar(&analyzeFlags.dumpStreams, "dump-streams", "", "Write every reassembled TCP stream to this directory, one file per direction")
+}
+
+// -----------------------------------------------------------------------------
+// shared analysis pipeline
+// -----------------------------------------------------------------------------
 
-		// Run capture in background
-		go func() {
-			defer close(packets)
-			if err := handle.Capture(packets); err != nil {
-				fmt.Fprintf(os.Stderr, "Capture error: %v\n", err)
+// analysisOptions enables optional analyzers
+type analysisOptions struct {
+	detect    bool              // run the SYN flood and port scan detector
+	dumper    *tcp.StreamDumper // write reassembled streams to disk when set
+	conntrack bool              // read the local conntrack table once the capture ends
+	duration  time.Duration     // stop the captures after this much wall-clock time, 0 to read them to the end
+}
+
+// newAnalysisOptions builds the options from the command line, creating the
+// stream directory up front so a bad path fails before capturing
+func newAnalysisOptions(detect bool, dumpStreams string) (analysisOptions, error) {
+	opts := analysisOptions{detect: detect}
+	if dumpStreams != "" {
+		dumper, err := tcp.NewStreamDumper(dumpStreams)
+		if err != nil {
+			return opts, fmt.Errorf("failed to create stream directory: %w", err)
+		}
+		opts.dumper = dumper
+	}
+	return opts, nil
+}
+
+// runAnalysis drains the captures through the analyzers and builds the report
+func runAnalysis(handles []*pcap.CaptureHandle, opts analysisOptions) report.DiagnosticResult {
+	// Packet channel for TCP analysis, in batches of a few hundred. The
+	// buffer absorbs bursts; live captures drop (and count) whole batches
+	// once it is full.
+	batches := make(chan *pcap.Batch, 32)
+
+	// Stop live captures after the duration, busy or not
+	if opts.duration > 0 {
+		timer := time.AfterFunc(opts.duration, func() {
+			for _, h := range handles {
+				h.Stop()
 			}
-		}()
+		})
+		defer timer.Stop()
+	}
 
-		// Analyze TCP handshakes
-		tcpStats := tcp.AnalyzeHandshake(packets)
+	// Run captures in background
+	go func() {
+		defer close(batches)
+		if err := pcap.CaptureAllBatches(handles, batches); err != nil {
+			fmt.Fprintf(os.Stderr, "Capture error: %v\n", err)
+		}
+	}()
 
-		// Read conntrack
-		connEntries, err := conntrack.ReadConntrack()
+	// Analyze TCP handshakes, in total and per interface, and the data
+	// phase of the same flows
+	tcpStats := tcp.NewAnalyzer(tcp.DefaultConfig)
+	streams := tcpStats.Streams()
+	dnsStats := dns.NewAnalyzer(dns.DefaultConfig)
+	tlsStats := tls.NewAnalyzer(tls.DefaultConfig)
+	httpStats := http.NewAnalyzer(http.DefaultConfig)
+	consumers := []tcp.NewConsumer{dnsStats.Consumer, tlsStats.Consumer, httpStats.Consumer}
+	if opts.dumper != nil {
+		consumers = append(consumers, opts.dumper.Consumer)
+	}
+	reassembler := tcp.NewReassembler(tcp.DefaultReassemblyConfig, consumers...)
+	icmpStats := icmp.NewAnalyzer(icmp.DefaultConfig)
+	mtuStats := mtu.NewAnalyzer(mtu.DefaultConfig)
+	udpStats := udp.NewAnalyzer(udp.DefaultConfig)
+	quicStats := quic.NewAnalyzer(quic.DefaultConfig)
+	neighborStats := neighbor.NewAnalyzer(neighbor.DefaultConfig)
+	dhcpStats := dhcp.NewAnalyzer(dhcp.DefaultConfig)
+	var detector *detect.Detector
+	if opts.detect {
+		detector = detect.New(detect.DefaultConfig)
+	}
+	perIface := make(map[string]*interfaceAnalysis)
+	for _, h := range handles {
+		perIface[h.Name()] = &interfaceAnalysis{tcp: tcp.NewAnalyzer(tcp.DefaultConfig)}
+	}
+	perSegment := make(map[pcap.Segment]*segmentAnalysis)
+	var unsegmented int
+	var last time.Time
+	for batch := range batches {
+		// analyzers take a pointer, which spares boxing every packet; none
+		// keeps it, or its layers, beyond Process
+		for i := range batch.Packets {
+			pkt := &batch.Packets[i]
+			tcpStats.Process(pkt)
+			reassembler.Process(pkt)
+			dnsStats.Process(pkt)
+			icmpStats.Process(pkt)
+			mtuStats.Process(pkt)
+			udpStats.Process(pkt)
+			quicStats.Process(pkt)
+			neighborStats.Process(pkt)
+			dhcpStats.Process(pkt)
+			if detector != nil {
+				detector.Process(pkt)
+			}
+			if ts := pkt.Metadata().Timestamp; ts.After(last) {
+				last = ts
+			}
+			// a reassembled datagram counts as the frames it arrived in
+			frames := max(1, pkt.Frag.Fragments)
+			if ia := perIface[pkt.Interface]; ia != nil {
+				ia.packets += frames
+				ia.bytes += uint64(pkt.Metadata().Length)
+				ia.tcp.Process(pkt)
+			}
+			sa := perSegment[pkt.Encap.Segment]
+			if sa == nil && len(perSegment) < maxSegments {
+				sa = &segmentAnalysis{interfaceAnalysis: interfaceAnalysis{tcp: tcp.NewAnalyzer(tcp.DefaultConfig)}}
+				perSegment[pkt.Encap.Segment] = sa
+			}
+			if sa == nil {
+				unsegmented++
+				continue
+			}
+			sa.packets += frames
+			sa.bytes += uint64(pkt.Metadata().Length)
+			sa.tcp.Process(pkt)
+			sa.addEndpoints(pkt.Encap)
+		}
+		batch.Release()
+	}
+	tcpStats.Finish()
+	reassembler.Finish()
+	tlsStats.Finish()
+	httpStats.Finish()
+	dnsStats.Finish()
+	icmpStats.Finish()
+	udpStats.Finish()
+	quicStats.Finish()
+	neighborStats.Finish()
+	dhcpStats.Finish()
+
+	result := report.DiagnosticResult{
+		Timestamp: time.Now(),
+		TCPStats:  tcpReport(tcpStats.Stats()),
+		Latency:   latencyReport(tcpStats),
+		DNS:       dnsReport(dnsStats),
+		TLS:       tlsReport(tlsStats),
+		QUIC:      quicReport(quicStats),
+		HTTP:      httpReport(httpStats),
+		TCPStream: streamReport(streams),
+	}
+	result.TCPOptions, result.Middlebox = optionsReport(tcpStats)
+	result.Teardown = teardownReport(tcpStats)
+	result.ICMP = icmpReport(icmpStats)
+	if opts.conntrack {
+		// read right away, while the flows of the capture are still in the table
+		udpStats.Correlate(addConntrack(&result))
+	}
+	result.UDP = udpReport(udpStats)
+	result.Neighbor = neighborReport(neighborStats)
+	result.DHCP = dhcpReport(dhcpStats)
+	result.Reassembly = reassemblyReport(reassembler.Stats())
+	if opts.dumper != nil {
+		result.Reassembly.DumpDir = opts.dumper.Dir()
+		result.Reassembly.DumpFiles = len(opts.dumper.Files())
+		if err := opts.dumper.Close(); err != nil {
+			result.Warnings = append(result.Warnings, fmt.Sprintf("not every stream could be written: %v", err))
+		}
+	}
+	if detector != nil {
+		detector.Finish()
+		result.Findings = findingsReport(detector.Findings())
+	}
+	if n := tcpStats.Stats().Evicted; n > 0 {
+		result.Warnings = append(result.Warnings, fmt.Sprintf(
+			"%d flows were evicted from the full flow table; handshake outcomes are incomplete", n))
+	}
+	var total pcap.Stats
+	var fragments pcap.FragmentStats
+	for _, h := range handles {
+		ia := perIface[h.Name()]
+		ia.tcp.FinishAt(last)
+		st, err := h.Stats()
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Warning: could not read conntrack: %v\n", err)
-			connEntries = []conntrack.Entry{}
-		}
-		connStats := conntrack.CountStates(connEntries)
-
-		// Build result
-		result := report.DiagnosticResult{
-			Timestamp:    time.Now(),
-			DurationSecs: diagnoseFlags.duration,
-			TCPStats: struct {
-				SynSent     int     `json:"syn_sent"`
-				SynAckRcvd  int     `json:"syn_ack_received"`
-				RstRcvd     int     `json:"rst_received"`
-				SynAckRatio float64 `json:"syn_ack_ratio_percent"`
-			}{
-				SynSent:     tcpStats.SynSent,
-				SynAckRcvd:  tcpStats.SynAckRcvd,
-				RstRcvd:     tcpStats.RstRcvd,
-				SynAckRatio: tcpStats.SynAckRatio,
-			},
-			ConntrackCounters: struct {
-				Total       int `json:"total"`
-				Established int `json:"established"`
-				SynSent     int `json:"syn_sent"`
-				Unreplied   int `json:"unreplied"`
-				Other       int `json:"other"`
-			}{
-				Total:       connStats.Total,
-				Established: connStats.Established,
-				SynSent:     connStats.SynSent,
-				Unreplied:   connStats.Unreplied,
-				Other:       connStats.Other,
+			fmt.Fprintf(os.Stderr, "Warning: could not read capture statistics for %s: %v\n", h.Name(), err)
+		}
+		total.PacketsReceived += st.PacketsReceived
+		total.PacketsDropped += st.PacketsDropped
+		total.PacketsIfDropped += st.PacketsIfDropped
+		total.PacketsChannelDropped += st.PacketsChannelDropped
+		fragments = addFragments(fragments, h.Fragments())
+
+		result.Interfaces = append(result.Interfaces, h.Name())
+		result.PacketsCaptured += h.PacketsCaptured()
+		result.PerInterface = append(result.PerInterface, report.InterfaceStats{
+			Name:            h.Name(),
+			PacketsCaptured: ia.packets,
+			Bytes:           ia.bytes,
+			CaptureStats:    captureReport(st),
+			TCPStats:        tcpReport(ia.tcp.Stats()),
+		})
+	}
+	result.CaptureStats = captureReport(total)
+	result.Fragmentation = fragmentationReport(mtuStats, fragments)
+	if fragments.Evicted > 0 {
+		result.Warnings = append(result.Warnings, fmt.Sprintf(
+			"%d IP fragments were passed on unreassembled because too many datagrams were incomplete at once", fragments.Evicted))
+	}
+	result.PerSegment = segmentReport(perSegment, last)
+	if unsegmented > 0 && len(result.PerSegment) > 0 {
+		result.Warnings = append(result.Warnings, fmt.Sprintf(
+			"more than %d VLANs and tunnels; %d packets are missing from the per-segment breakdown", maxSegments, unsegmented))
+	}
+	return result
+}
+
+// captureReport converts capture counters into their report form
+func captureReport(st pcap.Stats) report.CaptureStats {
+	return report.CaptureStats{
+		Received:         st.PacketsReceived,
+		KernelDropped:    st.PacketsDropped,
+		InterfaceDropped: st.PacketsIfDropped,
+		ChannelDropped:   st.PacketsChannelDropped,
+		DropRate:         st.DropRate(),
+	}
+}
+
+// interfaceAnalysis accumulates the per-interface part of the analysis
+type interfaceAnalysis struct {
+	packets int
+	bytes   uint64
+	tcp     *tcp.Analyzer
+}
+
+// addFragments sums the fragment counters of two handles
+func addFragments(a, b pcap.FragmentStats) pcap.FragmentStats {
+	a.Fragments += b.Fragments
+	a.Reassembled += b.Reassembled
+	a.Incomplete += b.Incomplete
+	a.Pending += b.Pending
+	a.Overlaps += b.Overlaps
+	a.Duplicates += b.Duplicates
+	a.Invalid += b.Invalid
+	a.Evicted += b.Evicted
+	return a
+}
+
+// fragmentationReport converts the packet sizes of a and the fragment
+// counters of the capture handles into their report form
+func fragmentationReport(a *mtu.Analyzer, f pcap.FragmentStats) report.Fragmentation {
+	st := a.Stats()
+	out := report.Fragmentation{
+		MTU:           mtu.DefaultConfig.MTU,
+		Packets:       st.Packets,
+		MaxSize:       st.MaxSize,
+		Fragments:     f.Fragments,
+		Reassembled:   f.Reassembled,
+		MaxDatagram:   st.MaxDatagram,
+		Incomplete:    f.Incomplete,
+		Pending:       f.Pending,
+		Overlaps:      f.Overlaps,
+		Duplicates:    f.Duplicates,
+		Unreassembled: f.Invalid + f.Evicted,
+		DontFragment:  st.DontFragment,
+		Oversized:     st.Oversized,
+		MaxOversized:  st.MaxOversized,
+	}
+	for _, p := range a.Paths() {
+		out.Paths = append(out.Paths, report.MTUPath{
+			Src:        p.Src.String(),
+			Dst:        p.Dst.String(),
+			Packets:    p.Packets,
+			MaxSize:    p.MaxSize,
+			MaxDF:      p.MaxDF,
+			Fragmented: p.Fragmented,
+			Fragments:  p.Fragments,
+			Oversized:  p.Oversized,
+		})
+	}
+	return out
+}
+
+// maxSegments caps the VLANs and tunnels broken out in the report, and
+// maxTunnelEndpoints the endpoint pairs listed for each of them
+const (
+	maxSegments        = 64
+	maxTunnelEndpoints = 4
+)
+
+// segmentAnalysis accumulates the part of the analysis for one VLAN or
+// tunnel
+type segmentAnalysis struct {
+	interfaceAnalysis
+	endpoints [][2]netip.Addr
+}
+
+// addEndpoints records the tunnel endpoints of e, in either direction
+func (sa *segmentAnalysis) addEndpoints(e pcap.Encapsulation) {
+	if !e.Src.IsValid() || len(sa.endpoints) >= maxTunnelEndpoints {
+		return
+	}
+	for _, ep := range sa.endpoints {
+		if ep == [2]netip.Addr{e.Src, e.Dst} || ep == [2]netip.Addr{e.Dst, e.Src} {
+			return
+		}
+	}
+	sa.endpoints = append(sa.endpoints, [2]netip.Addr{e.Src, e.Dst})
+}
+
+// segmentReport breaks packet and handshake counters out per VLAN and
+// tunnel, busiest first. It returns nil when no packet was tagged or
+// tunnelled, since the breakdown would repeat the totals.
+func segmentReport(perSegment map[pcap.Segment]*segmentAnalysis, end time.Time) []report.SegmentStats {
+	if _, untagged := perSegment[pcap.Segment{}]; len(perSegment) == 0 || untagged && len(perSegment) == 1 {
+		return nil
+	}
+	var segments []report.SegmentStats
+	for seg, sa := range perSegment {
+		sa.tcp.FinishAt(end)
+		var endpoints []string
+		for _, ep := range sa.endpoints {
+			endpoints = append(endpoints, ep[0].String()+" ↔ "+ep[1].String())
+		}
+		segments = append(segments, report.SegmentStats{
+			Segment:         seg.String(),
+			VLAN:            seg.VLAN,
+			InnerVLAN:       seg.InnerVLAN,
+			Tunnel:          seg.Tunnel.String(),
+			VNI:             seg.VNI,
+			Endpoints:       endpoints,
+			PacketsCaptured: sa.packets,
+			Bytes:           sa.bytes,
+			TCPStats:        tcpReport(sa.tcp.Stats()),
+		})
+	}
+	sort.Slice(segments, func(i, j int) bool {
+		if segments[i].PacketsCaptured != segments[j].PacketsCaptured {
+			return segments[i].PacketsCaptured > segments[j].PacketsCaptured
+		}
+		return segments[i].Segment < segments[j].Segment
+	})
+	return segments
+}
+
+// tcpReport converts handshake counters into their report form
+func tcpReport(s tcp.HandshakeStats) report.TCPHandshake {
+	return report.TCPHandshake{
+		SynSent:           s.SynSent,
+		SynAckRcvd:        s.SynAckRcvd,
+		RstRcvd:           s.RstRcvd,
+		SynAckRatio:       s.SynAckRatio,
+		Completed:         s.Completed,
+		NoAck:             s.NoAck,
+		Unanswered:        s.Unanswered,
+		Reset:             s.Reset,
+		Pending:           s.Pending,
+		Evicted:           s.EvictedHandshakes,
+		SynRetransmits:    s.SynRetransmits,
+		SynAckRetransmits: s.SynAckRetransmits,
+	}
+}
+
+// maxLatencyServers caps the servers listed in the latency section
+const maxLatencyServers = 20
+
+// latencyReport converts the handshake latency histograms of a into their
+// report form, keeping the busiest servers only
+func latencyReport(a *tcp.Analyzer) report.Latency {
+	total, perServer := a.Latency()
+	out := report.Latency{
+		SynAck:  latencySummary(&total.SynAck),
+		Ack:     latencySummary(&total.Ack),
+		Skipped: a.Stats().LatencySkipped,
+	}
+	if len(perServer) > maxLatencyServers {
+		perServer = perServer[:maxLatencyServers]
+	}
+	for _, l := range perServer {
+		out.PerServer = append(out.PerServer, report.ServerLatency{
+			Server: l.Server.String(),
+			SynAck: latencySummary(&l.SynAck),
+			Ack:    latencySummary(&l.Ack),
+		})
+	}
+	return out
+}
+
+// latencySummary condenses a histogram into percentiles in milliseconds
+func latencySummary(h *tcp.Histogram) report.LatencySummary {
+	s := report.LatencySummary{
+		Samples: h.Count(),
+		P50Ms:   millis(h.Quantile(0.50)),
+		P90Ms:   millis(h.Quantile(0.90)),
+		P99Ms:   millis(h.Quantile(0.99)),
+		MaxMs:   millis(h.Max()),
+	}
+	for _, b := range h.Distribution() {
+		s.Histogram = append(s.Histogram, report.LatencyBucket{
+			LowMs:  millis(b.Low),
+			HighMs: millis(b.High),
+			Count:  b.Count,
+		})
+	}
+	return s
+}
+
+func millis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// maxResolvers caps the resolvers listed in the DNS section
+const maxResolvers = 20
+
+// dnsReport converts the DNS counters of a into their report form, keeping
+// the busiest resolvers only
+func dnsReport(a *dns.Analyzer) report.DNS {
+	total, perResolver := a.Stats()
+	out := report.DNS{DNSCounters: dnsCounters(total)}
+	if len(perResolver) > maxResolvers {
+		perResolver = perResolver[:maxResolvers]
+	}
+	for _, r := range perResolver {
+		rr := report.DNSResolver{Resolver: r.Resolver.String(), DNSCounters: dnsCounters(r)}
+		for _, n := range r.TopNames(dns.DefaultConfig.TopNames) {
+			rr.TopNames = append(rr.TopNames, report.NameCount{Name: n.Name, Count: n.Count})
+		}
+		out.PerResolver = append(out.PerResolver, rr)
+	}
+	return out
+}
+
+func dnsCounters(s *dns.Stats) report.DNSCounters {
+	return report.DNSCounters{
+		Queries:        s.Queries,
+		Responses:      s.Responses,
+		Unanswered:     s.Unanswered,
+		UnansweredRate: s.UnansweredRate(),
+		Pending:        s.Pending,
+		Unmatched:      s.Unmatched,
+		NXDomain:       s.NXDomain,
+		NXDomainRate:   s.Rate(s.NXDomain),
+		ServFail:       s.ServFail,
+		ServFailRate:   s.Rate(s.ServFail),
+		Refused:        s.Refused,
+		RefusedRate:    s.Rate(s.Refused),
+		OtherErrors:    s.OtherErrors,
+		Truncated:      s.Truncated,
+		Latency:        latencySummary(&s.Latency),
+	}
+}
+
+// maxTLSServers caps the server names and fingerprints listed in the TLS
+// and QUIC sections
+const maxTLSServers = 20
+
+// tlsReport converts the TLS handshake statistics of a into their report
+// form, keeping the server names with the most failures and the most common
+// fingerprints only
+func tlsReport(a *tls.Analyzer) report.TLS {
+	st := a.Stats()
+	out := report.TLS{
+		Handshakes:      st.Handshakes,
+		Failed:          st.Failed,
+		Pending:         st.Pending,
+		Expired:         st.Expired,
+		OfferedVersions: nameCounts(st.OfferedVersions, tls.VersionName),
+		Versions:        nameCounts(st.Versions, tls.VersionName),
+		CipherSuites:    nameCounts(st.CipherSuites, tls.CipherSuiteName),
+		ALPN:            nameCounts(st.ALPN, func(p string) string { return p }),
+		Alerts:          nameCounts(st.Alerts, func(a string) string { return a }),
+	}
+	servers := a.Servers()
+	if len(servers) > maxTLSServers {
+		servers = servers[:maxTLSServers]
+	}
+	for _, s := range servers {
+		rs := report.TLSServer{
+			Name:       s.Name,
+			Handshakes: s.Handshakes,
+			Failed:     s.Failed,
+			Failures:   nameCounts(s.Failures, func(f string) string { return f }),
+			Expired:    s.Expired,
+		}
+		if s.Version != 0 {
+			rs.Version = tls.VersionName(s.Version)
+			rs.CipherSuite = tls.CipherSuiteName(s.CipherSuite)
+		}
+		for _, c := range s.Certificates {
+			rs.Certificates = append(rs.Certificates, report.TLSCertificate(c))
+		}
+		out.Servers = append(out.Servers, rs)
+	}
+	fps := a.Fingerprints()
+	if len(fps) > maxTLSServers {
+		fps = fps[:maxTLSServers]
+	}
+	for _, fp := range fps {
+		out.Fingerprints = append(out.Fingerprints, report.TLSFingerprint{JA4: fp.JA4, JA3: fp.JA3, Count: fp.Count, SNI: fp.SNI})
+	}
+	return out
+}
+
+// quicReport converts the QUIC connection attempts of a into their report
+// form, keeping the server names with the most problems only
+func quicReport(a *quic.Analyzer) report.QUIC {
+	st := a.Stats()
+	out := report.QUIC{
+		Connections:     st.Connections,
+		Completed:       st.Completed,
+		Failed:          st.Failed,
+		NoResponse:      st.NoResponse,
+		VersionMismatch: st.VersionMismatch,
+		Pending:         st.Pending,
+		Retries:         st.Retries,
+		Evicted:         st.Evicted,
+		Versions:        nameCounts(st.Versions, quic.VersionName),
+		ALPN:            nameCounts(st.ALPN, func(p string) string { return p }),
+		Errors:          nameCounts(st.Errors, func(e string) string { return e }),
+		HandshakeTime:   latencySummary(&st.HandshakeTime),
+	}
+	servers := a.Servers()
+	if len(servers) > maxTLSServers {
+		servers = servers[:maxTLSServers]
+	}
+	for _, s := range servers {
+		out.Servers = append(out.Servers, report.QUICServer{
+			Name:       s.Name,
+			Address:    s.Address.String(),
+			Attempts:   s.Attempts,
+			Completed:  s.Completed,
+			Failed:     s.Failed,
+			NoResponse: s.NoResponse,
+			Version:    quic.VersionName(s.Version),
+			ALPN:       s.ALPN,
+			Errors:     nameCounts(s.Errors, func(e string) string { return e }),
+		})
+	}
+	return out
+}
+
+// nameCounts orders the entries of m by how often they were seen, naming
+// each key with name
+func nameCounts[K comparable](m map[K]int, name func(K) string) []report.NameCount {
+	out := make([]report.NameCount, 0, len(m))
+	for k, n := range m {
+		out = append(out, report.NameCount{Name: name(k), Count: n})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Count != out[j].Count {
+			return out[i].Count > out[j].Count
+		}
+		return out[i].Name < out[j].Name
+	})
+	return out
+}
+
+// maxEndpoints caps the host and path pairs listed in the HTTP section
+const maxEndpoints = 20
+
+// httpReport converts the HTTP counters of a into their report form,
+// keeping the busiest endpoints only
+func httpReport(a *http.Analyzer) report.HTTP {
+	total, endpoints := a.Stats()
+	out := report.HTTP{
+		HTTPCounters: report.HTTPCounters{
+			Requests:     total.Requests,
+			Responses:    total.Responses,
+			TTFB:         latencySummary(&total.TTFB),
+			ResponseTime: latencySummary(&total.Total),
+		},
+		Connections:              total.Connections,
+		ReusedConnections:        total.ReusedConnections,
+		ReuseRate:                total.ReuseRate(),
+		RequestsPerConnection:    total.RequestsPerConnection(),
+		MaxRequestsPerConnection: total.MaxRequestsPerConnection,
+		Unanswered:               total.Unanswered,
+		Pending:                  total.Pending,
+		Upgrades:                 total.Upgrades,
+		Lost:                     total.Lost,
+	}
+	for code, n := range total.StatusCodes {
+		out.StatusCodes = append(out.StatusCodes, report.StatusCount{Code: code, Count: n})
+		switch {
+		case code >= 500:
+			out.ServerErrors += n
+		case code >= 400:
+			out.ClientErrors += n
+		}
+	}
+	sort.Slice(out.StatusCodes, func(i, j int) bool {
+		if out.StatusCodes[i].Count != out.StatusCodes[j].Count {
+			return out.StatusCodes[i].Count > out.StatusCodes[j].Count
+		}
+		return out.StatusCodes[i].Code < out.StatusCodes[j].Code
+	})
+	if len(endpoints) > maxEndpoints {
+		endpoints = endpoints[:maxEndpoints]
+	}
+	for _, e := range endpoints {
+		out.Endpoints = append(out.Endpoints, report.HTTPEndpoint{
+			Host: e.Host,
+			Path: e.Path,
+			HTTPCounters: report.HTTPCounters{
+				Requests:     e.Requests,
+				Responses:    e.Responses,
+				ClientErrors: e.ClientErrors,
+				ServerErrors: e.ServerErrors,
+				TTFB:         latencySummary(&e.TTFB),
+				ResponseTime: latencySummary(&e.Total),
 			},
-			PacketsCaptured: int(handle.PacketsCaptured()),
+		})
+	}
+	return out
+}
+
+// maxICMPNetworks caps the destination networks and black holes listed in
+// the ICMP section
+const maxICMPNetworks = 20
+
+// icmpReport converts the ICMP errors and black holes of a into their
+// report form
+func icmpReport(a *icmp.Analyzer) report.ICMP {
+	st := a.Stats()
+	out := report.ICMP{
+		Total:    st.Total,
+		Unparsed: st.Unparsed,
+		Messages: nameCounts(st.Messages, icmp.Kind.String),
+	}
+	networks := a.Networks()
+	if len(networks) > maxICMPNetworks {
+		networks = networks[:maxICMPNetworks]
+	}
+	for _, n := range networks {
+		rn := report.ICMPNetwork{
+			Network:    n.Prefix.String(),
+			Total:      n.Total,
+			Messages:   nameCounts(n.Messages, icmp.Kind.String),
+			Flows:      n.Flows,
+			MinMTU:     n.MinMTU,
+			BlackHoles: n.BlackHoles,
+		}
+		if n.Flows > 0 {
+			rn.Example = n.Example.String()
 		}
+		for _, r := range n.Reporters {
+			rn.Reporters = append(rn.Reporters, r.String())
+		}
+		out.Networks = append(out.Networks, rn)
+	}
+	holes := a.BlackHoles()
+	if len(holes) > maxICMPNetworks {
+		holes = holes[:maxICMPNetworks]
+	}
+	for _, b := range holes {
+		out.BlackHoles = append(out.BlackHoles, report.BlackHole{
+			Sender:      b.Src.String(),
+			Receiver:    b.Dst.String(),
+			Size:        b.Size,
+			Retransmits: b.Retransmits,
+			Start:       b.Start,
+			Recovered:   b.Recovered,
+		})
+	}
+	return out
+}
 
-		// Simple summary
-		result.Summary = fmt.Sprintf("Captured %d packets, %d SYN sent, %.1f%% SYN-ACK ratio, %d conntrack entries",
-			result.PacketsCaptured, result.TCPStats.SynSent, result.TCPStats.SynAckRatio, result.ConntrackCounters.Total)
-		result.Recommendation = "Check SYN-ACK ratio; low values may indicate packet loss or network issues."
+// maxNeighborHosts caps the hosts listed in the ARP and Neighbor Discovery
+// section
+const maxNeighborHosts = 20
 
-		// Write report
-		var writeErr error
-		if diagnoseFlags.format == "json" {
-			writeErr = report.ToJSON(&result, diagnoseFlags.output)
-		} else {
-			writeErr = report.ToMarkdown(&result, diagnoseFlags.output)
+// neighborReport converts the address resolution activity of a into its
+// report form, keeping the hosts with the most problems only
+func neighborReport(a *neighbor.Analyzer) report.Neighbor {
+	st := a.Stats()
+	out := report.Neighbor{
+		ARPRequests:   st.ARPRequests,
+		Solicitations: st.Solicitations,
+		Probes:        st.Probes,
+		Answered:      st.Answered,
+		Unanswered:    st.Unanswered,
+		Unobserved:    st.Unobserved,
+		Gratuitous:    st.Gratuitous,
+		Storms:        st.Storms,
+		Flooding:      st.Flooding,
+		ResolveTime:   latencySummary(&st.ResolveTime),
+	}
+	hosts := a.Hosts()
+	if len(hosts) > maxNeighborHosts {
+		hosts = hosts[:maxNeighborHosts]
+	}
+	for _, h := range hosts {
+		out.Hosts = append(out.Hosts, report.NeighborHost{
+			IP:         h.IP.String(),
+			MAC:        h.MAC,
+			Requests:   h.Requests,
+			Answered:   h.Answered,
+			Unanswered: h.Unanswered,
+			Targets:    addrStrings(h.Targets),
+			PeakRate:   h.PeakRate,
+			Flooding:   h.Flooding,
+			Gratuitous: h.Gratuitous,
+			Storm:      h.Storm,
+			Visible:    h.Visible,
+		})
+	}
+	for _, c := range a.Conflicts() {
+		out.Conflicts = append(out.Conflicts, report.NeighborConflict{
+			IP:    c.IP.String(),
+			MACs:  c.MACs,
+			DAD:   c.DAD,
+			First: c.First,
+		})
+	}
+	return out
+}
+
+// maxDHCPClients caps the clients listed in the DHCP section
+const maxDHCPClients = 20
+
+// dhcpReport converts the DHCP exchanges of a into their report form,
+// keeping the clients with the most problems only
+func dhcpReport(a *dhcp.Analyzer) report.DHCP {
+	st := a.Stats()
+	out := report.DHCP{
+		Exchanges:   st.Exchanges,
+		Acked:       st.Acked,
+		Naks:        st.Naks,
+		NoOffer:     st.NoOffer,
+		NoAck:       st.NoAck,
+		Pending:     st.Pending,
+		Renewals:    st.Renewals,
+		Informs:     st.Informs,
+		Retransmits: st.Retransmits,
+		Competing:   st.Competing,
+		Declines:    st.Declines,
+		Releases:    st.Releases,
+		Evicted:     st.Evicted,
+		Messages:    nameCounts(st.Messages, func(m string) string { return m }),
+		Statuses:    nameCounts(st.Statuses, func(s string) string { return s }),
+		OfferTime:   latencySummary(&st.OfferTime),
+		AcquireTime: latencySummary(&st.AcquireTime),
+	}
+	for _, s := range a.Servers() {
+		version := "DHCPv4"
+		if s.V6 {
+			version = "DHCPv6"
+		}
+		srv := report.DHCPServer{
+			ID:        s.ID,
+			Address:   s.Address.String(),
+			Version:   version,
+			Offers:    s.Offers,
+			Acks:      s.Acks,
+			Naks:      s.Naks,
+			Competing: s.Competing,
+			Routers:   addrStrings(s.Lease.Routers),
+			DNS:       addrStrings(s.Lease.DNS),
+			Domain:    s.Lease.Domain,
+			LeaseSecs: int(s.Lease.Time / time.Second),
 		}
-		if writeErr != nil {
-			return fmt.Errorf("failed to write report: %w", writeErr)
+		if s.Lease.Address.IsValid() {
+			srv.Offered = s.Lease.Address.String()
 		}
+		if s.Lease.Subnet.IsValid() {
+			srv.Subnet = s.Lease.Subnet.String()
+		}
+		out.Servers = append(out.Servers, srv)
+	}
+	clients := a.Clients()
+	if len(clients) > maxDHCPClients {
+		clients = clients[:maxDHCPClients]
+	}
+	for _, c := range clients {
+		cl := report.DHCPClient{
+			ID:          c.ID,
+			Exchanges:   c.Exchanges,
+			Acked:       c.Acked,
+			Naks:        c.Naks,
+			NoOffer:     c.NoOffer,
+			NoAck:       c.NoAck,
+			Declines:    c.Declines,
+			Retransmits: c.Retransmits,
+			Last:        c.Last.String(),
+		}
+		if c.Address.IsValid() {
+			cl.Address = c.Address.String()
+		}
+		out.Clients = append(out.Clients, cl)
+	}
+	return out
+}
 
-		fmt.Printf("Report written to %s\n", diagnoseFlags.output)
-		return nil
-	},
+// addrStrings formats a list of addresses
+func addrStrings(addrs []netip.Addr) []string {
+	out := make([]string, len(addrs))
+	for i, a := range addrs {
+		out[i] = a.String()
+	}
+	return out
 }
 
-func init() {
-	diagnoseCmd.Flags().StringVarP(&diagnoseFlags.interfaceName, "interface", "i", "", "Network interface to capture on (required)")
-	diagnoseCmd.Flags().IntVarP(&diagnoseFlags.duration, "duration", "d", 30, "Capture duration in seconds")
-	diagnoseCmd.Flags().StringVarP(&diagnoseFlags.output, "output", "o", "report.md", "Output file path")
-	diagnoseCmd.Flags().StringVarP(&diagnoseFlags.format, "format", "f", "markdown", "Output format (json or markdown)")
-	diagnoseCmd.Flags().StringVar(&diagnoseFlags.filter, "filter", "", "BPF filter (e.g., 'tcp port 80')")
+// udpReport converts the UDP flows of a into their report form
+func udpReport(a *udp.Analyzer) report.UDP {
+	st := a.Stats()
+	out := report.UDP{
+		Flows:              st.Flows,
+		OneWay:             st.OneWay,
+		OneWayRate:         st.OneWayRate(),
+		Pending:            st.Pending,
+		Multicast:          st.Multicast,
+		Evicted:            st.Evicted,
+		PacketsSent:        st.Sent.Packets,
+		BytesSent:          st.Sent.Bytes,
+		PacketsReceived:    st.Received.Packets,
+		BytesReceived:      st.Received.Bytes,
+		ConntrackFlows:     st.ConntrackFlows,
+		ConntrackUnreplied: st.ConntrackUnreplied,
+		Confirmed:          st.Confirmed,
+	}
+	lower := 0
+	for i, n := range st.Sizes {
+		name := fmt.Sprintf("%d+", lower)
+		if i < len(udp.SizeBuckets) {
+			name = fmt.Sprintf("%d-%d", lower, udp.SizeBuckets[i])
+			lower = udp.SizeBuckets[i] + 1
+		}
+		if n > 0 {
+			out.Sizes = append(out.Sizes, report.NameCount{Name: name, Count: n})
+		}
+	}
+	for _, f := range a.TopFlows() {
+		out.TopFlows = append(out.TopFlows, report.UDPFlow{
+			Client:          f.Client.String(),
+			Server:          f.Server.String(),
+			PacketsSent:     f.Sent.Packets,
+			BytesSent:       f.Sent.Bytes,
+			PacketsReceived: f.Received.Packets,
+			BytesReceived:   f.Received.Bytes,
+			MaxSize:         max(f.Sent.MaxSize, f.Received.MaxSize),
+			DurationMs:      millis(f.Last.Sub(f.Start)),
+			OneWay:          f.OneWay(),
+			Conntrack:       f.Conntrack.String(),
+		})
+	}
+	for _, d := range a.Destinations() {
+		out.Destinations = append(out.Destinations, report.UDPDestination{
+			Server:             d.Server.String(),
+			Flows:              d.Flows,
+			OneWay:             d.OneWay,
+			Packets:            d.Packets,
+			ConntrackUnreplied: d.ConntrackUnreplied,
+		})
+	}
+	return out
+}
+
+// findingsReport converts detector findings into their report form
+func findingsReport(findings []detect.Finding) []report.Finding {
+	var out []report.Finding
+	for _, f := range findings {
+		rf := report.Finding{
+			Kind:     f.Kind.String(),
+			Start:    f.Start,
+			End:      f.End,
+			Target:   f.Target,
+			Probes:   f.Probes,
+			HalfOpen: f.HalfOpen,
+			Sources:  f.Sources,
+			Spoofed:  f.Spoofed,
+			Distinct: f.Distinct,
+			Resets:   f.Resets,
+		}
+		if f.Source.IsValid() {
+			rf.Source = f.Source.String()
+		}
+		for _, o := range f.TopSources {
+			rf.TopSources = append(rf.TopSources, report.Offender{Addr: o.Addr.String(), Count: o.Count})
+		}
+		switch f.Kind {
+		case detect.KindSYNFlood:
+			rf.Detail = fmt.Sprintf("%d half-open from %d sources", f.HalfOpen, f.Sources)
+			if f.Spoofed {
+				rf.Detail += ", sources look spoofed"
+			}
+		case detect.KindVerticalScan:
+			rf.Detail = fmt.Sprintf("%d ports probed, %d RSTs back", f.Distinct, f.Resets)
+		case detect.KindHorizontalScan:
+			rf.Detail = fmt.Sprintf("%d hosts probed, %d RSTs back", f.Distinct, f.Resets)
+		}
+		out = append(out, rf)
+	}
+	return out
+}
+
+// maxTeardownServers caps the servers listed in the teardown section
+const maxTeardownServers = 20
+
+// teardownReport converts the connection endings of a into their report form
+func teardownReport(a *tcp.Analyzer) report.Teardown {
+	total, perServer := a.Lifecycle()
+	out := report.Teardown{Aborted: total.Aborted()}
+	for _, e := range []tcp.Ending{tcp.EndGraceful, tcp.EndHalfClosed, tcp.EndRefused,
+		tcp.EndClientReset, tcp.EndServerReset, tcp.EndIdle, tcp.EndOpen} {
+		if total.Count(e) == 0 {
+			continue
+		}
+		h := &total.Durations[e]
+		out.Endings = append(out.Endings, report.ConnectionEnding{
+			Ending: e.String(),
+			Count:  total.Count(e),
+			P50Ms:  millis(h.Quantile(0.50)),
+			P99Ms:  millis(h.Quantile(0.99)),
+			MaxMs:  millis(h.Max()),
+		})
+	}
+	if len(perServer) > maxTeardownServers {
+		perServer = perServer[:maxTeardownServers]
+	}
+	for _, l := range perServer {
+		h := l.Duration()
+		out.PerServer = append(out.PerServer, report.ServerTeardown{
+			Server:      l.Server.String(),
+			Graceful:    l.Count(tcp.EndGraceful),
+			HalfClosed:  l.Count(tcp.EndHalfClosed),
+			Refused:     l.Count(tcp.EndRefused),
+			ClientReset: l.Count(tcp.EndClientReset),
+			ServerReset: l.Count(tcp.EndServerReset),
+			Idle:        l.Count(tcp.EndIdle),
+			Open:        l.Count(tcp.EndOpen),
+			P50Ms:       millis(h.Quantile(0.50)),
+			P99Ms:       millis(h.Quantile(0.99)),
+		})
+	}
+	return out
+}
+
+// reassemblyReport converts the stream reassembly counters into their
+// report form
+func reassemblyReport(s tcp.ReassemblyStats) report.Reassembly {
+	return report.Reassembly{
+		Streams:      s.Streams,
+		Bytes:        s.Bytes,
+		Gaps:         s.Gaps,
+		MissingBytes: s.MissingBytes,
+		OverlapBytes: s.OverlapBytes,
+	}
+}
+
+// optionsReport converts the handshake option statistics and middlebox
+// findings of a into their report form
+func optionsReport(a *tcp.Analyzer) (report.TCPOptions, []report.Middlebox) {
+	st, findings := a.Options()
+	out := report.TCPOptions{
+		Handshakes:  st.Handshakes,
+		WindowScale: report.OptionUsage(st.WindowScale),
+		SACK:        report.OptionUsage(st.SACK),
+		Timestamps:  report.OptionUsage(st.Timestamps),
+		ECN:         report.OptionUsage(st.ECN),
+		ClientMSS:   mssCounts(st.ClientMSS),
+		ServerMSS:   mssCounts(st.ServerMSS),
+	}
+	var mb []report.Middlebox
+	for _, f := range findings {
+		mb = append(mb, report.Middlebox{
+			Server:    f.Server.String(),
+			Anomaly:   f.Anomaly.String(),
+			Count:     f.Count,
+			ClientMSS: f.ClientMSS,
+			ServerMSS: f.ServerMSS,
+		})
+	}
+	return out, mb
+}
+
+// mssCounts orders MSS values by how often they were seen
+func mssCounts(m map[uint16]int) []report.MSSCount {
+	out := make([]report.MSSCount, 0, len(m))
+	for mss, n := range m {
+		out = append(out, report.MSSCount{MSS: mss, Count: n})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Count != out[j].Count {
+			return out[i].Count > out[j].Count
+		}
+		return out[i].MSS > out[j].MSS
+	})
+	return out
+}
+
+// maxStreamFlows caps the flows listed in the data phase section
+const maxStreamFlows = 20
+
+// streamReport converts the data phase counters of a into their report
+// form, keeping the flows with the most issues only
+func streamReport(a *tcp.StreamAnalyzer) report.TCPStream {
+	st := a.Stats()
+	out := report.TCPStream{StreamCounters: streamCounters(&st)}
+	flows := a.Flows()
+	if len(flows) > maxStreamFlows {
+		flows = flows[:maxStreamFlows]
+	}
+	for _, f := range flows {
+		out.Flows = append(out.Flows, flowStream(&f))
+	}
+	stalled := a.Stalled()
+	if len(stalled) > maxStreamFlows {
+		stalled = stalled[:maxStreamFlows]
+	}
+	for _, f := range stalled {
+		out.StalledFlows = append(out.StalledFlows, flowStream(&f))
+	}
+	return out
+}
+
+func flowStream(f *tcp.FlowStream) report.FlowStream {
+	return report.FlowStream{
+		Client:         f.Key.Client.String(),
+		Server:         f.Key.Server.String(),
+		StreamCounters: streamCounters(&f.StreamStats),
+	}
+}
+
+func streamCounters(s *tcp.StreamStats) report.StreamCounters {
+	return report.StreamCounters{
+		Segments:                s.Segments,
+		DataSegments:            s.DataSegments,
+		Bytes:                   s.Bytes,
+		Retransmissions:         s.Retransmissions,
+		FastRetransmissions:     s.FastRetransmissions,
+		SpuriousRetransmissions: s.SpuriousRetransmissions,
+		DuplicateAcks:           s.DuplicateAcks,
+		OutOfOrder:              s.OutOfOrder,
+		LostSegments:            s.LostSegments,
+		RetransmissionRate:      s.RetransmissionRate(),
+		ZeroWindows:             s.ZeroWindows,
+		ZeroWindowProbes:        s.ZeroWindowProbes,
+		WindowFull:              s.WindowFull,
+		WindowUpdates:           s.WindowUpdates,
+		StallMs:                 millis(s.StallTime),
+	}
+}
+
+// addConntrack fills in the conntrack counters of the local host and returns
+// the entries read
+func addConntrack(result *report.DiagnosticResult) []conntrack.Entry {
+	connEntries, err := conntrack.ReadConntrack()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: could not read conntrack: %v\n", err)
+		connEntries = []conntrack.Entry{}
+	}
+	connStats := conntrack.CountStates(connEntries)
+
+	result.ConntrackCounters.Total = connStats.Total
+	result.ConntrackCounters.Established = connStats.Established
+	result.ConntrackCounters.SynSent = connStats.SynSent
+	result.ConntrackCounters.Unreplied = connStats.Unreplied
+	result.ConntrackCounters.Other = connStats.Other
+	return connEntries
+}
+
+// warnDrops adds a warning for every capture whose drop rate exceeds
+// threshold percent, since the analysis is then based on partial data
+func warnDrops(result *report.DiagnosticResult, threshold float64) {
+	if result.CaptureStats.DropRate > threshold {
+		result.Warnings = append(result.Warnings, fmt.Sprintf(
+			"%.2f%% of packets were dropped (kernel %d, interface %d, analysis backlog %d); handshake ratios are unreliable",
+			result.CaptureStats.DropRate, result.CaptureStats.KernelDropped,
+			result.CaptureStats.InterfaceDropped, result.CaptureStats.ChannelDropped))
+	}
+	if len(result.PerInterface) < 2 {
+		return
+	}
+	for _, ia := range result.PerInterface {
+		if ia.CaptureStats.DropRate > threshold {
+			result.Warnings = append(result.Warnings, fmt.Sprintf("%s dropped %.2f%% of packets",
+				ia.Name, ia.CaptureStats.DropRate))
+		}
+	}
+}
+
+// summarize sets the summary and recommendation of the report
+func summarize(result *report.DiagnosticResult) {
+	hs := result.TCPStats
+	result.Summary = fmt.Sprintf("Captured %d packets, %d connection attempts: %d completed, %d not ACKed, %d unanswered, %d reset, %d pending; %d conntrack entries",
+		result.PacketsCaptured, hs.SynSent, hs.Completed, hs.NoAck, hs.Unanswered, hs.Reset, hs.Pending, result.ConntrackCounters.Total)
+
+	var advice []string
+	if n := len(result.Findings); n > 0 {
+		advice = append(advice, fmt.Sprintf(
+			"%d SYN flood or port scan findings (see Findings); block or rate-limit the listed sources before looking further.", n))
+	}
+	if d := result.DNS; d.Unanswered+d.ServFail+d.Refused > d.Queries/100 {
+		advice = append(advice, fmt.Sprintf(
+			"%d of %d DNS queries went unanswered, %d failed with SERVFAIL and %d were refused (see DNS Resolution); fix name resolution first, since it looks like a connectivity problem to applications.",
+			d.Unanswered, d.Queries, d.ServFail, d.Refused))
+	}
+	if t := result.TLS; t.Expired > 0 {
+		advice = append(advice, fmt.Sprintf(
+			"%d TLS handshakes presented an expired or not yet valid certificate (see TLS Handshakes); renew it or check the clocks involved.",
+			t.Expired))
+	}
+	if t := result.TLS; t.Failed > 0 {
+		advice = append(advice, fmt.Sprintf(
+			"%d of %d TLS handshakes failed (see TLS Handshakes); the alerts tell version or cipher mismatches from certificate problems and interception.",
+			t.Failed, t.Handshakes))
+	}
+	if q := result.QUIC; q.NoResponse+q.Failed+q.VersionMismatch > q.Connections/100 {
+		advice = append(advice, fmt.Sprintf(
+			"%d of %d QUIC connection attempts got no response and %d failed (see QUIC Connections); if UDP port 443 is blocked on purpose, expect HTTP/3 clients to fall back to TCP after a delay.",
+			q.NoResponse, q.Connections, q.Failed+q.VersionMismatch))
+	}
+	if h := result.HTTP; h.ServerErrors > h.Responses/100 || h.Unanswered > (h.Requests-h.Pending)/100 {
+		advice = append(advice, fmt.Sprintf(
+			"%d of %d HTTP responses were server errors and %d requests went unanswered (see HTTP Transactions); check the logs of the listed services.",
+			h.ServerErrors, h.Responses, h.Unanswered))
+	}
+	if d := result.DHCP; d.NoOffer+d.NoAck+d.Naks > 0 {
+		advice = append(advice, fmt.Sprintf(
+			"%d DHCP exchanges got no offer, %d were never acknowledged and %d were refused (see DHCP); without a lease the host has no network, so check the DHCP server, its pool and any relay agent first.",
+			d.NoOffer, d.NoAck, d.Naks))
+	}
+	if d := result.DHCP; d.Competing > 0 {
+		advice = append(advice, fmt.Sprintf(
+			"%d DHCP exchanges were answered by more than one server (see DHCP); unless they are a failover pair, find and shut down the rogue server.",
+			d.Competing))
+	}
+	if n := len(result.Neighbor.Conflicts); n > 0 {
+		advice = append(advice, fmt.Sprintf(
+			"%d IP addresses are claimed by more than one MAC (see ARP and Neighbor Discovery); unless this is VRRP failover or proxy ARP, find the duplicate before it steals traffic.",
+			n))
+	}
+	if nb := result.Neighbor; nb.Unanswered > 0 {
+		advice = append(advice, fmt.Sprintf(
+			"%d address resolutions went unanswered (see ARP and Neighbor Discovery); the targets are down, on another VLAN or filtered, and connections to them fail before any SYN is sent.",
+			nb.Unanswered))
+	}
+	if nb := result.Neighbor; nb.Storms+nb.Flooding > 0 {
+		advice = append(advice, fmt.Sprintf(
+			"%d hosts sent gratuitous ARP storms and %d flooded resolution requests (see ARP and Neighbor Discovery); look for flapping failover, loops or scanners.",
+			nb.Storms, nb.Flooding))
+	}
+	if hs.Unanswered > 0 {
+		advice = append(advice, "Unanswered SYNs point at a firewall dropping traffic, a routing problem or an unreachable server.")
+	}
+	if hs.NoAck > 0 {
+		advice = append(advice, "SYN-ACKs that were never acknowledged suggest asymmetric routing or a middlebox dropping return traffic.")
+	}
+	if hs.Reset > 0 {
+		advice = append(advice, "Handshakes reset by the peer usually mean nothing is listening on the port or the connection was refused.")
+	}
+	if aborted := result.Teardown.Aborted; aborted > 0 {
+		advice = append(advice, fmt.Sprintf(
+			"%d accepted connections were aborted with RST (see Connection Teardown); look for application crashes, timeouts or load balancer idle limits.",
+			aborted))
+	}
+	if hs.SynRetransmits > 0 {
+		advice = append(advice, "SYN retransmissions indicate packet loss on the path.")
+	}
+	if st := result.TCPStream; st.RetransmissionRate > 1 {
+		advice = append(advice, fmt.Sprintf(
+			"%.1f%% of data segments were retransmitted (%d fast, %d spurious); look for loss or congestion on the path.",
+			st.RetransmissionRate, st.FastRetransmissions, st.SpuriousRetransmissions))
+	} else if st.OutOfOrder > st.DataSegments/100 && st.OutOfOrder > 0 {
+		advice = append(advice, "Frequent reordering suggests load balancing across paths of different latency.")
+	}
+	if len(result.Middlebox) > 0 {
+		advice = append(advice, "SYN-ACK options disagree with the SYNs for some servers (see Middlebox Interference); check firewalls, NAT and VPN gateways for MSS clamping or option stripping.")
+	}
+	if n := len(result.ICMP.BlackHoles); n > 0 {
+		advice = append(advice, fmt.Sprintf(
+			"%d TCP flows look like path MTU black holes (see ICMP Errors); let fragmentation-needed and packet-too-big messages through firewalls, or clamp the MSS on tunnels and VPNs.",
+			n))
+	}
+	if f := result.Fragmentation; f.Incomplete+f.Overlaps > 0 {
+		advice = append(advice, fmt.Sprintf(
+			"%d fragmented datagrams never completed and %d were dropped for overlapping fragments (see IP Fragmentation and MTU); a firewall or NAT on the path probably drops non-first fragments, so have the senders use smaller datagrams (an EDNS buffer size of 1232 for DNS) or let fragments through.",
+			f.Incomplete, f.Overlaps))
+	}
+	if f := result.Fragmentation; f.Oversized > 0 {
+		advice = append(advice, fmt.Sprintf(
+			"%d packets with DF set or over IPv6 were larger than the %d-byte MTU, up to %d bytes (see IP Fragmentation and MTU); unless the capture host's offloads built them, check that every hop supports jumbo frames.",
+			f.Oversized, f.MTU, f.MaxOversized))
+	}
+	if u := result.UDP; u.OneWay > 0 && u.OneWay > (u.Flows-u.Multicast)/100 {
+		confirmed := ""
+		if u.Confirmed > 0 {
+			confirmed = fmt.Sprintf(", %d of them confirmed unreplied by conntrack", u.Confirmed)
+		}
+		advice = append(advice, fmt.Sprintf(
+			"%d of %d UDP flows were never answered%s (see UDP Flows); check firewalls and security groups for the listed servers, and that the services listen.",
+			u.OneWay, u.Flows, confirmed))
+	}
+	if st := result.TCPStream; st.ZeroWindows > 0 {
+		advice = append(advice, fmt.Sprintf(
+			"Receivers closed their window %d times, stalling for %.1f ms in total; this is application back-pressure rather than network loss.",
+			st.ZeroWindows, st.StallMs))
+	}
+	if len(advice) == 0 {
+		advice = append(advice, "No problems detected.")
+	}
+	result.Recommendation = strings.Join(advice, " ")
+}
+
+// writeReport writes the result in the requested format
+func writeReport(result *report.DiagnosticResult, format, output string) error {
+	var writeErr error
+	if format == "json" {
+		writeErr = report.ToJSON(result, output)
+	} else {
+		writeErr = report.ToMarkdown(result, output)
+	}
+	if writeErr != nil {
+		return fmt.Errorf("failed to write report: %w", writeErr)
+	}
+
+	fmt.Printf("Report written to %s\n", output)
+	return nil
 }
 
 // -----------------------------------------------------------------------------
@@ -205,4 +1566,4 @@ var versionCmd = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
 		fmt.Printf("network-app %s (commit %s, built %s)\n", version, commit, date)
 	},
-}
\ No newline at end of file
+}
@@ -0,0 +1,24 @@
+package analyzer
+
+import (
+	"net/netip"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// Addrs returns the source and destination of an IPv4 or IPv6 header, and
+// false for any other network layer
+func Addrs(l gopacket.NetworkLayer) (src, dst netip.Addr, ok bool) {
+	switch ip := l.(type) {
+	case *layers.IPv4:
+		src, _ = netip.AddrFromSlice(ip.SrcIP.To4())
+		dst, _ = netip.AddrFromSlice(ip.DstIP.To4())
+	case *layers.IPv6:
+		src, _ = netip.AddrFromSlice(ip.SrcIP)
+		dst, _ = netip.AddrFromSlice(ip.DstIP)
+	default:
+		return src, dst, false
+	}
+	return src, dst, true
+}
@@ -0,0 +1,34 @@
+// Package analyzer holds what the protocol analyzers share: a clock driven
+// by packet timestamps and the addresses of IP headers
+package analyzer
+
+import "time"
+
+// sweepInterval is how much capture time passes between two sweeps
+const sweepInterval = time.Second
+
+// Clock follows capture time for an analyzer that expires state as the
+// capture goes on. It never moves backwards, so packets merged from several
+// interfaces slightly out of order do not confuse it.
+type Clock struct {
+	now       time.Time
+	lastSweep time.Time
+}
+
+// Advance moves the clock to ts if that is later and reports whether a
+// sweep is due, which is about once per second of capture time
+func (c *Clock) Advance(ts time.Time) bool {
+	if ts.After(c.now) {
+		c.now = ts
+	}
+	if c.now.Sub(c.lastSweep) < sweepInterval {
+		return false
+	}
+	c.lastSweep = c.now
+	return true
+}
+
+// Now returns the latest capture time seen
+func (c *Clock) Now() time.Time {
+	return c.now
+}
@@ -0,0 +1,30 @@
+package analyzer
+
+import (
+	"testing"
+	"time"
+)
+
+func TestClock(t *testing.T) {
+	base := time.Date(2026, 2, 20, 10, 0, 0, 0, time.UTC)
+	var c Clock
+	for _, step := range []struct {
+		at    time.Duration
+		sweep bool
+		now   time.Duration
+	}{
+		{at: 0, sweep: true, now: 0},
+		{at: 500 * time.Millisecond, sweep: false, now: 500 * time.Millisecond},
+		// a packet merged out of order does not turn the clock back
+		{at: 100 * time.Millisecond, sweep: false, now: 500 * time.Millisecond},
+		{at: time.Second, sweep: true, now: time.Second},
+		{at: 1900 * time.Millisecond, sweep: false, now: 1900 * time.Millisecond},
+	} {
+		if sweep := c.Advance(base.Add(step.at)); sweep != step.sweep {
+			t.Errorf("Advance(%v) = %v, want %v", step.at, sweep, step.sweep)
+		}
+		if now := c.Now().Sub(base); now != step.now {
+			t.Errorf("after Advance(%v), Now = %v, want %v", step.at, now, step.now)
+		}
+	}
+}
@@ -21,15 +21,17 @@ type Entry struct {
 	IPBytesOut uint64
 	PacketsIn  uint64
 	PacketsOut uint64
+	Unreplied  bool // no packet seen in the reply direction yet
+	Assured    bool // seen in both directions long enough to be kept under pressure
 }
 
 // Counters holds aggregated connection statistics
 type Counters struct {
-	Total      int
+	Total       int
 	Established int
-	SynSent    int
-	Unreplied  int
-	Other      int
+	SynSent     int
+	Unreplied   int
+	Other       int
 }
 
 // ReadConntrack parses /proc/net/nf_conntrack and returns entries
@@ -75,46 +77,71 @@ func parseLine(line string) (Entry, error) {
 	e := Entry{}
 	fields := strings.Fields(line)
 
-	// conntrack line format: family l3proto l4proto l4num timeout state [key=value]...
-	// Skip first 6 fields (family, l3proto, l4proto, l4num, timeout, state)
-	// Then parse key=value pairs
-	for i := 6; i < len(fields); i++ {
-		field := fields[i]
+	// conntrack line format: family l3proto l4proto l4num timeout [state] key=value... [FLAGS]
+	// The state is only there for stateful protocols such as TCP. The
+	// key=value pairs describe the original tuple, then the reply tuple;
+	// each starts with src=.
+	if len(fields) < 6 {
+		return e, fmt.Errorf("short conntrack line: %q", line)
+	}
+	e.Proto = fields[2]
+	fmt.Sscanf(fields[4], "%d", &e.Timeout)
+	tuple := 0
+	for _, field := range fields[5:] {
+		switch field {
+		case "[UNREPLIED]":
+			e.Unreplied = true
+			continue
+		case "[ASSURED]":
+			e.Assured = true
+			continue
+		}
 		kv := strings.SplitN(field, "=", 2)
 		if len(kv) != 2 {
+			if tuple == 0 && e.State == "" {
+				e.State = field
+			}
 			continue
 		}
+		if kv[0] == "src" {
+			tuple++
+		}
+		original := tuple == 1
 		switch kv[0] {
 		case "src":
-			e.SrcIP = kv[1]
+			if original {
+				e.SrcIP = kv[1]
+			}
 		case "dst":
-			e.DstIP = kv[1]
+			if original {
+				e.DstIP = kv[1]
+			}
 		case "sport":
-			e.SrcPort = kv[1]
+			if original {
+				e.SrcPort = kv[1]
+			}
 		case "dport":
-			e.DstPort = kv[1]
-		case "tcp_state":
-			e.State = kv[1]
-		case "timeout":
-			fmt.Sscanf(kv[1], "%d", &e.Timeout)
+			if original {
+				e.DstPort = kv[1]
+			}
+		case "packets":
+			if original {
+				fmt.Sscanf(kv[1], "%d", &e.PacketsOut)
+			} else {
+				fmt.Sscanf(kv[1], "%d", &e.PacketsIn)
+			}
+		case "bytes":
+			if original {
+				fmt.Sscanf(kv[1], "%d", &e.IPBytesOut)
+			} else {
+				fmt.Sscanf(kv[1], "%d", &e.IPBytesIn)
+			}
 		}
 	}
 
-	// Determine protocol from field 2 (index 2)
-	if len(fields) > 2 {
-		e.Proto = fields[2]
-	}
-
-	// Determine state from field 5 (index 5) if not set from tcp_state
-	if e.State == "" && len(fields) > 5 {
-		e.State = fields[5]
-	}
-
-	// infer state from timeout or mark if missing
-	if e.State == "" {
-		if e.Timeout > 0 {
-			e.State = "UNREPLIED" // fallback heuristic
-		}
+	// stateless protocols such as UDP only carry the flag
+	if e.State == "" && e.Unreplied {
+		e.State = "UNREPLIED"
 	}
 	return e, nil
-}
\ No newline at end of file
+}
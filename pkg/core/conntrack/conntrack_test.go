@@ -82,4 +82,26 @@ func TestParseLineUnreplied(t *testing.T) {
 	if entry.SrcIP != "192.168.1.20" {
 		t.Errorf("SrcIP = %s, want 192.168.1.20", entry.SrcIP)
 	}
-}
\ No newline at end of file
+}
+
+func TestParseLineUDP(t *testing.T) {
+	line := `ipv4     2 udp      17 28 src=192.168.1.20 dst=10.0.0.53 sport=40000 dport=53 packets=3 bytes=180 [UNREPLIED] src=10.0.0.53 dst=192.168.1.20 sport=53 dport=40000 packets=0 bytes=0 mark=0 zone=0 use=2`
+	entry, err := parseLine(line)
+	if err != nil {
+		t.Fatalf("parseLine failed: %v", err)
+	}
+	if entry.Proto != "udp" || entry.State != "UNREPLIED" || !entry.Unreplied || entry.Timeout != 28 {
+		t.Errorf("entry = %+v", entry)
+	}
+	if entry.SrcIP != "192.168.1.20" || entry.DstIP != "10.0.0.53" || entry.SrcPort != "40000" || entry.DstPort != "53" {
+		t.Errorf("original tuple = %+v", entry)
+	}
+	if entry.PacketsOut != 3 || entry.IPBytesOut != 180 || entry.PacketsIn != 0 {
+		t.Errorf("counters = %+v", entry)
+	}
+
+	line = `ipv4     2 udp      17 170 src=192.168.1.20 dst=10.0.0.53 sport=40001 dport=53 src=10.0.0.53 dst=192.168.1.20 sport=53 dport=40001 [ASSURED] mark=0 zone=0 use=2`
+	if entry, _ = parseLine(line); entry.State != "" || entry.Unreplied || !entry.Assured {
+		t.Errorf("answered entry = %+v", entry)
+	}
+}
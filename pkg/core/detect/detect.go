@@ -0,0 +1,365 @@
+// Package detect flags SYN floods and port scans in captured traffic
+package detect
+
+import (
+	"net/netip"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+
+	"network-app/pkg/core/analyzer"
+)
+
+// Kind is the type of a finding
+type Kind int
+
+const (
+	KindSYNFlood       Kind = iota // many half-open handshakes towards one service
+	KindVerticalScan               // one source probing many ports of one host
+	KindHorizontalScan             // one source probing one port on many hosts
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindSYNFlood:
+		return "SYN flood"
+	case KindVerticalScan:
+		return "vertical port scan"
+	case KindHorizontalScan:
+		return "horizontal port scan"
+	}
+	return "unknown"
+}
+
+// Config holds the detection window and thresholds
+type Config struct {
+	Window        time.Duration // traffic is evaluated in windows of this length
+	FloodRate     float64       // SYNs per second towards one service
+	FloodHalfOpen float64       // fraction of those SYNs never completed
+	ScanPorts     int           // distinct ports of one host probed by one source
+	ScanHosts     int           // distinct hosts probed on one port by one source
+	TopOffenders  int           // sources listed per finding
+}
+
+// DefaultConfig flags floods of 100 SYN/s and scans of 25 ports or hosts
+// within 10 seconds
+var DefaultConfig = Config{
+	Window:        10 * time.Second,
+	FloodRate:     100,
+	FloodHalfOpen: 0.8,
+	ScanPorts:     25,
+	ScanHosts:     25,
+	TopOffenders:  5,
+}
+
+// maxPending bounds the handshakes remembered per window
+const maxPending = 1 << 20
+
+// Offender is a source address and the number of probes it sent
+type Offender struct {
+	Addr  netip.Addr
+	Count int
+}
+
+// Finding describes an attack seen during one or more consecutive windows
+type Finding struct {
+	Kind       Kind
+	Start, End time.Time
+	Target     string     // service for floods, host for vertical and port for horizontal scans
+	Source     netip.Addr // scanning source, invalid for floods
+	Probes     int        // SYNs (or other connection probes) sent
+	HalfOpen   int        // floods: SYNs whose handshake never completed
+	Sources    int        // floods: distinct source addresses
+	Spoofed    bool       // floods: nearly every source sent a single SYN
+	Distinct   int        // scans: ports or hosts probed
+	Resets     int        // RSTs sent back to the sources
+	TopSources []Offender // floods: busiest sources
+}
+
+type findingKey struct {
+	kind   Kind
+	target string
+	source netip.Addr
+}
+
+// handshake identifies a SYN waiting for the client's final ACK
+type handshake struct {
+	src, dst netip.AddrPort
+}
+
+type service struct {
+	syns      int
+	completed int
+	sources   map[netip.Addr]int
+	resets    int
+}
+
+type scanner struct {
+	probes    int
+	completed int
+	resets    int
+	ports     map[netip.Addr]map[uint16]struct{} // by host
+	hosts     map[uint16]map[netip.Addr]struct{} // by port
+}
+
+// window accumulates the traffic of one detection window
+type window struct {
+	start, last time.Time
+	services    map[netip.AddrPort]*service
+	scanners    map[netip.Addr]*scanner
+	pending     map[handshake]uint32 // client ISN
+}
+
+func newWindow(start time.Time) *window {
+	return &window{
+		start:    start,
+		last:     start,
+		services: make(map[netip.AddrPort]*service),
+		scanners: make(map[netip.Addr]*scanner),
+		pending:  make(map[handshake]uint32),
+	}
+}
+
+// Detector evaluates the packet stream window by window. Time is driven by
+// packet timestamps, so replayed captures give the same findings as live
+// ones.
+type Detector struct {
+	cfg      Config
+	win      *window
+	findings []*Finding
+	last     map[findingKey]*Finding // findings of the previous window, for merging
+}
+
+// New creates a detector
+func New(cfg Config) *Detector {
+	return &Detector{cfg: cfg, last: make(map[findingKey]*Finding)}
+}
+
+// Process adds a packet to the current window
+func (d *Detector) Process(pkt gopacket.Packet) {
+	tcp, ok := pkt.Layer(layers.LayerTypeTCP).(*layers.TCP)
+	if !ok {
+		return
+	}
+	srcIP, dstIP, ok := analyzer.Addrs(pkt.NetworkLayer())
+	if !ok {
+		return
+	}
+	src := netip.AddrPortFrom(srcIP, uint16(tcp.SrcPort))
+	dst := netip.AddrPortFrom(dstIP, uint16(tcp.DstPort))
+
+	ts := pkt.Metadata().Timestamp
+	if d.win == nil {
+		d.win = newWindow(ts)
+	}
+	if ts.Sub(d.win.start) >= d.cfg.Window {
+		d.evaluate(d.win.start.Add(d.cfg.Window))
+		// skip empty windows
+		start := d.win.start.Add(ts.Sub(d.win.start).Truncate(d.cfg.Window))
+		d.win = newWindow(start)
+	}
+	if ts.After(d.win.last) {
+		d.win.last = ts
+	}
+	w := d.win
+
+	switch {
+	case tcp.RST:
+		if s := w.services[src]; s != nil {
+			s.resets++
+		}
+		if sc := w.scanners[dstIP]; sc != nil {
+			sc.resets++
+		}
+
+	case tcp.SYN && !tcp.ACK:
+		s := w.services[dst]
+		if s == nil {
+			s = &service{sources: make(map[netip.Addr]int)}
+			w.services[dst] = s
+		}
+		s.syns++
+		s.sources[srcIP]++
+		if len(w.pending) < maxPending {
+			w.pending[handshake{src, dst}] = tcp.Seq
+		}
+		d.probe(srcIP, dst)
+
+	case tcp.SYN:
+		// SYN-ACK
+
+	case !tcp.ACK:
+		// FIN, NULL and Xmas probes
+		d.probe(srcIP, dst)
+
+	default:
+		k := handshake{src, dst}
+		if isn, ok := w.pending[k]; ok && tcp.Seq == isn+1 {
+			delete(w.pending, k)
+			if s := w.services[dst]; s != nil {
+				s.completed++
+			}
+			if sc := w.scanners[srcIP]; sc != nil {
+				sc.completed++
+			}
+		}
+	}
+}
+
+// probe records a connection attempt by src for scan detection
+func (d *Detector) probe(src netip.Addr, dst netip.AddrPort) {
+	sc := d.win.scanners[src]
+	if sc == nil {
+		sc = &scanner{
+			ports: make(map[netip.Addr]map[uint16]struct{}),
+			hosts: make(map[uint16]map[netip.Addr]struct{}),
+		}
+		d.win.scanners[src] = sc
+	}
+	sc.probes++
+	ports := sc.ports[dst.Addr()]
+	if ports == nil {
+		ports = make(map[uint16]struct{})
+		sc.ports[dst.Addr()] = ports
+	}
+	ports[dst.Port()] = struct{}{}
+	hosts := sc.hosts[dst.Port()]
+	if hosts == nil {
+		hosts = make(map[netip.Addr]struct{})
+		sc.hosts[dst.Port()] = hosts
+	}
+	hosts[dst.Addr()] = struct{}{}
+}
+
+// Finish evaluates the last, possibly partial, window
+func (d *Detector) Finish() {
+	if d.win != nil {
+		d.evaluate(d.win.last)
+		d.win = nil
+	}
+}
+
+// Findings returns everything flagged so far, earliest first
+func (d *Detector) Findings() []Finding {
+	out := make([]Finding, len(d.findings))
+	for i, f := range d.findings {
+		out[i] = *f
+	}
+	sort.Slice(out, func(i, j int) bool {
+		a, b := &out[i], &out[j]
+		switch {
+		case !a.Start.Equal(b.Start):
+			return a.Start.Before(b.Start)
+		case a.Kind != b.Kind:
+			return a.Kind < b.Kind
+		case a.Target != b.Target:
+			return a.Target < b.Target
+		}
+		return a.Source.Less(b.Source)
+	})
+	return out
+}
+
+// evaluate turns the current window into findings, merging them with
+// findings of the previous window
+func (d *Detector) evaluate(end time.Time) {
+	w := d.win
+	secs := end.Sub(w.start).Seconds()
+	if secs < 1 {
+		secs = 1
+	}
+	current := make(map[findingKey]*Finding)
+	add := func(f *Finding) {
+		k := findingKey{f.Kind, f.Target, f.Source}
+		if prev := d.last[k]; prev != nil && !prev.End.Before(w.start) {
+			prev.merge(f, d.cfg.TopOffenders)
+			current[k] = prev
+			return
+		}
+		d.findings = append(d.findings, f)
+		current[k] = f
+	}
+
+	for addr, s := range w.services {
+		halfOpen := s.syns - s.completed
+		if float64(s.syns)/secs < d.cfg.FloodRate || float64(halfOpen) < d.cfg.FloodHalfOpen*float64(s.syns) {
+			continue
+		}
+		add(&Finding{
+			Kind:       KindSYNFlood,
+			Start:      w.start,
+			End:        end,
+			Target:     addr.String(),
+			Probes:     s.syns,
+			HalfOpen:   halfOpen,
+			Sources:    len(s.sources),
+			Spoofed:    len(s.sources)*2 >= s.syns,
+			Resets:     s.resets,
+			TopSources: top(s.sources, d.cfg.TopOffenders),
+		})
+	}
+
+	for src, sc := range w.scanners {
+		if sc.completed*2 >= sc.probes {
+			// a busy client, not a scanner
+			continue
+		}
+		for host, ports := range sc.ports {
+			if len(ports) >= d.cfg.ScanPorts {
+				add(&Finding{Kind: KindVerticalScan, Start: w.start, End: end, Target: host.String(),
+					Source: src, Probes: sc.probes, Distinct: len(ports), Resets: sc.resets})
+			}
+		}
+		for port, hosts := range sc.hosts {
+			if len(hosts) >= d.cfg.ScanHosts {
+				add(&Finding{Kind: KindHorizontalScan, Start: w.start, End: end, Target: portString(port),
+					Source: src, Probes: sc.probes, Distinct: len(hosts), Resets: sc.resets})
+			}
+		}
+	}
+	d.last = current
+}
+
+// merge extends f with the finding of the following window
+func (f *Finding) merge(o *Finding, n int) {
+	f.End = o.End
+	f.Probes += o.Probes
+	f.HalfOpen += o.HalfOpen
+	f.Resets += o.Resets
+	f.Sources = max(f.Sources, o.Sources)
+	f.Distinct = max(f.Distinct, o.Distinct)
+	f.Spoofed = f.Spoofed || o.Spoofed
+	if len(o.TopSources) == 0 {
+		return
+	}
+	counts := make(map[netip.Addr]int)
+	for _, s := range append(f.TopSources, o.TopSources...) {
+		counts[s.Addr] += s.Count
+	}
+	f.TopSources = top(counts, n)
+}
+
+// top returns the n sources with the highest counts
+func top(counts map[netip.Addr]int, n int) []Offender {
+	out := make([]Offender, 0, len(counts))
+	for a, c := range counts {
+		out = append(out, Offender{Addr: a, Count: c})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Count != out[j].Count {
+			return out[i].Count > out[j].Count
+		}
+		return out[i].Addr.Less(out[j].Addr)
+	})
+	if len(out) > n {
+		out = out[:n]
+	}
+	return out
+}
+
+func portString(port uint16) string {
+	return "port " + strconv.Itoa(int(port))
+}
@@ -0,0 +1,123 @@
+package detect
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+
+	"network-app/pkg/core/internal/packettest"
+)
+
+// segment builds a TCP segment captured at the given offset from the packettest.Epoch
+func segment(t *testing.T, src, dst netip.AddrPort, flags string, seq uint32, at time.Duration) gopacket.Packet {
+	t.Helper()
+	ip, et := packettest.IP(src.Addr(), dst.Addr(), layers.IPProtocolTCP)
+	tcp := &layers.TCP{SrcPort: layers.TCPPort(src.Port()), DstPort: layers.TCPPort(dst.Port()), Seq: seq, Window: 1024}
+	for _, c := range flags {
+		switch c {
+		case 'S':
+			tcp.SYN = true
+		case 'A':
+			tcp.ACK = true
+		case 'R':
+			tcp.RST = true
+		case 'F':
+			tcp.FIN = true
+		}
+	}
+	tcp.SetNetworkLayerForChecksum(ip)
+	return packettest.Frame(t, at, packettest.Ethernet(et), ip.(gopacket.SerializableLayer), tcp)
+}
+
+func addr(a, b byte, port uint16) netip.AddrPort {
+	return netip.AddrPortFrom(netip.AddrFrom4([4]byte{10, 0, a, b}), port)
+}
+
+func TestSYNFlood(t *testing.T) {
+	d := New(DefaultConfig)
+	target := addr(0, 1, 80)
+	// 2000 SYNs from 1000 spoofed sources over 15 seconds, spanning two windows
+	for i := 0; i < 2000; i++ {
+		src := addr(byte(1+i%1000/250), byte(i%250), uint16(1024+i))
+		at := time.Duration(i) * 15 * time.Second / 2000
+		d.Process(segment(t, src, target, "S", 1, at))
+	}
+	// a few legitimate handshakes complete
+	for i := 0; i < 10; i++ {
+		src := addr(9, byte(i), 40000)
+		d.Process(segment(t, src, target, "S", 100, 2*time.Second))
+		d.Process(segment(t, target, src, "SA", 500, 2*time.Second))
+		d.Process(segment(t, src, target, "A", 101, 2*time.Second))
+	}
+	d.Finish()
+
+	findings := d.Findings()
+	if len(findings) != 1 {
+		t.Fatalf("findings = %+v, want one flood", findings)
+	}
+	f := findings[0]
+	if f.Kind != KindSYNFlood || f.Target != target.String() {
+		t.Errorf("finding = %v on %s", f.Kind, f.Target)
+	}
+	if f.Probes != 2010 || f.HalfOpen != 2000 || !f.Spoofed {
+		t.Errorf("Probes, HalfOpen, Spoofed = %d, %d, %v", f.Probes, f.HalfOpen, f.Spoofed)
+	}
+	if !f.Start.Equal(packettest.Epoch) || f.End.Before(packettest.Epoch.Add(14*time.Second)) {
+		t.Errorf("window = %v..%v, want the two windows merged", f.Start, f.End)
+	}
+	if len(f.TopSources) != DefaultConfig.TopOffenders || f.TopSources[0].Count != 2 {
+		t.Errorf("TopSources = %+v", f.TopSources)
+	}
+}
+
+func TestPortScans(t *testing.T) {
+	d := New(DefaultConfig)
+	scanner := netip.MustParseAddr("192.0.2.66")
+	victim := addr(0, 1, 0)
+	for port := uint16(1); port <= 100; port++ {
+		at := time.Duration(port) * 10 * time.Millisecond
+		src := netip.AddrPortFrom(scanner, 50000)
+		dst := netip.AddrPortFrom(victim.Addr(), port)
+		d.Process(segment(t, src, dst, "S", 1, at))
+		d.Process(segment(t, dst, src, "RA", 0, at))
+	}
+	// FIN probes to port 22 across a subnet
+	for host := byte(1); host <= 30; host++ {
+		src := netip.AddrPortFrom(scanner, 50001)
+		d.Process(segment(t, src, addr(1, host, 22), "F", 1, 2*time.Second))
+	}
+	d.Finish()
+
+	findings := d.Findings()
+	if len(findings) != 2 {
+		t.Fatalf("findings = %+v, want a vertical and a horizontal scan", findings)
+	}
+	v, h := findings[0], findings[1]
+	if v.Kind != KindVerticalScan || v.Source != scanner || v.Target != victim.Addr().String() || v.Distinct != 100 || v.Resets != 100 {
+		t.Errorf("vertical = %+v", v)
+	}
+	if h.Kind != KindHorizontalScan || h.Target != "port 22" || h.Distinct != 31 { // the vertical scan hit port 22 as well
+		t.Errorf("horizontal = %+v", h)
+	}
+}
+
+func TestNormalTraffic(t *testing.T) {
+	d := New(DefaultConfig)
+	client := netip.MustParseAddr("192.168.1.10")
+	// a browser opening 50 connections to 50 servers, all completing
+	for i := 0; i < 50; i++ {
+		src := netip.AddrPortFrom(client, uint16(40000+i))
+		dst := addr(2, byte(i), 443)
+		at := time.Duration(i) * 100 * time.Millisecond
+		d.Process(segment(t, src, dst, "S", 7, at))
+		d.Process(segment(t, dst, src, "SA", 9, at))
+		d.Process(segment(t, src, dst, "A", 8, at))
+	}
+	d.Finish()
+	if findings := d.Findings(); len(findings) != 0 {
+		t.Errorf("findings = %+v, want none", findings)
+	}
+}
@@ -0,0 +1,438 @@
+// Package dhcp follows DHCPv4 and DHCPv6 exchanges, from the client's
+// first DISCOVER or SOLICIT to the server's ACK or REPLY. Exchanges that
+// never get an offer or an acknowledgement explain why a host has no
+// address, and offers from more than one server point at a rogue server.
+package dhcp
+
+import (
+	"net/netip"
+	"sort"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+
+	"network-app/pkg/core/analyzer"
+	"network-app/pkg/core/tcp"
+)
+
+// Config holds the timeouts and table limits
+type Config struct {
+	IdleTimeout     time.Duration // exchanges silent for this long are over
+	ResponseTimeout time.Duration // exchanges younger than this at the end of the capture are pending
+	MaxExchanges    int           // exchanges tracked at once; new ones are dropped and counted once full
+	MaxClients      int           // clients tracked; exchanges beyond count in the totals only
+	MaxServers      int
+}
+
+// DefaultConfig gives up on an exchange after 90 seconds of silence,
+// longer than the 64-second retransmission backoff of RFC 2131
+var DefaultConfig = Config{
+	IdleTimeout:     90 * time.Second,
+	ResponseTimeout: 5 * time.Second,
+	MaxExchanges:    100000,
+	MaxClients:      10000,
+	MaxServers:      1000,
+}
+
+// Outcome is how an exchange ended
+type Outcome int
+
+const (
+	OutcomeAcked   Outcome = iota // the server acknowledged the lease or answered the query
+	OutcomeNak                    // the server refused the request
+	OutcomeNoOffer                // nothing answered the DISCOVER or SOLICIT
+	OutcomeNoAck                  // offered or requested, but never acknowledged
+	OutcomePending                // still in progress when the capture ended
+)
+
+func (o Outcome) String() string {
+	switch o {
+	case OutcomeAcked:
+		return "acked"
+	case OutcomeNak:
+		return "nak"
+	case OutcomeNoOffer:
+		return "no offer"
+	case OutcomeNoAck:
+		return "no ack"
+	case OutcomePending:
+		return "pending"
+	}
+	return "unknown"
+}
+
+// Stats counts every exchange
+type Stats struct {
+	Exchanges   int
+	Acked       int
+	Naks        int
+	NoOffer     int
+	NoAck       int
+	Pending     int
+	Renewals    int // exchanges for a lease the client already held, rather than a new one
+	Informs     int // exchanges for configuration only
+	Retransmits int // client messages repeated within an exchange
+	Competing   int // exchanges offered by more than one server
+	Declines    int // addresses the client found in use after the ACK
+	Releases    int
+	Evicted     int            // exchanges not tracked because the table was full
+	Messages    map[string]int // by message name, e.g. DHCPDISCOVER or SOLICIT
+	Statuses    map[string]int // DHCPv6 error statuses in replies
+	OfferTime   tcp.Histogram  // first DISCOVER or SOLICIT to the first offer
+	AcquireTime tcp.Histogram  // first client message to the ACK, for new and renewed leases
+}
+
+// Server holds what one DHCP server offered and acknowledged
+type Server struct {
+	ID        string     // server identifier: an IPv4 address, or a DUID for DHCPv6
+	Address   netip.Addr // source of its last message; a relay agent when relayed
+	V6        bool
+	Offers    int
+	Acks      int
+	Naks      int
+	Competing int   // exchanges another server offered for too
+	Lease     Lease // of its last offer or acknowledgement
+}
+
+// Client holds the exchanges of one client
+type Client struct {
+	ID          string // hardware address, or DUID for DHCPv6
+	V6          bool
+	Exchanges   int
+	Acked       int
+	Naks        int
+	NoOffer     int
+	NoAck       int
+	Declines    int
+	Retransmits int
+	Last        Outcome
+	Address     netip.Addr // last address acknowledged
+	Server      string     // identifier of the server that acknowledged it
+}
+
+// Problems returns the exchanges of the client that failed, and the
+// addresses it declined
+func (c *Client) Problems() int {
+	return c.Naks + c.NoOffer + c.NoAck + c.Declines
+}
+
+// key identifies a client or a server
+type key struct {
+	v6 bool
+	id string
+}
+
+// exchange is the pursuit of one lease or configuration by a client.
+// DHCPv6 clients pick a new transaction ID for the REQUEST that follows
+// an ADVERTISE, so exchanges are tracked per client and remember their
+// transaction IDs.
+type exchange struct {
+	key         key
+	kind        kind // of the first message
+	xids        []uint32
+	last        kind
+	start, seen time.Time
+	offered     map[string]bool // servers that offered
+}
+
+// maxXIDs bounds the transaction IDs remembered per exchange
+const maxXIDs = 8
+
+func (e *exchange) has(xid uint32) bool {
+	for _, x := range e.xids {
+		if x == xid {
+			return true
+		}
+	}
+	return false
+}
+
+// Analyzer follows DHCP exchanges. Time is driven by packet timestamps.
+type Analyzer struct {
+	cfg       Config
+	stats     Stats
+	exchanges map[key]*exchange
+	clients   map[key]*Client
+	servers   map[key]*Server
+	clock     analyzer.Clock
+}
+
+// NewAnalyzer creates a DHCP analyzer
+func NewAnalyzer(cfg Config) *Analyzer {
+	return &Analyzer{
+		cfg: cfg,
+		stats: Stats{
+			Messages: make(map[string]int),
+			Statuses: make(map[string]int),
+		},
+		exchanges: make(map[key]*exchange),
+		clients:   make(map[key]*Client),
+		servers:   make(map[key]*Server),
+	}
+}
+
+// Process handles a single packet
+func (a *Analyzer) Process(pkt gopacket.Packet) {
+	var m *message
+	var ok bool
+	if d, is := pkt.Layer(layers.LayerTypeDHCPv4).(*layers.DHCPv4); is {
+		m, ok = fromV4(d)
+	} else if d, is := pkt.Layer(layers.LayerTypeDHCPv6).(*layers.DHCPv6); is {
+		m, ok = fromV6(d)
+	}
+	if !ok {
+		return
+	}
+	src, _, _ := analyzer.Addrs(pkt.NetworkLayer())
+	ts := pkt.Metadata().Timestamp
+	a.advance(ts)
+	a.stats.Messages[m.name]++
+	if m.status != "" {
+		a.stats.Statuses[m.status]++
+	}
+	if m.client == "" {
+		return
+	}
+	k := key{m.v6, m.client}
+	switch m.kind {
+	case kindDiscover, kindRequest, kindRenew, kindInform:
+		a.request(k, m, ts)
+	case kindOffer, kindAck, kindNak:
+		a.reply(k, m, src, ts)
+	case kindDecline:
+		a.stats.Declines++
+		if c := a.client(k); c != nil {
+			c.Declines++
+		}
+	case kindRelease:
+		a.stats.Releases++
+	}
+}
+
+// request handles a client message, starting an exchange unless one is
+// in progress
+func (a *Analyzer) request(k key, m *message, ts time.Time) {
+	e := a.exchanges[k]
+	if e == nil {
+		if len(a.exchanges) >= a.cfg.MaxExchanges {
+			a.stats.Evicted++
+			return
+		}
+		e = &exchange{key: k, kind: m.kind, start: ts, offered: make(map[string]bool)}
+		a.exchanges[k] = e
+	} else if m.kind == e.last {
+		a.stats.Retransmits++
+		if c := a.client(k); c != nil {
+			c.Retransmits++
+		}
+	}
+	if !e.has(m.xid) && len(e.xids) < maxXIDs {
+		e.xids = append(e.xids, m.xid)
+	}
+	e.last = m.kind
+	e.seen = ts
+}
+
+// reply handles a server message answering an exchange in progress
+func (a *Analyzer) reply(k key, m *message, src netip.Addr, ts time.Time) {
+	e := a.exchanges[k]
+	if e == nil || !e.has(m.xid) {
+		return
+	}
+	e.seen = ts
+	if m.server == "" {
+		m.server = src.String()
+	}
+	s := a.server(m, src)
+	switch m.kind {
+	case kindOffer:
+		if len(e.offered) == 0 {
+			a.stats.OfferTime.Record(ts.Sub(e.start))
+		}
+		e.offered[m.server] = true
+		if s != nil {
+			s.Offers++
+			s.Lease = m.lease
+		}
+	case kindAck:
+		if s != nil {
+			s.Acks++
+			s.Lease = m.lease
+		}
+		if e.kind != kindInform {
+			a.stats.AcquireTime.Record(ts.Sub(e.start))
+		}
+		delete(a.exchanges, k)
+		a.finish(e, OutcomeAcked, m)
+	case kindNak:
+		if s != nil {
+			s.Naks++
+		}
+		delete(a.exchanges, k)
+		a.finish(e, OutcomeNak, m)
+	}
+}
+
+// server returns the server that sent m, nil once the table is full
+func (a *Analyzer) server(m *message, src netip.Addr) *Server {
+	k := key{m.v6, m.server}
+	s := a.servers[k]
+	if s == nil {
+		if len(a.servers) >= a.cfg.MaxServers {
+			return nil
+		}
+		s = &Server{ID: m.server, V6: m.v6}
+		a.servers[k] = s
+	}
+	s.Address = src
+	return s
+}
+
+// client returns the client of k, nil once the table is full
+func (a *Analyzer) client(k key) *Client {
+	c := a.clients[k]
+	if c == nil {
+		if len(a.clients) >= a.cfg.MaxClients {
+			return nil
+		}
+		c = &Client{ID: k.id, V6: k.v6}
+		a.clients[k] = c
+	}
+	return c
+}
+
+// advance moves the clock and, about once per second of capture time,
+// gives up on idle exchanges
+func (a *Analyzer) advance(ts time.Time) {
+	if !a.clock.Advance(ts) {
+		return
+	}
+	for k, e := range a.exchanges {
+		if a.clock.Now().Sub(e.seen) >= a.cfg.IdleTimeout {
+			delete(a.exchanges, k)
+			a.finish(e, outcome(e), nil)
+		}
+	}
+}
+
+// outcome classifies an exchange that got no final answer
+func outcome(e *exchange) Outcome {
+	if e.kind == kindDiscover && len(e.offered) == 0 {
+		return OutcomeNoOffer
+	}
+	return OutcomeNoAck
+}
+
+// finish folds an exchange into the statistics; m is the final answer,
+// if any
+func (a *Analyzer) finish(e *exchange, o Outcome, m *message) {
+	st := &a.stats
+	st.Exchanges++
+	switch e.kind {
+	case kindRenew:
+		st.Renewals++
+	case kindInform:
+		st.Informs++
+	}
+	if len(e.offered) > 1 {
+		st.Competing++
+		for id := range e.offered {
+			if s := a.servers[key{e.key.v6, id}]; s != nil {
+				s.Competing++
+			}
+		}
+	}
+	switch o {
+	case OutcomeAcked:
+		st.Acked++
+	case OutcomeNak:
+		st.Naks++
+	case OutcomeNoOffer:
+		st.NoOffer++
+	case OutcomeNoAck:
+		st.NoAck++
+	case OutcomePending:
+		st.Pending++
+	}
+
+	c := a.client(e.key)
+	if c == nil {
+		return
+	}
+	c.Exchanges++
+	c.Last = o
+	switch o {
+	case OutcomeAcked:
+		c.Acked++
+		if m != nil && m.lease.Address.IsValid() {
+			c.Address = m.lease.Address
+			c.Server = m.server
+		}
+	case OutcomeNak:
+		c.Naks++
+	case OutcomeNoOffer:
+		c.NoOffer++
+	case OutcomeNoAck:
+		c.NoAck++
+	}
+}
+
+// Finish classifies the exchanges still open
+func (a *Analyzer) Finish() {
+	for k, e := range a.exchanges {
+		delete(a.exchanges, k)
+		o := outcome(e)
+		if a.clock.Now().Sub(e.start) < a.cfg.ResponseTimeout {
+			o = OutcomePending
+		}
+		a.finish(e, o, nil)
+	}
+}
+
+// Stats returns the totals over all exchanges. Only valid after Finish.
+func (a *Analyzer) Stats() Stats {
+	return a.stats
+}
+
+// Servers returns the servers that answered, DHCPv4 first, then the
+// busiest
+func (a *Analyzer) Servers() []*Server {
+	out := make([]*Server, 0, len(a.servers))
+	for _, s := range a.servers {
+		out = append(out, s)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		x, y := out[i], out[j]
+		if x.V6 != y.V6 {
+			return !x.V6
+		}
+		if x.Offers+x.Acks != y.Offers+y.Acks {
+			return x.Offers+x.Acks > y.Offers+y.Acks
+		}
+		return x.ID < y.ID
+	})
+	return out
+}
+
+// Clients returns the clients, most problems first, then the busiest
+func (a *Analyzer) Clients() []*Client {
+	out := make([]*Client, 0, len(a.clients))
+	for _, c := range a.clients {
+		out = append(out, c)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		x, y := out[i], out[j]
+		if x.Problems() != y.Problems() {
+			return x.Problems() > y.Problems()
+		}
+		if x.Exchanges != y.Exchanges {
+			return x.Exchanges > y.Exchanges
+		}
+		if x.V6 != y.V6 {
+			return !x.V6
+		}
+		return x.ID < y.ID
+	})
+	return out
+}
@@ -0,0 +1,248 @@
+package dhcp
+
+import (
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+
+	"network-app/pkg/core/internal/packettest"
+)
+
+// v4 builds a broadcast DHCPv4 message from the client with the given
+// MAC, or from the server at src when src is set
+func v4(t *testing.T, at time.Duration, src string, typ layers.DHCPMsgType, xid uint32, client byte, yiaddr string, opts ...layers.DHCPOption) gopacket.Packet {
+	t.Helper()
+	d := &layers.DHCPv4{Operation: layers.DHCPOpRequest, HardwareType: layers.LinkTypeEthernet, Xid: xid, ClientHWAddr: packettest.MAC(client),
+		ClientIP: net.IPv4zero, YourClientIP: net.IPv4zero, NextServerIP: net.IPv4zero, RelayAgentIP: net.IPv4zero,
+		Options: append(layers.DHCPOptions{layers.NewDHCPOption(layers.DHCPOptMessageType, []byte{byte(typ)})}, opts...)}
+	ip := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolUDP, SrcIP: net.IPv4zero, DstIP: net.IPv4bcast}
+	udp := &layers.UDP{SrcPort: 68, DstPort: 67}
+	ethSrc := packettest.MAC(client)
+	if src != "" {
+		d.Operation = layers.DHCPOpReply
+		d.YourClientIP = net.ParseIP(yiaddr)
+		ip.SrcIP = net.ParseIP(src)
+		udp.SrcPort, udp.DstPort = 67, 68
+		ethSrc = packettest.MAC(1)
+	}
+	udp.SetNetworkLayerForChecksum(ip)
+	return packettest.Frame(t, at, &layers.Ethernet{SrcMAC: ethSrc, DstMAC: layers.EthernetBroadcast, EthernetType: layers.EthernetTypeIPv4}, ip, udp, d)
+}
+
+func opt(typ layers.DHCPOpt, data ...byte) layers.DHCPOption {
+	return layers.NewDHCPOption(typ, data)
+}
+
+func ip4(s ...string) []byte {
+	var out []byte
+	for _, a := range s {
+		out = append(out, net.ParseIP(a).To4()...)
+	}
+	return out
+}
+
+// v6 builds a DHCPv6 message; servers answer from src to the client's
+// link-local address
+func v6(t *testing.T, at time.Duration, server bool, typ layers.DHCPv6MsgType, xid uint32, opts ...layers.DHCPv6Option) gopacket.Packet {
+	t.Helper()
+	d := &layers.DHCPv6{MsgType: typ, TransactionID: []byte{byte(xid >> 16), byte(xid >> 8), byte(xid)}, Options: opts}
+	ip := &layers.IPv6{Version: 6, HopLimit: 1, NextHeader: layers.IPProtocolUDP,
+		SrcIP: net.ParseIP("fe80::10"), DstIP: net.ParseIP("ff02::1:2")}
+	udp := &layers.UDP{SrcPort: 546, DstPort: 547}
+	if server {
+		ip.SrcIP, ip.DstIP = net.ParseIP("fe80::1"), net.ParseIP("fe80::10")
+		udp.SrcPort, udp.DstPort = 547, 546
+	}
+	udp.SetNetworkLayerForChecksum(ip)
+	return packettest.Frame(t, at, &layers.Ethernet{SrcMAC: packettest.MAC(1), DstMAC: packettest.MAC(2), EthernetType: layers.EthernetTypeIPv6}, ip, udp, d)
+}
+
+// duid returns a DUID-LL (RFC 8415 section 11.4) for the MAC ending in n
+func duid(n byte) []byte {
+	return append([]byte{0, 3, 0, 1}, packettest.MAC(n)...)
+}
+
+// option6 encodes a DHCPv6 option, for nesting in another
+func option6(code layers.DHCPv6Opt, data []byte) []byte {
+	b := binary.BigEndian.AppendUint16(nil, uint16(code))
+	b = binary.BigEndian.AppendUint16(b, uint16(len(data)))
+	return append(b, data...)
+}
+
+// iana builds an identity association holding addr, or the status code
+// when addr is empty
+func iana(addr string, status uint16) layers.DHCPv6Option {
+	data := make([]byte, 12)
+	if addr != "" {
+		a := append(net.ParseIP(addr).To16(), 0, 0, 0x0e, 0x10, 0, 0, 0x1c, 0x20) // 3600 s preferred, 7200 s valid
+		data = append(data, option6(layers.DHCPv6OptIAAddr, a)...)
+	} else {
+		data = append(data, option6(layers.DHCPv6OptStatusCode, binary.BigEndian.AppendUint16(nil, status))...)
+	}
+	return layers.NewDHCPv6Option(layers.DHCPv6OptIANA, data)
+}
+
+func TestDHCPv4(t *testing.T) {
+	ms, s := time.Millisecond, time.Second
+	lease := []layers.DHCPOption{
+		opt(layers.DHCPOptServerID, ip4("10.0.0.1")...),
+		opt(layers.DHCPOptSubnetMask, 255, 255, 255, 0),
+		opt(layers.DHCPOptRouter, ip4("10.0.0.1")...),
+		opt(layers.DHCPOptDNS, ip4("10.0.0.53", "1.1.1.1")...),
+		opt(layers.DHCPOptDomainName, []byte("example.lan")...),
+		opt(layers.DHCPOptLeaseTime, 0, 0, 0x0e, 0x10),
+	}
+	rogue := []layers.DHCPOption{
+		opt(layers.DHCPOptServerID, ip4("10.0.0.66")...),
+		opt(layers.DHCPOptRouter, ip4("10.0.0.66")...),
+	}
+	a := NewAnalyzer(DefaultConfig)
+	for _, p := range []gopacket.Packet{
+		// offered by two servers, acknowledged by the first one asked
+		v4(t, 0, "", layers.DHCPMsgTypeDiscover, 1, 10, ""),
+		v4(t, 3*ms, "10.0.0.66", layers.DHCPMsgTypeOffer, 1, 10, "192.168.100.50", rogue...),
+		v4(t, 5*ms, "10.0.0.1", layers.DHCPMsgTypeOffer, 1, 10, "10.0.0.100", lease...),
+		v4(t, 10*ms, "", layers.DHCPMsgTypeRequest, 1, 10, "", opt(layers.DHCPOptServerID, ip4("10.0.0.1")...)),
+		v4(t, 12*ms, "10.0.0.1", layers.DHCPMsgTypeAck, 1, 10, "10.0.0.100", lease...),
+		// a lease held by another host
+		v4(t, s, "", layers.DHCPMsgTypeDecline, 7, 10, ""),
+		// rebooting with a lease from another network
+		v4(t, s, "", layers.DHCPMsgTypeRequest, 3, 30, "", opt(layers.DHCPOptRequestIP, ip4("172.16.0.9")...)),
+		v4(t, s+2*ms, "10.0.0.1", layers.DHCPMsgTypeNak, 3, 30, "", opt(layers.DHCPOptServerID, ip4("10.0.0.1")...)),
+		// offered, but the request goes unanswered
+		v4(t, 2*s, "", layers.DHCPMsgTypeDiscover, 4, 40, ""),
+		v4(t, 2*s+4*ms, "10.0.0.1", layers.DHCPMsgTypeOffer, 4, 40, "10.0.0.101", lease...),
+		v4(t, 2*s+10*ms, "", layers.DHCPMsgTypeRequest, 4, 40, "", opt(layers.DHCPOptServerID, ip4("10.0.0.1")...)),
+		// an offer for an exchange not captured
+		v4(t, 3*s, "10.0.0.1", layers.DHCPMsgTypeOffer, 99, 50, "10.0.0.102", lease...),
+		// nobody answers, despite retries
+		v4(t, 0, "", layers.DHCPMsgTypeDiscover, 2, 20, ""),
+		v4(t, 4*s, "", layers.DHCPMsgTypeDiscover, 2, 20, ""),
+		v4(t, 12*s, "", layers.DHCPMsgTypeDiscover, 2, 20, ""),
+		// still waiting at the end of the capture
+		v4(t, 118*s, "", layers.DHCPMsgTypeDiscover, 6, 60, ""),
+		v4(t, 120*s, "", layers.DHCPMsgTypeDiscover, 6, 60, ""),
+	} {
+		a.Process(p)
+	}
+	a.Finish()
+
+	st := a.Stats()
+	if st.Exchanges != 5 || st.Acked != 1 || st.Naks != 1 || st.NoOffer != 1 || st.NoAck != 1 || st.Pending != 1 {
+		t.Errorf("outcomes = %+v", st)
+	}
+	if st.Renewals != 1 || st.Retransmits != 3 || st.Competing != 1 || st.Declines != 1 {
+		t.Errorf("stats = %+v", st)
+	}
+	if st.Messages["DHCPDISCOVER"] != 7 || st.Messages["DHCPOFFER"] != 4 || st.Messages["DHCPREQUEST"] != 3 || st.Messages["DHCPNAK"] != 1 {
+		t.Errorf("messages = %v", st.Messages)
+	}
+	if st.OfferTime.Count() != 2 || st.OfferTime.Max() != 4*ms || st.AcquireTime.Count() != 1 || st.AcquireTime.Max() != 12*ms {
+		t.Errorf("offer time max %v, acquire time max %v", st.OfferTime.Max(), st.AcquireTime.Max())
+	}
+
+	servers := a.Servers()
+	if len(servers) != 2 {
+		t.Fatalf("servers = %+v", servers)
+	}
+	if s := servers[0]; s.ID != "10.0.0.1" || s.Offers != 2 || s.Acks != 1 || s.Naks != 1 || s.Competing != 1 {
+		t.Errorf("first server = %+v", s)
+	}
+	l := servers[0].Lease
+	if l.Address.String() != "10.0.0.101" || l.Subnet.String() != "10.0.0.0/24" || len(l.Routers) != 1 || len(l.DNS) != 2 ||
+		l.Domain != "example.lan" || l.Time != time.Hour {
+		t.Errorf("lease = %+v", l)
+	}
+	if s := servers[1]; s.ID != "10.0.0.66" || s.Offers != 1 || s.Competing != 1 || s.Lease.Routers[0].String() != "10.0.0.66" {
+		t.Errorf("second server = %+v", s)
+	}
+
+	clients := a.Clients()
+	if len(clients) != 5 {
+		t.Fatalf("clients = %+v", clients)
+	}
+	if c := clients[0]; c.ID != packettest.MAC(10).String() || c.Acked != 1 || c.Declines != 1 || c.Address.String() != "10.0.0.100" || c.Server != "10.0.0.1" {
+		t.Errorf("first client = %+v", c)
+	}
+	if c := clients[1]; c.ID != packettest.MAC(20).String() || c.NoOffer != 1 || c.Retransmits != 2 || c.Last != OutcomeNoOffer {
+		t.Errorf("second client = %+v", c)
+	}
+	if c := clients[2]; c.ID != packettest.MAC(30).String() || c.Naks != 1 {
+		t.Errorf("third client = %+v", c)
+	}
+	if c := clients[3]; c.ID != packettest.MAC(40).String() || c.NoAck != 1 {
+		t.Errorf("fourth client = %+v", c)
+	}
+	if c := clients[4]; c.ID != packettest.MAC(60).String() || c.Last != OutcomePending {
+		t.Errorf("fifth client = %+v", c)
+	}
+}
+
+func TestDHCPv6(t *testing.T) {
+	ms, s := time.Millisecond, time.Second
+	client := func(n byte) layers.DHCPv6Option { return layers.NewDHCPv6Option(layers.DHCPv6OptClientID, duid(n)) }
+	server := layers.NewDHCPv6Option(layers.DHCPv6OptServerID, duid(1))
+	dns := layers.NewDHCPv6Option(layers.DHCPv6OptDNSServers, net.ParseIP("2001:db8::53"))
+	domain := layers.NewDHCPv6Option(layers.DHCPv6OptDomainList, append([]byte{7}, "example\x03lan\x00"...))
+	notOnLink := layers.NewDHCPv6Option(layers.DHCPv6OptStatusCode, []byte{0, 4})
+	a := NewAnalyzer(DefaultConfig)
+	for _, p := range []gopacket.Packet{
+		// the REQUEST starts a new transaction
+		v6(t, 0, false, layers.DHCPv6MsgTypeSolicit, 0x111, client(10)),
+		v6(t, 2*ms, true, layers.DHCPv6MsgTypeAdverstise, 0x111, client(10), server, iana("2001:db8::100", 0)),
+		v6(t, 5*ms, false, layers.DHCPv6MsgTypeRequest, 0x222, client(10), server),
+		v6(t, 8*ms, true, layers.DHCPv6MsgTypeReply, 0x222, client(10), server, iana("2001:db8::100", 0), dns, domain),
+		// moved to another link
+		v6(t, s, false, layers.DHCPv6MsgTypeConfirm, 0x333, client(20)),
+		v6(t, s+ms, true, layers.DHCPv6MsgTypeReply, 0x333, client(20), server, notOnLink),
+		// the pool is exhausted
+		v6(t, 2*s, false, layers.DHCPv6MsgTypeSolicit, 0x444, client(30)),
+		v6(t, 2*s+ms, true, layers.DHCPv6MsgTypeAdverstise, 0x444, client(30), server, iana("", 2)),
+		// configuration only
+		v6(t, 3*s, false, layers.DHCPv6MsgTypeInformationRequest, 0x555, client(40)),
+		v6(t, 3*s+ms, true, layers.DHCPv6MsgTypeReply, 0x555, client(40), server, dns),
+	} {
+		a.Process(p)
+	}
+	a.Finish()
+
+	st := a.Stats()
+	if st.Exchanges != 4 || st.Acked != 2 || st.Naks != 2 || st.Renewals != 1 || st.Informs != 1 || st.Retransmits != 0 {
+		t.Errorf("stats = %+v", st)
+	}
+	if st.Statuses["NotOnLink"] != 1 || st.Statuses["NoAddrsAvail"] != 1 || st.Messages["SOLICIT"] != 2 || st.Messages["REPLY"] != 3 {
+		t.Errorf("statuses = %v, messages = %v", st.Statuses, st.Messages)
+	}
+	if st.OfferTime.Count() != 1 || st.AcquireTime.Count() != 1 || st.AcquireTime.Max() != 8*ms {
+		t.Errorf("acquire time max %v", st.AcquireTime.Max())
+	}
+
+	servers := a.Servers()
+	if len(servers) != 1 || !servers[0].V6 || servers[0].ID != net.HardwareAddr(duid(1)).String() ||
+		servers[0].Offers != 1 || servers[0].Acks != 2 || servers[0].Naks != 2 {
+		t.Fatalf("servers = %+v", servers)
+	}
+	if l := servers[0].Lease; len(l.DNS) != 1 || l.DNS[0] != netip.MustParseAddr("2001:db8::53") {
+		t.Errorf("lease = %+v", l)
+	}
+
+	clients := a.Clients()
+	var acked *Client
+	for _, c := range clients {
+		if c.ID == net.HardwareAddr(duid(10)).String() {
+			acked = c
+		}
+	}
+	if acked == nil || acked.Address.String() != "2001:db8::100" || acked.Acked != 1 || acked.Problems() != 0 {
+		t.Errorf("client = %+v", acked)
+	}
+	m, _ := fromV6(v6(t, 0, true, layers.DHCPv6MsgTypeReply, 1, client(10), iana("2001:db8::100", 0), domain).Layer(layers.LayerTypeDHCPv6).(*layers.DHCPv6))
+	if m.lease.Time != 2*time.Hour || m.lease.Domain != "example.lan" {
+		t.Errorf("lease = %+v", m.lease)
+	}
+}
@@ -0,0 +1,258 @@
+package dhcp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/google/gopacket/layers"
+)
+
+// kind is the role of a message in an exchange, the same for both versions
+type kind int
+
+const (
+	kindOther    kind = iota
+	kindDiscover      // DHCPDISCOVER, SOLICIT
+	kindOffer         // DHCPOFFER, ADVERTISE
+	kindRequest       // DHCPREQUEST selecting an offer, REQUEST
+	kindRenew         // DHCPREQUEST for a lease already held, RENEW, REBIND, CONFIRM
+	kindInform        // DHCPINFORM, INFORMATION-REQUEST
+	kindAck           // DHCPACK, REPLY with success
+	kindNak           // DHCPNAK, REPLY or ADVERTISE with an error status
+	kindDecline
+	kindRelease
+)
+
+// Lease holds the configuration a server offered or acknowledged
+type Lease struct {
+	Address netip.Addr   // for the client; unset for informational replies
+	Subnet  netip.Prefix // DHCPv4 only
+	Routers []netip.Addr // DHCPv4 only; IPv6 routers come from router advertisements
+	DNS     []netip.Addr
+	Domain  string
+	Time    time.Duration // lease time; the valid lifetime for DHCPv6
+}
+
+// message is a DHCPv4 or DHCPv6 message reduced to what the exchanges need
+type message struct {
+	v6     bool
+	name   string // e.g. DHCPDISCOVER, SOLICIT
+	kind   kind
+	xid    uint32
+	client string // hardware address, or DUID for DHCPv6
+	server string // server identifier, if the message carries one
+	lease  Lease
+	status string // DHCPv6 status of a failed reply
+}
+
+// fromV4 reads a DHCPv4 message; BOOTP messages without a type are skipped
+func fromV4(d *layers.DHCPv4) (*message, bool) {
+	m := &message{xid: d.Xid, client: d.ClientHWAddr.String()}
+	typ := layers.DHCPMsgTypeUnspecified
+	for _, o := range d.Options {
+		switch o.Type {
+		case layers.DHCPOptMessageType:
+			if len(o.Data) == 1 {
+				typ = layers.DHCPMsgType(o.Data[0])
+			}
+		case layers.DHCPOptServerID:
+			if ip, ok := netip.AddrFromSlice(o.Data); ok {
+				m.server = ip.String()
+			}
+		case layers.DHCPOptSubnetMask:
+			if len(o.Data) == 4 {
+				ones, _ := net.IPMask(o.Data).Size()
+				m.lease.Subnet = netip.PrefixFrom(netip.IPv4Unspecified(), ones)
+			}
+		case layers.DHCPOptRouter:
+			m.lease.Routers = addrs(o.Data, 4)
+		case layers.DHCPOptDNS:
+			m.lease.DNS = addrs(o.Data, 4)
+		case layers.DHCPOptDomainName:
+			m.lease.Domain = strings.TrimRight(string(o.Data), "\x00")
+		case layers.DHCPOptLeaseTime:
+			if len(o.Data) == 4 {
+				m.lease.Time = time.Duration(binary.BigEndian.Uint32(o.Data)) * time.Second
+			}
+		}
+	}
+	if typ == layers.DHCPMsgTypeUnspecified {
+		return nil, false
+	}
+	m.name = "DHCP" + strings.ToUpper(typ.String())
+	if ip, ok := netip.AddrFromSlice(d.YourClientIP.To4()); ok && !ip.IsUnspecified() {
+		m.lease.Address = ip
+		if m.lease.Subnet.IsValid() {
+			m.lease.Subnet, _ = ip.Prefix(m.lease.Subnet.Bits())
+		}
+	} else {
+		m.lease.Subnet = netip.Prefix{}
+	}
+	switch typ {
+	case layers.DHCPMsgTypeDiscover:
+		m.kind = kindDiscover
+	case layers.DHCPMsgTypeOffer:
+		m.kind = kindOffer
+	case layers.DHCPMsgTypeRequest:
+		// only a client selecting an offer names the server (RFC 2131
+		// section 4.3.2); otherwise it reboots or renews a lease it holds
+		m.kind = kindRenew
+		if m.server != "" {
+			m.kind = kindRequest
+		}
+	case layers.DHCPMsgTypeInform:
+		m.kind = kindInform
+	case layers.DHCPMsgTypeAck:
+		m.kind = kindAck
+	case layers.DHCPMsgTypeNak:
+		m.kind = kindNak
+	case layers.DHCPMsgTypeDecline:
+		m.kind = kindDecline
+	case layers.DHCPMsgTypeRelease:
+		m.kind = kindRelease
+	}
+	return m, true
+}
+
+// v6Names are the DHCPv6 message names of RFC 8415 section 7.3
+var v6Names = []string{
+	"UNSPECIFIED", "SOLICIT", "ADVERTISE", "REQUEST", "CONFIRM", "RENEW", "REBIND", "REPLY",
+	"RELEASE", "DECLINE", "RECONFIGURE", "INFORMATION-REQUEST", "RELAY-FORW", "RELAY-REPL",
+}
+
+// v6Statuses are the DHCPv6 status codes of RFC 8415 section 21.13
+var v6Statuses = []string{"Success", "UnspecFail", "NoAddrsAvail", "NoBinding", "NotOnLink", "UseMulticast", "NoPrefixAvail"}
+
+// fromV6 reads a DHCPv6 message. Relay messages are skipped: they only
+// travel between relay agents and servers.
+func fromV6(d *layers.DHCPv6) (*message, bool) {
+	if d.MsgType == layers.DHCPv6MsgTypeRelayForward || d.MsgType == layers.DHCPv6MsgTypeRelayReply || len(d.TransactionID) != 3 {
+		return nil, false
+	}
+	m := &message{v6: true, xid: uint32(d.TransactionID[0])<<16 | uint32(d.TransactionID[1])<<8 | uint32(d.TransactionID[2])}
+	m.name = "UNKNOWN"
+	if int(d.MsgType) < len(v6Names) {
+		m.name = v6Names[d.MsgType]
+	}
+	for _, o := range d.Options {
+		switch o.Code {
+		case layers.DHCPv6OptClientID:
+			m.client = net.HardwareAddr(o.Data).String()
+		case layers.DHCPv6OptServerID:
+			m.server = net.HardwareAddr(o.Data).String()
+		case layers.DHCPv6OptDNSServers:
+			m.lease.DNS = addrs(o.Data, 16)
+		case layers.DHCPv6OptDomainList:
+			if names := domains(o.Data); len(names) > 0 {
+				m.lease.Domain = strings.Join(names, " ")
+			}
+		case layers.DHCPv6OptStatusCode:
+			m.status = status(o.Data)
+		case layers.DHCPv6OptIANA:
+			// IAID, T1 and T2, then the addresses and status of the
+			// association
+			if len(o.Data) >= 12 {
+				m.ia(o.Data[12:])
+			}
+		}
+	}
+	switch d.MsgType {
+	case layers.DHCPv6MsgTypeSolicit:
+		m.kind = kindDiscover
+	case layers.DHCPv6MsgTypeAdverstise:
+		m.kind = kindOffer
+	case layers.DHCPv6MsgTypeRequest:
+		m.kind = kindRequest
+	case layers.DHCPv6MsgTypeConfirm, layers.DHCPv6MsgTypeRenew, layers.DHCPv6MsgTypeRebind:
+		m.kind = kindRenew
+	case layers.DHCPv6MsgTypeInformationRequest:
+		m.kind = kindInform
+	case layers.DHCPv6MsgTypeReply:
+		m.kind = kindAck
+	case layers.DHCPv6MsgTypeDecline:
+		m.kind = kindDecline
+	case layers.DHCPv6MsgTypeRelease:
+		m.kind = kindRelease
+	}
+	if m.status != "" && (m.kind == kindAck || m.kind == kindOffer) {
+		m.kind = kindNak
+	}
+	return m, true
+}
+
+// ia reads the options of an identity association for non-temporary
+// addresses: the first address and its valid lifetime, and any status
+func (m *message) ia(b []byte) {
+	for len(b) >= 4 {
+		code, n := binary.BigEndian.Uint16(b), int(binary.BigEndian.Uint16(b[2:]))
+		if len(b) < 4+n {
+			return
+		}
+		data := b[4 : 4+n]
+		switch layers.DHCPv6Opt(code) {
+		case layers.DHCPv6OptIAAddr:
+			if len(data) >= 24 && !m.lease.Address.IsValid() {
+				m.lease.Address, _ = netip.AddrFromSlice(data[:16])
+				m.lease.Time = time.Duration(binary.BigEndian.Uint32(data[20:24])) * time.Second
+			}
+		case layers.DHCPv6OptStatusCode:
+			if m.status == "" {
+				m.status = status(data)
+			}
+		}
+		b = b[4+n:]
+	}
+}
+
+// status names a DHCPv6 status code option, or returns "" for success
+func status(b []byte) string {
+	if len(b) < 2 {
+		return ""
+	}
+	code := int(binary.BigEndian.Uint16(b))
+	switch {
+	case code == 0:
+		return ""
+	case code < len(v6Statuses):
+		return v6Statuses[code]
+	}
+	return fmt.Sprintf("status %d", code)
+}
+
+// addrs reads a list of addresses of n bytes each
+func addrs(b []byte, n int) []netip.Addr {
+	var out []netip.Addr
+	for ; len(b) >= n; b = b[n:] {
+		if ip, ok := netip.AddrFromSlice(b[:n]); ok {
+			out = append(out, ip)
+		}
+	}
+	return out
+}
+
+// domains reads a list of uncompressed domain names (RFC 1035 section
+// 3.1), as DHCPv6 carries them
+func domains(b []byte) []string {
+	var out, labels []string
+	for len(b) > 0 {
+		n := int(b[0])
+		if n == 0 {
+			if len(labels) > 0 {
+				out = append(out, strings.Join(labels, "."))
+			}
+			labels = labels[:0]
+			b = b[1:]
+			continue
+		}
+		if len(b) < 1+n {
+			break
+		}
+		labels = append(labels, string(b[1:1+n]))
+		b = b[1+n:]
+	}
+	return out
+}
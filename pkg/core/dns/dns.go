@@ -0,0 +1,339 @@
+// Package dns matches DNS queries to their responses and measures
+// resolution latency and errors per resolver
+package dns
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+
+	"network-app/pkg/core/analyzer"
+	"network-app/pkg/core/tcp"
+)
+
+// Port is the DNS server port
+const Port = 53
+
+// Config holds the matching limits
+type Config struct {
+	Timeout    time.Duration // queries without a response for this long are unanswered
+	MaxPending int           // queries awaiting a response; older ones give way
+	TopNames   int           // names listed per resolver
+}
+
+// DefaultConfig gives resolvers 5 seconds, the usual stub resolver timeout
+var DefaultConfig = Config{
+	Timeout:    5 * time.Second,
+	MaxPending: 100000,
+	TopNames:   10,
+}
+
+// maxNames bounds the distinct names counted per resolver
+const maxNames = 10000
+
+// Stats counts DNS transactions in total or towards one resolver
+type Stats struct {
+	Resolver    netip.AddrPort // invalid for the aggregate over all resolvers
+	Queries     int
+	Responses   int // responses matched to a query
+	Unanswered  int
+	Pending     int // young queries still unanswered at the end of the capture
+	Unmatched   int // responses to queries that were not captured
+	NoError     int
+	NXDomain    int
+	ServFail    int
+	Refused     int
+	OtherErrors int
+	Truncated   int // UDP responses with the TC bit set
+	Latency     tcp.Histogram
+	names       map[string]int
+}
+
+// Rate returns n as a percentage of the matched responses
+func (s *Stats) Rate(n int) float64 {
+	if s.Responses == 0 {
+		return 0
+	}
+	return float64(n) / float64(s.Responses) * 100
+}
+
+// UnansweredRate returns the unanswered queries as a percentage of the
+// queries that had their chance to be answered
+func (s *Stats) UnansweredRate() float64 {
+	if n := s.Queries - s.Pending; n > 0 {
+		return float64(s.Unanswered) / float64(n) * 100
+	}
+	return 0
+}
+
+// NameCount is a queried name and how often it was asked for
+type NameCount struct {
+	Name  string
+	Count int
+}
+
+// TopNames returns the n most queried names, most frequent first
+func (s *Stats) TopNames(n int) []NameCount {
+	out := make([]NameCount, 0, len(s.names))
+	for name, c := range s.names {
+		out = append(out, NameCount{Name: name, Count: c})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Count != out[j].Count {
+			return out[i].Count > out[j].Count
+		}
+		return out[i].Name < out[j].Name
+	})
+	if len(out) > n {
+		out = out[:n]
+	}
+	return out
+}
+
+// txKey identifies a transaction: the endpoints, the transport and the ID
+type txKey struct {
+	client, server netip.AddrPort
+	overTCP        bool
+	id             uint16
+}
+
+type query struct {
+	sent time.Time
+}
+
+// queued is a pending query in the order queries were captured
+type queued struct {
+	key  txKey
+	sent time.Time
+}
+
+// Analyzer matches queries and responses seen over UDP (Process) and TCP
+// (Consumer, fed by a tcp.Reassembler). Time is driven by packet timestamps.
+type Analyzer struct {
+	cfg         Config
+	total       Stats
+	perResolver map[netip.AddrPort]*Stats
+	pending     map[txKey]query
+	order       []queued // pending queries, oldest first; answered ones are dropped when they reach the front
+	clock       analyzer.Clock
+}
+
+// NewAnalyzer creates a DNS analyzer
+func NewAnalyzer(cfg Config) *Analyzer {
+	return &Analyzer{
+		cfg:         cfg,
+		perResolver: make(map[netip.AddrPort]*Stats),
+		pending:     make(map[txKey]query),
+	}
+}
+
+// Process handles a single packet, picking out DNS over UDP
+func (a *Analyzer) Process(pkt gopacket.Packet) {
+	udp, ok := pkt.Layer(layers.LayerTypeUDP).(*layers.UDP)
+	if !ok || udp.SrcPort != Port && udp.DstPort != Port {
+		return
+	}
+	srcIP, dstIP, ok := analyzer.Addrs(pkt.NetworkLayer())
+	if !ok {
+		return
+	}
+	msg := &layers.DNS{}
+	if err := msg.DecodeFromBytes(udp.Payload, gopacket.NilDecodeFeedback); err != nil {
+		return
+	}
+	src := netip.AddrPortFrom(srcIP, uint16(udp.SrcPort))
+	dst := netip.AddrPortFrom(dstIP, uint16(udp.DstPort))
+	a.message(msg, src, dst, false, pkt.Metadata().Timestamp)
+}
+
+// message matches one decoded DNS message
+func (a *Analyzer) message(msg *layers.DNS, src, dst netip.AddrPort, overTCP bool, ts time.Time) {
+	a.advance(ts)
+	if !msg.QR {
+		if msg.OpCode != layers.DNSOpCodeQuery {
+			return
+		}
+		k := txKey{client: src, server: dst, overTCP: overTCP, id: msg.ID}
+		if _, ok := a.pending[k]; ok {
+			// a retry; latency is measured from the first attempt
+			return
+		}
+		if a.cfg.MaxPending > 0 && len(a.pending) >= a.cfg.MaxPending {
+			a.expireOldest()
+		}
+		a.pending[k] = query{sent: ts}
+		a.order = append(a.order, queued{key: k, sent: ts})
+		r := a.resolver(dst)
+		a.total.Queries++
+		r.Queries++
+		if len(msg.Questions) > 0 {
+			r.countName(strings.ToLower(string(msg.Questions[0].Name)))
+		}
+		return
+	}
+
+	k := txKey{client: dst, server: src, overTCP: overTCP, id: msg.ID}
+	q, ok := a.pending[k]
+	r := a.resolver(src)
+	if !ok {
+		a.total.Unmatched++
+		r.Unmatched++
+		return
+	}
+	delete(a.pending, k)
+	d := ts.Sub(q.sent)
+	for _, s := range []*Stats{&a.total, r} {
+		s.Responses++
+		s.Latency.Record(d)
+		switch msg.ResponseCode {
+		case layers.DNSResponseCodeNoErr:
+			s.NoError++
+		case layers.DNSResponseCodeNXDomain:
+			s.NXDomain++
+		case layers.DNSResponseCodeServFail:
+			s.ServFail++
+		case layers.DNSResponseCodeRefused:
+			s.Refused++
+		default:
+			s.OtherErrors++
+		}
+		if msg.TC && !overTCP {
+			s.Truncated++
+		}
+	}
+}
+
+func (a *Analyzer) resolver(addr netip.AddrPort) *Stats {
+	r := a.perResolver[addr]
+	if r == nil {
+		r = &Stats{Resolver: addr, names: make(map[string]int)}
+		a.perResolver[addr] = r
+	}
+	return r
+}
+
+func (s *Stats) countName(name string) {
+	if _, ok := s.names[name]; ok || len(s.names) < maxNames {
+		s.names[name]++
+	}
+}
+
+// advance moves the clock and, about once per second of capture time,
+// counts queries older than the timeout as unanswered
+func (a *Analyzer) advance(ts time.Time) {
+	if !a.clock.Advance(ts) {
+		return
+	}
+	for q, ok := a.oldest(); ok && a.clock.Now().Sub(q.sent) >= a.cfg.Timeout; q, ok = a.oldest() {
+		a.unanswered(q.key)
+	}
+}
+
+// oldest drops answered queries from the front of the queue and returns the
+// oldest query still pending
+func (a *Analyzer) oldest() (queued, bool) {
+	for len(a.order) > 0 {
+		q := a.order[0]
+		if p, ok := a.pending[q.key]; ok && p.sent.Equal(q.sent) {
+			return q, true
+		}
+		a.order = a.order[1:]
+	}
+	return queued{}, false
+}
+
+// expireOldest makes room in a full pending table
+func (a *Analyzer) expireOldest() {
+	if q, ok := a.oldest(); ok {
+		a.unanswered(q.key)
+	}
+}
+
+func (a *Analyzer) unanswered(k txKey) {
+	delete(a.pending, k)
+	a.total.Unanswered++
+	a.resolver(k.server).Unanswered++
+}
+
+// Finish counts the queries still waiting: those older than the timeout as
+// unanswered, younger ones as pending
+func (a *Analyzer) Finish() {
+	for k, q := range a.pending {
+		if a.clock.Now().Sub(q.sent) >= a.cfg.Timeout {
+			a.unanswered(k)
+			continue
+		}
+		delete(a.pending, k)
+		a.total.Pending++
+		a.resolver(k.server).Pending++
+	}
+	a.order = nil
+}
+
+// Stats returns the totals and the per-resolver counters, busiest resolver
+// first
+func (a *Analyzer) Stats() (total *Stats, perResolver []*Stats) {
+	out := make([]*Stats, 0, len(a.perResolver))
+	for _, r := range a.perResolver {
+		out = append(out, r)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Queries != out[j].Queries {
+			return out[i].Queries > out[j].Queries
+		}
+		return out[i].Resolver.String() < out[j].Resolver.String()
+	})
+	return &a.total, out
+}
+
+// Consumer is a tcp.NewConsumer reading DNS messages from TCP connections
+// to port 53
+func (a *Analyzer) Consumer(key tcp.FlowKey, start time.Time) tcp.Consumer {
+	if key.Server.Port() != Port {
+		return nil
+	}
+	return &tcpStream{a: a, key: key}
+}
+
+// tcpStream splits both directions of a DNS over TCP connection into
+// length-prefixed messages (RFC 1035 section 4.2.2)
+type tcpStream struct {
+	a    *Analyzer
+	key  tcp.FlowKey
+	bufs [2][]byte // client, server
+	lost [2]bool   // a gap broke the message framing
+}
+
+func (s *tcpStream) Data(c *tcp.Chunk) {
+	i, src, dst := 0, s.key.Client, s.key.Server
+	if !c.FromClient {
+		i, src, dst = 1, s.key.Server, s.key.Client
+	}
+	if c.Skipped > 0 {
+		s.lost[i] = true
+	}
+	if s.lost[i] {
+		return
+	}
+	buf := append(s.bufs[i], c.Data...)
+	for len(buf) >= 2 {
+		n := int(binary.BigEndian.Uint16(buf))
+		if len(buf) < 2+n {
+			break
+		}
+		msg := &layers.DNS{}
+		if err := msg.DecodeFromBytes(buf[2:2+n], gopacket.NilDecodeFeedback); err == nil {
+			s.a.message(msg, src, dst, true, c.Time)
+		}
+		buf = buf[2+n:]
+	}
+	// keep the partial message only
+	s.bufs[i] = append(s.bufs[i][:0], buf...)
+}
+
+func (s *tcpStream) Close() {}
@@ -0,0 +1,195 @@
+package dns
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+
+	"network-app/pkg/core/internal/packettest"
+	"network-app/pkg/core/tcp"
+)
+
+const (
+	stub     = "192.168.1.10:40000"
+	stub2    = "192.168.1.10:40001"
+	resolver = "192.168.1.1:53"
+	public   = "8.8.8.8:53"
+)
+
+// msg builds a DNS query for name, or a response with rcode when response
+// is set
+func msg(id uint16, name string, response bool, rcode layers.DNSResponseCode) *layers.DNS {
+	return &layers.DNS{
+		ID:           id,
+		QR:           response,
+		OpCode:       layers.DNSOpCodeQuery,
+		RD:           true,
+		ResponseCode: rcode,
+		Questions:    []layers.DNSQuestion{{Name: []byte(name), Type: layers.DNSTypeA, Class: layers.DNSClassIN}},
+	}
+}
+
+// packet wraps payload into an Ethernet frame from src to dst, over UDP or
+// TCP, sent at the given offset from packettest.Epoch
+func packet(t *testing.T, src, dst string, at time.Duration, transport gopacket.SerializableLayer, payload []byte) gopacket.Packet {
+	t.Helper()
+	s := netip.MustParseAddrPort(src)
+	d := netip.MustParseAddrPort(dst)
+	proto := layers.IPProtocolUDP
+	if _, ok := transport.(*layers.TCP); ok {
+		proto = layers.IPProtocolTCP
+	}
+	ip, et := packettest.IP(s.Addr(), d.Addr(), proto)
+	switch l := transport.(type) {
+	case *layers.UDP:
+		l.SrcPort, l.DstPort = layers.UDPPort(s.Port()), layers.UDPPort(d.Port())
+		l.SetNetworkLayerForChecksum(ip)
+	case *layers.TCP:
+		l.SrcPort, l.DstPort = layers.TCPPort(s.Port()), layers.TCPPort(d.Port())
+		l.Window = 64240
+		l.SetNetworkLayerForChecksum(ip)
+	}
+	return packettest.Frame(t, at, packettest.Ethernet(et), ip.(gopacket.SerializableLayer), transport, gopacket.Payload(payload))
+}
+
+func encode(t *testing.T, m *layers.DNS) []byte {
+	t.Helper()
+	buf := gopacket.NewSerializeBuffer()
+	if err := m.SerializeTo(buf, gopacket.SerializeOptions{FixLengths: true}); err != nil {
+		t.Fatalf("SerializeTo: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// udp sends m over UDP
+func udp(t *testing.T, src, dst string, at time.Duration, m *layers.DNS) gopacket.Packet {
+	return packet(t, src, dst, at, &layers.UDP{}, encode(t, m))
+}
+
+func TestDNSOverUDP(t *testing.T) {
+	ms := time.Millisecond
+	a := NewAnalyzer(DefaultConfig)
+	for _, p := range []gopacket.Packet{
+		udp(t, stub, resolver, 0, msg(1, "Example.com", false, 0)),
+		udp(t, resolver, stub, 20*ms, msg(1, "example.com", true, layers.DNSResponseCodeNoErr)),
+		// retried once, answered 30 ms after the first attempt
+		udp(t, stub2, resolver, 100*ms, msg(2, "example.com", false, 0)),
+		udp(t, stub2, resolver, 110*ms, msg(2, "example.com", false, 0)),
+		udp(t, resolver, stub2, 130*ms, msg(2, "example.com", true, layers.DNSResponseCodeNoErr)),
+		udp(t, stub, resolver, 200*ms, msg(3, "nope.example", false, 0)),
+		udp(t, resolver, stub, 210*ms, msg(3, "nope.example", true, layers.DNSResponseCodeNXDomain)),
+		// same ID, other resolver
+		udp(t, stub, public, 300*ms, msg(3, "example.org", false, 0)),
+		udp(t, public, stub, 400*ms, msg(3, "example.org", true, layers.DNSResponseCodeServFail)),
+		// never answered
+		udp(t, stub2, public, 500*ms, msg(4, "example.net", false, 0)),
+		// a response whose query was not captured
+		udp(t, resolver, stub, 600*ms, msg(9, "example.com", true, layers.DNSResponseCodeRefused)),
+		// still young when the capture ends
+		udp(t, stub, resolver, 6*time.Second, msg(5, "late.example", false, 0)),
+	} {
+		a.Process(p)
+	}
+	a.Finish()
+
+	total, per := a.Stats()
+	if total.Queries != 6 || total.Responses != 4 || total.Unanswered != 1 || total.Pending != 1 || total.Unmatched != 1 {
+		t.Errorf("total = %+v", total)
+	}
+	if total.NoError != 2 || total.NXDomain != 1 || total.ServFail != 1 || total.Refused != 0 {
+		t.Errorf("rcodes = %+v", total)
+	}
+	if total.Latency.Count() != 4 || total.Latency.Max() < 100*ms || total.Rate(total.NXDomain) != 25 {
+		t.Errorf("latency count %d max %v, NXDOMAIN rate %v", total.Latency.Count(), total.Latency.Max(), total.Rate(total.NXDomain))
+	}
+	if total.UnansweredRate() != 20 {
+		t.Errorf("UnansweredRate = %v, want 20", total.UnansweredRate())
+	}
+
+	if len(per) != 2 || per[0].Resolver.String() != resolver || per[0].Queries != 4 || per[1].Unanswered != 1 {
+		t.Fatalf("per resolver = %+v", per)
+	}
+	top := per[0].TopNames(2)
+	if len(top) != 2 || top[0] != (NameCount{"example.com", 2}) || top[1] != (NameCount{"late.example", 1}) {
+		t.Errorf("TopNames = %+v", top)
+	}
+	if q := per[0].Latency.Quantile(0.5); q < 20*ms || q > 31*ms {
+		t.Errorf("median latency = %v", q)
+	}
+}
+
+func TestDNSTruncatedThenTCP(t *testing.T) {
+	ms := time.Millisecond
+	a := NewAnalyzer(DefaultConfig)
+	r := tcp.NewReassembler(tcp.DefaultReassemblyConfig, a.Consumer)
+
+	tc := msg(7, "big.example", true, layers.DNSResponseCodeNoErr)
+	tc.TC = true
+	// two queries in one segment, the second answer split across segments
+	var queries, answers []byte
+	for _, m := range []*layers.DNS{msg(8, "big.example", false, 0), msg(9, "big.example", false, 0)} {
+		queries = append(queries, framed(t, m)...)
+	}
+	for _, m := range []*layers.DNS{msg(8, "big.example", true, 0), msg(9, "big.example", true, layers.DNSResponseCodeRefused)} {
+		answers = append(answers, framed(t, m)...)
+	}
+	split := len(answers) - 5
+
+	for _, p := range []gopacket.Packet{
+		udp(t, stub, resolver, 0, msg(7, "big.example", false, 0)),
+		udp(t, resolver, stub, 10*ms, tc),
+		packet(t, stub2, resolver, 11*ms, &layers.TCP{SYN: true, Seq: 100}, nil),
+		packet(t, resolver, stub2, 12*ms, &layers.TCP{SYN: true, ACK: true, Seq: 500, Ack: 101}, nil),
+		packet(t, stub2, resolver, 13*ms, &layers.TCP{ACK: true, PSH: true, Seq: 101, Ack: 501}, queries),
+		packet(t, resolver, stub2, 40*ms, &layers.TCP{ACK: true, PSH: true, Seq: 501, Ack: 101 + uint32(len(queries))}, answers[:split]),
+		packet(t, resolver, stub2, 41*ms, &layers.TCP{ACK: true, PSH: true, Seq: 501 + uint32(split), Ack: 101 + uint32(len(queries))}, answers[split:]),
+	} {
+		a.Process(p)
+		r.Process(p)
+	}
+	r.Finish()
+	a.Finish()
+
+	total, _ := a.Stats()
+	if total.Queries != 3 || total.Responses != 3 || total.Truncated != 1 || total.Refused != 1 {
+		t.Errorf("total = %+v", total)
+	}
+	if total.Latency.Max() < 28*ms {
+		t.Errorf("TCP latency max = %v, want about 28ms", total.Latency.Max())
+	}
+}
+
+// framed encodes m with the two byte length prefix of DNS over TCP
+func framed(t *testing.T, m *layers.DNS) []byte {
+	b := encode(t, m)
+	return append(binary.BigEndian.AppendUint16(nil, uint16(len(b))), b...)
+}
+
+func TestDNSMaxPending(t *testing.T) {
+	ms := time.Millisecond
+	cfg := DefaultConfig
+	cfg.MaxPending = 2
+	a := NewAnalyzer(cfg)
+	for _, p := range []gopacket.Packet{
+		udp(t, stub, resolver, 0, msg(1, "a.example", false, 0)),
+		udp(t, stub, resolver, 10*ms, msg(2, "b.example", false, 0)),
+		udp(t, resolver, stub, 20*ms, msg(1, "a.example", true, layers.DNSResponseCodeNoErr)),
+		udp(t, stub, resolver, 30*ms, msg(3, "c.example", false, 0)),
+		// the table is full: query 2 is the oldest still waiting
+		udp(t, stub, resolver, 40*ms, msg(4, "d.example", false, 0)),
+		udp(t, resolver, stub, 50*ms, msg(3, "c.example", true, layers.DNSResponseCodeNoErr)),
+		udp(t, resolver, stub, 60*ms, msg(2, "b.example", true, layers.DNSResponseCodeNoErr)),
+	} {
+		a.Process(p)
+	}
+	a.Finish()
+
+	total, _ := a.Stats()
+	if total.Queries != 4 || total.Responses != 2 || total.Unanswered != 1 || total.Unmatched != 1 || total.Pending != 1 {
+		t.Errorf("total = %+v", total)
+	}
+}
@@ -0,0 +1,434 @@
+// Package http parses HTTP/1.x requests and responses in reassembled TCP
+// streams to measure application latency per host and path
+package http
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"network-app/pkg/core/analyzer"
+	"network-app/pkg/core/tcp"
+)
+
+// Config holds the table limits
+type Config struct {
+	MaxEndpoints    int           // host and path pairs tracked; requests beyond count in the totals only
+	MaxPipeline     int           // requests per connection awaiting their response
+	ResponseTimeout time.Duration // requests sent less than this before the capture ended are pending
+}
+
+// DefaultConfig tracks 10000 host and path pairs and gives servers 10
+// seconds to answer
+var DefaultConfig = Config{
+	MaxEndpoints:    10000,
+	MaxPipeline:     100,
+	ResponseTimeout: 10 * time.Second,
+}
+
+// maxPath bounds the length of a reported path
+const maxPath = 128
+
+// Stats counts HTTP transactions over all connections
+type Stats struct {
+	Connections              int // connections carrying at least one request
+	ReusedConnections        int // connections carrying more than one request
+	MaxRequestsPerConnection int
+	Requests                 int
+	Responses                int // final responses matched to a request
+	Unanswered               int // requests left without a response for longer than the response timeout
+	Pending                  int // requests the capture ended too soon after to tell
+	Upgrades                 int // connections switched to another protocol or tunnelled by CONNECT
+	Lost                     int // connections whose parsing stopped at a capture gap or a malformed message
+	StatusCodes              map[int]int
+	TTFB                     tcp.Histogram // end of the request to the first byte of the response
+	Total                    tcp.Histogram // start of the request to the end of the response
+}
+
+// RequestsPerConnection returns the average number of requests carried by
+// an HTTP connection
+func (s *Stats) RequestsPerConnection() float64 {
+	if s.Connections == 0 {
+		return 0
+	}
+	return float64(s.Requests) / float64(s.Connections)
+}
+
+// ReuseRate returns the connections carrying more than one request as a
+// percentage of the HTTP connections
+func (s *Stats) ReuseRate() float64 {
+	if s.Connections == 0 {
+		return 0
+	}
+	return float64(s.ReusedConnections) / float64(s.Connections) * 100
+}
+
+// Endpoint counts the transactions towards one host and path. Query strings
+// are left out of the path.
+type Endpoint struct {
+	Host         string // Host header, or the server address without one
+	Path         string
+	Requests     int
+	Responses    int
+	ClientErrors int // 4xx
+	ServerErrors int // 5xx
+	TTFB         tcp.Histogram
+	Total        tcp.Histogram
+}
+
+type endpointKey struct {
+	host, path string
+}
+
+// Analyzer collects HTTP transactions from the streams of a tcp.Reassembler
+type Analyzer struct {
+	cfg       Config
+	stats     Stats
+	endpoints map[endpointKey]*Endpoint
+	clock     analyzer.Clock
+	waiting   []time.Time // start of the requests left by closed connections, too recent to call unanswered
+}
+
+// NewAnalyzer creates an HTTP analyzer
+func NewAnalyzer(cfg Config) *Analyzer {
+	return &Analyzer{
+		cfg:       cfg,
+		stats:     Stats{StatusCodes: make(map[int]int)},
+		endpoints: make(map[endpointKey]*Endpoint),
+	}
+}
+
+// Consumer is a tcp.NewConsumer looking for HTTP requests at the start of
+// every connection, whatever the port
+func (a *Analyzer) Consumer(key tcp.FlowKey, start time.Time) tcp.Consumer {
+	a.advance(start)
+	return &stream{a: a, key: key}
+}
+
+// advance moves the clock and, about once per second of capture time,
+// counts the waiting requests older than the response timeout as unanswered
+func (a *Analyzer) advance(ts time.Time) {
+	if !a.clock.Advance(ts) {
+		return
+	}
+	kept := a.waiting[:0]
+	for _, start := range a.waiting {
+		if a.answerDue(start) {
+			kept = append(kept, start)
+			continue
+		}
+		a.stats.Unanswered++
+	}
+	a.waiting = kept
+}
+
+// answerDue reports whether a request sent at start may still be answered
+func (a *Analyzer) answerDue(start time.Time) bool {
+	return a.clock.Now().Sub(start) < a.cfg.ResponseTimeout
+}
+
+// Finish counts the requests still waiting for their response as pending.
+// Call it once the reassembler has closed every stream.
+func (a *Analyzer) Finish() {
+	for _, start := range a.waiting {
+		if a.answerDue(start) {
+			a.stats.Pending++
+		} else {
+			a.stats.Unanswered++
+		}
+	}
+	a.waiting = nil
+}
+
+// Stats returns the totals and the per-endpoint counters, busiest endpoint
+// first
+func (a *Analyzer) Stats() (total *Stats, endpoints []*Endpoint) {
+	out := make([]*Endpoint, 0, len(a.endpoints))
+	for _, e := range a.endpoints {
+		out = append(out, e)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Requests != out[j].Requests {
+			return out[i].Requests > out[j].Requests
+		}
+		if out[i].Host != out[j].Host {
+			return out[i].Host < out[j].Host
+		}
+		return out[i].Path < out[j].Path
+	})
+	return &a.stats, out
+}
+
+// endpoint returns the counters of host and path, or nil once the table is
+// full
+func (a *Analyzer) endpoint(host, path string) *Endpoint {
+	k := endpointKey{host: host, path: path}
+	e := a.endpoints[k]
+	if e == nil && len(a.endpoints) < a.cfg.MaxEndpoints {
+		e = &Endpoint{Host: host, Path: path}
+		a.endpoints[k] = e
+	}
+	return e
+}
+
+// request is a request awaiting or receiving its response
+type request struct {
+	endpoint  *Endpoint
+	method    string
+	start     time.Time // first byte of the request
+	end       time.Time // last byte of the request; zero while the body is in flight
+	firstByte time.Time // first byte of the final response
+	status    int
+}
+
+// stream follows the requests and responses of one connection
+type stream struct {
+	a        *Analyzer
+	key      tcp.FlowKey
+	started  bool // the client spoke HTTP
+	done     bool
+	requests int
+	bufs     [2][]byte    // partial header blocks: client, server
+	msgStart [2]time.Time // first byte of the message being received
+	bodies   [2]body
+	inBody   [2]*request // the request whose body, or response body, is being received
+	queue    []*request  // requests awaiting their response, oldest first
+	last     time.Time
+}
+
+func (s *stream) Data(c *tcp.Chunk) {
+	s.a.advance(c.Time)
+	if s.done {
+		return
+	}
+	s.last = c.Time
+	i := 0
+	if !c.FromClient {
+		i = 1
+	}
+	if c.Skipped > 0 {
+		s.lose()
+		return
+	}
+	buf := c.Data
+	if len(s.bufs[i]) > 0 {
+		buf = append(s.bufs[i], c.Data...)
+	}
+	if !s.started {
+		if !c.FromClient {
+			// the server spoke first: not HTTP
+			s.stop()
+			return
+		}
+		ok, more := requestStart(buf)
+		if !ok {
+			if more {
+				s.bufs[i] = append(s.bufs[i][:0], buf...)
+			} else {
+				s.stop()
+			}
+			return
+		}
+		s.started = true
+	}
+
+	for len(buf) > 0 && !s.done {
+		if r := s.inBody[i]; r != nil {
+			n, complete, ok := s.bodies[i].consume(buf)
+			if !ok {
+				s.lose()
+				return
+			}
+			buf = buf[n:]
+			if !complete {
+				break
+			}
+			s.inBody[i] = nil
+			if c.FromClient {
+				r.end = c.Time
+			} else {
+				s.finish(r, c.Time)
+			}
+			continue
+		}
+		if s.msgStart[i].IsZero() {
+			s.msgStart[i] = c.Time
+		}
+		end := headerEnd(buf)
+		if end < 0 {
+			if len(buf) > maxHeader {
+				s.lose()
+				return
+			}
+			break
+		}
+		h, ok := parseHeader(buf[:end], c.FromClient)
+		if !ok {
+			s.lose()
+			return
+		}
+		buf = buf[end:]
+		start := s.msgStart[i]
+		s.msgStart[i] = time.Time{}
+		if c.FromClient {
+			s.request(h, start, c.Time)
+		} else {
+			s.response(h, start, c.Time)
+		}
+	}
+	if !s.done {
+		// keep the partial header block only
+		s.bufs[i] = append(s.bufs[i][:0], buf...)
+	}
+}
+
+// request handles a request header
+func (s *stream) request(h *header, start, now time.Time) {
+	if len(s.queue) >= s.a.cfg.MaxPipeline {
+		s.lose()
+		return
+	}
+	host, path := target(h)
+	if host == "" {
+		host = s.key.Server.String()
+	}
+	r := &request{endpoint: s.a.endpoint(host, path), method: h.method, start: start}
+	s.requests++
+	s.a.stats.Requests++
+	if r.endpoint != nil {
+		r.endpoint.Requests++
+	}
+	s.queue = append(s.queue, r)
+	s.bodies[0] = requestBody(h)
+	if s.bodies[0].mode == bodyNone {
+		r.end = now
+	} else {
+		s.inBody[0] = r
+	}
+}
+
+// response handles a response header, matching it to the oldest request
+func (s *stream) response(h *header, start, now time.Time) {
+	if len(s.queue) == 0 {
+		// a response without a request breaks the pairing for good
+		s.lose()
+		return
+	}
+	r := s.queue[0]
+	if h.status < 200 {
+		if h.status == 101 {
+			s.upgrade()
+		}
+		// interim response; the final one follows
+		return
+	}
+	r.firstByte = start
+	s.queue = s.queue[1:]
+	r.status = h.status
+	s.bodies[1] = responseBody(h, r.method)
+	switch {
+	case r.method == "CONNECT" && h.status < 300:
+		s.finish(r, now)
+		s.upgrade()
+	case s.bodies[1].mode == bodyNone:
+		s.finish(r, now)
+	default:
+		s.inBody[1] = r
+	}
+}
+
+// finish records a complete transaction
+func (s *stream) finish(r *request, now time.Time) {
+	sent := r.end
+	if sent.IsZero() {
+		// the server answered before the request body was complete
+		sent = r.start
+	}
+	st := &s.a.stats
+	st.Responses++
+	st.StatusCodes[r.status]++
+	st.TTFB.Record(r.firstByte.Sub(sent))
+	st.Total.Record(now.Sub(r.start))
+	if e := r.endpoint; e != nil {
+		e.Responses++
+		switch {
+		case r.status >= 500:
+			e.ServerErrors++
+		case r.status >= 400:
+			e.ClientErrors++
+		}
+		e.TTFB.Record(r.firstByte.Sub(sent))
+		e.Total.Record(now.Sub(r.start))
+	}
+}
+
+// upgrade stops parsing a connection that switched protocols
+func (s *stream) upgrade() {
+	s.a.stats.Upgrades++
+	s.stop()
+}
+
+// lose stops parsing a connection whose messages can no longer be framed
+func (s *stream) lose() {
+	if s.started {
+		s.a.stats.Lost++
+	}
+	s.stop()
+}
+
+// stop ends parsing, forgetting the requests in flight
+func (s *stream) stop() {
+	s.done = true
+	s.bufs = [2][]byte{}
+	s.inBody = [2]*request{}
+	s.queue = nil
+}
+
+func (s *stream) Close() {
+	if r := s.inBody[1]; r != nil && s.bodies[1].mode == bodyUntilClose {
+		// the response ended with the connection
+		s.finish(r, s.last)
+	}
+	if s.requests == 0 {
+		return
+	}
+	st := &s.a.stats
+	st.Connections++
+	if s.requests > 1 {
+		st.ReusedConnections++
+	}
+	st.MaxRequestsPerConnection = max(st.MaxRequestsPerConnection, s.requests)
+	for _, r := range s.queue {
+		if s.a.answerDue(r.start) {
+			// the capture may end before the response arrives
+			s.a.waiting = append(s.a.waiting, r.start)
+		} else {
+			st.Unanswered++
+		}
+	}
+}
+
+// target splits the request target into host and path, preferring the Host
+// header and leaving out the query string
+func target(h *header) (host, path string) {
+	host, path = h.host, h.target
+	if h.method == "CONNECT" {
+		// authority form
+		return strings.ToLower(h.target), ""
+	}
+	if i := strings.Index(path, "://"); i >= 0 {
+		// absolute form, sent to proxies
+		rest := path[i+3:]
+		authority, p, _ := strings.Cut(rest, "/")
+		if host == "" {
+			host = strings.ToLower(authority)
+		}
+		path = "/" + p
+	}
+	path, _, _ = strings.Cut(path, "?")
+	path, _, _ = strings.Cut(path, "#")
+	if len(path) > maxPath {
+		path = path[:maxPath]
+	}
+	return host, path
+}
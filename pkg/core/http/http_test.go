@@ -0,0 +1,150 @@
+package http
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"network-app/pkg/core/tcp"
+)
+
+var (
+	epoch = time.Date(2026, 2, 20, 10, 0, 0, 0, time.UTC)
+	key   = tcp.FlowKey{
+		Client: netip.MustParseAddrPort("192.168.1.10:54321"),
+		Server: netip.MustParseAddrPort("10.0.0.5:8080"),
+	}
+)
+
+// msg is one chunk of a connection: who sent it, when, and what
+type msg struct {
+	fromClient bool
+	at         time.Duration
+	data       string
+}
+
+// run feeds every connection to a fresh analyzer
+func run(conns ...[]msg) *Analyzer {
+	a := NewAnalyzer(DefaultConfig)
+	for _, conn := range conns {
+		s := a.Consumer(key, epoch)
+		for _, m := range conn {
+			s.Data(&tcp.Chunk{Data: []byte(m.data), FromClient: m.fromClient, Time: epoch.Add(m.at)})
+		}
+		s.Close()
+	}
+	a.Finish()
+	return a
+}
+
+func find(t *testing.T, endpoints []*Endpoint, host, path string) *Endpoint {
+	t.Helper()
+	for _, e := range endpoints {
+		if e.Host == host && e.Path == path {
+			return e
+		}
+	}
+	t.Fatalf("no endpoint %s%s in %+v", host, path, endpoints)
+	return nil
+}
+
+func TestKeepAlive(t *testing.T) {
+	ms := time.Millisecond
+	a := run([]msg{
+		{true, 0, "GET /a?id=1 HTTP/1.1\r\nHost: Example.com\r\n\r\n"},
+		{false, 30 * ms, "HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nhe"},
+		{false, 50 * ms, "llo"},
+		// chunked upload, split inside the chunk framing
+		{true, 100 * ms, "POST /b HTTP/1.1\r\nHost: example.com\r\nTransfer-Encoding: chunked\r\n\r\n4\r\nda"},
+		{true, 110 * ms, "ta\r\n0\r\n\r\n"},
+		{false, 150 * ms, "HTTP/1.1 503 Service Unavailable\r\nTransfer-Encoding: chunked\r\n\r\n3;x=y\r\nbad\r\n"},
+		{false, 160 * ms, "0\r\nX-Trailer: 1\r\n\r\n"},
+	})
+
+	total, endpoints := a.Stats()
+	if total.Connections != 1 || total.ReusedConnections != 1 || total.Requests != 2 || total.Responses != 2 || total.Lost != 0 {
+		t.Errorf("total = %+v", total)
+	}
+	if total.StatusCodes[200] != 1 || total.StatusCodes[503] != 1 || total.RequestsPerConnection() != 2 || total.ReuseRate() != 100 {
+		t.Errorf("status codes = %v", total.StatusCodes)
+	}
+	e := find(t, endpoints, "example.com", "/a")
+	if e.Responses != 1 || e.TTFB.Max() < 29*ms || e.TTFB.Max() > 31*ms || e.Total.Max() < 49*ms || e.Total.Max() > 51*ms {
+		t.Errorf("/a TTFB %v total %v", e.TTFB.Max(), e.Total.Max())
+	}
+	e = find(t, endpoints, "example.com", "/b")
+	if e.ServerErrors != 1 || e.TTFB.Max() < 39*ms || e.TTFB.Max() > 41*ms || e.Total.Max() < 59*ms || e.Total.Max() > 61*ms {
+		t.Errorf("/b = %+v, TTFB %v total %v", e, e.TTFB.Max(), e.Total.Max())
+	}
+}
+
+func TestPipeliningAndClose(t *testing.T) {
+	ms := time.Millisecond
+	a := run(
+		[]msg{
+			{true, 0, "HEAD /x HTTP/1.1\r\nHost: a.example\r\n\r\nGET /y HTTP/1.1\r\nHost: a.example\r\n\r\n"},
+			// no body after HEAD despite the length
+			{false, 10 * ms, "HTTP/1.1 200 OK\r\nContent-Length: 100\r\n\r\nHTTP/1.1 404 Not Found\r\nContent-Length: 0\r\n\r\n"},
+		},
+		[]msg{
+			// HTTP/1.0 response delimited by the end of the connection
+			{true, 0, "GET http://proxy.example/z?q HTTP/1.0\r\n\r\n"},
+			{false, 20 * ms, "HTTP/1.0 200 OK\r\n\r\nsome"},
+			{false, 70 * ms, "body"},
+		},
+		[]msg{
+			// 100 Continue is not the response; the capture ends before
+			// the second request is answered
+			{true, 0, "PUT /u HTTP/1.1\r\nHost: a.example\r\nContent-Length: 3\r\nExpect: 100-continue\r\n\r\n"},
+			{false, 5 * ms, "HTTP/1.1 100 Continue\r\n\r\n"},
+			{true, 6 * ms, "abc"},
+			{false, 80 * ms, "HTTP/1.1 201 Created\r\nContent-Length: 0\r\n\r\n"},
+			{true, 90 * ms, "GET /u HTTP/1.1\r\nHost: a.example\r\n\r\n"},
+		},
+		[]msg{
+			{true, 0, "GET /ws HTTP/1.1\r\nHost: a.example\r\nUpgrade: websocket\r\n\r\n"},
+			{false, 5 * ms, "HTTP/1.1 101 Switching Protocols\r\n\r\n\x81\x05hello"},
+		},
+		[]msg{
+			{true, 0, "SSH-2.0-OpenSSH_9.6\r\n"},
+		},
+		[]msg{
+			{true, 0, "GE"},
+			{true, 1 * ms, "T / HTTP/1.1\r\n\r\n"},
+			{false, 2 * ms, "garbage\r\n\r\n"},
+		},
+	)
+
+	total, endpoints := a.Stats()
+	if total.Connections != 5 || total.ReusedConnections != 2 || total.MaxRequestsPerConnection != 2 || total.Requests != 7 {
+		t.Errorf("connections = %+v", total)
+	}
+	if total.Responses != 4 || total.Unanswered != 0 || total.Pending != 1 || total.Upgrades != 1 || total.Lost != 1 {
+		t.Errorf("outcomes = %+v", total)
+	}
+	if e := find(t, endpoints, "a.example", "/y"); e.ClientErrors != 1 {
+		t.Errorf("/y = %+v", e)
+	}
+	if e := find(t, endpoints, "proxy.example", "/z"); e.Responses != 1 || e.Total.Max() < 69*ms {
+		t.Errorf("/z total %v", e.Total.Max())
+	}
+	if e := find(t, endpoints, "a.example", "/u"); e.Requests != 2 || e.Responses != 1 || e.TTFB.Max() < 73*ms || e.TTFB.Max() > 75*ms {
+		t.Errorf("/u = %+v, TTFB %v", e, e.TTFB.Max())
+	}
+}
+
+func TestUnansweredAndPending(t *testing.T) {
+	a := NewAnalyzer(DefaultConfig)
+	// the first request goes unanswered while the capture runs on for
+	// another 30 seconds; the second is cut off by its end
+	for _, at := range []time.Duration{0, 30 * time.Second} {
+		s := a.Consumer(key, epoch.Add(at))
+		s.Data(&tcp.Chunk{Data: []byte("GET / HTTP/1.1\r\nHost: slow.example\r\n\r\n"), FromClient: true, Time: epoch.Add(at)})
+		s.Close()
+	}
+	a.Finish()
+
+	if total, _ := a.Stats(); total.Requests != 2 || total.Unanswered != 1 || total.Pending != 1 {
+		t.Errorf("total = %+v, want 1 unanswered and 1 pending", total)
+	}
+}
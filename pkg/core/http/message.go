@@ -0,0 +1,231 @@
+package http
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+)
+
+// maxHeader bounds the buffered header block of a message; longer ones mean
+// the stream is not HTTP or is beyond what we care to parse
+const maxHeader = 64 << 10
+
+// methods are the request methods recognised at the start of a connection
+var methods = []string{"GET ", "HEAD ", "POST ", "PUT ", "DELETE ", "CONNECT ", "OPTIONS ", "TRACE ", "PATCH "}
+
+// requestStart reports whether b starts like an HTTP request, and whether
+// more bytes are needed to tell
+func requestStart(b []byte) (ok, more bool) {
+	for _, m := range methods {
+		if len(b) >= len(m) {
+			if string(b[:len(m)]) == m {
+				return true, false
+			}
+		} else if string(b) == m[:len(b)] {
+			more = true
+		}
+	}
+	return false, more
+}
+
+// header is the start line and the headers of a message that matter for
+// framing and reporting
+type header struct {
+	method        string // requests
+	target        string
+	status        int // responses
+	proto         string
+	host          string
+	contentLength int64 // -1 when absent
+	chunked       bool
+	close         bool // Connection: close, or HTTP/1.0 without keep-alive
+}
+
+// headerEnd returns the length of the header block at the start of b,
+// including the empty line, or -1 when it is incomplete
+func headerEnd(b []byte) int {
+	if i := bytes.Index(b, []byte("\r\n\r\n")); i >= 0 {
+		return i + 4
+	}
+	if i := bytes.Index(b, []byte("\n\n")); i >= 0 {
+		return i + 2
+	}
+	return -1
+}
+
+// parseHeader parses a complete header block. request selects the start
+// line format.
+func parseHeader(b []byte, request bool) (*header, bool) {
+	lines := strings.Split(strings.TrimRight(string(b), "\r\n"), "\n")
+	h := &header{contentLength: -1}
+	start := strings.Fields(strings.TrimSuffix(lines[0], "\r"))
+	if request {
+		if len(start) != 3 || !strings.HasPrefix(start[2], "HTTP/1.") {
+			return nil, false
+		}
+		h.method, h.target, h.proto = start[0], start[1], start[2]
+	} else {
+		if len(start) < 2 || !strings.HasPrefix(start[0], "HTTP/1.") {
+			return nil, false
+		}
+		code, err := strconv.Atoi(start[1])
+		if err != nil || code < 100 || code > 999 {
+			return nil, false
+		}
+		h.proto, h.status = start[0], code
+	}
+	keepAlive := false
+	for _, l := range lines[1:] {
+		name, value, ok := strings.Cut(strings.TrimSuffix(l, "\r"), ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "host":
+			h.host = strings.ToLower(value)
+		case "content-length":
+			if n, err := strconv.ParseInt(value, 10, 64); err == nil && n >= 0 {
+				h.contentLength = n
+			}
+		case "transfer-encoding":
+			h.chunked = strings.HasSuffix(strings.ToLower(value), "chunked")
+		case "connection":
+			for _, t := range strings.Split(strings.ToLower(value), ",") {
+				switch strings.TrimSpace(t) {
+				case "close":
+					h.close = true
+				case "keep-alive":
+					keepAlive = true
+				}
+			}
+		}
+	}
+	if h.proto == "HTTP/1.0" && !keepAlive {
+		h.close = true
+	}
+	return h, true
+}
+
+// bodyMode tells how the end of a message body is found (RFC 9112 section
+// 6.3)
+type bodyMode int
+
+const (
+	bodyNone bodyMode = iota
+	bodyLength
+	bodyChunked
+	bodyUntilClose
+)
+
+// body skips over a message body, tracking where it ends
+type body struct {
+	mode      bodyMode
+	remaining int64 // bodyLength, or the rest of the current chunk
+	state     chunkState
+	line      []byte // partial chunk size or trailer line
+}
+
+type chunkState int
+
+const (
+	chunkSize chunkState = iota
+	chunkData
+	chunkDataEnd
+	chunkTrailer
+)
+
+// maxChunkLine bounds a chunk size or trailer line
+const maxChunkLine = 4096
+
+// consume skips the body bytes at the start of b. It returns how many bytes
+// belonged to the body and whether the body is complete; ok is false when
+// the chunked framing is broken.
+func (b *body) consume(data []byte) (n int, done, ok bool) {
+	switch b.mode {
+	case bodyNone:
+		return 0, true, true
+	case bodyUntilClose:
+		return len(data), false, true
+	case bodyLength:
+		n = int(min(b.remaining, int64(len(data))))
+		b.remaining -= int64(n)
+		return n, b.remaining == 0, true
+	}
+	for n < len(data) {
+		switch b.state {
+		case chunkData:
+			k := int(min(b.remaining, int64(len(data)-n)))
+			b.remaining -= int64(k)
+			n += k
+			if b.remaining == 0 {
+				b.state = chunkDataEnd
+			}
+			continue
+		case chunkDataEnd:
+			// CRLF after the chunk data
+			c := data[n]
+			n++
+			if c == '\n' {
+				b.state = chunkSize
+			}
+			continue
+		}
+		i := bytes.IndexByte(data[n:], '\n')
+		if i < 0 {
+			b.line = append(b.line, data[n:]...)
+			if len(b.line) > maxChunkLine {
+				return n, false, false
+			}
+			return len(data), false, true
+		}
+		line := strings.TrimSpace(string(append(b.line, data[n:n+i]...)))
+		b.line = b.line[:0]
+		n += i + 1
+		if b.state == chunkTrailer {
+			if line == "" {
+				return n, true, true
+			}
+			continue
+		}
+		size, _, _ := strings.Cut(line, ";")
+		v, err := strconv.ParseInt(strings.TrimSpace(size), 16, 64)
+		if err != nil || v < 0 {
+			return n, false, false
+		}
+		if v == 0 {
+			b.state = chunkTrailer
+		} else {
+			b.state, b.remaining = chunkData, v
+		}
+	}
+	return n, false, true
+}
+
+// requestBody returns how the body of a request ends
+func requestBody(h *header) body {
+	switch {
+	case h.chunked:
+		return body{mode: bodyChunked}
+	case h.contentLength > 0:
+		return body{mode: bodyLength, remaining: h.contentLength}
+	}
+	return body{mode: bodyNone}
+}
+
+// responseBody returns how the body of a response to method ends
+func responseBody(h *header, method string) body {
+	switch {
+	case method == "HEAD" || h.status < 200 || h.status == 204 || h.status == 304:
+		return body{mode: bodyNone}
+	case method == "CONNECT" && h.status < 300:
+		return body{mode: bodyNone}
+	case h.chunked:
+		return body{mode: bodyChunked}
+	case h.contentLength == 0:
+		return body{mode: bodyNone}
+	case h.contentLength > 0:
+		return body{mode: bodyLength, remaining: h.contentLength}
+	}
+	return body{mode: bodyUntilClose}
+}
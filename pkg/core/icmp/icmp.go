@@ -0,0 +1,475 @@
+// Package icmp classifies ICMP and ICMPv6 error messages, ties them to the
+// flows they refer to and flags likely path MTU black holes
+package icmp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net/netip"
+	"sort"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+
+	"network-app/pkg/core/analyzer"
+)
+
+// Kind is the class of an ICMP error message
+type Kind int
+
+const (
+	KindNetUnreachable      Kind = iota // ICMPv6: no route to destination
+	KindHostUnreachable                 // ICMPv6: address unreachable
+	KindProtocolUnreachable             // IPv4 only
+	KindPortUnreachable
+	KindPacketTooBig // IPv4 fragmentation needed, ICMPv6 packet too big
+	KindAdminProhibited
+	KindOtherUnreachable
+	KindTTLExceeded
+	KindReassemblyTimeExceeded
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindNetUnreachable:
+		return "network unreachable"
+	case KindHostUnreachable:
+		return "host unreachable"
+	case KindProtocolUnreachable:
+		return "protocol unreachable"
+	case KindPortUnreachable:
+		return "port unreachable"
+	case KindPacketTooBig:
+		return "packet too big"
+	case KindAdminProhibited:
+		return "administratively prohibited"
+	case KindOtherUnreachable:
+		return "other unreachable"
+	case KindTTLExceeded:
+		return "TTL exceeded"
+	case KindReassemblyTimeExceeded:
+		return "reassembly time exceeded"
+	}
+	return "unknown"
+}
+
+// Config holds the grouping and the black hole heuristic
+type Config struct {
+	IPv4Prefix   int           // IPv4 destinations are grouped by networks of this prefix length
+	IPv6Prefix   int           // same for IPv6 destinations
+	LargeSegment int           // TCP payloads from this size on are watched for black holes
+	Retransmits  int           // retransmissions of a large segment before a flow is suspect
+	IdleTimeout  time.Duration // senders silent for this long are forgotten
+	MaxFlows     int           // TCP senders tracked
+}
+
+// DefaultConfig groups by /24 and /64 and suspects a black hole after two
+// retransmissions of a segment of 1200 bytes or more
+var DefaultConfig = Config{
+	IPv4Prefix:   24,
+	IPv6Prefix:   64,
+	LargeSegment: 1200,
+	Retransmits:  2,
+	IdleTimeout:  2 * time.Minute,
+	MaxFlows:     100000,
+}
+
+// Limits of the per-network tables
+const (
+	maxReporters = 8
+	maxNetFlows  = 10000
+	maxFeedback  = 100000
+)
+
+// Flow is the transport flow of the datagram an ICMP error refers to, as
+// sent by its original source. Ports are zero for protocols other than TCP
+// and UDP.
+type Flow struct {
+	Protocol layers.IPProtocol
+	Src, Dst netip.AddrPort
+}
+
+func (f Flow) String() string {
+	return fmt.Sprintf("%s %s → %s", f.Protocol, f.Src, f.Dst)
+}
+
+// Network aggregates the ICMP errors about one destination network
+type Network struct {
+	Prefix     netip.Prefix
+	Total      int
+	Messages   map[Kind]int
+	Flows      int          // distinct flows the messages referred to
+	Example    Flow         // first flow a message referred to
+	Reporters  []netip.Addr // distinct senders of the messages, routers or the destination itself
+	MinMTU     int          // smallest next-hop MTU reported; 0 without packet too big messages
+	BlackHoles int          // TCP flows suspected of a path MTU black hole
+	flows      map[Flow]struct{}
+}
+
+// Stats counts every ICMP error message
+type Stats struct {
+	Total    int
+	Messages map[Kind]int
+	Unparsed int // messages whose embedded datagram was too short or not IP
+}
+
+// BlackHole is a TCP flow whose large segments were retransmitted without
+// being acknowledged or answered by a packet too big message, while smaller
+// ones got through
+type BlackHole struct {
+	Flow
+	Size        int // payload of the segment that did not get through
+	Retransmits int
+	Start       time.Time // first transmission
+	Recovered   bool      // a smaller segment at the same sequence number got through later
+	delivered   bool      // the segment got through at full size after all
+}
+
+// sender is the direction of a TCP flow watched for black holes
+type sender struct {
+	acked   bool // the peer acknowledged something
+	seq     uint32
+	size    int // 0 when no large segment is outstanding
+	sends   int
+	first   time.Time
+	shrunk  bool // the segment was resent smaller
+	suspect *BlackHole
+	last    time.Time
+}
+
+// Analyzer processes packets. Time is driven by packet timestamps. Black
+// holes can only be seen when capturing on the sending side, where the lost
+// segments still show up.
+type Analyzer struct {
+	cfg        Config
+	stats      Stats
+	networks   map[netip.Prefix]*Network
+	senders    map[Flow]*sender
+	feedback   map[Flow]struct{} // flows that got a packet too big message
+	blackHoles []*BlackHole
+	clock      analyzer.Clock
+}
+
+// NewAnalyzer creates an ICMP analyzer
+func NewAnalyzer(cfg Config) *Analyzer {
+	return &Analyzer{
+		cfg:      cfg,
+		stats:    Stats{Messages: make(map[Kind]int)},
+		networks: make(map[netip.Prefix]*Network),
+		senders:  make(map[Flow]*sender),
+		feedback: make(map[Flow]struct{}),
+	}
+}
+
+// Process handles a single packet: ICMP errors, and TCP segments for the
+// black hole heuristic
+func (a *Analyzer) Process(pkt gopacket.Packet) {
+	src, dst, ok := analyzer.Addrs(pkt.NetworkLayer())
+	if !ok {
+		return
+	}
+	ts := pkt.Metadata().Timestamp
+	a.advance(ts)
+	switch l := pkt.TransportLayer().(type) {
+	case *layers.TCP:
+		a.segment(l, src, dst, ts)
+		return
+	case *layers.UDP:
+		return
+	}
+	if m, ok := pkt.Layer(layers.LayerTypeICMPv4).(*layers.ICMPv4); ok {
+		kind, ok := kind4(m.TypeCode)
+		if !ok {
+			return
+		}
+		mtu := 0
+		if kind == KindPacketTooBig {
+			mtu = int(m.Seq)
+		}
+		a.message(kind, src, m.Payload, mtu)
+		return
+	}
+	if m, ok := pkt.Layer(layers.LayerTypeICMPv6).(*layers.ICMPv6); ok && len(m.Payload) >= 4 {
+		kind, ok := kind6(m.TypeCode)
+		if !ok {
+			return
+		}
+		mtu := 0
+		if kind == KindPacketTooBig {
+			mtu = int(binary.BigEndian.Uint32(m.Payload))
+		}
+		a.message(kind, src, m.Payload[4:], mtu)
+	}
+}
+
+// kind4 classifies an ICMPv4 error; queries and replies are not errors
+func kind4(tc layers.ICMPv4TypeCode) (Kind, bool) {
+	switch tc.Type() {
+	case layers.ICMPv4TypeDestinationUnreachable:
+		switch tc.Code() {
+		case layers.ICMPv4CodeNet, layers.ICMPv4CodeNetUnknown, layers.ICMPv4CodeNetTOS:
+			return KindNetUnreachable, true
+		case layers.ICMPv4CodeHost, layers.ICMPv4CodeHostUnknown, layers.ICMPv4CodeHostTOS:
+			return KindHostUnreachable, true
+		case layers.ICMPv4CodeProtocol:
+			return KindProtocolUnreachable, true
+		case layers.ICMPv4CodePort:
+			return KindPortUnreachable, true
+		case layers.ICMPv4CodeFragmentationNeeded:
+			return KindPacketTooBig, true
+		case layers.ICMPv4CodeNetAdminProhibited, layers.ICMPv4CodeHostAdminProhibited, layers.ICMPv4CodeCommAdminProhibited:
+			return KindAdminProhibited, true
+		}
+		return KindOtherUnreachable, true
+	case layers.ICMPv4TypeTimeExceeded:
+		if tc.Code() == layers.ICMPv4CodeFragmentReassemblyTimeExceeded {
+			return KindReassemblyTimeExceeded, true
+		}
+		return KindTTLExceeded, true
+	}
+	return 0, false
+}
+
+// kind6 classifies an ICMPv6 error; informational messages are not errors
+func kind6(tc layers.ICMPv6TypeCode) (Kind, bool) {
+	switch tc.Type() {
+	case layers.ICMPv6TypeDestinationUnreachable:
+		switch tc.Code() {
+		case layers.ICMPv6CodeNoRouteToDst:
+			return KindNetUnreachable, true
+		case layers.ICMPv6CodeAddressUnreachable:
+			return KindHostUnreachable, true
+		case layers.ICMPv6CodePortUnreachable:
+			return KindPortUnreachable, true
+		case layers.ICMPv6CodeAdminProhibited, layers.ICMPv6CodeSrcAddressFailedPolicy, layers.ICMPv6CodeRejectRouteToDst:
+			return KindAdminProhibited, true
+		}
+		return KindOtherUnreachable, true
+	case layers.ICMPv6TypePacketTooBig:
+		return KindPacketTooBig, true
+	case layers.ICMPv6TypeTimeExceeded:
+		if tc.Code() == layers.ICMPv6CodeFragmentReassemblyTimeExceeded {
+			return KindReassemblyTimeExceeded, true
+		}
+		return KindTTLExceeded, true
+	}
+	return 0, false
+}
+
+// message records one ICMP error sent by reporter about the datagram at the
+// start of orig
+func (a *Analyzer) message(kind Kind, reporter netip.Addr, orig []byte, mtu int) {
+	a.stats.Total++
+	a.stats.Messages[kind]++
+	f, ok := original(orig)
+	if !ok {
+		a.stats.Unparsed++
+		return
+	}
+	n := a.network(f.Dst.Addr())
+	n.Total++
+	n.Messages[kind]++
+	if _, seen := n.flows[f]; !seen && len(n.flows) < maxNetFlows {
+		if len(n.flows) == 0 {
+			n.Example = f
+		}
+		n.flows[f] = struct{}{}
+		n.Flows++
+	}
+	if len(n.Reporters) < maxReporters && !contains(n.Reporters, reporter) {
+		n.Reporters = append(n.Reporters, reporter)
+	}
+	if kind == KindPacketTooBig {
+		if mtu > 0 && (n.MinMTU == 0 || mtu < n.MinMTU) {
+			n.MinMTU = mtu
+		}
+		if len(a.feedback) < maxFeedback {
+			a.feedback[f] = struct{}{}
+		}
+	}
+}
+
+func contains(addrs []netip.Addr, a netip.Addr) bool {
+	for _, x := range addrs {
+		if x == a {
+			return true
+		}
+	}
+	return false
+}
+
+// network returns the entry of the destination network holding addr
+func (a *Analyzer) network(addr netip.Addr) *Network {
+	bits := a.cfg.IPv4Prefix
+	if !addr.Is4() {
+		bits = a.cfg.IPv6Prefix
+	}
+	p, _ := addr.Prefix(bits)
+	n := a.networks[p]
+	if n == nil {
+		n = &Network{Prefix: p, Messages: make(map[Kind]int), flows: make(map[Flow]struct{})}
+		a.networks[p] = n
+	}
+	return n
+}
+
+// original decodes the IP header and the first transport bytes that an
+// ICMP error quotes from the offending datagram
+func original(b []byte) (Flow, bool) {
+	var f Flow
+	var src, dst netip.Addr
+	var rest []byte
+	switch {
+	case len(b) >= 20 && b[0]>>4 == 4:
+		ihl := int(b[0]&0x0f) * 4
+		if ihl < 20 || len(b) < ihl {
+			return f, false
+		}
+		f.Protocol = layers.IPProtocol(b[9])
+		src = netip.AddrFrom4([4]byte(b[12:16]))
+		dst = netip.AddrFrom4([4]byte(b[16:20]))
+		rest = b[ihl:]
+	case len(b) >= 40 && b[0]>>4 == 6:
+		f.Protocol = layers.IPProtocol(b[6])
+		src = netip.AddrFrom16([16]byte(b[8:24]))
+		dst = netip.AddrFrom16([16]byte(b[24:40]))
+		rest = b[40:]
+	default:
+		return f, false
+	}
+	var sport, dport uint16
+	if (f.Protocol == layers.IPProtocolTCP || f.Protocol == layers.IPProtocolUDP) && len(rest) >= 4 {
+		sport = binary.BigEndian.Uint16(rest)
+		dport = binary.BigEndian.Uint16(rest[2:])
+	}
+	f.Src = netip.AddrPortFrom(src, sport)
+	f.Dst = netip.AddrPortFrom(dst, dport)
+	return f, true
+}
+
+// segment feeds the black hole heuristic: a large segment sent again and
+// again without an acknowledgement, by a sender whose peer did acknowledge
+// earlier (smaller) segments
+func (a *Analyzer) segment(t *layers.TCP, src, dst netip.Addr, ts time.Time) {
+	k := Flow{
+		Protocol: layers.IPProtocolTCP,
+		Src:      netip.AddrPortFrom(src, uint16(t.SrcPort)),
+		Dst:      netip.AddrPortFrom(dst, uint16(t.DstPort)),
+	}
+	rev := Flow{Protocol: k.Protocol, Src: k.Dst, Dst: k.Src}
+	if t.RST {
+		delete(a.senders, k)
+		delete(a.senders, rev)
+		return
+	}
+	if t.ACK {
+		if r := a.senders[rev]; r != nil {
+			r.acked = true
+			if r.size > 0 && int32(t.Ack-r.seq) > 0 {
+				a.delivered(r)
+			}
+		}
+	}
+	n := len(t.Payload)
+	s := a.senders[k]
+	if s == nil {
+		if n < a.cfg.LargeSegment && !t.ACK || len(a.senders) >= a.cfg.MaxFlows {
+			return
+		}
+		s = &sender{}
+		a.senders[k] = s
+	}
+	s.last = ts
+	switch {
+	case s.size > 0 && t.Seq == s.seq && n >= s.size:
+		s.sends++
+		if s.sends == a.cfg.Retransmits+1 && s.acked && s.suspect == nil {
+			s.suspect = &BlackHole{Flow: k, Size: s.size, Retransmits: s.sends - 1, Start: s.first}
+			a.blackHoles = append(a.blackHoles, s.suspect)
+		} else if s.suspect != nil {
+			s.suspect.Retransmits = s.sends - 1
+		}
+	case s.size > 0 && t.Seq == s.seq && n > 0:
+		// resent smaller: the sender probes for a lower MTU
+		s.shrunk = true
+	case s.size == 0 && n >= a.cfg.LargeSegment:
+		s.seq, s.size, s.sends, s.first, s.shrunk = t.Seq, n, 1, ts, false
+	}
+}
+
+// delivered clears the outstanding large segment of s once acknowledged. A
+// segment that got through at full size was merely lost; one that needed
+// shrinking confirms the black hole.
+func (a *Analyzer) delivered(s *sender) {
+	if s.suspect != nil {
+		if s.shrunk {
+			s.suspect.Recovered = true
+		} else {
+			s.suspect.delivered = true
+		}
+	}
+	s.size, s.suspect = 0, nil
+}
+
+// advance moves the clock and, about once per second of capture time,
+// forgets idle senders
+func (a *Analyzer) advance(ts time.Time) {
+	if !a.clock.Advance(ts) {
+		return
+	}
+	for k, s := range a.senders {
+		if a.clock.Now().Sub(s.last) >= a.cfg.IdleTimeout {
+			delete(a.senders, k)
+		}
+	}
+}
+
+// Finish drops the suspects that were delivered after all or got packet
+// too big feedback, and counts the rest per destination network
+func (a *Analyzer) Finish() {
+	kept := a.blackHoles[:0]
+	for _, b := range a.blackHoles {
+		if _, ok := a.feedback[b.Flow]; ok || b.delivered {
+			continue
+		}
+		kept = append(kept, b)
+		a.network(b.Dst.Addr()).BlackHoles++
+	}
+	a.blackHoles = kept
+	a.senders = make(map[Flow]*sender)
+}
+
+// Stats returns the totals over all ICMP errors
+func (a *Analyzer) Stats() Stats {
+	return a.stats
+}
+
+// Networks returns the destination networks, most black holes first, then
+// most messages
+func (a *Analyzer) Networks() []*Network {
+	out := make([]*Network, 0, len(a.networks))
+	for _, n := range a.networks {
+		out = append(out, n)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].BlackHoles != out[j].BlackHoles {
+			return out[i].BlackHoles > out[j].BlackHoles
+		}
+		if out[i].Total != out[j].Total {
+			return out[i].Total > out[j].Total
+		}
+		return out[i].Prefix.String() < out[j].Prefix.String()
+	})
+	return out
+}
+
+// BlackHoles returns the flows suspected of a path MTU black hole, in the
+// order they were flagged. Only valid after Finish.
+func (a *Analyzer) BlackHoles() []BlackHole {
+	out := make([]BlackHole, 0, len(a.blackHoles))
+	for _, b := range a.blackHoles {
+		out = append(out, *b)
+	}
+	return out
+}
@@ -0,0 +1,175 @@
+package icmp
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+
+	"network-app/pkg/core/internal/packettest"
+)
+
+const (
+	client  = "192.168.1.10:54321"
+	server  = "93.184.216.34:443"
+	router  = "10.0.0.1:0"
+	client6 = "[2001:db8::10]:50000"
+	server6 = "[2001:db8:1::1]:80"
+	router6 = "[2001:db8::1]:0"
+)
+
+// segment builds a TCP segment from src to dst
+func segment(t *testing.T, src, dst string, at time.Duration, tcp *layers.TCP, size int) gopacket.Packet {
+	t.Helper()
+	s, d := netip.MustParseAddrPort(src), netip.MustParseAddrPort(dst)
+	ip, et := packettest.IP(s.Addr(), d.Addr(), layers.IPProtocolTCP)
+	tcp.SrcPort, tcp.DstPort, tcp.Window = layers.TCPPort(s.Port()), layers.TCPPort(d.Port()), 64240
+	tcp.SetNetworkLayerForChecksum(ip)
+	return packettest.Frame(t, at, packettest.Ethernet(et), ip.(gopacket.SerializableLayer), tcp, gopacket.Payload(make([]byte, size)))
+}
+
+// quote returns what an ICMP error quotes of a datagram from src to dst: the
+// IP header and the first 8 transport bytes
+func quote(t *testing.T, proto layers.IPProtocol, src, dst string) []byte {
+	t.Helper()
+	s, d := netip.MustParseAddrPort(src), netip.MustParseAddrPort(dst)
+	ip, _ := packettest.IP(s.Addr(), d.Addr(), proto)
+	var transport gopacket.SerializableLayer
+	if proto == layers.IPProtocolUDP {
+		udp := &layers.UDP{SrcPort: layers.UDPPort(s.Port()), DstPort: layers.UDPPort(d.Port())}
+		udp.SetNetworkLayerForChecksum(ip)
+		transport = udp
+	} else {
+		tcp := &layers.TCP{SrcPort: layers.TCPPort(s.Port()), DstPort: layers.TCPPort(d.Port()), ACK: true}
+		tcp.SetNetworkLayerForChecksum(ip)
+		transport = tcp
+	}
+	b := packettest.Serialize(t, ip.(gopacket.SerializableLayer), transport, gopacket.Payload(make([]byte, 100)))
+	if s.Addr().Is4() {
+		return b[:28]
+	}
+	return b[:48]
+}
+
+// errorMsg builds an ICMP or ICMPv6 error of typ and code sent by reporter
+// to dst, quoting orig
+func errorMsg(t *testing.T, reporter, dst string, at time.Duration, typ, code uint8, mtu int, orig []byte) gopacket.Packet {
+	t.Helper()
+	r, d := netip.MustParseAddrPort(reporter), netip.MustParseAddrPort(dst)
+	if r.Addr().Is4() {
+		ip, et := packettest.IP(r.Addr(), d.Addr(), layers.IPProtocolICMPv4)
+		m := &layers.ICMPv4{TypeCode: layers.CreateICMPv4TypeCode(typ, code), Seq: uint16(mtu)}
+		return packettest.Frame(t, at, packettest.Ethernet(et), ip.(gopacket.SerializableLayer), m, gopacket.Payload(orig))
+	}
+	ip, et := packettest.IP(r.Addr(), d.Addr(), layers.IPProtocolICMPv6)
+	m := &layers.ICMPv6{TypeCode: layers.CreateICMPv6TypeCode(typ, code)}
+	m.SetNetworkLayerForChecksum(ip)
+	body := binary.BigEndian.AppendUint32(nil, uint32(mtu))
+	return packettest.Frame(t, at, packettest.Ethernet(et), ip.(gopacket.SerializableLayer), m, gopacket.Payload(append(body, orig...)))
+}
+
+func TestClassification(t *testing.T) {
+	a := NewAnalyzer(DefaultConfig)
+	for _, p := range []gopacket.Packet{
+		errorMsg(t, router, client, 0, 3, 4, 1400, quote(t, layers.IPProtocolTCP, client, server)),
+		errorMsg(t, router, client, time.Second, 3, 4, 1280, quote(t, layers.IPProtocolTCP, "192.168.1.10:54322", "93.184.216.35:443")),
+		errorMsg(t, "93.184.216.34:0", client, 2*time.Second, 3, 3, 0, quote(t, layers.IPProtocolUDP, "192.168.1.10:40000", "93.184.216.34:53")),
+		errorMsg(t, router, client, 3*time.Second, 3, 13, 0, quote(t, layers.IPProtocolTCP, "192.168.1.10:40001", "198.51.100.7:22")),
+		errorMsg(t, router, client, 4*time.Second, 11, 0, 0, quote(t, layers.IPProtocolUDP, "192.168.1.10:33434", "198.51.100.7:33434")),
+		errorMsg(t, router6, client6, 5*time.Second, 2, 0, 1280, quote(t, layers.IPProtocolTCP, client6, server6)),
+		errorMsg(t, router6, client6, 6*time.Second, 1, 3, 0, quote(t, layers.IPProtocolTCP, client6, "[2001:db8:1::2]:80")),
+		// quotes too little to find the flow
+		errorMsg(t, router, client, 7*time.Second, 3, 1, 0, []byte{0x45, 0}),
+		// echo reply is not an error
+		errorMsg(t, server, client, 8*time.Second, 0, 0, 0, nil),
+	} {
+		a.Process(p)
+	}
+	a.Finish()
+
+	st := a.Stats()
+	if st.Total != 8 || st.Unparsed != 1 || st.Messages[KindPacketTooBig] != 3 || st.Messages[KindPortUnreachable] != 1 ||
+		st.Messages[KindAdminProhibited] != 1 || st.Messages[KindTTLExceeded] != 1 || st.Messages[KindHostUnreachable] != 2 {
+		t.Errorf("stats = %+v", st)
+	}
+	nets := a.Networks()
+	if len(nets) != 3 {
+		t.Fatalf("networks = %+v", nets)
+	}
+	n := nets[0]
+	if n.Prefix.String() != "93.184.216.0/24" || n.Total != 3 || n.Flows != 3 || n.MinMTU != 1280 || len(n.Reporters) != 2 {
+		t.Errorf("network = %+v", n)
+	}
+	if n.Example.String() != "TCP "+client+" → "+server {
+		t.Errorf("example = %s", n.Example)
+	}
+	if n := nets[2]; n.Prefix.String() != "2001:db8:1::/64" || n.MinMTU != 1280 || n.Messages[KindHostUnreachable] != 1 {
+		t.Errorf("IPv6 network = %+v", n)
+	}
+}
+
+func TestBlackHole(t *testing.T) {
+	ms := time.Millisecond
+	a := NewAnalyzer(DefaultConfig)
+	// flows from the server side, where the lost segments are captured
+	flows := []struct {
+		client string
+		end    func(at time.Duration) []gopacket.Packet
+	}{
+		// no feedback: a black hole
+		{client, func(time.Duration) []gopacket.Packet { return nil }},
+		// PMTU discovery at work
+		{"192.168.1.11:50000", func(at time.Duration) []gopacket.Packet {
+			return []gopacket.Packet{errorMsg(t, router, server, at, 3, 4, 1400, quote(t, layers.IPProtocolTCP, server, "192.168.1.11:50000"))}
+		}},
+		// lost a few times, then delivered at full size
+		{"192.168.2.12:50000", func(at time.Duration) []gopacket.Packet {
+			return []gopacket.Packet{segment(t, "192.168.2.12:50000", server, at, &layers.TCP{ACK: true, Seq: 1001, Ack: 5001 + 1448}, 0)}
+		}},
+		// recovered by resending smaller segments: still a black hole
+		{"192.168.3.13:50000", func(at time.Duration) []gopacket.Packet {
+			return []gopacket.Packet{
+				segment(t, server, "192.168.3.13:50000", at, &layers.TCP{ACK: true, Seq: 5001, Ack: 1001}, 500),
+				segment(t, "192.168.3.13:50000", server, at+ms, &layers.TCP{ACK: true, Seq: 1001, Ack: 5501}, 0),
+			}
+		}},
+	}
+	for i, f := range flows {
+		base := time.Duration(i) * 10 * time.Second
+		pkts := []gopacket.Packet{
+			segment(t, f.client, server, base, &layers.TCP{SYN: true, Seq: 1000}, 0),
+			segment(t, server, f.client, base+ms, &layers.TCP{SYN: true, ACK: true, Seq: 5000, Ack: 1001}, 0),
+			segment(t, f.client, server, base+2*ms, &layers.TCP{ACK: true, Seq: 1001, Ack: 5001}, 0),
+		}
+		for n, at := range []time.Duration{3, 250, 750, 1750} {
+			pkts = append(pkts, segment(t, server, f.client, base+at*ms, &layers.TCP{ACK: true, PSH: true, Seq: 5001, Ack: 1001}, 1448))
+			if n == 0 {
+				// a duplicate ACK for the handshake shows the client is alive
+				pkts = append(pkts, segment(t, f.client, server, base+at*ms+ms, &layers.TCP{ACK: true, Seq: 1001, Ack: 5001}, 0))
+			}
+		}
+		pkts = append(pkts, f.end(base+2*time.Second)...)
+		for _, p := range pkts {
+			a.Process(p)
+		}
+	}
+	a.Finish()
+
+	holes := a.BlackHoles()
+	if len(holes) != 2 {
+		t.Fatalf("black holes = %+v", holes)
+	}
+	if h := holes[0]; h.Dst.String() != client || h.Size != 1448 || h.Retransmits != 3 || h.Recovered || !h.Start.Equal(packettest.Epoch.Add(3*ms)) {
+		t.Errorf("black hole = %+v", h)
+	}
+	if h := holes[1]; h.Dst.String() != "192.168.3.13:50000" || !h.Recovered {
+		t.Errorf("recovered black hole = %+v", h)
+	}
+	nets := a.Networks()
+	if nets[0].Prefix.String() != "192.168.1.0/24" || nets[0].BlackHoles != 1 || nets[0].Messages[KindPacketTooBig] != 1 {
+		t.Errorf("networks = %+v", nets[0])
+	}
+}
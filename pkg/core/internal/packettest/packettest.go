@@ -0,0 +1,63 @@
+// Package packettest builds the captured packets the analyzer tests feed in
+package packettest
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// Epoch is the capture time packets are stamped relative to
+var Epoch = time.Date(2026, 2, 20, 10, 0, 0, 0, time.UTC)
+
+// MAC returns a locally administered MAC ending in n
+func MAC(n byte) net.HardwareAddr {
+	return net.HardwareAddr{2, 0, 0, 0, 0, n}
+}
+
+// Ethernet returns an Ethernet header from MAC(1) to MAC(2) carrying typ
+func Ethernet(typ layers.EthernetType) *layers.Ethernet {
+	return &layers.Ethernet{SrcMAC: MAC(1), DstMAC: MAC(2), EthernetType: typ}
+}
+
+// IP returns the IPv4 or IPv6 header from src to dst carrying proto, and
+// the EtherType announcing it
+func IP(src, dst netip.Addr, proto layers.IPProtocol) (gopacket.NetworkLayer, layers.EthernetType) {
+	if src.Is4() {
+		return &layers.IPv4{Version: 4, TTL: 64, Protocol: proto, SrcIP: src.AsSlice(), DstIP: dst.AsSlice()}, layers.EthernetTypeIPv4
+	}
+	return &layers.IPv6{Version: 6, HopLimit: 64, NextHeader: proto, SrcIP: src.AsSlice(), DstIP: dst.AsSlice()}, layers.EthernetTypeIPv6
+}
+
+// Serialize encodes ls, fixing lengths and checksums
+func Serialize(t testing.TB, ls ...gopacket.SerializableLayer) []byte {
+	t.Helper()
+	buf := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}, ls...); err != nil {
+		t.Fatalf("SerializeLayers: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// Frame encodes ls, starting with the Ethernet header, and decodes them as
+// a packet captured at the given offset from Epoch
+func Frame(t testing.TB, at time.Duration, ls ...gopacket.SerializableLayer) gopacket.Packet {
+	t.Helper()
+	pkt := gopacket.NewPacket(Serialize(t, ls...), layers.LinkTypeEthernet, gopacket.Default)
+	pkt.Metadata().Timestamp = Epoch.Add(at)
+	return pkt
+}
+
+// UDP returns an Ethernet frame from src to dst carrying payload over UDP,
+// captured at the given offset from Epoch
+func UDP(t testing.TB, src, dst netip.AddrPort, at time.Duration, payload []byte) gopacket.Packet {
+	t.Helper()
+	ip, typ := IP(src.Addr(), dst.Addr(), layers.IPProtocolUDP)
+	udp := &layers.UDP{SrcPort: layers.UDPPort(src.Port()), DstPort: layers.UDPPort(dst.Port())}
+	udp.SetNetworkLayerForChecksum(ip)
+	return Frame(t, at, Ethernet(typ), ip.(gopacket.SerializableLayer), udp, gopacket.Payload(payload))
+}
@@ -0,0 +1,166 @@
+// Package mtu reports the largest packets seen on each path, the datagrams
+// that arrived in fragments, and the packets that may not be fragmented yet
+// exceed a typical MTU
+package mtu
+
+import (
+	"net/netip"
+	"sort"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+
+	"network-app/pkg/core/analyzer"
+	"network-app/pkg/core/pcap"
+)
+
+// Config holds the MTU assumed for every path and the table limits
+type Config struct {
+	MTU      int // packets above this size must be fragmented to cross an Ethernet path
+	MaxPaths int // paths tracked at once; packets of new ones are counted only in the totals once full
+	TopPaths int // paths kept for the report
+}
+
+// DefaultConfig assumes Ethernet's 1500 byte MTU
+var DefaultConfig = Config{
+	MTU:      1500,
+	MaxPaths: 10000,
+	TopPaths: 20,
+}
+
+// Path counts the packets from one address to another
+type Path struct {
+	Src, Dst   netip.Addr
+	Packets    uint64
+	MaxSize    int // largest IP packet, or fragment of a reassembled datagram
+	Fragmented int // datagrams that arrived in fragments
+	Fragments  int // fragments those datagrams arrived in
+	Oversized  int // packets above the MTU that may not be fragmented
+	MaxDF      int // largest packet that may not be fragmented
+}
+
+// Stats counts every IP packet
+type Stats struct {
+	Packets      uint64
+	MaxSize      int
+	Fragmented   int // datagrams that arrived in fragments
+	Fragments    int
+	MaxDatagram  int    // largest reassembled datagram
+	DontFragment uint64 // packets with DF set, and IPv6 packets, which routers never fragment
+	Oversized    int    // of those, larger than the MTU
+	MaxOversized int
+	Evicted      int // packets of paths not tracked because the table was full
+}
+
+type pathKey struct {
+	src, dst netip.Addr
+}
+
+// Analyzer tracks packet sizes per path. Packets are measured by their IP
+// header; tunnelled ones by the inner header the pcap package presents.
+type Analyzer struct {
+	cfg   Config
+	stats Stats
+	paths map[pathKey]*Path
+}
+
+// NewAnalyzer creates a packet size tracker
+func NewAnalyzer(cfg Config) *Analyzer {
+	return &Analyzer{cfg: cfg, paths: make(map[pathKey]*Path)}
+}
+
+// Process handles a single packet. Datagrams reassembled by the pcap
+// package are measured by their largest fragment, the size that crossed
+// the path.
+func (a *Analyzer) Process(pkt gopacket.Packet) {
+	var frag pcap.Reassembly
+	switch p := pkt.(type) {
+	case pcap.Packet:
+		frag = p.Frag
+	case *pcap.Packet:
+		frag = p.Frag
+	}
+	var k pathKey
+	var size int
+	var df bool
+	switch ip := pkt.NetworkLayer().(type) {
+	case *layers.IPv4:
+		size, df = int(ip.Length), ip.Flags&layers.IPv4DontFragment != 0
+	case *layers.IPv6:
+		size, df = 40+int(ip.Length), true
+	default:
+		return
+	}
+	k.src, k.dst, _ = analyzer.Addrs(pkt.NetworkLayer())
+	st := &a.stats
+	st.Packets++
+	if frag.Fragments > 0 {
+		// the source fragmented it, or a router did before it could be dropped
+		st.Fragmented++
+		st.Fragments += frag.Fragments
+		st.MaxDatagram = max(st.MaxDatagram, size)
+		size, df = frag.Largest, false
+	}
+	st.MaxSize = max(st.MaxSize, size)
+	oversized := df && size > a.cfg.MTU
+	if df {
+		st.DontFragment++
+	}
+	if oversized {
+		st.Oversized++
+		st.MaxOversized = max(st.MaxOversized, size)
+	}
+
+	p := a.paths[k]
+	if p == nil {
+		if len(a.paths) >= a.cfg.MaxPaths {
+			st.Evicted++
+			return
+		}
+		p = &Path{Src: k.src, Dst: k.dst}
+		a.paths[k] = p
+	}
+	p.Packets++
+	p.MaxSize = max(p.MaxSize, size)
+	if frag.Fragments > 0 {
+		p.Fragmented++
+		p.Fragments += frag.Fragments
+	}
+	if df {
+		p.MaxDF = max(p.MaxDF, size)
+	}
+	if oversized {
+		p.Oversized++
+	}
+}
+
+// Stats returns the totals over all packets
+func (a *Analyzer) Stats() Stats {
+	return a.stats
+}
+
+// Paths returns the paths with oversized or fragmented packets, most such
+// packets first, followed by the others with the largest packets
+func (a *Analyzer) Paths() []*Path {
+	out := make([]*Path, 0, len(a.paths))
+	for _, p := range a.paths {
+		out = append(out, p)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		x, y := out[i], out[j]
+		if nx, ny := x.Oversized+x.Fragmented, y.Oversized+y.Fragmented; nx != ny {
+			return nx > ny
+		}
+		if x.MaxSize != y.MaxSize {
+			return x.MaxSize > y.MaxSize
+		}
+		if c := x.Src.Compare(y.Src); c != 0 {
+			return c < 0
+		}
+		return x.Dst.Compare(y.Dst) < 0
+	})
+	if len(out) > a.cfg.TopPaths {
+		out = out[:a.cfg.TopPaths]
+	}
+	return out
+}
@@ -0,0 +1,91 @@
+package mtu
+
+import (
+	"net"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+
+	"network-app/pkg/core/pcap"
+)
+
+// packet returns an IP packet of size bytes from src to dst carrying UDP
+func packet(t *testing.T, src, dst string, size int, df bool) gopacket.Packet {
+	t.Helper()
+	s, d := net.ParseIP(src), net.ParseIP(dst)
+	eth := &layers.Ethernet{SrcMAC: net.HardwareAddr{2, 0, 0, 0, 0, 1}, DstMAC: net.HardwareAddr{2, 0, 0, 0, 0, 2}}
+	var ip gopacket.SerializableLayer
+	var hdr int
+	if s.To4() != nil {
+		eth.EthernetType = layers.EthernetTypeIPv4
+		ip4 := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolUDP, SrcIP: s.To4(), DstIP: d.To4()}
+		if df {
+			ip4.Flags = layers.IPv4DontFragment
+		}
+		ip, hdr = ip4, 20
+	} else {
+		eth.EthernetType = layers.EthernetTypeIPv6
+		ip, hdr = &layers.IPv6{Version: 6, HopLimit: 64, NextHeader: layers.IPProtocolUDP, SrcIP: s, DstIP: d}, 40
+	}
+	buf := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true}, eth, ip,
+		&layers.UDP{SrcPort: 40000, DstPort: 5000}, gopacket.Payload(make([]byte, size-hdr-8))); err != nil {
+		t.Fatalf("SerializeLayers: %v", err)
+	}
+	return gopacket.NewPacket(buf.Bytes(), layers.LinkTypeEthernet, gopacket.Default)
+}
+
+func TestAnalyzer(t *testing.T) {
+	a := NewAnalyzer(DefaultConfig)
+	for _, p := range []gopacket.Packet{
+		packet(t, "10.0.0.1", "10.0.0.2", 1500, true),
+		packet(t, "10.0.0.1", "10.0.0.2", 9000, true), // jumbo, or a TSO/GRO aggregate
+		packet(t, "10.0.0.2", "10.0.0.1", 60, true),
+		packet(t, "10.0.0.3", "10.0.0.4", 1400, false),
+		// a datagram reassembled from fragments of at most 1500 bytes
+		pcap.Packet{Packet: packet(t, "10.0.0.3", "10.0.0.4", 4028, false), Frag: pcap.Reassembly{Fragments: 3, Largest: 1500}},
+		packet(t, "2001:db8::1", "2001:db8::2", 2000, false),
+		gopacket.NewPacket([]byte{1, 2, 3}, layers.LinkTypeEthernet, gopacket.Default),
+	} {
+		a.Process(p)
+	}
+
+	want := Stats{
+		Packets:      6,
+		MaxSize:      9000,
+		Fragmented:   1,
+		Fragments:    3,
+		MaxDatagram:  4028,
+		DontFragment: 4,
+		Oversized:    2,
+		MaxOversized: 9000,
+	}
+	if st := a.Stats(); st != want {
+		t.Errorf("stats = %+v\nwant    %+v", st, want)
+	}
+
+	paths := a.Paths()
+	if len(paths) != 4 {
+		t.Fatalf("got %d paths, want 4", len(paths))
+	}
+	if p := paths[0]; p.Src.String() != "10.0.0.1" || p.Packets != 2 || p.Oversized != 1 || p.MaxSize != 9000 || p.MaxDF != 9000 {
+		t.Errorf("first path = %+v", p)
+	}
+	if p := paths[1]; p.Src.String() != "2001:db8::1" || p.Oversized != 1 || p.MaxSize != 2000 {
+		t.Errorf("second path = %+v", p)
+	}
+	if p := paths[2]; p.Src.String() != "10.0.0.3" || p.Fragmented != 1 || p.Fragments != 3 || p.MaxSize != 1500 || p.MaxDF != 0 {
+		t.Errorf("third path = %+v", p)
+	}
+	if p := paths[3]; p.Src.String() != "10.0.0.2" || p.MaxSize != 60 {
+		t.Errorf("last path = %+v", p)
+	}
+
+	// the CLI passes pointers to the packets of a batch
+	b := NewAnalyzer(DefaultConfig)
+	b.Process(&pcap.Packet{Packet: packet(t, "10.0.0.3", "10.0.0.4", 4028, false), Frag: pcap.Reassembly{Fragments: 3, Largest: 1500}})
+	if st := b.Stats(); st.Fragmented != 1 || st.MaxSize != 1500 {
+		t.Errorf("stats of a packet pointer = %+v", st)
+	}
+}
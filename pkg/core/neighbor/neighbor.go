@@ -0,0 +1,465 @@
+// Package neighbor checks the health of address resolution on the local
+// link: ARP for IPv4 and Neighbor Discovery for IPv6. Unanswered requests,
+// addresses claimed by several MACs and gratuitous ARP storms explain SYN
+// timeouts that the handshake counters alone cannot.
+package neighbor
+
+import (
+	"net"
+	"net/netip"
+	"sort"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+
+	"network-app/pkg/core/analyzer"
+	"network-app/pkg/core/tcp"
+)
+
+// Config holds the timeouts, thresholds and table limits
+type Config struct {
+	ResolveTimeout   time.Duration // requests unanswered for this long have failed
+	DADWindow        time.Duration // how long a duplicate address probe watches for a defender
+	GratuitousWindow time.Duration
+	GratuitousStorm  int // gratuitous messages from one address within the window that make a storm
+	RequestRate      int // requests per second from one host that flag it
+	MaxHosts         int // hosts and addresses tracked; more only count in the totals
+	MaxPending       int // resolutions awaiting their answer
+}
+
+// DefaultConfig waits 3 seconds for an answer, as long as Linux retries
+// before giving up, and flags more than 20 gratuitous messages in 10
+// seconds or 50 requests in a second
+var DefaultConfig = Config{
+	ResolveTimeout:   3 * time.Second,
+	DADWindow:        5 * time.Second,
+	GratuitousWindow: 10 * time.Second,
+	GratuitousStorm:  20,
+	RequestRate:      50,
+	MaxHosts:         10000,
+	MaxPending:       100000,
+}
+
+// maxMACs bounds the MACs remembered per address and maxTargets the
+// unresolved targets remembered per host
+const (
+	maxMACs    = 4
+	maxTargets = 5
+)
+
+// Host holds the address resolution activity of one IP address
+type Host struct {
+	IP         netip.Addr
+	MAC        string
+	Requests   int // ARP requests or neighbor solicitations sent
+	Answered   int
+	Unanswered int          // resolutions that timed out
+	Targets    []netip.Addr // some of the addresses it failed to resolve
+	PeakRate   int          // requests in its busiest second
+	Flooding   bool         // PeakRate reached the configured request rate
+	Gratuitous int          // gratuitous ARP and unsolicited advertisements
+	Storm      bool         // gratuitous messages exceeded the storm threshold
+	// Visible reports whether unicast frames to the host were captured, so
+	// the answers to its requests would have been too
+	Visible bool
+
+	second      time.Time
+	inSecond    int
+	windowStart time.Time
+	inWindow    int
+}
+
+// Conflict is an address claimed by more than one MAC
+type Conflict struct {
+	IP    netip.Addr
+	MACs  []string
+	DAD   bool // a duplicate address probe was answered or raced, rather than conflicting claims seen
+	First time.Time
+}
+
+// Stats counts the address resolution activity of the link
+type Stats struct {
+	ARPRequests   int
+	Solicitations int // IPv6 neighbor solicitations, probes excluded
+	Probes        int // duplicate address detection: ARP probes and solicitations from ::
+	Answered      int
+	Unanswered    int // timed out, from hosts whose answers the capture would show
+	Unobserved    int // timed out, from hosts whose unicast traffic the capture does not see
+	Gratuitous    int
+	Storms        int // hosts flagged for gratuitous storms
+	Flooding      int // hosts flagged for their request rate
+	Conflicts     int // addresses claimed by several MACs
+	DADConflicts  int // of those, found by duplicate address detection
+	ResolveTime   tcp.Histogram
+}
+
+type pendingKey struct {
+	requester, target netip.Addr
+}
+
+type pending struct {
+	host  *Host
+	start time.Time
+}
+
+type probe struct {
+	mac string
+	at  time.Time
+}
+
+// Analyzer follows ARP and Neighbor Discovery. Time is driven by packet
+// timestamps.
+type Analyzer struct {
+	cfg       Config
+	stats     Stats
+	hosts     map[netip.Addr]*Host
+	claims    map[netip.Addr][]string
+	conflicts map[netip.Addr]*Conflict
+	pending   map[pendingKey]*pending
+	probes    map[netip.Addr]probe
+	visible   map[[6]byte]bool // MACs unicast frames were captured for
+	clock     analyzer.Clock
+}
+
+// NewAnalyzer creates an ARP and Neighbor Discovery analyzer
+func NewAnalyzer(cfg Config) *Analyzer {
+	return &Analyzer{
+		cfg:       cfg,
+		hosts:     make(map[netip.Addr]*Host),
+		claims:    make(map[netip.Addr][]string),
+		conflicts: make(map[netip.Addr]*Conflict),
+		pending:   make(map[pendingKey]*pending),
+		probes:    make(map[netip.Addr]probe),
+		visible:   make(map[[6]byte]bool),
+	}
+}
+
+// Process handles a single packet
+func (a *Analyzer) Process(pkt gopacket.Packet) {
+	ts := pkt.Metadata().Timestamp
+	a.advance(ts)
+	eth, _ := pkt.LinkLayer().(*layers.Ethernet)
+	if eth != nil {
+		a.see(eth.DstMAC)
+	}
+	if arp, ok := pkt.Layer(layers.LayerTypeARP).(*layers.ARP); ok {
+		a.arp(arp, ts)
+		return
+	}
+	ip6, ok := pkt.NetworkLayer().(*layers.IPv6)
+	if !ok {
+		return
+	}
+	src, _ := netip.AddrFromSlice(ip6.SrcIP)
+	dst, _ := netip.AddrFromSlice(ip6.DstIP)
+	if m, ok := pkt.Layer(layers.LayerTypeICMPv6NeighborSolicitation).(*layers.ICMPv6NeighborSolicitation); ok {
+		target, _ := netip.AddrFromSlice(m.TargetAddress)
+		if src.IsUnspecified() {
+			a.probe(target, srcMAC(eth), ts)
+			return
+		}
+		mac := option(m.Options, layers.ICMPv6OptSourceAddress, eth)
+		a.claim(src, mac, ts)
+		a.stats.Solicitations++
+		a.request(src, mac, target, ts)
+		return
+	}
+	if m, ok := pkt.Layer(layers.LayerTypeICMPv6NeighborAdvertisement).(*layers.ICMPv6NeighborAdvertisement); ok {
+		target, _ := netip.AddrFromSlice(m.TargetAddress)
+		mac := option(m.Options, layers.ICMPv6OptTargetAddress, eth)
+		a.claim(target, mac, ts)
+		if m.Solicited() && !dst.IsMulticast() {
+			a.answer(dst, target, ts)
+		} else if !m.Solicited() {
+			a.gratuitous(target, mac, ts)
+		}
+	}
+}
+
+// option returns the link-layer address option of type typ, or the source
+// address of the frame without one
+func option(opts layers.ICMPv6Options, typ layers.ICMPv6Opt, eth *layers.Ethernet) string {
+	for _, o := range opts {
+		if o.Type == typ && len(o.Data) == 6 {
+			return net.HardwareAddr(o.Data).String()
+		}
+	}
+	return srcMAC(eth)
+}
+
+// srcMAC returns the source address of eth, or "" for other link layers.
+// Only Neighbor Discovery needs it, so it is not formatted for every frame.
+func srcMAC(eth *layers.Ethernet) string {
+	if eth == nil {
+		return ""
+	}
+	return eth.SrcMAC.String()
+}
+
+// see notes that unicast frames to mac are captured
+func (a *Analyzer) see(mac net.HardwareAddr) {
+	if len(mac) != 6 || mac[0]&1 != 0 {
+		return
+	}
+	if k := [6]byte(mac); k != [6]byte{} && !a.visible[k] && len(a.visible) < a.cfg.MaxHosts {
+		a.visible[k] = true
+	}
+}
+
+func (a *Analyzer) arp(m *layers.ARP, ts time.Time) {
+	if m.Protocol != layers.EthernetTypeIPv4 || len(m.SourceProtAddress) != 4 || len(m.DstProtAddress) != 4 || len(m.SourceHwAddress) != 6 {
+		return
+	}
+	src := netip.AddrFrom4([4]byte(m.SourceProtAddress))
+	dst := netip.AddrFrom4([4]byte(m.DstProtAddress))
+	mac := net.HardwareAddr(m.SourceHwAddress).String()
+	switch {
+	case m.Operation == layers.ARPRequest && src.IsUnspecified():
+		a.probe(dst, mac, ts)
+	case m.Operation == layers.ARPRequest && src == dst:
+		a.claim(src, mac, ts)
+		a.gratuitous(src, mac, ts)
+	case m.Operation == layers.ARPRequest:
+		a.claim(src, mac, ts)
+		a.stats.ARPRequests++
+		a.request(src, mac, dst, ts)
+	case m.Operation == layers.ARPReply:
+		a.claim(src, mac, ts)
+		switch {
+		case src == dst:
+			a.gratuitous(src, mac, ts)
+			return
+		case dst.IsUnspecified():
+			// the owner defending its address against a probe
+			return
+		}
+		a.see(m.DstHwAddress)
+		a.answer(dst, src, ts)
+	}
+}
+
+// host returns the host with address ip, or nil once the table is full
+func (a *Analyzer) host(ip netip.Addr, mac string) *Host {
+	h := a.hosts[ip]
+	if h == nil {
+		if len(a.hosts) >= a.cfg.MaxHosts {
+			return nil
+		}
+		h = &Host{IP: ip}
+		a.hosts[ip] = h
+	}
+	if mac != "" {
+		h.MAC = mac
+	}
+	return h
+}
+
+// request records that requester asked for the link-layer address of target
+func (a *Analyzer) request(requester netip.Addr, mac string, target netip.Addr, ts time.Time) {
+	h := a.host(requester, mac)
+	if h == nil {
+		return
+	}
+	h.Requests++
+	if sec := ts.Truncate(time.Second); !sec.Equal(h.second) {
+		h.second, h.inSecond = sec, 0
+	}
+	h.inSecond++
+	h.PeakRate = max(h.PeakRate, h.inSecond)
+	if h.PeakRate >= a.cfg.RequestRate && !h.Flooding {
+		h.Flooding = true
+		a.stats.Flooding++
+	}
+	k := pendingKey{requester, target}
+	if a.pending[k] == nil && len(a.pending) < a.cfg.MaxPending {
+		// retries extend the wait for the first request
+		a.pending[k] = &pending{host: h, start: ts}
+	}
+}
+
+// answer records that target answered requester
+func (a *Analyzer) answer(requester, target netip.Addr, ts time.Time) {
+	k := pendingKey{requester, target}
+	p := a.pending[k]
+	if p == nil {
+		return
+	}
+	delete(a.pending, k)
+	p.host.Answered++
+	a.stats.Answered++
+	a.stats.ResolveTime.Record(ts.Sub(p.start))
+}
+
+// gratuitous records an unsolicited announcement of ip
+func (a *Analyzer) gratuitous(ip netip.Addr, mac string, ts time.Time) {
+	a.stats.Gratuitous++
+	h := a.host(ip, mac)
+	if h == nil {
+		return
+	}
+	h.Gratuitous++
+	if ts.Sub(h.windowStart) >= a.cfg.GratuitousWindow {
+		h.windowStart, h.inWindow = ts, 0
+	}
+	h.inWindow++
+	if h.inWindow > a.cfg.GratuitousStorm && !h.Storm {
+		h.Storm = true
+		a.stats.Storms++
+	}
+}
+
+// claim records that mac uses ip, flagging a conflict when another MAC
+// already does or a duplicate address probe for ip is under way
+func (a *Analyzer) claim(ip netip.Addr, mac string, ts time.Time) {
+	if !ip.IsValid() || ip.IsUnspecified() || mac == "" {
+		return
+	}
+	if p, ok := a.probes[ip]; ok && p.mac != mac && ts.Sub(p.at) < a.cfg.DADWindow {
+		a.conflict(ip, []string{p.mac, mac}, true, ts)
+	}
+	macs, ok := a.claims[ip]
+	if !ok && len(a.claims) >= a.cfg.MaxHosts {
+		return
+	}
+	for _, m := range macs {
+		if m == mac {
+			return
+		}
+	}
+	if len(macs) < maxMACs {
+		a.claims[ip] = append(macs, mac)
+	}
+	if len(macs) > 0 {
+		a.conflict(ip, a.claims[ip], false, ts)
+	}
+}
+
+// probe records a duplicate address detection probe for ip from mac; a
+// second prober at the same time is a conflict too
+func (a *Analyzer) probe(ip netip.Addr, mac string, ts time.Time) {
+	a.stats.Probes++
+	if p, ok := a.probes[ip]; ok && p.mac != mac && ts.Sub(p.at) < a.cfg.DADWindow {
+		a.conflict(ip, []string{p.mac, mac}, true, ts)
+	}
+	if len(a.probes) < a.cfg.MaxHosts {
+		a.probes[ip] = probe{mac: mac, at: ts}
+	}
+}
+
+func (a *Analyzer) conflict(ip netip.Addr, macs []string, dad bool, ts time.Time) {
+	c := a.conflicts[ip]
+	if c == nil {
+		c = &Conflict{IP: ip, First: ts}
+		a.conflicts[ip] = c
+		a.stats.Conflicts++
+	}
+	if dad && !c.DAD {
+		c.DAD = true
+		a.stats.DADConflicts++
+	}
+	for _, m := range macs {
+		found := false
+		for _, x := range c.MACs {
+			found = found || x == m
+		}
+		if !found && len(c.MACs) < maxMACs {
+			c.MACs = append(c.MACs, m)
+		}
+	}
+}
+
+// advance moves the clock and, about once per second of capture time,
+// fails the requests that waited too long
+func (a *Analyzer) advance(ts time.Time) {
+	if !a.clock.Advance(ts) {
+		return
+	}
+	a.expire()
+}
+
+func (a *Analyzer) expire() {
+	for k, p := range a.pending {
+		if a.clock.Now().Sub(p.start) >= a.cfg.ResolveTimeout {
+			delete(a.pending, k)
+			p.host.Unanswered++
+			if len(p.host.Targets) < maxTargets {
+				p.host.Targets = append(p.host.Targets, k.target)
+			}
+		}
+	}
+	for ip, p := range a.probes {
+		if a.clock.Now().Sub(p.at) >= a.cfg.DADWindow {
+			delete(a.probes, ip)
+		}
+	}
+}
+
+// Finish fails the requests that waited too long and sorts the unanswered
+// ones by whether the capture could have shown their answers
+func (a *Analyzer) Finish() {
+	a.expire()
+	for _, h := range a.hosts {
+		if mac, err := net.ParseMAC(h.MAC); err == nil && len(mac) == 6 {
+			h.Visible = a.visible[[6]byte(mac)]
+		}
+		if h.Visible {
+			a.stats.Unanswered += h.Unanswered
+		} else {
+			a.stats.Unobserved += h.Unanswered
+		}
+	}
+}
+
+// Stats returns the totals. Only valid after Finish.
+func (a *Analyzer) Stats() Stats {
+	return a.stats
+}
+
+// Hosts returns the hosts that sent requests or announcements, those with
+// failed resolutions, storms or request floods first
+func (a *Analyzer) Hosts() []*Host {
+	out := make([]*Host, 0, len(a.hosts))
+	for _, h := range a.hosts {
+		out = append(out, h)
+	}
+	failed := func(h *Host) int {
+		if h.Visible {
+			return h.Unanswered
+		}
+		return 0
+	}
+	sort.Slice(out, func(i, j int) bool {
+		x, y := out[i], out[j]
+		if failed(x) != failed(y) {
+			return failed(x) > failed(y)
+		}
+		if x.Storm != y.Storm {
+			return x.Storm
+		}
+		if x.PeakRate != y.PeakRate {
+			return x.PeakRate > y.PeakRate
+		}
+		if x.Requests+x.Gratuitous != y.Requests+y.Gratuitous {
+			return x.Requests+x.Gratuitous > y.Requests+y.Gratuitous
+		}
+		return x.IP.Less(y.IP)
+	})
+	return out
+}
+
+// Conflicts returns the addresses claimed by several MACs, in order of
+// appearance
+func (a *Analyzer) Conflicts() []*Conflict {
+	out := make([]*Conflict, 0, len(a.conflicts))
+	for _, c := range a.conflicts {
+		out = append(out, c)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if !out[i].First.Equal(out[j].First) {
+			return out[i].First.Before(out[j].First)
+		}
+		return out[i].IP.Less(out[j].IP)
+	})
+	return out
+}
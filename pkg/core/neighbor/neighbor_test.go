@@ -0,0 +1,162 @@
+package neighbor
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+
+	"network-app/pkg/core/internal/packettest"
+)
+
+var broadcast = net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+
+// arp builds an ARP message; requests are broadcast, replies unicast
+func arp(t *testing.T, at time.Duration, op uint16, src net.HardwareAddr, srcIP string, dst net.HardwareAddr, dstIP string) gopacket.Packet {
+	t.Helper()
+	ethDst, target := dst, dst
+	if op == layers.ARPRequest {
+		ethDst, target = broadcast, make(net.HardwareAddr, 6)
+	}
+	return packettest.Frame(t, at,
+		&layers.Ethernet{SrcMAC: src, DstMAC: ethDst, EthernetType: layers.EthernetTypeARP},
+		&layers.ARP{
+			AddrType: layers.LinkTypeEthernet, Protocol: layers.EthernetTypeIPv4, HwAddressSize: 6, ProtAddressSize: 4,
+			Operation: op, SourceHwAddress: src, SourceProtAddress: netip.MustParseAddr(srcIP).AsSlice(),
+			DstHwAddress: target, DstProtAddress: netip.MustParseAddr(dstIP).AsSlice(),
+		})
+}
+
+// ndp builds a neighbor solicitation, or an advertisement with the given
+// flags when adv is set
+func ndp(t *testing.T, at time.Duration, src net.HardwareAddr, srcIP, dstIP, target string, adv bool, flags uint8) gopacket.Packet {
+	t.Helper()
+	dst := netip.MustParseAddr(dstIP)
+	ethDst := net.HardwareAddr{0x33, 0x33, 0, 0, 0, 1}
+	if !dst.IsMulticast() {
+		ethDst = packettest.MAC(1)
+	}
+	ip := &layers.IPv6{Version: 6, HopLimit: 255, NextHeader: layers.IPProtocolICMPv6,
+		SrcIP: netip.MustParseAddr(srcIP).AsSlice(), DstIP: dst.AsSlice()}
+	var icmp *layers.ICMPv6
+	var msg gopacket.SerializableLayer
+	if adv {
+		icmp = &layers.ICMPv6{TypeCode: layers.CreateICMPv6TypeCode(layers.ICMPv6TypeNeighborAdvertisement, 0)}
+		msg = &layers.ICMPv6NeighborAdvertisement{Flags: flags, TargetAddress: netip.MustParseAddr(target).AsSlice(),
+			Options: layers.ICMPv6Options{{Type: layers.ICMPv6OptTargetAddress, Data: src}}}
+	} else {
+		icmp = &layers.ICMPv6{TypeCode: layers.CreateICMPv6TypeCode(layers.ICMPv6TypeNeighborSolicitation, 0)}
+		ns := &layers.ICMPv6NeighborSolicitation{TargetAddress: netip.MustParseAddr(target).AsSlice()}
+		if srcIP != "::" {
+			ns.Options = layers.ICMPv6Options{{Type: layers.ICMPv6OptSourceAddress, Data: src}}
+		}
+		msg = ns
+	}
+	icmp.SetNetworkLayerForChecksum(ip)
+	return packettest.Frame(t, at, &layers.Ethernet{SrcMAC: src, DstMAC: ethDst, EthernetType: layers.EthernetTypeIPv6}, ip, icmp, msg)
+}
+
+func TestARP(t *testing.T) {
+	ms, s := time.Millisecond, time.Second
+	pkts := []gopacket.Packet{
+		// resolved in 2 ms; the reply shows that .10 receives unicast frames
+		arp(t, 0, layers.ARPRequest, packettest.MAC(10), "192.168.1.10", nil, "192.168.1.1"),
+		arp(t, 2*ms, layers.ARPReply, packettest.MAC(1), "192.168.1.1", packettest.MAC(10), "192.168.1.10"),
+		// never answered, despite retries
+		arp(t, s, layers.ARPRequest, packettest.MAC(10), "192.168.1.10", nil, "192.168.1.50"),
+		arp(t, 2*s, layers.ARPRequest, packettest.MAC(10), "192.168.1.10", nil, "192.168.1.50"),
+		arp(t, 3*s, layers.ARPRequest, packettest.MAC(10), "192.168.1.10", nil, "192.168.1.50"),
+		// the answer to another host is unicast and not captured
+		arp(t, s, layers.ARPRequest, packettest.MAC(20), "192.168.1.20", nil, "192.168.1.1"),
+		// two MACs claiming one address; the request goes unanswered too
+		arp(t, 4*s, layers.ARPRequest, packettest.MAC(30), "192.168.1.30", nil, "192.168.1.1"),
+		arp(t, 4*s+ms, layers.ARPReply, packettest.MAC(31), "192.168.1.30", packettest.MAC(1), "192.168.1.1"),
+		// a probe answered by the current owner
+		arp(t, 5*s, layers.ARPRequest, packettest.MAC(40), "0.0.0.0", nil, "192.168.1.40"),
+		arp(t, 5*s+ms, layers.ARPReply, packettest.MAC(41), "192.168.1.40", packettest.MAC(40), "0.0.0.0"),
+	}
+	// a gratuitous ARP storm
+	for i := 0; i < 25; i++ {
+		pkts = append(pkts, arp(t, 10*s+time.Duration(i)*200*ms, layers.ARPRequest, packettest.MAC(60), "192.168.1.60", nil, "192.168.1.60"))
+	}
+	// a host sweeping the subnet
+	for i := 0; i < 60; i++ {
+		target := netip.AddrFrom4([4]byte{192, 168, 2, byte(i)}).String()
+		pkts = append(pkts, arp(t, 16*s+time.Duration(i)*10*ms, layers.ARPRequest, packettest.MAC(70), "192.168.1.70", nil, target))
+	}
+	pkts = append(pkts, arp(t, 30*s, layers.ARPRequest, packettest.MAC(10), "192.168.1.10", nil, "192.168.1.1"),
+		arp(t, 30*s+ms, layers.ARPReply, packettest.MAC(1), "192.168.1.1", packettest.MAC(10), "192.168.1.10"))
+
+	a := NewAnalyzer(DefaultConfig)
+	for _, p := range pkts {
+		a.Process(p)
+	}
+	a.Finish()
+
+	st := a.Stats()
+	if st.ARPRequests != 67 || st.Answered != 2 || st.Unanswered != 1 || st.Unobserved != 62 || st.Probes != 1 {
+		t.Errorf("requests = %+v", st)
+	}
+	if st.Gratuitous != 25 || st.Storms != 1 || st.Flooding != 1 || st.Conflicts != 2 || st.DADConflicts != 1 {
+		t.Errorf("stats = %+v", st)
+	}
+	if st.ResolveTime.Count() != 2 || st.ResolveTime.Max() != 2*ms {
+		t.Errorf("resolve time max %v", st.ResolveTime.Max())
+	}
+
+	hosts := a.Hosts()
+	if h := hosts[0]; h.IP.String() != "192.168.1.10" || !h.Visible || h.Unanswered != 1 || h.Answered != 2 ||
+		len(h.Targets) != 1 || h.Targets[0].String() != "192.168.1.50" {
+		t.Errorf("first host = %+v", h)
+	}
+	if h := hosts[1]; h.IP.String() != "192.168.1.60" || !h.Storm || h.Gratuitous != 25 || h.MAC != packettest.MAC(60).String() {
+		t.Errorf("second host = %+v", h)
+	}
+	if h := hosts[2]; h.IP.String() != "192.168.1.70" || !h.Flooding || h.PeakRate != 60 || h.Visible {
+		t.Errorf("third host = %+v", h)
+	}
+
+	conflicts := a.Conflicts()
+	if len(conflicts) != 2 {
+		t.Fatalf("conflicts = %+v", conflicts)
+	}
+	if c := conflicts[0]; c.IP.String() != "192.168.1.30" || c.DAD || len(c.MACs) != 2 {
+		t.Errorf("conflict = %+v", c)
+	}
+	if c := conflicts[1]; c.IP.String() != "192.168.1.40" || !c.DAD || c.MACs[0] != packettest.MAC(40).String() || c.MACs[1] != packettest.MAC(41).String() {
+		t.Errorf("DAD conflict = %+v", c)
+	}
+}
+
+func TestNDP(t *testing.T) {
+	ms := time.Millisecond
+	const solicited, override = 0x40, 0x20
+	a := NewAnalyzer(DefaultConfig)
+	for _, p := range []gopacket.Packet{
+		ndp(t, 0, packettest.MAC(10), "2001:db8::10", "ff02::1:ff00:1", "2001:db8::1", false, 0),
+		ndp(t, 3*ms, packettest.MAC(1), "2001:db8::1", "2001:db8::10", "2001:db8::1", true, solicited|override),
+		// duplicate address detection, defended by the current owner
+		ndp(t, time.Second, packettest.MAC(99), "::", "ff02::1:ff00:99", "2001:db8::99", false, 0),
+		ndp(t, time.Second+ms, packettest.MAC(98), "2001:db8::99", "ff02::1", "2001:db8::99", true, override),
+		// an unsolicited advertisement after a MAC change
+		ndp(t, 2*time.Second, packettest.MAC(2), "2001:db8::1", "ff02::1", "2001:db8::1", true, override),
+	} {
+		a.Process(p)
+	}
+	a.Finish()
+
+	st := a.Stats()
+	if st.Solicitations != 1 || st.Probes != 1 || st.Answered != 1 || st.Gratuitous != 2 || st.ResolveTime.Max() != 3*ms {
+		t.Errorf("stats = %+v", st)
+	}
+	if st.Conflicts != 2 || st.DADConflicts != 1 {
+		t.Errorf("conflicts = %+v", st)
+	}
+	conflicts := a.Conflicts()
+	if len(conflicts) != 2 || conflicts[0].IP.String() != "2001:db8::99" || !conflicts[0].DAD || conflicts[1].IP.String() != "2001:db8::1" {
+		t.Errorf("conflicts = %+v", conflicts)
+	}
+}
@@ -0,0 +1,86 @@
+package pcap
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// FanoutMode selects how packets are spread over the sockets of a fanout group
+type FanoutMode string
+
+const (
+	FanoutHash     FanoutMode = "hash"     // by flow hash, keeps flows on one socket
+	FanoutLB       FanoutMode = "lb"       // round robin
+	FanoutCPU      FanoutMode = "cpu"      // by receiving CPU
+	FanoutRollover FanoutMode = "rollover" // fill one socket before the next
+)
+
+// AFPacketConfig configures the TPACKET_V3 ring of an AF_PACKET source
+type AFPacketConfig struct {
+	BlockSize    int           // bytes per ring block, a multiple of the page size
+	NumBlocks    int           // number of blocks in the ring
+	FrameSize    int           // nominal frame size, a multiple of 16
+	BlockTimeout time.Duration // kernel retires a partly filled block after this
+	Timeout      time.Duration // poll timeout; reads return ErrReadTimeout when it expires
+	FanoutGroup  int           // fanout group id, 0 disables fanout
+	FanoutMode   FanoutMode
+}
+
+// DefaultAFPacketConfig is a 16 MiB ring suitable for multi-Gbps links
+var DefaultAFPacketConfig = AFPacketConfig{
+	BlockSize:    1 << 20,
+	NumBlocks:    16,
+	FrameSize:    2048,
+	BlockTimeout: 64 * time.Millisecond,
+	Timeout:      100 * time.Millisecond,
+	FanoutMode:   FanoutHash,
+}
+
+// validate checks the ring geometry before it is handed to the kernel
+func (c AFPacketConfig) validate() error {
+	page := os.Getpagesize()
+	switch {
+	case c.BlockSize <= 0 || c.BlockSize%page != 0:
+		return fmt.Errorf("block size %d must be a positive multiple of the page size (%d)", c.BlockSize, page)
+	case c.NumBlocks <= 0:
+		return fmt.Errorf("number of blocks must be > 0, got %d", c.NumBlocks)
+	case c.FrameSize <= 0 || c.FrameSize%16 != 0:
+		return fmt.Errorf("frame size %d must be a positive multiple of 16", c.FrameSize)
+	case c.FrameSize > c.BlockSize:
+		return fmt.Errorf("frame size %d exceeds block size %d", c.FrameSize, c.BlockSize)
+	case c.FanoutGroup < 0 || c.FanoutGroup > 0xffff:
+		return fmt.Errorf("fanout group %d out of range 0-65535", c.FanoutGroup)
+	}
+	if c.FanoutGroup != 0 {
+		if _, err := c.FanoutMode.value(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ParseFanoutMode converts a flag value into a FanoutMode
+func ParseFanoutMode(s string) (FanoutMode, error) {
+	m := FanoutMode(strings.ToLower(s))
+	if _, err := m.value(); err != nil {
+		return "", err
+	}
+	return m, nil
+}
+
+// value returns the PACKET_FANOUT_* constant for the mode
+func (m FanoutMode) value() (int, error) {
+	switch m {
+	case FanoutHash:
+		return 0, nil
+	case FanoutLB:
+		return 1, nil
+	case FanoutCPU:
+		return 2, nil
+	case FanoutRollover:
+		return 3, nil
+	}
+	return 0, fmt.Errorf("unknown fanout mode %q (want hash, lb, cpu or rollover)", string(m))
+}
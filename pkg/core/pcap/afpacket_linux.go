@@ -0,0 +1,225 @@
+package pcap
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"golang.org/x/sys/unix"
+)
+
+// Offsets into struct tpacket_block_desc (see linux/if_packet.h)
+const (
+	blockStatusOffset   = 8
+	blockNumPktsOffset  = 12
+	blockFirstPktOffset = 16
+)
+
+// Offsets of the struct sockaddr_ll that follows every tpacket3_hdr
+const (
+	sllOffset        = 48 // TPACKET_ALIGN(sizeof(struct tpacket3_hdr))
+	sllHatypeOffset  = 8
+	sllPkttypeOffset = 10
+)
+
+// afpacketSource reads frames from a TPACKET_V3 mmap ring without libpcap
+type afpacketSource struct {
+	fd      int
+	ring    []byte
+	cfg     AFPacketConfig
+	block   int    // block currently owned by user space
+	offset  uint32 // offset of the next frame within the block
+	pending uint32 // frames left in the block, 0 when no block is held
+	held    bool
+
+	mu    sync.Mutex
+	stats Stats // the kernel resets PACKET_STATISTICS on every read
+}
+
+// NewAFPacketSource opens an AF_PACKET socket with a TPACKET_V3 receive ring
+// on iface ("any" captures on all interfaces)
+func NewAFPacketSource(iface string, cfg AFPacketConfig) (PacketSource, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("afpacket config: %w", err)
+	}
+	ifindex := 0
+	if iface != "any" {
+		ifi, err := net.InterfaceByName(iface)
+		if err != nil {
+			return nil, fmt.Errorf("afpacket: %w", err)
+		}
+		ifindex = ifi.Index
+	}
+
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_RAW, int(htons(unix.ETH_P_ALL)))
+	if err != nil {
+		return nil, fmt.Errorf("afpacket socket: %w", err)
+	}
+	s := &afpacketSource{fd: fd, cfg: cfg}
+	if err := s.setup(ifindex); err != nil {
+		s.Close()
+		return nil, fmt.Errorf("afpacket(%s): %w", iface, err)
+	}
+	return s, nil
+}
+
+func (s *afpacketSource) setup(ifindex int) error {
+	if err := unix.SetsockoptInt(s.fd, unix.SOL_PACKET, unix.PACKET_VERSION, unix.TPACKET_V3); err != nil {
+		return fmt.Errorf("PACKET_VERSION: %w", err)
+	}
+	req := unix.TpacketReq3{
+		Block_size:     uint32(s.cfg.BlockSize),
+		Block_nr:       uint32(s.cfg.NumBlocks),
+		Frame_size:     uint32(s.cfg.FrameSize),
+		Frame_nr:       uint32(s.cfg.BlockSize / s.cfg.FrameSize * s.cfg.NumBlocks),
+		Retire_blk_tov: uint32(s.cfg.BlockTimeout / time.Millisecond),
+	}
+	if err := unix.SetsockoptTpacketReq3(s.fd, unix.SOL_PACKET, unix.PACKET_RX_RING, &req); err != nil {
+		return fmt.Errorf("PACKET_RX_RING: %w", err)
+	}
+	ring, err := unix.Mmap(s.fd, 0, s.cfg.BlockSize*s.cfg.NumBlocks, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED)
+	if err != nil {
+		return fmt.Errorf("mmap: %w", err)
+	}
+	s.ring = ring
+
+	addr := &unix.SockaddrLinklayer{Protocol: htons(unix.ETH_P_ALL), Ifindex: ifindex}
+	if err := unix.Bind(s.fd, addr); err != nil {
+		return fmt.Errorf("bind: %w", err)
+	}
+	if s.cfg.FanoutGroup != 0 {
+		mode, _ := s.cfg.FanoutMode.value()
+		if err := unix.SetsockoptInt(s.fd, unix.SOL_PACKET, unix.PACKET_FANOUT, s.cfg.FanoutGroup|mode<<16); err != nil {
+			return fmt.Errorf("PACKET_FANOUT: %w", err)
+		}
+	}
+	return nil
+}
+
+// ReadPacketData returns a copy of the next frame from the ring
+func (s *afpacketSource) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	data, ci, err := s.ZeroCopyReadPacketData()
+	if err != nil {
+		return nil, ci, err
+	}
+	return append([]byte(nil), data...), ci, nil
+}
+
+// ZeroCopyReadPacketData returns the next frame in the ring, waiting up to
+// the configured timeout for the kernel to hand over a block. The frame is
+// valid until the next read, which may return its block to the kernel.
+func (s *afpacketSource) ZeroCopyReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	deadline := time.Now().Add(s.cfg.Timeout)
+	for {
+		if !s.held {
+			if s.blockStatus()&unix.TP_STATUS_USER == 0 {
+				if err := s.poll(time.Until(deadline)); err != nil {
+					return nil, gopacket.CaptureInfo{}, err
+				}
+				continue
+			}
+			base := s.block * s.cfg.BlockSize
+			s.pending = binary.NativeEndian.Uint32(s.ring[base+blockNumPktsOffset:])
+			s.offset = binary.NativeEndian.Uint32(s.ring[base+blockFirstPktOffset:])
+			s.held = true
+		}
+		if s.pending == 0 {
+			s.releaseBlock()
+			continue
+		}
+
+		base := s.block*s.cfg.BlockSize + int(s.offset)
+		hdr := (*unix.Tpacket3Hdr)(unsafe.Pointer(&s.ring[base]))
+		s.offset += hdr.Next_offset
+		s.pending--
+
+		// loopback hands every packet to the socket twice, once outgoing and
+		// once incoming; keep the incoming copy like libpcap does
+		sll := base + sllOffset
+		if binary.NativeEndian.Uint16(s.ring[sll+sllHatypeOffset:]) == unix.ARPHRD_LOOPBACK &&
+			s.ring[sll+sllPkttypeOffset] == unix.PACKET_OUTGOING {
+			continue
+		}
+
+		start := base + int(hdr.Mac)
+		data := s.ring[start : start+int(hdr.Snaplen) : start+int(hdr.Snaplen)]
+		ci := gopacket.CaptureInfo{
+			Timestamp:     time.Unix(int64(hdr.Sec), int64(hdr.Nsec)),
+			CaptureLength: int(hdr.Snaplen),
+			Length:        int(hdr.Len),
+		}
+		return data, ci, nil
+	}
+}
+
+// blockStatus reads block_status of the current block
+func (s *afpacketSource) blockStatus() uint32 {
+	p := (*uint32)(unsafe.Pointer(&s.ring[s.block*s.cfg.BlockSize+blockStatusOffset]))
+	return atomic.LoadUint32(p)
+}
+
+// releaseBlock hands the current block back to the kernel
+func (s *afpacketSource) releaseBlock() {
+	p := (*uint32)(unsafe.Pointer(&s.ring[s.block*s.cfg.BlockSize+blockStatusOffset]))
+	atomic.StoreUint32(p, unix.TP_STATUS_KERNEL)
+	s.block = (s.block + 1) % s.cfg.NumBlocks
+	s.held = false
+}
+
+// poll waits for the socket to become readable
+func (s *afpacketSource) poll(timeout time.Duration) error {
+	if timeout <= 0 {
+		return ErrReadTimeout
+	}
+	fds := []unix.PollFd{{Fd: int32(s.fd), Events: unix.POLLIN | unix.POLLERR}}
+	n, err := unix.Poll(fds, int(timeout/time.Millisecond)+1)
+	if err == unix.EINTR {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("afpacket poll: %w", err)
+	}
+	if n == 0 {
+		return ErrReadTimeout
+	}
+	return nil
+}
+
+func (s *afpacketSource) LinkType() layers.LinkType {
+	return layers.LinkTypeEthernet
+}
+
+// Stats accumulates PACKET_STATISTICS. Kernel packet counts include drops.
+func (s *afpacketSource) Stats() (Stats, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st, err := unix.GetsockoptTpacketStatsV3(s.fd, unix.SOL_PACKET, unix.PACKET_STATISTICS)
+	if err != nil {
+		return s.stats, fmt.Errorf("PACKET_STATISTICS: %w", err)
+	}
+	s.stats.PacketsReceived += int(st.Packets)
+	s.stats.PacketsDropped += int(st.Drops)
+	return s.stats, nil
+}
+
+func (s *afpacketSource) Close() {
+	if s.ring != nil {
+		unix.Munmap(s.ring)
+		s.ring = nil
+	}
+	if s.fd >= 0 {
+		unix.Close(s.fd)
+		s.fd = -1
+	}
+}
+
+// htons converts a uint16 from host to network byte order
+func htons(v uint16) uint16 {
+	return binary.NativeEndian.Uint16(binary.BigEndian.AppendUint16(nil, v))
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package pcap
+
+import "errors"
+
+// NewAFPacketSource is only available on Linux
+func NewAFPacketSource(iface string, cfg AFPacketConfig) (PacketSource, error) {
+	return nil, errors.New("afpacket backend is only supported on Linux")
+}
@@ -0,0 +1,66 @@
+package pcap
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAFPacketConfigValidate(t *testing.T) {
+	if err := DefaultAFPacketConfig.validate(); err != nil {
+		t.Fatalf("default config invalid: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(*AFPacketConfig)
+	}{
+		{"block not page aligned", func(c *AFPacketConfig) { c.BlockSize = 1000 }},
+		{"no blocks", func(c *AFPacketConfig) { c.NumBlocks = 0 }},
+		{"frame not aligned", func(c *AFPacketConfig) { c.FrameSize = 1500 }},
+		{"frame larger than block", func(c *AFPacketConfig) { c.FrameSize = c.BlockSize * 2 }},
+		{"fanout group out of range", func(c *AFPacketConfig) { c.FanoutGroup = 70000 }},
+		{"bad fanout mode", func(c *AFPacketConfig) { c.FanoutGroup = 1; c.FanoutMode = "random" }},
+	}
+	for _, tt := range tests {
+		cfg := DefaultAFPacketConfig
+		tt.modify(&cfg)
+		if err := cfg.validate(); err == nil {
+			t.Errorf("%s: validate() succeeded, want error", tt.name)
+		}
+	}
+}
+
+func TestParseFanoutMode(t *testing.T) {
+	if m, err := ParseFanoutMode("LB"); err != nil || m != FanoutLB {
+		t.Errorf("ParseFanoutMode(LB) = %q, %v", m, err)
+	}
+	if _, err := ParseFanoutMode("bogus"); err == nil {
+		t.Error("ParseFanoutMode(bogus) should fail")
+	}
+}
+
+func TestAFPacketSource(t *testing.T) {
+	cfg := DefaultAFPacketConfig
+	cfg.NumBlocks = 2
+	cfg.Timeout = 200 * time.Millisecond
+	src, err := NewAFPacketSource("lo", cfg)
+	if err != nil {
+		t.Skipf("AF_PACKET not available (needs Linux and CAP_NET_RAW): %v", err)
+	}
+	defer src.Close()
+
+	// A quiet loopback may deliver nothing; either way the read must end
+	// with a packet or a timeout instead of blocking forever.
+	start := time.Now()
+	for {
+		if _, _, err := src.ReadPacketData(); err != nil {
+			break
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("capture did not time out")
+		}
+	}
+	if _, err := src.Stats(); err != nil {
+		t.Errorf("Stats() failed: %v", err)
+	}
+}
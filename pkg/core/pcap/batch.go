@@ -0,0 +1,86 @@
+package pcap
+
+import (
+	"sync/atomic"
+)
+
+// batchSize is the number of packets CaptureBatches delivers at once, and
+// freeBatches the number of released batches a handle keeps for reuse
+const (
+	batchSize   = 256
+	freeBatches = 64
+)
+
+// Batch is a run of packets read by one handle and delivered together,
+// saving a channel operation per packet. The common Ethernet, IP, TCP and
+// UDP packets are decoded into layers the batch owns, which are reused once
+// it is released: consumers must copy whatever they keep of a packet.
+type Batch struct {
+	Packets []Packet
+	records []record
+	free    chan *Batch
+}
+
+// Release hands the batch back to the handle that read it. Neither the
+// batch nor its packets may be used afterwards.
+func (b *Batch) Release() {
+	select {
+	case b.free <- b:
+	default:
+	}
+}
+
+// batch returns a released batch, or a new one when there is none
+func (c *CaptureHandle) batch() *Batch {
+	select {
+	case b := <-c.free:
+		b.Packets = b.Packets[:0]
+		return b
+	default:
+	}
+	b := &Batch{Packets: make([]Packet, 0, batchSize), records: make([]record, batchSize), free: c.free}
+	for i := range b.records {
+		b.records[i].init()
+	}
+	return b
+}
+
+// CaptureBatches reads packets and sends them to ch in batches, the last
+// one possibly short. Unlike Capture it decodes the common frames without
+// allocating, so a consumer that releases its batches keeps up with much
+// faster links. In non-blocking mode a batch the consumer is not ready for
+// is dropped as a whole.
+func (c *CaptureHandle) CaptureBatches(ch chan<- *Batch) error {
+	b := c.batch()
+	for {
+		packet, ok := c.next(&b.records[len(b.Packets)])
+		if !ok {
+			break
+		}
+		b.Packets = append(b.Packets, packet)
+		if len(b.Packets) == batchSize {
+			c.send(ch, b)
+			b = c.batch()
+		}
+	}
+	if len(b.Packets) > 0 {
+		c.send(ch, b)
+	} else {
+		b.Release()
+	}
+	return c.teeErr
+}
+
+// send delivers b, or in non-blocking mode drops it when ch is full
+func (c *CaptureHandle) send(ch chan<- *Batch, b *Batch) {
+	if !c.nonBlocking {
+		ch <- b
+		return
+	}
+	select {
+	case ch <- b:
+	default:
+		atomic.AddInt64(&c.channelDropped, int64(len(b.Packets)))
+		b.Release()
+	}
+}
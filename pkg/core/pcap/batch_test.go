@@ -0,0 +1,103 @@
+package pcap
+
+import (
+	"testing"
+
+	"github.com/google/gopacket/layers"
+)
+
+func TestCaptureBatches(t *testing.T) {
+	// enough packets for two batches, with an ARP request decoded by
+	// NewPacket among them
+	arp := serialize(t, &layers.Ethernet{SrcMAC: fragMAC, DstMAC: layers.EthernetBroadcast, EthernetType: layers.EthernetTypeARP},
+		&layers.ARP{AddrType: layers.LinkTypeEthernet, Protocol: layers.EthernetTypeIPv4, HwAddressSize: 6, ProtAddressSize: 4, Operation: layers.ARPRequest,
+			SourceHwAddress: fragMAC, SourceProtAddress: []byte{10, 0, 0, 1}, DstHwAddress: make([]byte, 6), DstProtAddress: []byte{10, 0, 0, 2}})
+	frames := make([]Frame, batchSize+10)
+	for i := range frames {
+		frames[i] = Frame{Data: tcpFrame(t, true, i%2 == 1)}
+	}
+	frames[3].Data = arp
+	handle := NewHandle(NewMemorySource(layers.LinkTypeEthernet, frames), "mem0")
+
+	batches := make(chan *Batch, 4)
+	if err := handle.CaptureBatches(batches); err != nil {
+		t.Fatalf("CaptureBatches failed: %v", err)
+	}
+	close(batches)
+	var sizes []int
+	for b := range batches {
+		sizes = append(sizes, len(b.Packets))
+		for i, p := range b.Packets {
+			if p.Interface != "mem0" {
+				t.Fatalf("packet %d on %q", i, p.Interface)
+			}
+			tcp, ok := p.Layer(layers.LayerTypeTCP).(*layers.TCP)
+			if len(sizes) == 1 && i == 3 {
+				if p.Layer(layers.LayerTypeARP) == nil {
+					t.Errorf("ARP request decoded as %v", p.Layers())
+				}
+				continue
+			}
+			if !ok || !tcp.SYN || tcp.ACK != (i%2 == 1) {
+				t.Fatalf("packet %d of batch %d: %v", i, len(sizes), p.Layers())
+			}
+		}
+		b.Release()
+	}
+	if len(sizes) != 2 || sizes[0] != batchSize || sizes[1] != 10 {
+		t.Errorf("batch sizes = %v", sizes)
+	}
+
+	// released batches are reused, layers and all
+	b := handle.batch()
+	if len(b.Packets) != 0 || b.records[0].parser == nil || len(handle.free) != 1 {
+		t.Errorf("reused batch holds %d packets, %d more free", len(b.Packets), len(handle.free))
+	}
+}
+
+func TestCaptureBatchesNonBlocking(t *testing.T) {
+	frames := make([]Frame, 3*batchSize)
+	for i := range frames {
+		frames[i] = Frame{Data: tcpFrame(t, true, false)}
+	}
+	handle := NewHandle(NewMemorySource(layers.LinkTypeEthernet, frames), "mem0")
+	handle.SetNonBlocking(true)
+
+	// nobody reads the channel, so the batches beyond the first are dropped
+	batches := make(chan *Batch, 1)
+	if err := handle.CaptureBatches(batches); err != nil {
+		t.Fatalf("CaptureBatches failed: %v", err)
+	}
+	stats, _ := handle.Stats()
+	if stats.PacketsReceived != 3*batchSize || stats.PacketsChannelDropped != 2*batchSize {
+		t.Errorf("received %d, dropped %d", stats.PacketsReceived, stats.PacketsChannelDropped)
+	}
+}
+
+func TestCaptureAllBatches(t *testing.T) {
+	eth0 := NewHandle(NewMemorySource(layers.LinkTypeEthernet, []Frame{
+		{Data: tcpFrame(t, true, false)},
+		{Data: tcpFrame(t, true, true)},
+	}), "eth0")
+	eth1 := NewHandle(NewMemorySource(layers.LinkTypeEthernet, []Frame{
+		{Data: tcpFrame(t, true, false)},
+	}), "eth1")
+
+	batches := make(chan *Batch)
+	go func() {
+		defer close(batches)
+		if err := CaptureAllBatches([]*CaptureHandle{eth0, eth1}, batches); err != nil {
+			t.Errorf("CaptureAllBatches failed: %v", err)
+		}
+	}()
+	counts := make(map[string]int)
+	for b := range batches {
+		for _, p := range b.Packets {
+			counts[p.Interface]++
+		}
+		b.Release()
+	}
+	if counts["eth0"] != 2 || counts["eth1"] != 1 {
+		t.Errorf("per-interface counts = %v, want eth0:2 eth1:1", counts)
+	}
+}
@@ -0,0 +1,115 @@
+package pcap
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// loopSource replays frames over and over until n have been read
+type loopSource struct {
+	frames [][]byte
+	n, pos int
+	now    time.Time
+}
+
+func (s *loopSource) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	if s.pos >= s.n {
+		return nil, gopacket.CaptureInfo{}, io.EOF
+	}
+	data := s.frames[s.pos%len(s.frames)]
+	s.pos++
+	s.now = s.now.Add(time.Microsecond)
+	return data, gopacket.CaptureInfo{Timestamp: s.now, CaptureLength: len(data), Length: len(data)}, nil
+}
+
+func (s *loopSource) LinkType() layers.LinkType { return layers.LinkTypeEthernet }
+func (s *loopSource) Stats() (Stats, error)     { return Stats{PacketsReceived: s.pos}, nil }
+func (s *loopSource) Close()                    {}
+
+// benchFrames returns a mix of TCP data segments, ACKs and UDP datagrams
+// as seen on a busy link
+func benchFrames(b *testing.B) [][]byte {
+	eth := &layers.Ethernet{SrcMAC: net.HardwareAddr{2, 0, 0, 0, 0, 1}, DstMAC: net.HardwareAddr{2, 0, 0, 0, 0, 2}, EthernetType: layers.EthernetTypeIPv4}
+	ip := &layers.IPv4{Version: 4, TTL: 64, SrcIP: net.IP{10, 0, 0, 1}, DstIP: net.IP{10, 0, 0, 2}, Flags: layers.IPv4DontFragment}
+	var frames [][]byte
+	for i := 0; i < 64; i++ {
+		buf := gopacket.NewSerializeBuffer()
+		opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+		var err error
+		switch i % 4 {
+		case 0, 1:
+			ip.Protocol = layers.IPProtocolTCP
+			tcp := &layers.TCP{SrcPort: 443, DstPort: layers.TCPPort(40000 + i), ACK: true, PSH: true, Seq: uint32(i) * 1448, Window: 501}
+			tcp.SetNetworkLayerForChecksum(ip)
+			err = gopacket.SerializeLayers(buf, opts, eth, ip, tcp, gopacket.Payload(make([]byte, 1448)))
+		case 2:
+			ip.Protocol = layers.IPProtocolTCP
+			tcp := &layers.TCP{SrcPort: layers.TCPPort(40000 + i), DstPort: 443, ACK: true, Ack: uint32(i) * 1448, Window: 501}
+			tcp.SetNetworkLayerForChecksum(ip)
+			err = gopacket.SerializeLayers(buf, opts, eth, ip, tcp)
+		case 3:
+			ip.Protocol = layers.IPProtocolUDP
+			udp := &layers.UDP{SrcPort: layers.UDPPort(50000 + i), DstPort: 443}
+			udp.SetNetworkLayerForChecksum(ip)
+			err = gopacket.SerializeLayers(buf, opts, eth, ip, udp, gopacket.Payload(make([]byte, 1200)))
+		}
+		if err != nil {
+			b.Fatalf("SerializeLayers: %v", err)
+		}
+		frames = append(frames, buf.Bytes())
+	}
+	return frames
+}
+
+// consume stands in for the analyzers, which look up a transport layer of
+// every packet
+func consume(p gopacket.Packet) int {
+	if l := p.TransportLayer(); l != nil {
+		return len(l.LayerPayload())
+	}
+	return 0
+}
+
+// BenchmarkCapture measures the packet-by-packet path: every packet sent
+// on its own over a channel of interface{}
+func BenchmarkCapture(b *testing.B) {
+	handle := NewHandle(&loopSource{frames: benchFrames(b), n: b.N}, "bench")
+	packets := make(chan interface{}, 8192)
+	b.ReportAllocs()
+	b.ResetTimer()
+	go func() {
+		defer close(packets)
+		handle.Capture(packets)
+	}()
+	var n int
+	for raw := range packets {
+		n += consume(raw.(gopacket.Packet))
+	}
+	b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "pkts/s")
+}
+
+// BenchmarkCaptureBatches measures the batched path the CLI uses: frames
+// decoded into reused layers and consumed by pointer
+func BenchmarkCaptureBatches(b *testing.B) {
+	handle := NewHandle(&loopSource{frames: benchFrames(b), n: b.N}, "bench")
+	batches := make(chan *Batch, 32)
+	b.ReportAllocs()
+	b.ResetTimer()
+	go func() {
+		defer close(batches)
+		handle.CaptureBatches(batches)
+	}()
+	var n int
+	for batch := range batches {
+		for i := range batch.Packets {
+			n += consume(&batch.Packets[i])
+		}
+		batch.Release()
+	}
+	b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "pkts/s")
+}
@@ -0,0 +1,179 @@
+package pcap
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// record is an Ethernet frame decoded into layers it owns, the fast path of
+// CaptureBatches. It satisfies gopacket.Packet like the packets NewPacket
+// returns, but decoding one allocates nothing once the record is reused.
+type record struct {
+	data    []byte
+	meta    gopacket.PacketMetadata
+	parser  *gopacket.DecodingLayerParser
+	decoded []gopacket.LayerType
+	layers  []gopacket.Layer
+
+	link      gopacket.LinkLayer
+	network   gopacket.NetworkLayer
+	transport gopacket.TransportLayer
+	app       gopacket.ApplicationLayer
+
+	eth     layers.Ethernet
+	dot1q   layers.Dot1Q
+	ip4     layers.IPv4
+	ip6     layers.IPv6
+	tcp     layers.TCP
+	udp     layers.UDP
+	icmp4   layers.ICMPv4
+	icmp6   layers.ICMPv6
+	payload gopacket.Payload
+}
+
+// init sets up the parser of a record, which must not move afterwards
+func (r *record) init() {
+	r.parser = gopacket.NewDecodingLayerParser(layers.LayerTypeEthernet,
+		&r.eth, &r.dot1q, &r.ip4, &r.ip6, &r.tcp, &r.udp, &r.icmp4, &r.icmp6, &r.payload)
+	r.decoded = make([]gopacket.LayerType, 0, 8)
+	r.layers = make([]gopacket.Layer, 0, 8)
+}
+
+// decode copies data into r and decodes it. It returns false for frames
+// the fast path leaves to NewPacket: malformed ones, fragments, tunnels,
+// stacked VLAN tags, ARP, IPv6 extension headers, ICMPv6 other than
+// errors, and the DHCP messages the analyzers look up by layer type.
+func (r *record) decode(data []byte, ci gopacket.CaptureInfo) bool {
+	r.data = append(r.data[:0], data...)
+	if err := r.parser.DecodeLayers(r.data, &r.decoded); err != nil {
+		next, ok := err.(gopacket.UnsupportedLayerType)
+		if !ok || !stopsAt(r.decoded, gopacket.LayerType(next)) {
+			return false
+		}
+	}
+	r.layers = r.layers[:0]
+	r.link, r.network, r.transport, r.app = nil, nil, nil, nil
+	for i, t := range r.decoded {
+		for _, u := range r.decoded[:i] {
+			if t == u {
+				return false
+			}
+		}
+		switch t {
+		case layers.LayerTypeEthernet:
+			r.layers = append(r.layers, &r.eth)
+			r.link = &r.eth
+		case layers.LayerTypeDot1Q:
+			r.layers = append(r.layers, &r.dot1q)
+		case layers.LayerTypeIPv4:
+			if r.network != nil || r.ip4.Flags&layers.IPv4MoreFragments != 0 || r.ip4.FragOffset != 0 {
+				return false
+			}
+			r.layers = append(r.layers, &r.ip4)
+			r.network = &r.ip4
+		case layers.LayerTypeIPv6:
+			if r.network != nil || r.ip6.HopByHop != nil {
+				return false
+			}
+			r.layers = append(r.layers, &r.ip6)
+			r.network = &r.ip6
+		case layers.LayerTypeTCP:
+			r.layers = append(r.layers, &r.tcp)
+			r.transport = &r.tcp
+		case layers.LayerTypeUDP:
+			r.layers = append(r.layers, &r.udp)
+			r.transport = &r.udp
+		case layers.LayerTypeICMPv4:
+			r.layers = append(r.layers, &r.icmp4)
+		case layers.LayerTypeICMPv6:
+			r.layers = append(r.layers, &r.icmp6)
+		case gopacket.LayerTypePayload:
+			r.layers = append(r.layers, &r.payload)
+			r.app = &r.payload
+		}
+	}
+	r.meta = gopacket.PacketMetadata{
+		CaptureInfo: ci,
+		Truncated:   r.parser.Truncated || ci.CaptureLength < ci.Length,
+	}
+	return true
+}
+
+// stopsAt reports whether decoding may end at next, a layer the record has
+// no decoder for: only application protocols above TCP or UDP that no
+// analyzer, nor decapsulate, looks up by type
+func stopsAt(decoded []gopacket.LayerType, next gopacket.LayerType) bool {
+	if len(decoded) == 0 {
+		return false
+	}
+	switch decoded[len(decoded)-1] {
+	case layers.LayerTypeTCP, layers.LayerTypeUDP:
+	default:
+		return false
+	}
+	switch next {
+	case layers.LayerTypeDHCPv4, layers.LayerTypeDHCPv6, layers.LayerTypeVXLAN, layers.LayerTypeGeneve:
+		return false
+	}
+	return true
+}
+
+func (r *record) Layers() []gopacket.Layer                    { return r.layers }
+func (r *record) LinkLayer() gopacket.LinkLayer               { return r.link }
+func (r *record) NetworkLayer() gopacket.NetworkLayer         { return r.network }
+func (r *record) TransportLayer() gopacket.TransportLayer     { return r.transport }
+func (r *record) ApplicationLayer() gopacket.ApplicationLayer { return r.app }
+func (r *record) ErrorLayer() gopacket.ErrorLayer             { return nil }
+func (r *record) Data() []byte                                { return r.data }
+func (r *record) Metadata() *gopacket.PacketMetadata          { return &r.meta }
+
+func (r *record) Layer(t gopacket.LayerType) gopacket.Layer {
+	for _, l := range r.layers {
+		if l.LayerType() == t {
+			return l
+		}
+	}
+	return nil
+}
+
+func (r *record) LayerClass(c gopacket.LayerClass) gopacket.Layer {
+	for _, l := range r.layers {
+		if c.Contains(l.LayerType()) {
+			return l
+		}
+	}
+	return nil
+}
+
+// String and Dump print the packet the way gopacket's packets do
+func (r *record) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "PACKET: %d bytes", len(r.data))
+	if r.meta.Truncated {
+		b.WriteString(", truncated")
+	}
+	if r.meta.Length > 0 {
+		fmt.Fprintf(&b, ", wire length %d cap length %d", r.meta.Length, r.meta.CaptureLength)
+	}
+	if !r.meta.Timestamp.IsZero() {
+		fmt.Fprintf(&b, " @ %v", r.meta.Timestamp)
+	}
+	b.WriteByte('\n')
+	for i, l := range r.layers {
+		fmt.Fprintf(&b, "- Layer %d (%02d bytes) = %s\n", i+1, len(l.LayerContents()), gopacket.LayerString(l))
+	}
+	return b.String()
+}
+
+func (r *record) Dump() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "-- FULL PACKET DATA (%d bytes) ------------------------------------\n%s", len(r.data), hex.Dump(r.data))
+	for i, l := range r.layers {
+		fmt.Fprintf(&b, "--- Layer %d ---\n%s", i+1, gopacket.LayerDump(l))
+	}
+	return b.String()
+}
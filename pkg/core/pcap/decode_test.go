@@ -0,0 +1,96 @@
+package pcap
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+func TestRecordDecode(t *testing.T) {
+	eth := func(typ layers.EthernetType) *layers.Ethernet {
+		return &layers.Ethernet{SrcMAC: net.HardwareAddr{2, 0, 0, 0, 0, 1}, DstMAC: net.HardwareAddr{2, 0, 0, 0, 0, 2}, EthernetType: typ}
+	}
+	ip, tcp := inner4()
+	ip6 := &layers.IPv6{Version: 6, HopLimit: 64, NextHeader: layers.IPProtocolUDP, SrcIP: net.ParseIP("2001:db8::10"), DstIP: net.ParseIP("2001:db8::53")}
+	dns := &layers.UDP{SrcPort: 40000, DstPort: 53}
+	dns.SetNetworkLayerForChecksum(ip6)
+	query := serialize(t, &layers.DNS{ID: 7, RD: true, Questions: []layers.DNSQuestion{{Name: []byte("example.com"), Type: layers.DNSTypeA, Class: layers.DNSClassIN}}})
+	icmp4 := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolICMPv4, SrcIP: net.IP{10, 0, 0, 254}, DstIP: net.IP{10, 0, 0, 1}}
+	icmp6 := &layers.IPv6{Version: 6, HopLimit: 64, NextHeader: layers.IPProtocolICMPv6, SrcIP: net.ParseIP("2001:db8::1"), DstIP: net.ParseIP("2001:db8::10")}
+	icmp6Layer := func(typ uint8) *layers.ICMPv6 {
+		m := &layers.ICMPv6{TypeCode: layers.CreateICMPv6TypeCode(typ, 0)}
+		m.SetNetworkLayerForChecksum(icmp6)
+		return m
+	}
+	dhcp := &layers.UDP{SrcPort: 68, DstPort: 67}
+	dhcp.SetNetworkLayerForChecksum(ip)
+	data := tcpFrame(t, false, true)
+	data = append(data, bytes.Repeat([]byte{0xab}, 100)...)
+	data[16], data[17] = 0, 140 // IP length covering the payload
+	tests := []struct {
+		name  string
+		frame []byte
+		fast  bool
+	}{
+		{"TCP", tcpFrame(t, true, false), true},
+		{"TCP with data", data, true},
+		{"DNS over IPv6", serialize(t, eth(layers.EthernetTypeIPv6), ip6, dns, gopacket.Payload(query)), true},
+		{"VLAN", serialize(t, eth(layers.EthernetTypeDot1Q), &layers.Dot1Q{VLANIdentifier: 10, Type: layers.EthernetTypeIPv4}, ip, tcp), true},
+		{"ICMPv4", serialize(t, eth(layers.EthernetTypeIPv4), icmp4, &layers.ICMPv4{TypeCode: layers.CreateICMPv4TypeCode(3, 4)}, gopacket.Payload(data[14:42])), true},
+		{"ICMPv6 packet too big", serialize(t, eth(layers.EthernetTypeIPv6), icmp6, icmp6Layer(layers.ICMPv6TypePacketTooBig), gopacket.Payload(make([]byte, 52))), true},
+
+		{"ARP", serialize(t, eth(layers.EthernetTypeARP), &layers.ARP{AddrType: layers.LinkTypeEthernet, Protocol: layers.EthernetTypeIPv4, HwAddressSize: 6, ProtAddressSize: 4,
+			Operation: layers.ARPRequest, SourceHwAddress: []byte{2, 0, 0, 0, 0, 1}, SourceProtAddress: []byte{10, 0, 0, 1}, DstHwAddress: make([]byte, 6), DstProtAddress: []byte{10, 0, 0, 2}}), false},
+		{"fragment", frag4(t, 1, 0, true, layers.IPProtocolUDP, make([]byte, 1480)), false},
+		{"VXLAN", overlay(t, 4789, serialize(t, &layers.VXLAN{ValidIDFlag: true, VNI: 5001}, gopacket.Payload(innerFrame(t)))), false},
+		{"QinQ", serialize(t, eth(layers.EthernetTypeQinQ), &layers.Dot1Q{VLANIdentifier: 100, Type: layers.EthernetTypeDot1Q},
+			&layers.Dot1Q{VLANIdentifier: 20, Type: layers.EthernetTypeIPv4}, ip, tcp), false},
+		{"IP-in-IP", serialize(t, eth(layers.EthernetTypeIPv4), &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolIPv6, SrcIP: net.IP{10, 0, 0, 1}, DstIP: net.IP{10, 0, 0, 2}},
+			ip6, dns, gopacket.Payload(query)), false},
+		{"DHCPv4", serialize(t, eth(layers.EthernetTypeIPv4), &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolUDP, SrcIP: net.IPv4zero, DstIP: net.IPv4bcast},
+			dhcp, &layers.DHCPv4{Operation: layers.DHCPOpRequest, HardwareType: layers.LinkTypeEthernet, HardwareLen: 6, ClientHWAddr: net.HardwareAddr{2, 0, 0, 0, 0, 1}}), false},
+		{"neighbor solicitation", serialize(t, eth(layers.EthernetTypeIPv6), icmp6, icmp6Layer(layers.ICMPv6TypeNeighborSolicitation),
+			&layers.ICMPv6NeighborSolicitation{TargetAddress: net.ParseIP("2001:db8::1")}), false},
+		{"malformed", tcpFrame(t, true, false)[:30], false},
+	}
+	var r record
+	r.init()
+	ts := time.Date(2026, 2, 20, 10, 30, 0, 0, time.UTC)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ci := gopacket.CaptureInfo{Timestamp: ts, CaptureLength: len(tt.frame), Length: len(tt.frame)}
+			if got := r.decode(tt.frame, ci); got != tt.fast {
+				t.Fatalf("decode = %v, want %v: %v", got, tt.fast, r.decoded)
+			}
+			if !tt.fast {
+				return
+			}
+			want := gopacket.NewPacket(tt.frame, layers.LinkTypeEthernet, gopacket.Default)
+			for _, l := range r.Layers() {
+				w := want.Layer(l.LayerType())
+				if w == nil || !bytes.Equal(l.LayerContents(), w.LayerContents()) || !bytes.Equal(l.LayerPayload(), w.LayerPayload()) {
+					t.Errorf("layer %v = %v, want %v", l.LayerType(), gopacket.LayerString(l), w)
+				}
+			}
+			if r.LinkLayer() != r.Layer(layers.LayerTypeEthernet) || r.NetworkLayer().LayerType() != want.NetworkLayer().LayerType() {
+				t.Errorf("link %v, network %v", r.LinkLayer(), r.NetworkLayer())
+			}
+			if wt := want.TransportLayer(); wt != nil && (r.TransportLayer() == nil || r.TransportLayer().LayerType() != wt.LayerType()) {
+				t.Errorf("transport = %v, want %v", r.TransportLayer(), wt.LayerType())
+			}
+			if m := r.Metadata(); !m.Timestamp.Equal(ts) || m.Truncated {
+				t.Errorf("metadata = %+v", m)
+			}
+		})
+	}
+
+	// a frame cut short by the snap length
+	frame := serialize(t, eth(layers.EthernetTypeIPv6), ip6, dns, gopacket.Payload(query))
+	if !r.decode(frame[:70], gopacket.CaptureInfo{CaptureLength: 70, Length: len(frame)}) || !r.Metadata().Truncated {
+		t.Errorf("truncated frame: %v, metadata %+v", r.decoded, r.Metadata())
+	}
+}
@@ -0,0 +1,297 @@
+package pcap
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"sort"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+
+	"network-app/pkg/core/analyzer"
+)
+
+// fragTimeout is how long an incomplete datagram is kept after its first
+// fragment, and maxFragMemory bounds the fragment bytes held; both as the
+// Linux defaults ipfrag_time and ipfrag_high_thresh
+const (
+	fragTimeout   = 30 * time.Second
+	maxFragMemory = 4 << 20
+)
+
+// FragmentStats counts the IPv4 and IPv6 fragments a handle has seen
+type FragmentStats struct {
+	Fragments   int // fragments seen
+	Reassembled int // datagrams reassembled and passed on
+	Incomplete  int // datagrams a fragment of which never arrived within the timeout
+	Pending     int // datagrams still incomplete when the capture ended
+	Overlaps    int // datagrams dropped because their fragments overlapped or disagreed on the length
+	Duplicates  int // fragments seen twice, ignored
+	Invalid     int // fragments passed on as they are: beyond 64 KiB or misaligned
+	Evicted     int // fragments passed on as they are because the memory limit was reached
+}
+
+// Reassembly describes the fragments a reassembled datagram arrived in
+type Reassembly struct {
+	Fragments int // 0 when the packet was not fragmented
+	Largest   int // IP length of the largest fragment, the size that crossed the path
+}
+
+type fragKey struct {
+	src, dst netip.Addr
+	proto    layers.IPProtocol // part of the key for IPv4 only
+	id       uint32
+}
+
+type fragment struct {
+	offset int
+	data   []byte
+}
+
+// datagram holds the fragments of one datagram until it is complete
+type datagram struct {
+	frags   []fragment
+	first   gopacket.Packet // the packet holding the fragment at offset 0
+	ip      int             // index of the fragmented IP layer in first
+	total   int             // payload length, -1 until the last fragment arrives
+	highest int             // end of the furthest fragment held
+	have    int             // payload bytes held
+	largest int
+	wire    int // bytes of the frames the fragments arrived in
+	start   time.Time
+	dropped bool // fragments overlapped; later ones are swallowed until the timeout
+}
+
+// defragmenter reassembles fragmented IPv4 and IPv6 datagrams. Like Linux
+// it drops datagrams whose fragments overlap (RFC 5722) rather than guess
+// which copy the receiver would keep.
+type defragmenter struct {
+	stats   FragmentStats
+	pending map[fragKey]*datagram
+	memory  int
+	clock   analyzer.Clock
+}
+
+func newDefragmenter() *defragmenter {
+	return &defragmenter{pending: make(map[fragKey]*datagram)}
+}
+
+// Stats returns the fragment counters, counting the datagrams still
+// incomplete as pending
+func (d *defragmenter) Stats() FragmentStats {
+	st := d.stats
+	for _, dg := range d.pending {
+		if !dg.dropped {
+			st.Pending++
+		}
+	}
+	return st
+}
+
+// defragment passes p on unless it is an IP fragment. Fragments are held
+// until their datagram is complete, which is then returned in place of the
+// fragment that completed it; ok is false while they are held. The
+// reassembled packet keeps the layers in front of the fragmented IP header,
+// such as the link layer and outer tunnel headers, of the first fragment.
+func (d *defragmenter) defragment(p gopacket.Packet) (out gopacket.Packet, r Reassembly, ok bool) {
+	d.advance(p.Metadata().Timestamp)
+	for {
+		ls := p.Layers()
+		i, k, f, more := fragmentOf(ls)
+		if i < 0 {
+			return p, r, true
+		}
+		d.stats.Fragments++
+		dg, held := d.add(p, i, k, f, more)
+		if !held {
+			return p, r, true
+		}
+		if dg == nil {
+			return nil, r, false
+		}
+		p = d.reassemble(p, dg)
+		// an inner datagram may be fragmented too; report the outer one
+		if r.Fragments == 0 {
+			r = Reassembly{Fragments: len(dg.frags), Largest: dg.largest}
+		}
+	}
+}
+
+// fragmentOf finds the first fragmented IP header among ls. It returns the
+// index of that IP layer, or -1.
+func fragmentOf(ls []gopacket.Layer) (i int, k fragKey, f fragment, more bool) {
+	for i, l := range ls {
+		switch l := l.(type) {
+		case *layers.IPv4:
+			if l.Flags&layers.IPv4MoreFragments == 0 && l.FragOffset == 0 {
+				continue
+			}
+			k.src, k.dst, _ = analyzer.Addrs(l)
+			k.proto, k.id = l.Protocol, uint32(l.Id)
+			return i, k, fragment{int(l.FragOffset) * 8, l.Payload}, l.Flags&layers.IPv4MoreFragments != 0
+		case *layers.IPv6Fragment:
+			for j := i - 1; j >= 0; j-- {
+				if ip, ok := ls[j].(*layers.IPv6); ok {
+					k.src, k.dst, _ = analyzer.Addrs(ip)
+					k.id = l.Identification
+					return j, k, fragment{int(l.FragmentOffset) * 8, l.Payload}, l.MoreFragments
+				}
+			}
+		}
+	}
+	return -1, k, f, false
+}
+
+// add holds fragment f of packet p. It returns the datagram once complete,
+// and held false for a fragment that cannot be reassembled.
+func (d *defragmenter) add(p gopacket.Packet, i int, k fragKey, f fragment, more bool) (_ *datagram, held bool) {
+	end, limit := f.offset+len(f.data), 65535
+	if ip, ok := p.Layers()[i].(*layers.IPv4); ok {
+		limit -= len(ip.Contents) // the total length covers the header
+	}
+	if end > limit || more && len(f.data)%8 != 0 || len(f.data) == 0 {
+		d.stats.Invalid++
+		return nil, false
+	}
+	dg := d.pending[k]
+	if dg == nil {
+		dg = &datagram{total: -1, start: d.clock.Now()}
+		d.pending[k] = dg
+	}
+	if dg.dropped {
+		return nil, true
+	}
+	for _, g := range dg.frags {
+		if f.offset < g.offset+len(g.data) && g.offset < end {
+			if f.offset == g.offset && len(f.data) == len(g.data) {
+				d.stats.Duplicates++
+				return nil, true
+			}
+			d.drop(dg)
+			d.stats.Overlaps++
+			return nil, true
+		}
+	}
+	if dg.total >= 0 && (!more || end > dg.total) || !more && end < dg.highest {
+		// a second last fragment, or data beyond the last one
+		d.drop(dg)
+		d.stats.Overlaps++
+		return nil, true
+	}
+	if d.memory+len(f.data) > maxFragMemory {
+		if len(dg.frags) == 0 {
+			delete(d.pending, k)
+		}
+		d.stats.Evicted++
+		return nil, false
+	}
+	dg.frags = append(dg.frags, f)
+	dg.have += len(f.data)
+	dg.highest = max(dg.highest, end)
+	d.memory += len(f.data)
+	dg.largest = max(dg.largest, ipLength(p.Layers()[i]))
+	dg.wire += p.Metadata().Length
+	if f.offset == 0 {
+		dg.first, dg.ip = p, i
+	}
+	if !more {
+		dg.total = end
+	}
+	if dg.total < 0 || dg.have < dg.total {
+		return nil, true
+	}
+	delete(d.pending, k)
+	d.memory -= dg.have
+	return dg, true
+}
+
+// ipLength returns the length of an IP packet from its header
+func ipLength(l gopacket.Layer) int {
+	switch ip := l.(type) {
+	case *layers.IPv4:
+		return int(ip.Length)
+	case *layers.IPv6:
+		return 40 + int(ip.Length)
+	}
+	return 0
+}
+
+// drop discards the fragments of dg and swallows any later ones
+func (d *defragmenter) drop(dg *datagram) {
+	d.memory -= dg.have
+	dg.frags, dg.first, dg.have, dg.dropped = nil, nil, 0, true
+}
+
+// reassemble builds and decodes the datagram dg, completed by packet p
+func (d *defragmenter) reassemble(p gopacket.Packet, dg *datagram) gopacket.Packet {
+	sort.Slice(dg.frags, func(i, j int) bool { return dg.frags[i].offset < dg.frags[j].offset })
+	payload := make([]byte, 0, dg.total)
+	for _, f := range dg.frags {
+		payload = append(payload, f.data...)
+	}
+	ls := dg.first.Layers()
+	var data []byte
+	var first gopacket.LayerType
+	switch ip := ls[dg.ip].(type) {
+	case *layers.IPv4:
+		hdr := append([]byte(nil), ip.Contents...)
+		binary.BigEndian.PutUint16(hdr[2:], uint16(len(hdr)+len(payload)))
+		binary.BigEndian.PutUint16(hdr[6:], 0) // flags and fragment offset
+		binary.BigEndian.PutUint16(hdr[10:], 0)
+		binary.BigEndian.PutUint16(hdr[10:], checksum(hdr))
+		data, first = append(hdr, payload...), layers.LayerTypeIPv4
+	case *layers.IPv6:
+		// the extension headers in front of the fragment header are dropped
+		hdr := append([]byte(nil), ip.Contents[:40]...)
+		binary.BigEndian.PutUint16(hdr[4:], uint16(len(payload)))
+		hdr[6] = byte(nextHeader(ls[dg.ip:]))
+		data, first = append(hdr, payload...), layers.LayerTypeIPv6
+	}
+	whole := gopacket.NewPacket(data, first, gopacket.DecodeOptions{SkipDecodeRecovery: true})
+	d.stats.Reassembled++
+	// the datagram is as long on the wire as all of its fragments
+	m := p.Metadata()
+	m.Length = dg.wire
+	return newInner(p, append(append([]gopacket.Layer(nil), ls[:dg.ip]...), whole.Layers()...))
+}
+
+// nextHeader returns the protocol following the IPv6 fragment header in ls
+func nextHeader(ls []gopacket.Layer) layers.IPProtocol {
+	for _, l := range ls {
+		if f, ok := l.(*layers.IPv6Fragment); ok {
+			return f.NextHeader
+		}
+	}
+	return layers.IPProtocolNoNextHeader
+}
+
+// checksum returns the Internet checksum of an IPv4 header
+func checksum(hdr []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(hdr); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(hdr[i:]))
+	}
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return ^uint16(sum)
+}
+
+// advance moves the clock and, about once per second of capture time,
+// drops the datagrams that timed out
+func (d *defragmenter) advance(ts time.Time) {
+	if !d.clock.Advance(ts) {
+		return
+	}
+	for k, dg := range d.pending {
+		if d.clock.Now().Sub(dg.start) < fragTimeout {
+			continue
+		}
+		if !dg.dropped {
+			d.stats.Incomplete++
+		}
+		d.memory -= dg.have
+		delete(d.pending, k)
+	}
+}
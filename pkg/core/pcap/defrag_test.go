@@ -0,0 +1,146 @@
+package pcap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+var (
+	fragSrc = net.IP{192, 168, 1, 10}
+	fragDst = net.IP{192, 168, 1, 20}
+	fragMAC = net.HardwareAddr{2, 0, 0, 0, 0, 1}
+)
+
+// udpDatagram returns the IP payload of a UDP datagram carrying n bytes
+func udpDatagram(t *testing.T, src, dst net.IP, n int) []byte {
+	var ip gopacket.NetworkLayer = &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolUDP, SrcIP: src, DstIP: dst}
+	if src.To4() == nil {
+		ip = &layers.IPv6{Version: 6, HopLimit: 64, NextHeader: layers.IPProtocolUDP, SrcIP: src, DstIP: dst}
+	}
+	udp := &layers.UDP{SrcPort: 40000, DstPort: 5000}
+	udp.SetNetworkLayerForChecksum(ip)
+	return serialize(t, udp, gopacket.Payload(bytes.Repeat([]byte{0xab}, n)))
+}
+
+// frag4 returns an Ethernet frame carrying data at offset of the IPv4
+// datagram id
+func frag4(t *testing.T, id uint16, offset int, more bool, proto layers.IPProtocol, data []byte) []byte {
+	ip := &layers.IPv4{Version: 4, TTL: 64, Protocol: proto, SrcIP: fragSrc, DstIP: fragDst, Id: id, FragOffset: uint16(offset / 8)}
+	if more {
+		ip.Flags = layers.IPv4MoreFragments
+	}
+	return serialize(t, &layers.Ethernet{SrcMAC: fragMAC, DstMAC: fragMAC, EthernetType: layers.EthernetTypeIPv4}, ip, gopacket.Payload(data))
+}
+
+// frag6 is frag4 for IPv6, which gopacket cannot serialize a fragment
+// header for
+func frag6(t *testing.T, id uint32, offset int, more bool, data []byte) []byte {
+	hdr := make([]byte, 8)
+	hdr[0] = byte(layers.IPProtocolUDP)
+	binary.BigEndian.PutUint16(hdr[2:], uint16(offset))
+	if more {
+		hdr[3] |= 1
+	}
+	binary.BigEndian.PutUint32(hdr[4:], id)
+	ip := &layers.IPv6{Version: 6, HopLimit: 64, NextHeader: layers.IPProtocolIPv6Fragment,
+		SrcIP: net.ParseIP("2001:db8::10"), DstIP: net.ParseIP("2001:db8::20")}
+	return serialize(t, &layers.Ethernet{SrcMAC: fragMAC, DstMAC: fragMAC, EthernetType: layers.EthernetTypeIPv6}, ip, gopacket.Payload(append(hdr, data...)))
+}
+
+// fragments splits data into fragments of at most size bytes
+func fragments(data []byte, size int) (offsets []int, chunks [][]byte) {
+	for off := 0; off < len(data); off += size {
+		offsets = append(offsets, off)
+		chunks = append(chunks, data[off:min(off+size, len(data))])
+	}
+	return offsets, chunks
+}
+
+func TestDefragment(t *testing.T) {
+	start := time.Date(2026, 2, 20, 10, 30, 0, 0, time.UTC)
+	var frames []Frame
+	add := func(at time.Duration, data []byte) {
+		frames = append(frames, Frame{Data: data, CaptureInfo: gopacket.CaptureInfo{
+			Timestamp: start.Add(at), CaptureLength: len(data), Length: len(data)}})
+	}
+	// 3008 bytes in three fragments, the last first
+	dgram := udpDatagram(t, fragSrc, fragDst, 3000)
+	offs, chunks := fragments(dgram, 1480)
+	add(0, frag4(t, 1, offs[2], false, layers.IPProtocolUDP, chunks[2]))
+	add(0, tcpFrame(t, true, false))
+	add(0, frag4(t, 1, offs[0], true, layers.IPProtocolUDP, chunks[0]))
+	add(0, frag4(t, 1, offs[0], true, layers.IPProtocolUDP, chunks[0])) // duplicate
+	add(0, frag4(t, 1, offs[1], true, layers.IPProtocolUDP, chunks[1]))
+	// overlapping fragments drop the datagram, and its last fragment too
+	add(0, frag4(t, 2, 0, true, layers.IPProtocolUDP, dgram[:1480]))
+	add(0, frag4(t, 2, 1472, true, layers.IPProtocolUDP, dgram[1472:2952]))
+	add(0, frag4(t, 2, 2952, false, layers.IPProtocolUDP, dgram[2952:]))
+	// IPv6, with the same identification as an IPv4 datagram
+	dgram6 := udpDatagram(t, net.ParseIP("2001:db8::10"), net.ParseIP("2001:db8::20"), 2000)
+	add(0, frag6(t, 1, 0, true, dgram6[:1448]))
+	add(0, frag6(t, 1, 1448, false, dgram6[1448:]))
+	// a fragmented VXLAN packet whose inner frame holds a TCP SYN
+	outer := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolUDP, SrcIP: fragSrc, DstIP: fragDst}
+	vx := &layers.UDP{SrcPort: 40000, DstPort: 4789}
+	vx.SetNetworkLayerForChecksum(outer)
+	tunnel := serialize(t, vx, &layers.VXLAN{ValidIDFlag: true, VNI: 42}, gopacket.Payload(tcpFrame(t, true, false)))
+	add(time.Second, frag4(t, 3, 0, true, layers.IPProtocolUDP, tunnel[:48]))
+	add(time.Second, frag4(t, 3, 48, false, layers.IPProtocolUDP, tunnel[48:]))
+	// one fragment never arrives, one datagram is cut off by the end
+	add(2*time.Second, frag4(t, 4, 0, true, layers.IPProtocolUDP, dgram[:1480]))
+	add(40*time.Second, frag4(t, 5, 0, true, layers.IPProtocolUDP, dgram[:1480]))
+	// a misaligned fragment is passed on as it is
+	add(40*time.Second, frag4(t, 6, 0, true, layers.IPProtocolUDP, dgram[:1001]))
+
+	handle := NewHandle(NewMemorySource(layers.LinkTypeEthernet, frames), "mem0")
+	var got []Packet
+	for pkt, ok := handle.next(nil); ok; pkt, ok = handle.next(nil) {
+		got = append(got, pkt)
+	}
+	if len(got) != 5 {
+		t.Fatalf("got %d packets, want 5", len(got))
+	}
+
+	udp, ok := got[1].Layer(layers.LayerTypeUDP).(*layers.UDP)
+	if !ok || len(udp.Payload) != 3000 || udp.Payload[2999] != 0xab {
+		t.Fatalf("reassembled IPv4 datagram: %v", got[1].Layers())
+	}
+	if ip := got[1].NetworkLayer().(*layers.IPv4); ip.Length != 3028 || ip.Flags != 0 || ip.SrcIP.String() != "192.168.1.10" {
+		t.Errorf("reassembled header = %+v", ip)
+	}
+	if got[1].LinkLayer() == nil || got[1].Frag != (Reassembly{Fragments: 3, Largest: 1500}) {
+		t.Errorf("link layer %v, reassembly %+v", got[1].LinkLayer(), got[1].Frag)
+	}
+	if m := got[1].Metadata(); m.Length != 3*(14+20)+3008 || !m.Timestamp.Equal(start) {
+		t.Errorf("metadata = %+v", m)
+	}
+
+	if udp, ok := got[2].Layer(layers.LayerTypeUDP).(*layers.UDP); !ok || len(udp.Payload) != 2000 {
+		t.Fatalf("reassembled IPv6 datagram: %v", got[2].Layers())
+	}
+	if got[2].Frag.Fragments != 2 || got[2].Frag.Largest != 40+8+1448 {
+		t.Errorf("IPv6 reassembly = %+v", got[2].Frag)
+	}
+
+	if got[3].Encap.Tunnel != TunnelVXLAN || got[3].Encap.VNI != 42 || got[3].Frag.Fragments != 2 {
+		t.Errorf("tunnel %v, reassembly %+v", got[3].Encap, got[3].Frag)
+	}
+	if tcp, ok := got[3].TransportLayer().(*layers.TCP); !ok || !tcp.SYN {
+		t.Errorf("inner transport layer = %v", got[3].TransportLayer())
+	}
+
+	if got[4].Layer(gopacket.LayerTypeFragment) == nil {
+		t.Errorf("misaligned fragment: %v", got[4].Layers())
+	}
+
+	want := FragmentStats{Fragments: 14, Reassembled: 3, Incomplete: 1, Pending: 1, Overlaps: 1, Duplicates: 1, Invalid: 1}
+	if st := handle.Fragments(); st != want {
+		t.Errorf("stats = %+v\nwant    %+v", st, want)
+	}
+}
@@ -0,0 +1,185 @@
+package pcap
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+
+	"network-app/pkg/core/analyzer"
+)
+
+func init() {
+	// the Linux vxlan driver listens on the port used before IANA assigned
+	// 4789, and Open vSwitch and flannel deployments still use it
+	layers.RegisterUDPPortLayerType(8472, layers.LayerTypeVXLAN)
+}
+
+// Tunnel is the kind of tunnel a packet was carried in
+type Tunnel int
+
+const (
+	TunnelNone Tunnel = iota
+	TunnelGRE
+	TunnelVXLAN
+	TunnelGeneve
+	TunnelIPinIP // IPv4 or IPv6 directly inside IPv4 or IPv6, including 6in4
+)
+
+func (t Tunnel) String() string {
+	switch t {
+	case TunnelGRE:
+		return "GRE"
+	case TunnelVXLAN:
+		return "VXLAN"
+	case TunnelGeneve:
+		return "Geneve"
+	case TunnelIPinIP:
+		return "IP-in-IP"
+	}
+	return ""
+}
+
+// Segment is the VLAN and virtual network a packet belongs to
+type Segment struct {
+	VLAN      uint16 // outer 802.1Q tag, the service tag with QinQ; 0 when untagged
+	InnerVLAN uint16 // customer tag with QinQ
+	Tunnel    Tunnel
+	VNI       uint32 // VXLAN or Geneve network identifier, or GRE key
+}
+
+// IsZero reports whether the packet was neither tagged nor tunnelled
+func (s Segment) IsZero() bool {
+	return s == Segment{}
+}
+
+// String returns e.g. "VLAN 100.20 / VXLAN 5001", or "untagged"
+func (s Segment) String() string {
+	var parts []string
+	switch {
+	case s.InnerVLAN != 0:
+		parts = append(parts, fmt.Sprintf("VLAN %d.%d", s.VLAN, s.InnerVLAN))
+	case s.VLAN != 0:
+		parts = append(parts, fmt.Sprintf("VLAN %d", s.VLAN))
+	}
+	switch {
+	case s.Tunnel == TunnelGRE && s.VNI != 0:
+		parts = append(parts, fmt.Sprintf("GRE key %d", s.VNI))
+	case s.Tunnel == TunnelVXLAN || s.Tunnel == TunnelGeneve:
+		parts = append(parts, fmt.Sprintf("%s %d", s.Tunnel, s.VNI))
+	case s.Tunnel != TunnelNone:
+		parts = append(parts, s.Tunnel.String())
+	}
+	if len(parts) == 0 {
+		return "untagged"
+	}
+	return strings.Join(parts, " / ")
+}
+
+// Encapsulation is the outer context of a packet: its VLAN tags and the
+// tunnel the analyzed inner packet was carried in. With nested tunnels the
+// innermost one is kept; VLAN tags are those of the outer frame.
+type Encapsulation struct {
+	Segment
+	Src, Dst netip.Addr // tunnel endpoints
+}
+
+// decapsulate finds the VLAN tags and tunnel headers of p. A tunnelled
+// packet is returned as a view of its inner layers, so analyzers follow the
+// flows it carries rather than the tunnel.
+func decapsulate(p gopacket.Packet) (gopacket.Packet, Encapsulation) {
+	var e Encapsulation
+	var src, dst netip.Addr
+	ls := p.Layers()
+	start, tags := 0, 0
+	for i, l := range ls {
+		switch l := l.(type) {
+		case *layers.Dot1Q:
+			if e.Tunnel == TunnelNone {
+				switch tags {
+				case 0:
+					e.VLAN = l.VLANIdentifier
+				case 1:
+					e.InnerVLAN = l.VLANIdentifier
+				}
+				tags++
+			}
+		case *layers.IPv4, *layers.IPv6:
+			if i > 0 {
+				if prev := ls[i-1].LayerType(); prev == layers.LayerTypeIPv4 || prev == layers.LayerTypeIPv6 {
+					e.Tunnel, e.VNI, e.Src, e.Dst, start = TunnelIPinIP, 0, src, dst, i
+				}
+			}
+			src, dst, _ = analyzer.Addrs(l.(gopacket.NetworkLayer))
+		case *layers.GRE:
+			e.Tunnel, e.VNI, e.Src, e.Dst, start = TunnelGRE, 0, src, dst, i+1
+			if l.KeyPresent {
+				e.VNI = l.Key
+			}
+		case *layers.VXLAN:
+			e.Tunnel, e.VNI, e.Src, e.Dst, start = TunnelVXLAN, l.VNI, src, dst, i+1
+		case *layers.Geneve:
+			e.Tunnel, e.VNI, e.Src, e.Dst, start = TunnelGeneve, l.VNI, src, dst, i+1
+		}
+	}
+	if e.Tunnel == TunnelNone {
+		return p, e
+	}
+	return newInner(p, ls[start:]), e
+}
+
+// inner is the view of a tunnelled packet from its inner headers on. Data
+// and Metadata still describe the whole frame as captured.
+type inner struct {
+	gopacket.Packet
+	layers      []gopacket.Layer
+	link        gopacket.LinkLayer
+	network     gopacket.NetworkLayer
+	transport   gopacket.TransportLayer
+	application gopacket.ApplicationLayer
+}
+
+func newInner(p gopacket.Packet, ls []gopacket.Layer) *inner {
+	in := &inner{Packet: p, layers: ls}
+	for _, l := range ls {
+		if x, ok := l.(gopacket.LinkLayer); ok && in.link == nil {
+			in.link = x
+		}
+		if x, ok := l.(gopacket.NetworkLayer); ok && in.network == nil {
+			in.network = x
+		}
+		if x, ok := l.(gopacket.TransportLayer); ok && in.transport == nil {
+			in.transport = x
+		}
+		if x, ok := l.(gopacket.ApplicationLayer); ok && in.application == nil {
+			in.application = x
+		}
+	}
+	return in
+}
+
+func (p *inner) Layers() []gopacket.Layer                    { return p.layers }
+func (p *inner) LinkLayer() gopacket.LinkLayer               { return p.link }
+func (p *inner) NetworkLayer() gopacket.NetworkLayer         { return p.network }
+func (p *inner) TransportLayer() gopacket.TransportLayer     { return p.transport }
+func (p *inner) ApplicationLayer() gopacket.ApplicationLayer { return p.application }
+
+func (p *inner) Layer(t gopacket.LayerType) gopacket.Layer {
+	for _, l := range p.layers {
+		if l.LayerType() == t {
+			return l
+		}
+	}
+	return nil
+}
+
+func (p *inner) LayerClass(c gopacket.LayerClass) gopacket.Layer {
+	for _, l := range p.layers {
+		if c.Contains(l.LayerType()) {
+			return l
+		}
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package pcap
+
+import (
+	"net"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+func serialize(t *testing.T, ls ...gopacket.SerializableLayer) []byte {
+	t.Helper()
+	buf := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}, ls...); err != nil {
+		t.Fatalf("SerializeLayers: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// inner4 returns the inner IPv4 packet 192.168.1.10 -> 93.184.216.34 with
+// a TCP SYN
+func inner4() (*layers.IPv4, *layers.TCP) {
+	ip := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolTCP, SrcIP: net.IP{192, 168, 1, 10}, DstIP: net.IP{93, 184, 216, 34}}
+	tcp := &layers.TCP{SrcPort: 54321, DstPort: 443, SYN: true, Window: 64240}
+	tcp.SetNetworkLayerForChecksum(ip)
+	return ip, tcp
+}
+
+// innerFrame returns an Ethernet frame carrying the inner packet
+func innerFrame(t *testing.T) []byte {
+	ip, tcp := inner4()
+	return serialize(t, &layers.Ethernet{SrcMAC: net.HardwareAddr{2, 0, 0, 0, 1, 1}, DstMAC: net.HardwareAddr{2, 0, 0, 0, 1, 2}, EthernetType: layers.EthernetTypeIPv4}, ip, tcp)
+}
+
+// overlay returns an Ethernet frame from tunnel endpoint 10.0.0.1 to
+// 10.0.0.2 carrying payload in UDP to port
+func overlay(t *testing.T, port layers.UDPPort, payload []byte) []byte {
+	ip := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolUDP, SrcIP: net.IP{10, 0, 0, 1}, DstIP: net.IP{10, 0, 0, 2}}
+	udp := &layers.UDP{SrcPort: 40000, DstPort: port}
+	udp.SetNetworkLayerForChecksum(ip)
+	return serialize(t, &layers.Ethernet{SrcMAC: net.HardwareAddr{2, 0, 0, 0, 0, 1}, DstMAC: net.HardwareAddr{2, 0, 0, 0, 0, 2}, EthernetType: layers.EthernetTypeIPv4},
+		ip, udp, gopacket.Payload(payload))
+}
+
+func TestDecapsulate(t *testing.T) {
+	eth := func(typ layers.EthernetType) *layers.Ethernet {
+		return &layers.Ethernet{SrcMAC: net.HardwareAddr{2, 0, 0, 0, 0, 1}, DstMAC: net.HardwareAddr{2, 0, 0, 0, 0, 2}, EthernetType: typ}
+	}
+	outer := func(proto layers.IPProtocol) *layers.IPv4 {
+		return &layers.IPv4{Version: 4, TTL: 64, Protocol: proto, SrcIP: net.IP{10, 0, 0, 1}, DstIP: net.IP{10, 0, 0, 2}}
+	}
+	ip, tcp := inner4()
+	vxlan := serialize(t, &layers.VXLAN{ValidIDFlag: true, VNI: 5001}, gopacket.Payload(innerFrame(t)))
+	geneve := append([]byte{0, 0, 0x65, 0x58, 0, 0, 77, 0}, innerFrame(t)...)
+	dns := &layers.UDP{SrcPort: 40000, DstPort: 53}
+	ip6 := &layers.IPv6{Version: 6, HopLimit: 64, NextHeader: layers.IPProtocolUDP, SrcIP: net.ParseIP("2001:db8::10"), DstIP: net.ParseIP("2001:db8::53")}
+	dns.SetNetworkLayerForChecksum(ip6)
+
+	tests := []struct {
+		name      string
+		frame     []byte
+		segment   string
+		endpoints bool
+		src       string // inner source address
+		transport gopacket.LayerType
+	}{
+		{"plain", tcpFrame(t, true, false), "untagged", false, "192.168.1.10", layers.LayerTypeTCP},
+		{"QinQ", serialize(t, eth(layers.EthernetTypeQinQ), &layers.Dot1Q{VLANIdentifier: 100, Type: layers.EthernetTypeDot1Q},
+			&layers.Dot1Q{VLANIdentifier: 20, Type: layers.EthernetTypeIPv4}, ip, tcp), "VLAN 100.20", false, "192.168.1.10", layers.LayerTypeTCP},
+		{"VXLAN on a VLAN", serialize(t, eth(layers.EthernetTypeDot1Q), &layers.Dot1Q{VLANIdentifier: 10, Type: layers.EthernetTypeIPv4},
+			gopacket.Payload(overlay(t, 4789, vxlan)[14:])), "VLAN 10 / VXLAN 5001", true, "192.168.1.10", layers.LayerTypeTCP},
+		{"VXLAN on the Linux port", overlay(t, 8472, vxlan), "VXLAN 5001", true, "192.168.1.10", layers.LayerTypeTCP},
+		{"Geneve", overlay(t, 6081, geneve), "Geneve 77", true, "192.168.1.10", layers.LayerTypeTCP},
+		{"GRE", serialize(t, eth(layers.EthernetTypeIPv4), outer(layers.IPProtocolGRE), &layers.GRE{KeyPresent: true, Key: 7, Protocol: layers.EthernetTypeIPv4}, ip, tcp),
+			"GRE key 7", true, "192.168.1.10", layers.LayerTypeTCP},
+		{"IP-in-IP", serialize(t, eth(layers.EthernetTypeIPv4), outer(layers.IPProtocolIPv4), ip, tcp), "IP-in-IP", true, "192.168.1.10", layers.LayerTypeTCP},
+		{"6in4", serialize(t, eth(layers.EthernetTypeIPv4), outer(layers.IPProtocolIPv6), ip6, dns), "IP-in-IP", true, "2001:db8::10", layers.LayerTypeUDP},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handle := NewHandle(NewMemorySource(layers.LinkTypeEthernet, []Frame{{Data: tt.frame}}), "mem0")
+			pkt, ok := handle.next(nil)
+			if !ok {
+				t.Fatal("no packet")
+			}
+			if got := pkt.Encap.String(); got != tt.segment {
+				t.Errorf("segment = %q, want %q", got, tt.segment)
+			}
+			if tt.endpoints && (pkt.Encap.Src.String() != "10.0.0.1" || pkt.Encap.Dst.String() != "10.0.0.2") {
+				t.Errorf("endpoints = %v, %v", pkt.Encap.Src, pkt.Encap.Dst)
+			}
+			if n := pkt.NetworkLayer(); n == nil || n.NetworkFlow().Src().String() != tt.src {
+				t.Fatalf("network layer = %v", n)
+			}
+			if tr := pkt.TransportLayer(); tr == nil || tr.LayerType() != tt.transport {
+				t.Errorf("transport layer = %v", tr)
+			}
+			if l := pkt.Layer(tt.transport); l != pkt.TransportLayer() {
+				t.Errorf("Layer(%v) = %v, not the inner transport layer", tt.transport, l)
+			}
+			if pkt.Encap.Tunnel != TunnelNone && (pkt.Layer(layers.LayerTypeUDP) != nil) != (tt.transport == layers.LayerTypeUDP) {
+				t.Error("the outer UDP header is visible")
+			}
+		})
+	}
+}
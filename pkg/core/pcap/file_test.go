@@ -0,0 +1,52 @@
+package pcap
+
+import (
+	"testing"
+
+	"github.com/google/gopacket/layers"
+)
+
+func TestOpenFile(t *testing.T) {
+	for _, name := range []string{"handshake.pcap", "handshake.pcapng", "handshake.pcap.gz"} {
+		t.Run(name, func(t *testing.T) {
+			handle, err := OpenFile("testdata/" + name)
+			if err != nil {
+				t.Fatalf("OpenFile(%s) failed: %v", name, err)
+			}
+			defer handle.Close()
+
+			var syns int
+			for pkt := range handle.Packets() {
+				tcp, ok := pkt.Layer(layers.LayerTypeTCP).(*layers.TCP)
+				if !ok {
+					t.Fatalf("packet without TCP layer: %v", pkt)
+				}
+				if tcp.SYN {
+					syns++
+				}
+				if pkt.Metadata().Timestamp.IsZero() {
+					t.Error("packet timestamp not set")
+				}
+			}
+
+			if got := handle.PacketsCaptured(); got != 6 {
+				t.Errorf("PacketsCaptured = %d, want 6", got)
+			}
+			if syns != 3 {
+				t.Errorf("SYN packets = %d, want 3", syns)
+			}
+			if got := handle.Span().Milliseconds(); got != 101 {
+				t.Errorf("Span = %dms, want 101ms", got)
+			}
+		})
+	}
+}
+
+func TestOpenFileNotCapture(t *testing.T) {
+	if _, err := OpenFile("file.go"); err == nil {
+		t.Error("OpenFile on a Go source file should fail")
+	}
+	if _, err := OpenFile("testdata/missing.pcap"); err == nil {
+		t.Error("OpenFile on a missing file should fail")
+	}
+}
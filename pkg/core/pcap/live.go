@@ -0,0 +1,96 @@
+//go:build cgo
+
+package pcap
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+)
+
+// LibpcapAvailable reports whether the binary was built with libpcap support
+const LibpcapAvailable = true
+
+// liveSource captures from a network interface through libpcap
+type liveSource struct {
+	handle *pcap.Handle
+}
+
+// NewLiveSource opens a libpcap capture on the given interface. Reads return
+// ErrReadTimeout when no packet arrived within timeout.
+func NewLiveSource(iface string, filter string, timeout time.Duration) (PacketSource, error) {
+	handle, err := pcap.OpenLive(iface, int32(65536), false, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("pcap.OpenLive(%s): %w", iface, err)
+	}
+	if filter != "" {
+		if err := handle.SetBPFFilter(filter); err != nil {
+			handle.Close()
+			return nil, fmt.Errorf("SetBPFFilter(%s): %w", filter, err)
+		}
+	}
+	return &liveSource{handle: handle}, nil
+}
+
+func (s *liveSource) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	data, ci, err := s.handle.ReadPacketData()
+	return data, ci, liveError(err)
+}
+
+// ZeroCopyReadPacketData returns the next frame in libpcap's buffer
+func (s *liveSource) ZeroCopyReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	data, ci, err := s.handle.ZeroCopyReadPacketData()
+	return data, ci, liveError(err)
+}
+
+// liveError maps libpcap's read timeout to ErrReadTimeout
+func liveError(err error) error {
+	if err == pcap.NextErrorTimeoutExpired {
+		return ErrReadTimeout
+	}
+	return err
+}
+
+func (s *liveSource) LinkType() layers.LinkType {
+	return s.handle.LinkType()
+}
+
+func (s *liveSource) Stats() (Stats, error) {
+	st, err := s.handle.Stats()
+	if err != nil {
+		return Stats{}, fmt.Errorf("pcap stats: %w", err)
+	}
+	return Stats{
+		PacketsReceived:  st.PacketsReceived,
+		PacketsDropped:   st.PacketsDropped,
+		PacketsIfDropped: st.PacketsIfDropped,
+	}, nil
+}
+
+func (s *liveSource) Close() {
+	s.handle.Close()
+}
+
+// Interfaces returns a list of available network interfaces
+func Interfaces() ([]Interface, error) {
+	devs, err := pcap.FindAllDevs()
+	if err != nil {
+		return nil, err
+	}
+	result := make([]Interface, len(devs))
+	for i, d := range devs {
+		addrs := make([]string, len(d.Addresses))
+		for j, a := range d.Addresses {
+			addrs[j] = a.IP.String()
+		}
+		result[i] = Interface{
+			Name:        d.Name,
+			Description: d.Description,
+			Addresses:   addrs,
+		}
+	}
+	return result, nil
+}
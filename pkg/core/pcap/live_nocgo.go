@@ -0,0 +1,44 @@
+//go:build !cgo
+
+package pcap
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+// LibpcapAvailable reports whether the binary was built with libpcap support
+const LibpcapAvailable = false
+
+// errNoLibpcap is returned by the libpcap entry points in cgo-free builds
+var errNoLibpcap = errors.New("built without libpcap (CGO_ENABLED=0); use the afpacket backend")
+
+// NewLiveSource is unavailable without cgo
+func NewLiveSource(iface string, filter string, timeout time.Duration) (PacketSource, error) {
+	return nil, errNoLibpcap
+}
+
+// Interfaces returns a list of available network interfaces
+func Interfaces() ([]Interface, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	result := make([]Interface, len(ifaces))
+	for i, iface := range ifaces {
+		var addrs []string
+		if as, err := iface.Addrs(); err == nil {
+			for _, a := range as {
+				if ipnet, ok := a.(*net.IPNet); ok {
+					addrs = append(addrs, ipnet.IP.String())
+				}
+			}
+		}
+		result[i] = Interface{
+			Name:      iface.Name,
+			Addresses: addrs,
+		}
+	}
+	return result, nil
+}
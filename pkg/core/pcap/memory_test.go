@@ -0,0 +1,130 @@
+package pcap
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+func TestMemorySource(t *testing.T) {
+	base := time.Date(2026, 2, 20, 10, 0, 0, 0, time.UTC)
+	frames := []Frame{
+		{Data: tcpFrame(t, true, false), CaptureInfo: gopacket.CaptureInfo{Timestamp: base}},
+		{Data: tcpFrame(t, true, true), CaptureInfo: gopacket.CaptureInfo{Timestamp: base.Add(time.Second)}},
+	}
+	handle := NewHandle(NewMemorySource(layers.LinkTypeEthernet, frames), "mem0")
+	defer handle.Close()
+
+	packets := make(chan interface{})
+	go func() {
+		defer close(packets)
+		handle.Capture(packets)
+	}()
+
+	var n int
+	for raw := range packets {
+		pkt, ok := raw.(gopacket.Packet)
+		if !ok {
+			t.Fatalf("unexpected value on channel: %T", raw)
+		}
+		if pkt.Layer(layers.LayerTypeTCP) == nil {
+			t.Error("packet has no TCP layer")
+		}
+		n++
+	}
+
+	if n != 2 {
+		t.Errorf("received %d packets, want 2", n)
+	}
+	if handle.PacketsCaptured() != 2 {
+		t.Errorf("PacketsCaptured = %d, want 2", handle.PacketsCaptured())
+	}
+	if handle.Span() != time.Second {
+		t.Errorf("Span = %v, want 1s", handle.Span())
+	}
+	stats, err := handle.Stats()
+	if err != nil {
+		t.Fatalf("Stats() failed: %v", err)
+	}
+	if stats.PacketsReceived != 2 {
+		t.Errorf("PacketsReceived = %d, want 2", stats.PacketsReceived)
+	}
+}
+
+func TestMemorySourceClosed(t *testing.T) {
+	src := NewMemorySource(layers.LinkTypeEthernet, []Frame{{Data: tcpFrame(t, true, false)}})
+	src.Close()
+	if _, _, err := src.ReadPacketData(); err == nil {
+		t.Error("ReadPacketData after Close should fail")
+	}
+}
+
+// tcpFrame serializes a minimal Ethernet/IPv4/TCP frame
+func tcpFrame(t *testing.T, syn, ack bool) []byte {
+	t.Helper()
+	eth := &layers.Ethernet{
+		SrcMAC:       net.HardwareAddr{0x02, 0, 0, 0, 0, 1},
+		DstMAC:       net.HardwareAddr{0x02, 0, 0, 0, 0, 2},
+		EthernetType: layers.EthernetTypeIPv4,
+	}
+	ip := &layers.IPv4{
+		Version:  4,
+		TTL:      64,
+		Protocol: layers.IPProtocolTCP,
+		SrcIP:    net.IP{192, 168, 1, 10},
+		DstIP:    net.IP{93, 184, 216, 34},
+	}
+	tcp := &layers.TCP{SrcPort: 54321, DstPort: 443, SYN: syn, ACK: ack, Window: 64240}
+	tcp.SetNetworkLayerForChecksum(ip)
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, eth, ip, tcp); err != nil {
+		t.Fatalf("SerializeLayers: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestNonBlockingDrops(t *testing.T) {
+	frames := make([]Frame, 5)
+	for i := range frames {
+		frames[i] = Frame{Data: tcpFrame(t, true, false)}
+	}
+	handle := NewHandle(NewMemorySource(layers.LinkTypeEthernet, frames), "mem0")
+	handle.SetNonBlocking(true)
+
+	// Nobody reads the channel, so everything beyond its buffer is dropped
+	packets := make(chan interface{}, 2)
+	if err := handle.Capture(packets); err != nil {
+		t.Fatalf("Capture failed: %v", err)
+	}
+
+	stats, err := handle.Stats()
+	if err != nil {
+		t.Fatalf("Stats() failed: %v", err)
+	}
+	if stats.PacketsReceived != 5 {
+		t.Errorf("PacketsReceived = %d, want 5", stats.PacketsReceived)
+	}
+	if stats.PacketsChannelDropped != 3 {
+		t.Errorf("PacketsChannelDropped = %d, want 3", stats.PacketsChannelDropped)
+	}
+	if stats.DropRate() != 60 {
+		t.Errorf("DropRate = %.1f, want 60.0", stats.DropRate())
+	}
+}
+
+func TestStatsDropRate(t *testing.T) {
+	st := Stats{PacketsReceived: 90, PacketsDropped: 5, PacketsIfDropped: 10}
+	if st.Dropped() != 15 {
+		t.Errorf("Dropped = %d, want 15", st.Dropped())
+	}
+	if st.DropRate() != 15 {
+		t.Errorf("DropRate = %.1f, want 15.0", st.DropRate())
+	}
+	if (Stats{}).DropRate() != 0 {
+		t.Error("DropRate of empty stats should be 0")
+	}
+}
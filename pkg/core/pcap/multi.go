@@ -0,0 +1,72 @@
+package pcap
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+)
+
+// CaptureAll runs Capture on every handle concurrently, merging their packets
+// into ch. It returns once all handles are exhausted.
+func CaptureAll(handles []*CaptureHandle, ch chan<- interface{}) error {
+	return captureAll(handles, func(h *CaptureHandle) error { return h.Capture(ch) })
+}
+
+// CaptureAllBatches is CaptureAll for CaptureBatches. Every batch holds the
+// packets of a single handle.
+func CaptureAllBatches(handles []*CaptureHandle, ch chan<- *Batch) error {
+	return captureAll(handles, func(h *CaptureHandle) error { return h.CaptureBatches(ch) })
+}
+
+// captureAll runs capture on every handle concurrently and joins the errors
+func captureAll(handles []*CaptureHandle, capture func(*CaptureHandle) error) error {
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []error
+	)
+	for _, h := range handles {
+		wg.Add(1)
+		go func(h *CaptureHandle) {
+			defer wg.Done()
+			if err := capture(h); err != nil {
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("%s: %w", h.Name(), err))
+				mu.Unlock()
+			}
+		}(h)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// ExpandInterfaces resolves the special name "any" into every interface that
+// is up, so that each one gets its own capture and attribution. Duplicates
+// are removed while keeping the order of the remaining names.
+func ExpandInterfaces(names []string) ([]string, error) {
+	var result []string
+	seen := make(map[string]bool)
+	add := func(name string) {
+		if !seen[name] {
+			seen[name] = true
+			result = append(result, name)
+		}
+	}
+	for _, name := range names {
+		if name != "any" {
+			add(name)
+			continue
+		}
+		ifaces, err := net.Interfaces()
+		if err != nil {
+			return nil, fmt.Errorf("list interfaces: %w", err)
+		}
+		for _, iface := range ifaces {
+			if iface.Flags&net.FlagUp != 0 {
+				add(iface.Name)
+			}
+		}
+	}
+	return result, nil
+}
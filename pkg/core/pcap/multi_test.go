@@ -0,0 +1,55 @@
+package pcap
+
+import (
+	"testing"
+
+	"github.com/google/gopacket/layers"
+)
+
+func TestCaptureAll(t *testing.T) {
+	eth0 := NewHandle(NewMemorySource(layers.LinkTypeEthernet, []Frame{
+		{Data: tcpFrame(t, true, false)},
+		{Data: tcpFrame(t, true, true)},
+	}), "eth0")
+	eth1 := NewHandle(NewMemorySource(layers.LinkTypeEthernet, []Frame{
+		{Data: tcpFrame(t, true, false)},
+	}), "eth1")
+
+	packets := make(chan interface{})
+	go func() {
+		defer close(packets)
+		if err := CaptureAll([]*CaptureHandle{eth0, eth1}, packets); err != nil {
+			t.Errorf("CaptureAll failed: %v", err)
+		}
+	}()
+
+	counts := make(map[string]int)
+	for raw := range packets {
+		pkt, ok := raw.(Packet)
+		if !ok {
+			t.Fatalf("unexpected value on channel: %T", raw)
+		}
+		counts[pkt.Interface]++
+	}
+	if counts["eth0"] != 2 || counts["eth1"] != 1 {
+		t.Errorf("per-interface counts = %v, want eth0:2 eth1:1", counts)
+	}
+}
+
+func TestExpandInterfaces(t *testing.T) {
+	names, err := ExpandInterfaces([]string{"eth9", "eth9", "any"})
+	if err != nil {
+		t.Fatalf("ExpandInterfaces failed: %v", err)
+	}
+	if len(names) == 0 || names[0] != "eth9" {
+		t.Fatalf("ExpandInterfaces = %v, want eth9 first", names)
+	}
+	for i, n := range names {
+		if n == "any" {
+			t.Error("any was not expanded")
+		}
+		if i > 0 && n == "eth9" {
+			t.Error("duplicate eth9 not removed")
+		}
+	}
+}
@@ -2,34 +2,47 @@
 package pcap
 
 import (
+	"errors"
 	"fmt"
 	"sync/atomic"
 	"time"
 
 	"github.com/google/gopacket"
-	"github.com/google/gopacket/pcap"
+	"github.com/google/gopacket/layers"
 )
 
-// CaptureHandle wraps a pcap handle
+// CaptureHandle decodes packets from a PacketSource and feeds the analyzers
 type CaptureHandle struct {
-	handle          *pcap.Handle
+	handle          PacketSource
 	iface           string
 	packetsCaptured int64 // atomic counter
+	stopped         int32 // atomic flag, set by Stop
+	first, last     time.Time
+	tee             PacketWriter
+	teeErr          error
+	nonBlocking     bool
+	channelDropped  int64 // atomic counter
+	defrag          *defragmenter
+	zeroCopy        zeroCopySource // handle, when it can lend frames
+	free            chan *Batch    // batches released by the consumer
 }
 
-// NewCapture opens a packet capture on the given interface
+// NewHandle wraps an arbitrary packet source. name identifies the source in
+// reports, usually the interface name or file path.
+func NewHandle(src PacketSource, name string) *CaptureHandle {
+	c := &CaptureHandle{handle: src, iface: name, defrag: newDefragmenter(), free: make(chan *Batch, freeBatches)}
+	c.zeroCopy, _ = src.(zeroCopySource)
+	return c
+}
+
+// NewCapture opens a packet capture on the given interface. timeout is the
+// poll timeout of the reads; the capture runs until Stop.
 func NewCapture(iface string, filter string, timeout time.Duration) (*CaptureHandle, error) {
-	handle, err := pcap.OpenLive(iface, int32(65536), false, timeout)
+	src, err := NewLiveSource(iface, filter, timeout)
 	if err != nil {
-		return nil, fmt.Errorf("pcap.OpenLive(%s): %w", iface, err)
-	}
-	if filter != "" {
-		if err := handle.SetBPFFilter(filter); err != nil {
-			handle.Close()
-			return nil, fmt.Errorf("SetBPFFilter(%s): %w", filter, err)
-		}
+		return nil, err
 	}
-	return &CaptureHandle{handle: handle, iface: iface}, nil
+	return NewHandle(src, iface), nil
 }
 
 // Close releases the capture handle
@@ -37,23 +50,81 @@ func (c *CaptureHandle) Close() {
 	c.handle.Close()
 }
 
+// Stop ends the capture after the read in progress, which returns within
+// the poll timeout of a live source. It may be called from any goroutine;
+// close the handle once the capture has returned.
+func (c *CaptureHandle) Stop() {
+	atomic.StoreInt32(&c.stopped, 1)
+}
+
+// Name returns the interface name or file path the handle reads from
+func (c *CaptureHandle) Name() string {
+	return c.iface
+}
+
+// LinkType returns the link type of the underlying source
+func (c *CaptureHandle) LinkType() layers.LinkType {
+	return c.handle.LinkType()
+}
+
+// Stats returns the counters reported by the underlying source together with
+// the packets the handle itself dropped
+func (c *CaptureHandle) Stats() (Stats, error) {
+	st, err := c.handle.Stats()
+	st.PacketsChannelDropped = int(atomic.LoadInt64(&c.channelDropped))
+	return st, err
+}
+
+// Fragments returns the IP fragment counters. Read it once the packets
+// have been consumed.
+func (c *CaptureHandle) Fragments() FragmentStats {
+	return c.defrag.Stats()
+}
+
+// SetNonBlocking makes Capture, CaptureBatches and Packets drop packets instead of waiting
+// when the consumer is not ready. Live captures use it so a slow analyzer
+// shows up as counted drops rather than silent kernel buffer overruns; file
+// sources should keep the default blocking behaviour.
+func (c *CaptureHandle) SetNonBlocking(enable bool) {
+	c.nonBlocking = enable
+}
+
+// Packet is a decoded packet tagged with the interface (or file) it was
+// captured on. It satisfies gopacket.Packet, so analyzers can ignore the tag.
+// Tunnelled packets present their inner layers; Encap holds the outer
+// context. Fragmented datagrams are passed on once reassembled, and Frag
+// tells how they arrived.
+type Packet struct {
+	gopacket.Packet
+	Interface string
+	Encap     Encapsulation
+	Frag      Reassembly
+}
+
+// Tee copies every raw frame read from now on to w. Writing stops at the
+// first error, which Capture then returns.
+func (c *CaptureHandle) Tee(w PacketWriter) {
+	c.tee = w
+}
+
 // Capture reads packets and sends them to the provided channel (as interface{})
 func (c *CaptureHandle) Capture(ch chan<- interface{}) error {
 	for {
-		data, _, err := c.handle.ReadPacketData()
-		if err != nil {
+		packet, ok := c.next(nil)
+		if !ok {
 			break
 		}
-		if data == nil {
-			break
+		if !c.nonBlocking {
+			ch <- packet
+			continue
+		}
+		select {
+		case ch <- packet:
+		default:
+			atomic.AddInt64(&c.channelDropped, 1)
 		}
-		packet := gopacket.NewPacket(data, c.handle.LinkType(), gopacket.DecodeOptions{
-			SkipDecodeRecovery: true,
-		})
-		ch <- packet
-		atomic.AddInt64(&c.packetsCaptured, 1)
 	}
-	return nil
+	return c.teeErr
 }
 
 // PacketsCaptured returns the number of packets captured so far
@@ -61,53 +132,93 @@ func (c *CaptureHandle) PacketsCaptured() int {
 	return int(atomic.LoadInt64(&c.packetsCaptured))
 }
 
+// Span returns the time between the first and last packet read. It is only
+// meaningful once the capture has finished.
+func (c *CaptureHandle) Span() time.Duration {
+	return c.last.Sub(c.first)
+}
+
 // Packets returns a channel of decoded packets (alternative interface)
 func (c *CaptureHandle) Packets() <-chan gopacket.Packet {
 	ch := make(chan gopacket.Packet)
 	go func() {
 		defer close(ch)
 		for {
-			data, _, err := c.handle.ReadPacketData()
-			if err != nil {
+			packet, ok := c.next(nil)
+			if !ok {
 				break
 			}
-			if data == nil {
-				break
+			if !c.nonBlocking {
+				ch <- packet
+				continue
+			}
+			select {
+			case ch <- packet:
+			default:
+				atomic.AddInt64(&c.channelDropped, 1)
 			}
-			packet := gopacket.NewPacket(data, c.handle.LinkType(), gopacket.DecodeOptions{
-				SkipDecodeRecovery: true,
-			})
-			ch <- packet
-			atomic.AddInt64(&c.packetsCaptured, 1)
 		}
 	}()
 	return ch
 }
 
+// next reads and decodes a single packet, holding IP fragments back until
+// their datagram is complete. Ethernet frames are decoded into r when it is
+// not nil and covers them, by NewPacket otherwise. It returns false once the
+// source is exhausted (end of file, read timeout or a closed handle).
+func (c *CaptureHandle) next(r *record) (Packet, bool) {
+	for atomic.LoadInt32(&c.stopped) == 0 {
+		data, ci, err := c.read()
+		if errors.Is(err, ErrReadTimeout) {
+			continue
+		}
+		if err != nil || data == nil {
+			return Packet{}, false
+		}
+		if c.tee != nil && c.teeErr == nil {
+			if err := c.tee.WritePacket(ci, data); err != nil {
+				c.teeErr = fmt.Errorf("tee: %w", err)
+			}
+		}
+		var packet gopacket.Packet
+		linkType := c.handle.LinkType()
+		if r != nil && linkType == layers.LinkTypeEthernet && r.decode(data, ci) {
+			packet = r
+		} else {
+			packet = gopacket.NewPacket(data, linkType, gopacket.DecodeOptions{
+				SkipDecodeRecovery: true,
+			})
+			m := packet.Metadata()
+			m.CaptureInfo = ci
+			m.Truncated = m.Truncated || ci.CaptureLength < ci.Length
+		}
+		if c.first.IsZero() {
+			c.first = ci.Timestamp
+		}
+		c.last = ci.Timestamp
+		atomic.AddInt64(&c.packetsCaptured, 1)
+		packet, frag, ok := c.defrag.defragment(packet)
+		if !ok {
+			continue
+		}
+		inner, encap := decapsulate(packet)
+		return Packet{Packet: inner, Interface: c.iface, Encap: encap, Frag: frag}, true
+	}
+	return Packet{}, false
+}
+
+// read returns the next frame, borrowed from the source when it supports
+// that; both decoding paths copy it before the following read
+func (c *CaptureHandle) read() ([]byte, gopacket.CaptureInfo, error) {
+	if c.zeroCopy != nil {
+		return c.zeroCopy.ZeroCopyReadPacketData()
+	}
+	return c.handle.ReadPacketData()
+}
+
 // Interface describes a network interface
 type Interface struct {
 	Name        string
 	Description string
 	Addresses   []string
 }
-
-// Interfaces returns a list of available network interfaces
-func Interfaces() ([]Interface, error) {
-	devs, err := pcap.FindAllDevs()
-	if err != nil {
-		return nil, err
-	}
-	result := make([]Interface, len(devs))
-	for i, d := range devs {
-		addrs := make([]string, len(d.Addresses))
-		for j, a := range d.Addresses {
-			addrs[j] = a.IP.String()
-		}
-		result[i] = Interface{
-			Name:        d.Name,
-			Description: d.Description,
-			Addresses:   addrs,
-		}
-	}
-	return result, nil
-}
\ No newline at end of file
@@ -3,9 +3,15 @@ package pcap
 import (
 	"testing"
 	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
 )
 
 func TestNewCapture(t *testing.T) {
+	if !LibpcapAvailable {
+		t.Skip("built without libpcap")
+	}
 	// Skip if not running as root or no interfaces available
 	ifaces, err := Interfaces()
 	if err != nil {
@@ -29,6 +35,9 @@ func TestNewCapture(t *testing.T) {
 }
 
 func TestCaptureStats(t *testing.T) {
+	if !LibpcapAvailable {
+		t.Skip("built without libpcap")
+	}
 	ifaces, err := Interfaces()
 	if err != nil {
 		t.Skipf("No interfaces available: %v", err)
@@ -61,6 +70,42 @@ func TestCaptureStats(t *testing.T) {
 	}
 }
 
+// quietSource is a live source on a link without traffic
+type quietSource struct{}
+
+func (quietSource) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	time.Sleep(time.Millisecond)
+	return nil, gopacket.CaptureInfo{}, ErrReadTimeout
+}
+func (quietSource) LinkType() layers.LinkType { return layers.LinkTypeEthernet }
+func (quietSource) Stats() (Stats, error)     { return Stats{}, nil }
+func (quietSource) Close()                    {}
+
+func TestStop(t *testing.T) {
+	handle := NewHandle(quietSource{}, "quiet0")
+	defer handle.Close()
+	done := make(chan error)
+	go func() {
+		done <- handle.CaptureBatches(make(chan *Batch, 1))
+	}()
+
+	// read timeouts do not end the capture, Stop does
+	select {
+	case <-done:
+		t.Fatal("capture ended on a read timeout")
+	case <-time.After(50 * time.Millisecond):
+	}
+	handle.Stop()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("CaptureBatches() = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("capture did not stop")
+	}
+}
+
 func TestInterfaces(t *testing.T) {
 	ifaces, err := Interfaces()
 	if err != nil {
@@ -70,4 +115,4 @@ func TestInterfaces(t *testing.T) {
 	if len(ifaces) == 0 {
 		t.Error("No interfaces returned")
 	}
-}
\ No newline at end of file
+}
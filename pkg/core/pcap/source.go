@@ -0,0 +1,56 @@
+package pcap
+
+import (
+	"errors"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// ErrReadTimeout is returned by live sources when no frame arrived within
+// their poll timeout. It does not end the capture; CaptureHandle.Stop does.
+var ErrReadTimeout = errors.New("read timeout expired")
+
+// PacketSource produces raw frames for a CaptureHandle. Implementations exist
+// for live libpcap capture, capture files and in-memory frames.
+type PacketSource interface {
+	// ReadPacketData returns the next frame. Any error other than
+	// ErrReadTimeout, including io.EOF, ends the capture.
+	ReadPacketData() ([]byte, gopacket.CaptureInfo, error)
+	// LinkType returns the link layer of the frames
+	LinkType() layers.LinkType
+	// Stats returns the capture counters collected so far
+	Stats() (Stats, error)
+	// Close releases the source
+	Close()
+}
+
+// zeroCopySource is implemented by sources that can lend a frame instead
+// of copying it. The frame is only valid until the next read.
+type zeroCopySource interface {
+	ZeroCopyReadPacketData() ([]byte, gopacket.CaptureInfo, error)
+}
+
+// Stats holds capture counters reported by a PacketSource
+type Stats struct {
+	PacketsReceived       int
+	PacketsDropped        int // dropped by the kernel
+	PacketsIfDropped      int // dropped by the interface or driver
+	PacketsChannelDropped int // dropped by a non-blocking CaptureHandle whose consumer fell behind
+}
+
+// Dropped returns the number of packets lost anywhere on the capture path
+func (s Stats) Dropped() int {
+	return s.PacketsDropped + s.PacketsIfDropped + s.PacketsChannelDropped
+}
+
+// DropRate returns the lost share of all packets that reached the capture
+// point, in percent. Kernel and channel drops are already part of
+// PacketsReceived; interface drops never reach the kernel and are not.
+func (s Stats) DropRate() float64 {
+	seen := s.PacketsReceived + s.PacketsIfDropped
+	if seen <= 0 {
+		return 0
+	}
+	return float64(s.Dropped()) / float64(seen) * 100
+}
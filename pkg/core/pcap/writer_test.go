@@ -0,0 +1,140 @@
+package pcap
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+)
+
+func TestRotatingWriterTee(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "capture.pcapng")
+	w, err := NewRotatingWriter(RotateConfig{
+		Path:      path,
+		LinkType:  layers.LinkTypeEthernet,
+		Interface: "eth0",
+		Filter:    "tcp",
+		Comment:   "incident 42",
+	})
+	if err != nil {
+		t.Fatalf("NewRotatingWriter failed: %v", err)
+	}
+
+	handle, err := OpenFile("testdata/handshake.pcap")
+	if err != nil {
+		t.Fatalf("OpenFile failed: %v", err)
+	}
+	defer handle.Close()
+	handle.Tee(w)
+
+	packets := make(chan interface{})
+	go func() {
+		defer close(packets)
+		if err := handle.Capture(packets); err != nil {
+			t.Errorf("Capture failed: %v", err)
+		}
+	}()
+	for range packets {
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	if files := w.Files(); len(files) != 1 || files[0] != path {
+		t.Fatalf("Files = %v, want [%s]", files, path)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer f.Close()
+	r, err := pcapgo.NewNgReader(f, pcapgo.DefaultNgReaderOptions)
+	if err != nil {
+		t.Fatalf("NewNgReader failed: %v", err)
+	}
+	if r.SectionInfo().Comment != "incident 42" {
+		t.Errorf("section comment = %q, want %q", r.SectionInfo().Comment, "incident 42")
+	}
+	intf, err := r.Interface(0)
+	if err != nil {
+		t.Fatalf("Interface(0) failed: %v", err)
+	}
+	if intf.Name != "eth0" || intf.Filter != "tcp" {
+		t.Errorf("interface = %q/%q, want eth0/tcp", intf.Name, intf.Filter)
+	}
+	var n int
+	for {
+		if _, _, err := r.ReadPacketData(); err != nil {
+			break
+		}
+		n++
+	}
+	if n != 6 {
+		t.Errorf("read back %d packets, want 6", n)
+	}
+}
+
+func TestRotatingWriterRotation(t *testing.T) {
+	dir := t.TempDir()
+	w, err := NewRotatingWriter(RotateConfig{
+		Path:     filepath.Join(dir, "ring.pcapng"),
+		MaxAge:   time.Second,
+		MaxFiles: 2,
+		LinkType: layers.LinkTypeEthernet,
+	})
+	if err != nil {
+		t.Fatalf("NewRotatingWriter failed: %v", err)
+	}
+
+	data := tcpFrame(t, true, false)
+	base := time.Date(2026, 2, 20, 10, 0, 0, 0, time.UTC)
+	for i := 0; i < 4; i++ {
+		ci := gopacket.CaptureInfo{Timestamp: base.Add(time.Duration(i) * time.Second), CaptureLength: len(data), Length: len(data)}
+		if err := w.WritePacket(ci, data); err != nil {
+			t.Fatalf("WritePacket failed: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	want := []string{filepath.Join(dir, "ring-00003.pcapng"), filepath.Join(dir, "ring-00004.pcapng")}
+	files := w.Files()
+	if len(files) != 2 || files[0] != want[0] || files[1] != want[1] {
+		t.Fatalf("Files = %v, want %v", files, want)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 2 {
+		t.Errorf("%d files on disk, want 2", len(entries))
+	}
+}
+
+func TestRotatingWriterSize(t *testing.T) {
+	dir := t.TempDir()
+	w, err := NewRotatingWriter(RotateConfig{
+		Path:     filepath.Join(dir, "size"),
+		MaxSize:  150,
+		LinkType: layers.LinkTypeEthernet,
+	})
+	if err != nil {
+		t.Fatalf("NewRotatingWriter failed: %v", err)
+	}
+	data := tcpFrame(t, true, false)
+	ci := gopacket.CaptureInfo{Timestamp: time.Now(), CaptureLength: len(data), Length: len(data)}
+	for i := 0; i < 5; i++ {
+		if err := w.WritePacket(ci, data); err != nil {
+			t.Fatalf("WritePacket failed: %v", err)
+		}
+	}
+	w.Close()
+	if got := len(w.Files()); got != 3 {
+		t.Errorf("%d files, want 3 (two 88 byte packets per file)", got)
+	}
+	if filepath.Ext(w.Files()[0]) != ".pcapng" {
+		t.Errorf("rotated file %s should get a .pcapng extension", w.Files()[0])
+	}
+}
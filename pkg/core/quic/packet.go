@@ -0,0 +1,343 @@
+package quic
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+// Versions whose Initial packets can be decrypted
+const (
+	Version1 uint32 = 0x00000001 // RFC 9000
+	Version2 uint32 = 0x6b3343cf // RFC 9369
+)
+
+// VersionName returns the name of a QUIC version, e.g. "QUIC v1"
+func VersionName(v uint32) string {
+	switch {
+	case v == Version1:
+		return "QUIC v1"
+	case v == Version2:
+		return "QUIC v2"
+	case v&0xffffff00 == 0xff000000:
+		return fmt.Sprintf("draft-%d", v&0xff)
+	case v&0x0f0f0f0f == 0x0a0a0a0a:
+		return "reserved"
+	}
+	return fmt.Sprintf("0x%08x", v)
+}
+
+// packetType is the type of a long header packet, independent of the
+// version's encoding
+type packetType int
+
+const (
+	typeInitial packetType = iota
+	type0RTT
+	typeHandshake
+	typeRetry
+	typeVersionNegotiation
+	typeUnknown // a version whose packet types are not known
+)
+
+// longHeader is a parsed long header packet (RFC 9000 section 17.2)
+type longHeader struct {
+	typ        packetType
+	version    uint32
+	dcid, scid []byte
+	pnOffset   int // start of the protected packet number
+	end        int // end of the packet; a datagram can coalesce several
+}
+
+// parseLong parses the long header packet at the start of b
+func parseLong(b []byte) (*longHeader, bool) {
+	if len(b) < 7 || b[0]&0x80 == 0 {
+		return nil, false
+	}
+	h := &longHeader{version: binary.BigEndian.Uint32(b[1:5]), end: len(b)}
+	r := b[5:]
+	var ok bool
+	if h.dcid, r, ok = cid(r); !ok {
+		return nil, false
+	}
+	if h.scid, r, ok = cid(r); !ok {
+		return nil, false
+	}
+	if h.version == 0 {
+		h.typ = typeVersionNegotiation
+		return h, true
+	}
+	if b[0]&0x40 == 0 || h.version != Version1 && h.version != Version2 {
+		// the fixed bit is required; other versions may frame packets differently
+		h.typ = typeUnknown
+		return h, true
+	}
+	bits := b[0] >> 4 & 3
+	if h.version == Version2 {
+		// RFC 9369 section 3.2 rotates the packet types
+		bits = (bits + 3) & 3
+	}
+	h.typ = packetType(bits)
+	if h.typ == typeRetry {
+		return h, true
+	}
+	if h.typ == typeInitial {
+		n, rest, ok := varint(r)
+		if !ok || uint64(len(rest)) < n {
+			return nil, false
+		}
+		r = rest[n:] // token
+	}
+	n, rest, ok := varint(r)
+	if !ok || uint64(len(rest)) < n {
+		return nil, false
+	}
+	h.pnOffset = len(b) - len(rest)
+	h.end = h.pnOffset + int(n)
+	return h, true
+}
+
+// cid reads a connection ID with its length prefix
+func cid(b []byte) (id, rest []byte, ok bool) {
+	if len(b) < 1 || len(b) < 1+int(b[0]) {
+		return nil, nil, false
+	}
+	return b[1 : 1+b[0]], b[1+b[0]:], true
+}
+
+// varint reads a variable-length integer (RFC 9000 section 16)
+func varint(b []byte) (v uint64, rest []byte, ok bool) {
+	if len(b) == 0 {
+		return 0, nil, false
+	}
+	n := 1 << (b[0] >> 6)
+	if len(b) < n {
+		return 0, nil, false
+	}
+	v = uint64(b[0] & 0x3f)
+	for _, c := range b[1:n] {
+		v = v<<8 | uint64(c)
+	}
+	return v, b[n:], true
+}
+
+// Initial salts and key labels per version (RFC 9001 section 5.2, RFC 9369
+// section 3.3)
+var (
+	saltV1 = []byte{0x38, 0x76, 0x2c, 0xf7, 0xf5, 0x59, 0x34, 0xb3, 0x4d, 0x17, 0x9a, 0xe6, 0xa4, 0xc8, 0x0c, 0xad, 0xcc, 0xbb, 0x7f, 0x0a}
+	saltV2 = []byte{0x0d, 0xed, 0xe3, 0xde, 0xf7, 0x00, 0xa6, 0xdb, 0x81, 0x93, 0x81, 0xbe, 0x6e, 0x26, 0x9d, 0xcb, 0xf9, 0xbd, 0x2e, 0xd9}
+)
+
+// keys protect the Initial packets of one direction
+type keys struct {
+	aead cipher.AEAD
+	iv   []byte
+	hp   cipher.Block
+}
+
+// initialKeys derives the Initial keys of the client, or of the server,
+// from the Destination Connection ID of the client's first Initial packet
+func initialKeys(version uint32, dcid []byte, server bool) (*keys, error) {
+	salt, prefix := saltV1, "quic "
+	if version == Version2 {
+		salt, prefix = saltV2, "quicv2 "
+	}
+	initial := hkdfExtract(salt, dcid)
+	label := "client in"
+	if server {
+		label = "server in"
+	}
+	secret := expandLabel(initial, label, 32)
+	block, err := aes.NewCipher(expandLabel(secret, prefix+"key", 16))
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	hp, err := aes.NewCipher(expandLabel(secret, prefix+"hp", 16))
+	if err != nil {
+		return nil, err
+	}
+	return &keys{aead: aead, iv: expandLabel(secret, prefix+"iv", 12), hp: hp}, nil
+}
+
+// hkdfExtract is HKDF-Extract with SHA-256 (RFC 5869)
+func hkdfExtract(salt, ikm []byte) []byte {
+	m := hmac.New(sha256.New, salt)
+	m.Write(ikm)
+	return m.Sum(nil)
+}
+
+// expandLabel is HKDF-Expand-Label with an empty context (RFC 8446
+// section 7.1)
+func expandLabel(secret []byte, label string, n int) []byte {
+	label = "tls13 " + label
+	info := binary.BigEndian.AppendUint16(nil, uint16(n))
+	info = append(info, byte(len(label)))
+	info = append(info, label...)
+	info = append(info, 0)
+	var out, t []byte
+	for i := byte(1); len(out) < n; i++ {
+		m := hmac.New(sha256.New, secret)
+		m.Write(t)
+		m.Write(info)
+		m.Write([]byte{i})
+		t = m.Sum(nil)
+		out = append(out, t...)
+	}
+	return out[:n]
+}
+
+// open removes header protection from the packet p and decrypts its
+// payload, leaving p unchanged
+func (k *keys) open(p []byte, h *longHeader) ([]byte, bool) {
+	pn := h.pnOffset
+	if pn+4+aes.BlockSize > h.end {
+		return nil, false
+	}
+	mask := make([]byte, aes.BlockSize)
+	k.hp.Encrypt(mask, p[pn+4:pn+4+aes.BlockSize])
+	header := append([]byte(nil), p[:pn+4]...)
+	header[0] ^= mask[0] & 0x0f
+	pnLen := int(header[0]&3) + 1
+	var num uint64
+	for i := 0; i < pnLen; i++ {
+		header[pn+i] ^= mask[1+i]
+		num = num<<8 | uint64(header[pn+i])
+	}
+	header = header[:pn+pnLen]
+	// Initial packet numbers are small enough for the truncated number to
+	// be the full one
+	nonce := append([]byte(nil), k.iv...)
+	for i := 0; i < 8; i++ {
+		nonce[len(nonce)-1-i] ^= byte(num >> (8 * i))
+	}
+	payload, err := k.aead.Open(nil, nonce, p[pn+pnLen:h.end], header)
+	return payload, err == nil
+}
+
+// Frame types allowed in Initial packets (RFC 9000 section 12.4)
+const (
+	framePadding         = 0x00
+	framePing            = 0x01
+	frameAck             = 0x02
+	frameAckECN          = 0x03
+	frameCrypto          = 0x06
+	frameConnectionClose = 0x1c
+)
+
+// cryptoFrame is a piece of the TLS handshake
+type cryptoFrame struct {
+	offset uint64
+	data   []byte
+}
+
+// frames holds what matters of the frames of an Initial packet
+type frames struct {
+	crypto []cryptoFrame
+	closed bool
+	code   uint64 // transport error code of a CONNECTION_CLOSE
+}
+
+// parseFrames parses the decrypted payload of an Initial packet
+func parseFrames(b []byte) (frames, bool) {
+	var f frames
+	for len(b) > 0 {
+		typ := b[0]
+		b = b[1:]
+		var ok bool
+		switch typ {
+		case framePadding, framePing:
+		case frameAck, frameAckECN:
+			// largest acknowledged, delay, range count, first range
+			var vs [4]uint64
+			for i := range vs {
+				if vs[i], b, ok = varint(b); !ok {
+					return f, false
+				}
+			}
+			skip := 2 * vs[2] // gap and length per range
+			if typ == frameAckECN {
+				skip += 3
+			}
+			for ; skip > 0; skip-- {
+				if _, b, ok = varint(b); !ok {
+					return f, false
+				}
+			}
+		case frameCrypto:
+			var off, n uint64
+			if off, b, ok = varint(b); !ok {
+				return f, false
+			}
+			if n, b, ok = varint(b); !ok || uint64(len(b)) < n {
+				return f, false
+			}
+			f.crypto = append(f.crypto, cryptoFrame{offset: off, data: b[:n]})
+			b = b[n:]
+		case frameConnectionClose:
+			var n uint64
+			if f.code, b, ok = varint(b); !ok {
+				return f, false
+			}
+			if _, b, ok = varint(b); !ok { // frame type
+				return f, false
+			}
+			if n, b, ok = varint(b); !ok || uint64(len(b)) < n {
+				return f, false
+			}
+			f.closed = true
+			b = b[n:] // reason phrase
+		default:
+			return f, false
+		}
+	}
+	return f, true
+}
+
+// maxCrypto bounds the handshake data buffered per connection; a
+// ClientHello with post-quantum key shares still fits in a few KiB
+const maxCrypto = 16 << 10
+
+// cryptoStream reassembles the CRYPTO frames of one direction, which
+// clients may send out of order and across several packets
+type cryptoStream struct {
+	frags []cryptoFrame
+	size  int
+}
+
+func (s *cryptoStream) add(f cryptoFrame) {
+	if f.offset+uint64(len(f.data)) > maxCrypto || s.size+len(f.data) > maxCrypto {
+		return
+	}
+	s.frags = append(s.frags, cryptoFrame{offset: f.offset, data: append([]byte(nil), f.data...)})
+	s.size += len(f.data)
+}
+
+// message returns the first handshake message once it is complete
+func (s *cryptoStream) message() ([]byte, bool) {
+	sort.Slice(s.frags, func(i, j int) bool { return s.frags[i].offset < s.frags[j].offset })
+	var buf []byte
+	for _, f := range s.frags {
+		if f.offset > uint64(len(buf)) {
+			break
+		}
+		if end := f.offset + uint64(len(f.data)); end > uint64(len(buf)) {
+			buf = append(buf, f.data[uint64(len(buf))-f.offset:]...)
+		}
+	}
+	if len(buf) < 4 {
+		return nil, false
+	}
+	n := 4 + (int(buf[1])<<16 | int(buf[2])<<8 | int(buf[3]))
+	if len(buf) < n {
+		return nil, false
+	}
+	return buf[:n], true
+}
@@ -0,0 +1,409 @@
+// Package quic follows QUIC connection attempts in UDP traffic. Initial
+// packets are protected with keys derived from the client's Destination
+// Connection ID, so the ClientHello they carry, with its SNI and ALPN, can
+// be read by anyone on the path.
+package quic
+
+import (
+	"bytes"
+	"fmt"
+	"net/netip"
+	"sort"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+
+	"network-app/pkg/core/analyzer"
+	"network-app/pkg/core/tcp"
+	"network-app/pkg/core/tls"
+)
+
+// Config holds the timeouts and table limits
+type Config struct {
+	IdleTimeout     time.Duration // attempts silent for this long are over
+	ResponseTimeout time.Duration // attempts younger than this at the end of the capture are pending
+	MaxConnections  int           // attempts tracked at once; new ones are dropped and counted once full
+	MaxServers      int           // server names tracked; attempts beyond count in the totals only
+}
+
+// DefaultConfig gives up on a handshake after 30 seconds of silence, the
+// usual idle timeout of QUIC stacks
+var DefaultConfig = Config{
+	IdleTimeout:     30 * time.Second,
+	ResponseTimeout: 3 * time.Second,
+	MaxConnections:  100000,
+	MaxServers:      10000,
+}
+
+// Outcome is how a connection attempt ended
+type Outcome int
+
+const (
+	OutcomeCompleted       Outcome = iota // the client sent 1-RTT packets
+	OutcomeFailed                         // closed with an error, or the server answered but the handshake never completed
+	OutcomeNoResponse                     // nothing came back from the server
+	OutcomeVersionMismatch                // the server only sent Version Negotiation
+	OutcomePending                        // still in progress when the capture ended
+)
+
+var transportErrors = []string{
+	"NO_ERROR", "INTERNAL_ERROR", "CONNECTION_REFUSED", "FLOW_CONTROL_ERROR",
+	"STREAM_LIMIT_ERROR", "STREAM_STATE_ERROR", "FINAL_SIZE_ERROR", "FRAME_ENCODING_ERROR",
+	"TRANSPORT_PARAMETER_ERROR", "CONNECTION_ID_LIMIT_ERROR", "PROTOCOL_VIOLATION", "INVALID_TOKEN",
+	"APPLICATION_ERROR", "CRYPTO_BUFFER_EXCEEDED", "KEY_UPDATE_ERROR", "AEAD_LIMIT_REACHED",
+	"NO_VIABLE_PATH",
+}
+
+// ErrorName returns the name of a transport error code. TLS alerts map to
+// CRYPTO_ERROR codes (RFC 9001 section 4.8).
+func ErrorName(code uint64) string {
+	if code >= 0x100 && code <= 0x1ff {
+		return "CRYPTO_ERROR (" + tls.Alert{Level: 2, Description: uint8(code)}.String() + ")"
+	}
+	if code < uint64(len(transportErrors)) {
+		return transportErrors[code]
+	}
+	return fmt.Sprintf("0x%x", code)
+}
+
+// Stats counts every connection attempt
+type Stats struct {
+	Connections     int // attempts, each starting with a client Initial packet
+	Completed       int
+	Failed          int
+	NoResponse      int
+	VersionMismatch int
+	Pending         int
+	Retries         int // Retry packets: the server validated the client address first
+	Evicted         int // attempts not tracked because the table was full
+	Versions        map[uint32]int
+	ALPN            map[string]int // offered by clients
+	Errors          map[string]int // CONNECTION_CLOSE seen in Initial packets, by ErrorName
+	HandshakeTime   tcp.Histogram  // first client Initial to first client 1-RTT packet
+}
+
+// Server aggregates the attempts towards one server name
+type Server struct {
+	Name       string // SNI, or the server address when the ClientHello was not seen
+	Address    netip.AddrPort
+	Attempts   int
+	Completed  int
+	Failed     int
+	NoResponse int
+	Version    uint32   // of the last attempt
+	ALPN       []string // offered in the last attempt
+	Errors     map[string]int
+}
+
+// Problems returns the attempts that did not complete
+func (s *Server) Problems() int {
+	return s.Failed + s.NoResponse
+}
+
+type flowKey struct {
+	a, b netip.AddrPort // the lower endpoint first
+}
+
+func keyOf(x, y netip.AddrPort) flowKey {
+	if x.Compare(y) > 0 {
+		x, y = y, x
+	}
+	return flowKey{x, y}
+}
+
+// conn is a connection attempt, from the client's first Initial packet
+type conn struct {
+	client, server netip.AddrPort
+	version        uint32
+	dcid           []byte // the client's Destination Connection ID, which the Initial keys derive from
+	start, last    time.Time
+	crypto         cryptoStream
+	hello          *tls.ClientHello
+	answered       bool // the server sent something other than Version Negotiation
+	negotiated     bool // the server sent Version Negotiation
+	closeError     string
+}
+
+// Analyzer follows QUIC connection attempts. Time is driven by packet
+// timestamps.
+type Analyzer struct {
+	cfg     Config
+	stats   Stats
+	conns   map[flowKey]*conn
+	servers map[string]*Server
+	clock   analyzer.Clock
+}
+
+// NewAnalyzer creates a QUIC analyzer
+func NewAnalyzer(cfg Config) *Analyzer {
+	return &Analyzer{
+		cfg: cfg,
+		stats: Stats{
+			Versions: make(map[uint32]int),
+			ALPN:     make(map[string]int),
+			Errors:   make(map[string]int),
+		},
+		conns:   make(map[flowKey]*conn),
+		servers: make(map[string]*Server),
+	}
+}
+
+// Process handles a single packet
+func (a *Analyzer) Process(pkt gopacket.Packet) {
+	udp, ok := pkt.Layer(layers.LayerTypeUDP).(*layers.UDP)
+	if !ok || len(udp.Payload) == 0 {
+		return
+	}
+	srcIP, dstIP, ok := analyzer.Addrs(pkt.NetworkLayer())
+	if !ok {
+		return
+	}
+	ts := pkt.Metadata().Timestamp
+	a.advance(ts)
+	src := netip.AddrPortFrom(srcIP, uint16(udp.SrcPort))
+	dst := netip.AddrPortFrom(dstIP, uint16(udp.DstPort))
+	k := keyOf(src, dst)
+	c := a.conns[k]
+	b := udp.Payload
+
+	if b[0]&0x80 == 0 {
+		// short header: 1-RTT keys are in use once the client sends one
+		if c != nil && src == c.client && b[0]&0x40 != 0 {
+			a.stats.HandshakeTime.Record(ts.Sub(c.start))
+			delete(a.conns, k)
+			a.finish(c, OutcomeCompleted)
+		}
+		return
+	}
+	if c == nil {
+		if c = a.attempt(src, dst, b, ts); c == nil {
+			return
+		}
+		a.conns[k] = c
+	}
+	c.last = ts
+	fromClient := src == c.client
+	for len(b) > 0 {
+		h, ok := parseLong(b)
+		if !ok {
+			return
+		}
+		a.packet(c, h, b[:h.end], fromClient)
+		b = b[h.end:]
+	}
+}
+
+// attempt starts following the connection whose first datagram is b, if it
+// holds a client Initial packet
+func (a *Analyzer) attempt(src, dst netip.AddrPort, b []byte, ts time.Time) *conn {
+	h, ok := parseLong(b)
+	// clients pad their first datagram to 1200 bytes and pick a Destination
+	// Connection ID of at least 8 bytes
+	if !ok || len(b) < 1200 || len(h.dcid) < 8 || len(h.dcid) > 20 {
+		return nil
+	}
+	switch h.typ {
+	case typeInitial:
+		k, err := initialKeys(h.version, h.dcid, false)
+		if err != nil {
+			return nil
+		}
+		if _, ok := k.open(b[:h.end], h); !ok {
+			return nil
+		}
+	case typeUnknown:
+		// a version the Initial keys are not known for; the server may
+		// still negotiate
+		if b[0]&0x40 == 0 {
+			return nil
+		}
+	default:
+		return nil
+	}
+	if len(a.conns) >= a.cfg.MaxConnections {
+		a.stats.Evicted++
+		return nil
+	}
+	return &conn{client: src, server: dst, version: h.version, start: ts}
+}
+
+// packet handles one long header packet p of a connection
+func (a *Analyzer) packet(c *conn, h *longHeader, p []byte, fromClient bool) {
+	if fromClient {
+		if h.typ == typeVersionNegotiation {
+			return
+		}
+		c.version = h.version
+		if h.typ != typeInitial {
+			return
+		}
+		if !bytes.Equal(h.dcid, c.dcid) {
+			// a new Destination Connection ID after Retry changes the keys
+			c.dcid = append(c.dcid[:0], h.dcid...)
+		}
+		if f, ok := a.open(c, h, p, false); ok {
+			if c.hello == nil {
+				for _, cf := range f.crypto {
+					c.crypto.add(cf)
+				}
+				if msg, ok := c.crypto.message(); ok {
+					c.hello, _ = tls.ParseClientHello(msg)
+					c.crypto = cryptoStream{}
+				}
+			}
+			a.closed(c, f)
+		}
+		return
+	}
+	switch h.typ {
+	case typeVersionNegotiation:
+		c.negotiated = true
+		return
+	case typeRetry:
+		a.stats.Retries++
+	case typeInitial:
+		if f, ok := a.open(c, h, p, true); ok {
+			a.closed(c, f)
+		}
+	}
+	c.answered = true
+}
+
+// open decrypts an Initial packet of c
+func (a *Analyzer) open(c *conn, h *longHeader, p []byte, server bool) (frames, bool) {
+	k, err := initialKeys(h.version, c.dcid, server)
+	if err != nil {
+		return frames{}, false
+	}
+	payload, ok := k.open(p, h)
+	if !ok {
+		return frames{}, false
+	}
+	return parseFrames(payload)
+}
+
+// closed notes a CONNECTION_CLOSE among the frames f
+func (a *Analyzer) closed(c *conn, f frames) {
+	if f.closed && c.closeError == "" {
+		c.closeError = ErrorName(f.code)
+	}
+}
+
+// advance moves the clock and, about once per second of capture time,
+// gives up on idle attempts
+func (a *Analyzer) advance(ts time.Time) {
+	if !a.clock.Advance(ts) {
+		return
+	}
+	for k, c := range a.conns {
+		if a.clock.Now().Sub(c.last) >= a.cfg.IdleTimeout {
+			delete(a.conns, k)
+			a.finish(c, outcome(c))
+		}
+	}
+}
+
+// outcome classifies an attempt that did not complete
+func outcome(c *conn) Outcome {
+	switch {
+	case c.closeError != "" || c.answered:
+		return OutcomeFailed
+	case c.negotiated:
+		return OutcomeVersionMismatch
+	}
+	return OutcomeNoResponse
+}
+
+// finish folds an attempt into the statistics
+func (a *Analyzer) finish(c *conn, o Outcome) {
+	st := &a.stats
+	st.Connections++
+	st.Versions[c.version]++
+	if c.closeError != "" {
+		st.Errors[c.closeError]++
+	}
+	switch o {
+	case OutcomeCompleted:
+		st.Completed++
+	case OutcomeFailed:
+		st.Failed++
+	case OutcomeNoResponse:
+		st.NoResponse++
+	case OutcomeVersionMismatch:
+		st.VersionMismatch++
+	case OutcomePending:
+		st.Pending++
+	}
+	name := c.server.String()
+	if c.hello != nil {
+		for _, p := range c.hello.ALPN {
+			st.ALPN[p]++
+		}
+		if c.hello.SNI != "" {
+			name = c.hello.SNI
+		}
+	}
+
+	s := a.servers[name]
+	if s == nil {
+		if len(a.servers) >= a.cfg.MaxServers {
+			return
+		}
+		s = &Server{Name: name, Errors: make(map[string]int)}
+		a.servers[name] = s
+	}
+	s.Address = c.server
+	s.Attempts++
+	s.Version = c.version
+	if c.hello != nil {
+		s.ALPN = c.hello.ALPN
+	}
+	if c.closeError != "" {
+		s.Errors[c.closeError]++
+	}
+	switch o {
+	case OutcomeCompleted:
+		s.Completed++
+	case OutcomeFailed, OutcomeVersionMismatch:
+		s.Failed++
+	case OutcomeNoResponse:
+		s.NoResponse++
+	}
+}
+
+// Finish classifies the attempts still open
+func (a *Analyzer) Finish() {
+	for k, c := range a.conns {
+		delete(a.conns, k)
+		o := outcome(c)
+		if c.closeError == "" && a.clock.Now().Sub(c.start) < a.cfg.ResponseTimeout {
+			o = OutcomePending
+		}
+		a.finish(c, o)
+	}
+}
+
+// Stats returns the totals over all attempts. Only valid after Finish.
+func (a *Analyzer) Stats() Stats {
+	return a.stats
+}
+
+// Servers returns the per-name statistics, most problems first, then the
+// busiest
+func (a *Analyzer) Servers() []*Server {
+	out := make([]*Server, 0, len(a.servers))
+	for _, s := range a.servers {
+		out = append(out, s)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Problems() != out[j].Problems() {
+			return out[i].Problems() > out[j].Problems()
+		}
+		if out[i].Attempts != out[j].Attempts {
+			return out[i].Attempts > out[j].Attempts
+		}
+		return out[i].Name < out[j].Name
+	})
+	return out
+}
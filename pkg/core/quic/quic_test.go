@@ -0,0 +1,250 @@
+package quic
+
+import (
+	cryptotls "crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+
+	"network-app/pkg/core/internal/packettest"
+)
+
+func unhex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// TestInitialKeys checks the key derivation against RFC 9001 appendix A.1
+// and RFC 9369 appendix A.1
+func TestInitialKeys(t *testing.T) {
+	dcid := unhex(t, "8394c8f03e515708")
+	for _, tc := range []struct {
+		version uint32
+		server  bool
+		key, iv string
+	}{
+		{Version1, false, "1f369613dd76d5467730efcbe3b1a22d", "fa044b2f42a3fd3b46fb255c"},
+		{Version1, true, "cf3a5331653c364c88f0f379b6067e37", "0ac1493ca1905853b0bba03e"},
+		{Version2, false, "8b1a0bc121284290a29e0971b5cd045d", "91f73e2351d8fa91660e909f"},
+	} {
+		salt, prefix, label := saltV1, "quic ", "client in"
+		if tc.version == Version2 {
+			salt, prefix = saltV2, "quicv2 "
+		}
+		if tc.server {
+			label = "server in"
+		}
+		secret := expandLabel(hkdfExtract(salt, dcid), label, 32)
+		if got := hex.EncodeToString(expandLabel(secret, prefix+"key", 16)); got != tc.key {
+			t.Errorf("%s server=%v key = %s", VersionName(tc.version), tc.server, got)
+		}
+		if got := hex.EncodeToString(expandLabel(secret, prefix+"iv", 12)); got != tc.iv {
+			t.Errorf("%s server=%v iv = %s", VersionName(tc.version), tc.server, got)
+		}
+	}
+
+	// header protection mask of the client Initial in RFC 9001 appendix A.2
+	k, err := initialKeys(Version1, dcid, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mask := make([]byte, 16)
+	k.hp.Encrypt(mask, unhex(t, "d1b1c98dd7689fb8ec11d242b123dc9b"))
+	if got := hex.EncodeToString(mask[:5]); got != "437b9aec36" {
+		t.Errorf("mask = %s", got)
+	}
+}
+
+// clientHello returns the ClientHello handshake message crypto/tls sends
+// for serverName
+func clientHello(t *testing.T, serverName string, alpn ...string) []byte {
+	t.Helper()
+	c, s := net.Pipe()
+	defer c.Close()
+	defer s.Close()
+	go cryptotls.Client(c, &cryptotls.Config{ServerName: serverName, NextProtos: alpn, MinVersion: cryptotls.VersionTLS13}).Handshake()
+	hdr := make([]byte, 5)
+	if _, err := io.ReadFull(s, hdr); err != nil {
+		t.Fatal(err)
+	}
+	msg := make([]byte, binary.BigEndian.Uint16(hdr[3:]))
+	if _, err := io.ReadFull(s, msg); err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+func cryptoFrameBytes(off int, data []byte) []byte {
+	b := []byte{frameCrypto}
+	b = binary.BigEndian.AppendUint32(b, 0x80000000|uint32(off))
+	b = binary.BigEndian.AppendUint32(b, 0x80000000|uint32(len(data)))
+	return append(b, data...)
+}
+
+// seal builds a protected long header packet of typ carrying frames, padded
+// to pad bytes
+func seal(t *testing.T, version uint32, typ packetType, dcid, scid, keyDCID []byte, server bool, pn uint16, frames []byte, pad int) []byte {
+	t.Helper()
+	bits := byte(typ)
+	if version == Version2 {
+		bits = (bits + 1) & 3
+	}
+	hdr := []byte{0xc0 | bits<<4 | 1}
+	hdr = binary.BigEndian.AppendUint32(hdr, version)
+	hdr = append(append(hdr, byte(len(dcid))), dcid...)
+	hdr = append(append(hdr, byte(len(scid))), scid...)
+	if typ == typeInitial {
+		hdr = append(hdr, 0) // no token
+	}
+	// the header so far, a 2-byte length, a 2-byte packet number and the tag
+	if n := pad - len(hdr) - 2 - 2 - 16 - len(frames); n > 0 {
+		frames = append(frames, make([]byte, n)...)
+	}
+	hdr = binary.BigEndian.AppendUint16(hdr, 0x4000|uint16(2+len(frames)+16))
+	pnOffset := len(hdr)
+	hdr = binary.BigEndian.AppendUint16(hdr, pn)
+	k, err := initialKeys(version, keyDCID, server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce := append([]byte(nil), k.iv...)
+	nonce[10] ^= byte(pn >> 8)
+	nonce[11] ^= byte(pn)
+	p := k.aead.Seal(hdr, nonce, frames, hdr)
+	mask := make([]byte, 16)
+	k.hp.Encrypt(mask, p[pnOffset+4:pnOffset+20])
+	p[0] ^= mask[0] & 0x0f
+	p[pnOffset] ^= mask[1]
+	p[pnOffset+1] ^= mask[2]
+	return p
+}
+
+// opaque builds an unprotected long header packet standing in for one the
+// analyzer cannot decrypt
+func opaque(version uint32, typ packetType, dcid, scid []byte, size int) []byte {
+	bits := byte(typ)
+	if version == Version2 {
+		bits = (bits + 1) & 3
+	}
+	p := []byte{0xc0 | bits<<4 | 1}
+	p = binary.BigEndian.AppendUint32(p, version)
+	p = append(append(p, byte(len(dcid))), dcid...)
+	p = append(append(p, byte(len(scid))), scid...)
+	p = binary.BigEndian.AppendUint16(p, 0x4000|uint16(size))
+	return append(p, make([]byte, size)...)
+}
+
+func datagram(t *testing.T, src, dst string, at time.Duration, payload []byte) gopacket.Packet {
+	t.Helper()
+	return packettest.UDP(t, netip.MustParseAddrPort(src), netip.MustParseAddrPort(dst), at, payload)
+}
+
+func TestConnections(t *testing.T) {
+	ms := time.Millisecond
+	const server = "203.0.113.5:443"
+	dcid := unhex(t, "0011223344556677")
+	scid := unhex(t, "a1a2a3a4")
+	sid := unhex(t, "b1b2b3b4b5b6b7b8")
+	short := append([]byte{0x41}, make([]byte, 40)...)
+	ack := []byte{frameAck, 0, 0, 0, 0}
+
+	// completed: the ClientHello split across two packets, out of order
+	hello := clientHello(t, "quic.example", "h3")
+	half := len(hello) / 2
+	completed := []gopacket.Packet{
+		datagram(t, "192.168.1.10:50000", server, 0, seal(t, Version1, typeInitial, dcid, scid, dcid, false, 0, cryptoFrameBytes(half, hello[half:]), 1200)),
+		datagram(t, "192.168.1.10:50000", server, ms, seal(t, Version1, typeInitial, dcid, scid, dcid, false, 1, cryptoFrameBytes(0, hello[:half]), 1200)),
+		datagram(t, server, "192.168.1.10:50000", 30*ms, append(
+			seal(t, Version1, typeInitial, scid, sid, dcid, true, 0, append(ack, cryptoFrameBytes(0, []byte{2, 0, 0, 0})...), 0),
+			opaque(Version1, typeHandshake, scid, sid, 500)...)),
+		datagram(t, "192.168.1.10:50000", server, 60*ms, short),
+	}
+
+	// QUIC v2, refused by the server for lack of a common application protocol
+	refused := []gopacket.Packet{
+		datagram(t, "192.168.1.11:50001", server, 2*time.Second, seal(t, Version2, typeInitial, dcid, scid, dcid, false, 0, cryptoFrameBytes(0, clientHello(t, "v2.example", "h3")), 1200)),
+		datagram(t, server, "192.168.1.11:50001", 2*time.Second+30*ms, seal(t, Version2, typeInitial, scid, sid, dcid, true, 0,
+			[]byte{frameConnectionClose, 0x41, 0x78, 0x06, 0}, 0)),
+	}
+
+	// blocked: never answered
+	blocked := datagram(t, "192.168.1.12:50002", "198.51.100.9:443", 3*time.Second,
+		seal(t, Version1, typeInitial, dcid, scid, dcid, false, 0, cryptoFrameBytes(0, clientHello(t, "blocked.example")), 1200))
+
+	// an unknown version, answered with Version Negotiation
+	unknown := opaque(0x1a2a3a4a, typeInitial, dcid, scid, 1200)
+	vn := append([]byte{0x80}, 0, 0, 0, 0)
+	vn = append(append(vn, byte(len(scid))), scid...)
+	vn = append(append(vn, byte(len(dcid))), dcid...)
+	vn = binary.BigEndian.AppendUint32(vn, Version1)
+	negotiated := []gopacket.Packet{
+		datagram(t, "192.168.1.13:50003", server, 4*time.Second, unknown),
+		datagram(t, server, "192.168.1.13:50003", 4*time.Second+20*ms, vn),
+	}
+
+	// Retry, then a new Destination Connection ID and new keys
+	retryDCID := unhex(t, "c1c2c3c4c5c6c7c8c9")
+	retry := opaque(Version1, typeRetry, scid, retryDCID, 0)
+	retried := []gopacket.Packet{
+		datagram(t, "192.168.1.14:50004", server, 5*time.Second, seal(t, Version1, typeInitial, dcid, scid, dcid, false, 0, cryptoFrameBytes(0, clientHello(t, "retry.example", "h3")), 1200)),
+		datagram(t, server, "192.168.1.14:50004", 5*time.Second+20*ms, retry),
+		datagram(t, "192.168.1.14:50004", server, 5*time.Second+21*ms, seal(t, Version1, typeInitial, retryDCID, scid, retryDCID, false, 1, cryptoFrameBytes(0, clientHello(t, "retry.example", "h3")), 1200)),
+		datagram(t, server, "192.168.1.14:50004", 5*time.Second+40*ms, seal(t, Version1, typeInitial, scid, sid, retryDCID, true, 0, ack, 0)),
+		datagram(t, "192.168.1.14:50004", server, 5*time.Second+60*ms, short),
+	}
+
+	// not QUIC at all, and an attempt just before the end of the capture that
+	// shares the unknown version's server address
+	noise := datagram(t, "192.168.1.15:40000", server, 6*time.Second, append([]byte{0xc3, 0, 0, 0, 1, 8}, make([]byte, 1300)...))
+	late := datagram(t, "192.168.1.16:50005", server, 10*time.Second, seal(t, Version1, typeInitial, dcid, scid, dcid, false, 0, nil, 1200))
+
+	a := NewAnalyzer(DefaultConfig)
+	var all []gopacket.Packet
+	all = append(append(append(all, completed...), refused...), blocked)
+	all = append(append(all, negotiated...), retried...)
+	for _, p := range append(all, noise, late) {
+		a.Process(p)
+	}
+	a.Finish()
+
+	st := a.Stats()
+	if st.Connections != 6 || st.Completed != 2 || st.Failed != 1 || st.NoResponse != 1 || st.VersionMismatch != 1 || st.Pending != 1 {
+		t.Errorf("outcomes = %+v", st)
+	}
+	if st.Retries != 1 || st.Versions[Version1] != 4 || st.Versions[Version2] != 1 || st.Versions[0x1a2a3a4a] != 1 || st.ALPN["h3"] != 3 {
+		t.Errorf("stats = %+v", st)
+	}
+	if st.Errors["CRYPTO_ERROR (fatal no_application_protocol)"] != 1 || st.HandshakeTime.Count() != 2 || st.HandshakeTime.Max() != 60*ms {
+		t.Errorf("errors %v, handshake time %v", st.Errors, st.HandshakeTime.Max())
+	}
+
+	servers := make(map[string]*Server)
+	for _, s := range a.Servers() {
+		servers[s.Name] = s
+	}
+	if s := servers["quic.example"]; s == nil || s.Completed != 1 || s.Address.String() != server || len(s.ALPN) != 1 {
+		t.Errorf("quic.example = %+v", s)
+	}
+	if s := servers["v2.example"]; s == nil || s.Failed != 1 || s.Version != Version2 {
+		t.Errorf("v2.example = %+v", s)
+	}
+	if s := servers["blocked.example"]; s == nil || s.NoResponse != 1 {
+		t.Errorf("blocked.example = %+v", s)
+	}
+	if s := servers["retry.example"]; s == nil || s.Completed != 1 {
+		t.Errorf("retry.example = %+v", s)
+	}
+	if s := servers[server]; s == nil || s.Attempts != 2 || s.Failed != 1 {
+		t.Errorf("%s = %+v", server, s)
+	}
+}
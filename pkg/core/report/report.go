@@ -11,15 +11,25 @@ import (
 
 // DiagnosticResult aggregates all diagnostic data
 type DiagnosticResult struct {
-	Timestamp    time.Time `json:"timestamp"`
-	Interfaces   []string  `json:"interfaces"`
-	DurationSecs int       `json:"duration_seconds"`
-	TCPStats     struct {
-		SynSent     int     `json:"syn_sent"`
-		SynAckRcvd  int     `json:"syn_ack_received"`
-		RstRcvd     int     `json:"rst_received"`
-		SynAckRatio float64 `json:"syn_ack_ratio_percent"`
-	} `json:"tcp_handshake"`
+	Timestamp         time.Time     `json:"timestamp"`
+	Interfaces        []string      `json:"interfaces"`
+	DurationSecs      int           `json:"duration_seconds"`
+	TCPStats          TCPHandshake  `json:"tcp_handshake"`
+	Latency           Latency       `json:"handshake_latency"`
+	DNS               DNS           `json:"dns"`
+	TLS               TLS           `json:"tls"`
+	QUIC              QUIC          `json:"quic"`
+	HTTP              HTTP          `json:"http"`
+	TCPStream         TCPStream     `json:"tcp_stream"`
+	Reassembly        Reassembly    `json:"tcp_reassembly"`
+	TCPOptions        TCPOptions    `json:"tcp_options"`
+	Teardown          Teardown      `json:"teardown"`
+	Middlebox         []Middlebox   `json:"middlebox_interference,omitempty"`
+	ICMP              ICMP          `json:"icmp"`
+	Fragmentation     Fragmentation `json:"fragmentation"`
+	UDP               UDP           `json:"udp"`
+	Neighbor          Neighbor      `json:"neighbor"`
+	DHCP              DHCP          `json:"dhcp"`
 	ConntrackCounters struct {
 		Total       int `json:"total"`
 		Established int `json:"established"`
@@ -27,9 +37,590 @@ type DiagnosticResult struct {
 		Unreplied   int `json:"unreplied"`
 		Other       int `json:"other"`
 	} `json:"conntrack"`
-	PacketsCaptured int `json:"packets_captured"`
-	Summary         string `json:"summary"`
-	Recommendation  string `json:"recommendation"`
+	PacketsCaptured int              `json:"packets_captured"`
+	CaptureStats    CaptureStats     `json:"capture_stats"`
+	PerInterface    []InterfaceStats `json:"per_interface,omitempty"`
+	PerSegment      []SegmentStats   `json:"per_segment,omitempty"`
+	CaptureFiles    []string         `json:"capture_files,omitempty"`
+	Warnings        []string         `json:"warnings,omitempty"`
+	Findings        []Finding        `json:"findings,omitempty"`
+	Summary         string           `json:"summary"`
+	Recommendation  string           `json:"recommendation"`
+}
+
+// Finding is a SYN flood or port scan flagged by the detector
+type Finding struct {
+	Kind       string     `json:"kind"`
+	Start      time.Time  `json:"start"`
+	End        time.Time  `json:"end"`
+	Target     string     `json:"target"`
+	Source     string     `json:"source,omitempty"`
+	Probes     int        `json:"probes"`
+	HalfOpen   int        `json:"half_open,omitempty"`
+	Sources    int        `json:"sources,omitempty"`
+	Spoofed    bool       `json:"spoofed_sources,omitempty"`
+	Distinct   int        `json:"distinct_targets,omitempty"`
+	Resets     int        `json:"resets"`
+	TopSources []Offender `json:"top_sources,omitempty"`
+	Detail     string     `json:"detail"`
+}
+
+// Offender is a source address and the number of probes it sent
+type Offender struct {
+	Addr  string `json:"addr"`
+	Count int    `json:"count"`
+}
+
+// CaptureStats holds receive and drop counters of the capture path
+type CaptureStats struct {
+	Received         int     `json:"received"`
+	KernelDropped    int     `json:"dropped_by_kernel"`
+	InterfaceDropped int     `json:"dropped_by_interface"`
+	ChannelDropped   int     `json:"dropped_by_backpressure"`
+	DropRate         float64 `json:"drop_rate_percent"`
+}
+
+// TCPHandshake holds per-connection handshake outcomes
+type TCPHandshake struct {
+	SynSent           int     `json:"syn_sent"`
+	SynAckRcvd        int     `json:"syn_ack_received"`
+	RstRcvd           int     `json:"rst_received"`
+	SynAckRatio       float64 `json:"syn_ack_ratio_percent"`
+	Completed         int     `json:"completed"`
+	NoAck             int     `json:"syn_ack_not_acked"`
+	Unanswered        int     `json:"unanswered"`
+	Reset             int     `json:"reset_during_handshake"`
+	Pending           int     `json:"pending"`
+	Evicted           int     `json:"evicted_during_handshake"`
+	SynRetransmits    int     `json:"syn_retransmits"`
+	SynAckRetransmits int     `json:"syn_ack_retransmits"`
+}
+
+// DNS holds DNS transaction counters in total and per resolver
+type DNS struct {
+	DNSCounters
+	PerResolver []DNSResolver `json:"per_resolver,omitempty"`
+}
+
+// DNSCounters counts queries, responses and errors. Error rates are
+// percentages of the answered queries.
+type DNSCounters struct {
+	Queries        int            `json:"queries"`
+	Responses      int            `json:"responses"`
+	Unanswered     int            `json:"unanswered"`
+	UnansweredRate float64        `json:"unanswered_rate_percent"`
+	Pending        int            `json:"pending"`
+	Unmatched      int            `json:"unmatched_responses"`
+	NXDomain       int            `json:"nxdomain"`
+	NXDomainRate   float64        `json:"nxdomain_rate_percent"`
+	ServFail       int            `json:"servfail"`
+	ServFailRate   float64        `json:"servfail_rate_percent"`
+	Refused        int            `json:"refused"`
+	RefusedRate    float64        `json:"refused_rate_percent"`
+	OtherErrors    int            `json:"other_errors"`
+	Truncated      int            `json:"truncated"`
+	Latency        LatencySummary `json:"latency"`
+}
+
+// DNSResolver holds the DNS counters of one resolver and the names most
+// often asked of it
+type DNSResolver struct {
+	Resolver string `json:"resolver"`
+	DNSCounters
+	TopNames []NameCount `json:"top_names,omitempty"`
+}
+
+// NameCount is a queried name and how often it was asked for
+type NameCount struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// TLS holds TLS handshake outcomes, the negotiated parameters, the server
+// names contacted and the client fingerprints
+type TLS struct {
+	Handshakes      int              `json:"handshakes"`
+	Failed          int              `json:"failed"`
+	Pending         int              `json:"pending"`
+	Expired         int              `json:"expired_certificates"`
+	OfferedVersions []NameCount      `json:"offered_versions,omitempty"`
+	Versions        []NameCount      `json:"versions,omitempty"`
+	CipherSuites    []NameCount      `json:"cipher_suites,omitempty"`
+	ALPN            []NameCount      `json:"alpn,omitempty"`
+	Alerts          []NameCount      `json:"alerts,omitempty"`
+	Servers         []TLSServer      `json:"servers,omitempty"`
+	Fingerprints    []TLSFingerprint `json:"fingerprints,omitempty"`
+}
+
+// TLSServer holds the handshakes towards one server name (SNI), or server
+// address when the client sent none
+type TLSServer struct {
+	Name         string           `json:"name"`
+	Handshakes   int              `json:"handshakes"`
+	Failed       int              `json:"failed"`
+	Failures     []NameCount      `json:"failures,omitempty"`
+	Version      string           `json:"version,omitempty"`
+	CipherSuite  string           `json:"cipher_suite,omitempty"`
+	Certificates []TLSCertificate `json:"certificates,omitempty"`
+	Expired      bool             `json:"certificate_expired,omitempty"`
+}
+
+// TLSCertificate is one certificate of a chain sent in the clear, leaf
+// first
+type TLSCertificate struct {
+	Subject   string    `json:"subject"`
+	Issuer    string    `json:"issuer"`
+	NotBefore time.Time `json:"not_before"`
+	NotAfter  time.Time `json:"not_after"`
+}
+
+// TLSFingerprint is a client fingerprint and how many handshakes carried it
+type TLSFingerprint struct {
+	JA4   string `json:"ja4"`
+	JA3   string `json:"ja3"`
+	Count int    `json:"count"`
+	SNI   string `json:"example_sni,omitempty"`
+}
+
+// QUIC holds QUIC connection attempts and their outcomes, the versions
+// and application protocols offered, and the server names contacted
+type QUIC struct {
+	Connections     int            `json:"connections"`
+	Completed       int            `json:"completed"`
+	Failed          int            `json:"failed"`
+	NoResponse      int            `json:"no_response"`
+	VersionMismatch int            `json:"version_mismatch"`
+	Pending         int            `json:"pending"`
+	Retries         int            `json:"retries"`
+	Evicted         int            `json:"evicted"`
+	Versions        []NameCount    `json:"versions,omitempty"`
+	ALPN            []NameCount    `json:"alpn,omitempty"`
+	Errors          []NameCount    `json:"errors,omitempty"`
+	HandshakeTime   LatencySummary `json:"handshake_time"`
+	Servers         []QUICServer   `json:"servers,omitempty"`
+}
+
+// QUICServer holds the attempts towards one server name (SNI), or server
+// address when the ClientHello was not seen
+type QUICServer struct {
+	Name       string      `json:"name"`
+	Address    string      `json:"address"`
+	Attempts   int         `json:"attempts"`
+	Completed  int         `json:"completed"`
+	Failed     int         `json:"failed"`
+	NoResponse int         `json:"no_response"`
+	Version    string      `json:"version"`
+	ALPN       []string    `json:"alpn,omitempty"`
+	Errors     []NameCount `json:"errors,omitempty"`
+}
+
+// HTTP holds HTTP/1.x transactions, status codes and keep-alive reuse in
+// total and per host and path
+type HTTP struct {
+	HTTPCounters
+	Connections              int            `json:"connections"`
+	ReusedConnections        int            `json:"reused_connections"`
+	ReuseRate                float64        `json:"reuse_rate_percent"`
+	RequestsPerConnection    float64        `json:"requests_per_connection"`
+	MaxRequestsPerConnection int            `json:"max_requests_per_connection"`
+	Unanswered               int            `json:"unanswered"`
+	Pending                  int            `json:"pending"`
+	Upgrades                 int            `json:"upgrades"`
+	Lost                     int            `json:"lost_connections"`
+	StatusCodes              []StatusCount  `json:"status_codes,omitempty"`
+	Endpoints                []HTTPEndpoint `json:"endpoints,omitempty"`
+}
+
+// HTTPCounters counts requests, responses and errors and summarizes the
+// time to first byte and the total response time
+type HTTPCounters struct {
+	Requests     int            `json:"requests"`
+	Responses    int            `json:"responses"`
+	ClientErrors int            `json:"client_errors"`
+	ServerErrors int            `json:"server_errors"`
+	TTFB         LatencySummary `json:"time_to_first_byte"`
+	ResponseTime LatencySummary `json:"response_time"`
+}
+
+// HTTPEndpoint holds the HTTP counters of one host and path
+type HTTPEndpoint struct {
+	Host string `json:"host"`
+	Path string `json:"path"`
+	HTTPCounters
+}
+
+// StatusCount is an HTTP status code and how often it was returned
+type StatusCount struct {
+	Code  int `json:"code"`
+	Count int `json:"count"`
+}
+
+// TCPStream holds data phase counters over all flows
+type TCPStream struct {
+	StreamCounters
+	Flows        []FlowStream `json:"flows,omitempty"`
+	StalledFlows []FlowStream `json:"stalled_flows,omitempty"`
+}
+
+// StreamCounters counts retransmissions and related events. Retransmissions
+// includes the fast and spurious ones.
+type StreamCounters struct {
+	Segments                int     `json:"segments"`
+	DataSegments            int     `json:"data_segments"`
+	Bytes                   uint64  `json:"payload_bytes"`
+	Retransmissions         int     `json:"retransmissions"`
+	FastRetransmissions     int     `json:"fast_retransmissions"`
+	SpuriousRetransmissions int     `json:"spurious_retransmissions"`
+	DuplicateAcks           int     `json:"duplicate_acks"`
+	OutOfOrder              int     `json:"out_of_order"`
+	LostSegments            int     `json:"lost_segments"`
+	RetransmissionRate      float64 `json:"retransmission_rate_percent"`
+	ZeroWindows             int     `json:"zero_windows"`
+	ZeroWindowProbes        int     `json:"zero_window_probes"`
+	WindowFull              int     `json:"window_full"`
+	WindowUpdates           int     `json:"window_reopened"`
+	StallMs                 float64 `json:"stall_ms"`
+}
+
+// FlowStream holds the data phase counters of one flow
+type FlowStream struct {
+	Client string `json:"client"`
+	Server string `json:"server"`
+	StreamCounters
+}
+
+// Reassembly holds the counters of TCP stream reassembly
+type Reassembly struct {
+	Streams      int    `json:"streams"`
+	Bytes        uint64 `json:"bytes"`
+	Gaps         int    `json:"gaps"`
+	MissingBytes uint64 `json:"missing_bytes"`
+	OverlapBytes uint64 `json:"overlap_bytes"`
+	DumpDir      string `json:"dump_dir,omitempty"`
+	DumpFiles    int    `json:"dump_files,omitempty"`
+}
+
+// Teardown holds how connections ended, in total and per server
+type Teardown struct {
+	Aborted   int                `json:"aborted"` // reset by either side after the handshake
+	Endings   []ConnectionEnding `json:"endings,omitempty"`
+	PerServer []ServerTeardown   `json:"per_server,omitempty"`
+}
+
+// ConnectionEnding counts connections that ended one way and their durations
+type ConnectionEnding struct {
+	Ending string  `json:"ending"`
+	Count  int     `json:"count"`
+	P50Ms  float64 `json:"duration_p50_ms"`
+	P99Ms  float64 `json:"duration_p99_ms"`
+	MaxMs  float64 `json:"duration_max_ms"`
+}
+
+// ServerTeardown counts connection endings towards one server and the
+// durations of its connections, whatever their ending
+type ServerTeardown struct {
+	Server      string  `json:"server"`
+	Graceful    int     `json:"graceful"`
+	HalfClosed  int     `json:"half_closed"`
+	Refused     int     `json:"refused"`
+	ClientReset int     `json:"reset_by_client"`
+	ServerReset int     `json:"reset_by_server"`
+	Idle        int     `json:"idle"`
+	Open        int     `json:"open"`
+	P50Ms       float64 `json:"duration_p50_ms"`
+	P99Ms       float64 `json:"duration_p99_ms"`
+}
+
+// TCPOptions summarizes the options negotiated in answered handshakes
+type TCPOptions struct {
+	Handshakes  int         `json:"handshakes"`
+	WindowScale OptionUsage `json:"window_scale"`
+	SACK        OptionUsage `json:"sack_permitted"`
+	Timestamps  OptionUsage `json:"timestamps"`
+	ECN         OptionUsage `json:"ecn"`
+	ClientMSS   []MSSCount  `json:"client_mss,omitempty"`
+	ServerMSS   []MSSCount  `json:"server_mss,omitempty"`
+}
+
+// OptionUsage counts SYNs offering an option and SYN-ACKs accepting it
+type OptionUsage struct {
+	Offered  int `json:"offered"`
+	Accepted int `json:"accepted"`
+}
+
+// MSSCount is the number of handshakes that advertised an MSS value
+type MSSCount struct {
+	MSS   uint16 `json:"mss"`
+	Count int    `json:"count"`
+}
+
+// Middlebox is a suspicious SYN/SYN-ACK option mismatch towards one server
+type Middlebox struct {
+	Server    string `json:"server"`
+	Anomaly   string `json:"anomaly"`
+	Count     int    `json:"count"`
+	ClientMSS uint16 `json:"client_mss"`
+	ServerMSS uint16 `json:"server_mss"`
+}
+
+// ICMP holds ICMP and ICMPv6 error messages per destination network and
+// the TCP flows suspected of a path MTU black hole
+type ICMP struct {
+	Total      int           `json:"total"`
+	Unparsed   int           `json:"unparsed"`
+	Messages   []NameCount   `json:"messages,omitempty"`
+	Networks   []ICMPNetwork `json:"networks,omitempty"`
+	BlackHoles []BlackHole   `json:"pmtu_black_holes,omitempty"`
+}
+
+// ICMPNetwork holds the ICMP errors about one destination network
+type ICMPNetwork struct {
+	Network    string      `json:"network"`
+	Total      int         `json:"total"`
+	Messages   []NameCount `json:"messages"`
+	Flows      int         `json:"flows"`
+	Example    string      `json:"example_flow,omitempty"`
+	Reporters  []string    `json:"reporters,omitempty"`
+	MinMTU     int         `json:"min_mtu,omitempty"`
+	BlackHoles int         `json:"pmtu_black_holes"`
+}
+
+// BlackHole is a TCP flow whose large segments were retransmitted without
+// acknowledgement or ICMP feedback
+type BlackHole struct {
+	Sender      string    `json:"sender"`
+	Receiver    string    `json:"receiver"`
+	Size        int       `json:"segment_size"`
+	Retransmits int       `json:"retransmits"`
+	Start       time.Time `json:"start"`
+	Recovered   bool      `json:"recovered"`
+}
+
+// Fragmentation holds the IP fragments seen, how their reassembly went, and
+// the largest packets per path
+type Fragmentation struct {
+	MTU           int       `json:"mtu"`
+	Packets       uint64    `json:"packets"`
+	MaxSize       int       `json:"max_packet_size"`
+	Fragments     int       `json:"fragments"`
+	Reassembled   int       `json:"reassembled"`
+	MaxDatagram   int       `json:"max_datagram_size,omitempty"`
+	Incomplete    int       `json:"incomplete"`
+	Pending       int       `json:"pending"`
+	Overlaps      int       `json:"overlaps"`
+	Duplicates    int       `json:"duplicates"`
+	Unreassembled int       `json:"unreassembled"`
+	DontFragment  uint64    `json:"dont_fragment"`
+	Oversized     int       `json:"oversized"`
+	MaxOversized  int       `json:"max_oversized,omitempty"`
+	Paths         []MTUPath `json:"paths,omitempty"`
+}
+
+// MTUPath holds the packet sizes seen from one address to another
+type MTUPath struct {
+	Src        string `json:"src"`
+	Dst        string `json:"dst"`
+	Packets    uint64 `json:"packets"`
+	MaxSize    int    `json:"max_packet_size"`
+	MaxDF      int    `json:"max_dont_fragment_size,omitempty"`
+	Fragmented int    `json:"fragmented"`
+	Fragments  int    `json:"fragments"`
+	Oversized  int    `json:"oversized"`
+}
+
+// UDP holds the UDP flows, the one-way flows among them and what the
+// conntrack table said about them
+type UDP struct {
+	Flows              int              `json:"flows"`
+	OneWay             int              `json:"one_way"`
+	OneWayRate         float64          `json:"one_way_rate"`
+	Pending            int              `json:"pending"`
+	Multicast          int              `json:"multicast"`
+	Evicted            int              `json:"evicted"`
+	PacketsSent        uint64           `json:"packets_sent"`
+	BytesSent          uint64           `json:"bytes_sent"`
+	PacketsReceived    uint64           `json:"packets_received"`
+	BytesReceived      uint64           `json:"bytes_received"`
+	Sizes              []NameCount      `json:"datagram_sizes,omitempty"`
+	ConntrackFlows     int              `json:"conntrack_flows"`
+	ConntrackUnreplied int              `json:"conntrack_unreplied"`
+	Confirmed          int              `json:"confirmed_by_conntrack"`
+	TopFlows           []UDPFlow        `json:"top_flows,omitempty"`
+	Destinations       []UDPDestination `json:"one_way_destinations,omitempty"`
+}
+
+// UDPFlow is one UDP flow; the client sent first
+type UDPFlow struct {
+	Client          string  `json:"client"`
+	Server          string  `json:"server"`
+	PacketsSent     uint64  `json:"packets_sent"`
+	BytesSent       uint64  `json:"bytes_sent"`
+	PacketsReceived uint64  `json:"packets_received"`
+	BytesReceived   uint64  `json:"bytes_received"`
+	MaxSize         int     `json:"max_datagram"`
+	DurationMs      float64 `json:"duration_ms"`
+	OneWay          bool    `json:"one_way"`
+	Conntrack       string  `json:"conntrack,omitempty"`
+}
+
+// UDPDestination holds the one-way flows towards one server
+type UDPDestination struct {
+	Server             string `json:"server"`
+	Flows              int    `json:"flows"`
+	OneWay             int    `json:"one_way"`
+	Packets            uint64 `json:"packets"`
+	ConntrackUnreplied int    `json:"conntrack_unreplied"`
+}
+
+// Neighbor holds ARP and IPv6 Neighbor Discovery health: address
+// resolutions, gratuitous messages and addresses claimed by several MACs
+type Neighbor struct {
+	ARPRequests   int                `json:"arp_requests"`
+	Solicitations int                `json:"solicitations"`
+	Probes        int                `json:"probes"`
+	Answered      int                `json:"answered"`
+	Unanswered    int                `json:"unanswered"`
+	Unobserved    int                `json:"unobserved"`
+	Gratuitous    int                `json:"gratuitous"`
+	Storms        int                `json:"storms"`
+	Flooding      int                `json:"flooding"`
+	ResolveTime   LatencySummary     `json:"resolve_time"`
+	Hosts         []NeighborHost     `json:"hosts,omitempty"`
+	Conflicts     []NeighborConflict `json:"conflicts,omitempty"`
+}
+
+// NeighborHost holds the resolutions and gratuitous messages of one host
+type NeighborHost struct {
+	IP         string   `json:"ip"`
+	MAC        string   `json:"mac"`
+	Requests   int      `json:"requests"`
+	Answered   int      `json:"answered"`
+	Unanswered int      `json:"unanswered"`
+	Targets    []string `json:"unresolved_targets,omitempty"`
+	PeakRate   int      `json:"peak_rate"`
+	Flooding   bool     `json:"flooding"`
+	Gratuitous int      `json:"gratuitous"`
+	Storm      bool     `json:"storm"`
+	Visible    bool     `json:"visible"`
+}
+
+// NeighborConflict is an IP address claimed by several MACs
+type NeighborConflict struct {
+	IP    string    `json:"ip"`
+	MACs  []string  `json:"macs"`
+	DAD   bool      `json:"dad"`
+	First time.Time `json:"first"`
+}
+
+// DHCP holds DHCPv4 and DHCPv6 exchanges, their outcomes and the servers
+// that answered them
+type DHCP struct {
+	Exchanges   int            `json:"exchanges"`
+	Acked       int            `json:"acked"`
+	Naks        int            `json:"naks"`
+	NoOffer     int            `json:"no_offer"`
+	NoAck       int            `json:"no_ack"`
+	Pending     int            `json:"pending"`
+	Renewals    int            `json:"renewals"`
+	Informs     int            `json:"informs"`
+	Retransmits int            `json:"retransmits"`
+	Competing   int            `json:"competing"`
+	Declines    int            `json:"declines"`
+	Releases    int            `json:"releases"`
+	Evicted     int            `json:"evicted"`
+	Messages    []NameCount    `json:"messages,omitempty"`
+	Statuses    []NameCount    `json:"statuses,omitempty"`
+	OfferTime   LatencySummary `json:"offer_time"`
+	AcquireTime LatencySummary `json:"acquire_time"`
+	Servers     []DHCPServer   `json:"servers,omitempty"`
+	Clients     []DHCPClient   `json:"clients,omitempty"`
+}
+
+// DHCPServer holds what one DHCP server offered and acknowledged
+type DHCPServer struct {
+	ID        string   `json:"id"`
+	Address   string   `json:"address"`
+	Version   string   `json:"version"`
+	Offers    int      `json:"offers"`
+	Acks      int      `json:"acks"`
+	Naks      int      `json:"naks"`
+	Competing int      `json:"competing"`
+	Offered   string   `json:"offered,omitempty"`
+	Subnet    string   `json:"subnet,omitempty"`
+	Routers   []string `json:"routers,omitempty"`
+	DNS       []string `json:"dns,omitempty"`
+	Domain    string   `json:"domain,omitempty"`
+	LeaseSecs int      `json:"lease_seconds"`
+}
+
+// DHCPClient holds the exchanges of one client, identified by its
+// hardware address or DHCPv6 DUID
+type DHCPClient struct {
+	ID          string `json:"id"`
+	Exchanges   int    `json:"exchanges"`
+	Acked       int    `json:"acked"`
+	Naks        int    `json:"naks"`
+	NoOffer     int    `json:"no_offer"`
+	NoAck       int    `json:"no_ack"`
+	Declines    int    `json:"declines"`
+	Retransmits int    `json:"retransmits"`
+	Last        string `json:"last"`
+	Address     string `json:"address,omitempty"`
+}
+
+// Latency holds handshake latencies over all servers and per server
+type Latency struct {
+	SynAck    LatencySummary  `json:"syn_to_syn_ack"`
+	Ack       LatencySummary  `json:"syn_ack_to_ack"`
+	Skipped   int             `json:"skipped_retransmitted"`
+	PerServer []ServerLatency `json:"per_server,omitempty"`
+}
+
+// ServerLatency holds the handshake latencies towards one IP:port
+type ServerLatency struct {
+	Server string         `json:"server"`
+	SynAck LatencySummary `json:"syn_to_syn_ack"`
+	Ack    LatencySummary `json:"syn_ack_to_ack"`
+}
+
+// LatencySummary condenses a latency histogram into percentiles
+type LatencySummary struct {
+	Samples   int             `json:"samples"`
+	P50Ms     float64         `json:"p50_ms"`
+	P90Ms     float64         `json:"p90_ms"`
+	P99Ms     float64         `json:"p99_ms"`
+	MaxMs     float64         `json:"max_ms"`
+	Histogram []LatencyBucket `json:"histogram,omitempty"`
+}
+
+// LatencyBucket is one range of a latency histogram
+type LatencyBucket struct {
+	LowMs  float64 `json:"low_ms"`
+	HighMs float64 `json:"high_ms"`
+	Count  int     `json:"count"`
+}
+
+// InterfaceStats breaks packet and handshake counters out per interface
+type InterfaceStats struct {
+	Name            string       `json:"name"`
+	PacketsCaptured int          `json:"packets_captured"`
+	Bytes           uint64       `json:"bytes"`
+	CaptureStats    CaptureStats `json:"capture_stats"`
+	TCPStats        TCPHandshake `json:"tcp_handshake"`
+}
+
+// SegmentStats breaks packet and handshake counters out per VLAN and
+// tunnel. Tunnelled packets are analyzed by their inner headers.
+type SegmentStats struct {
+	Segment         string       `json:"segment"`
+	VLAN            uint16       `json:"vlan,omitempty"`
+	InnerVLAN       uint16       `json:"inner_vlan,omitempty"`
+	Tunnel          string       `json:"tunnel,omitempty"`
+	VNI             uint32       `json:"vni,omitempty"` // VXLAN or Geneve VNI, or GRE key
+	Endpoints       []string     `json:"tunnel_endpoints,omitempty"`
+	PacketsCaptured int          `json:"packets_captured"`
+	Bytes           uint64       `json:"bytes"`
+	TCPStats        TCPHandshake `json:"tcp_handshake"`
 }
 
 // ToJSON writes diagnostic result as JSON
@@ -46,17 +637,511 @@ const markdownTmpl = `# Network Diagnostics Report
 **Generated:** {{ .Timestamp.Format "2006-01-02 15:04:05" }}
 
 ## Overview
-- **Interfaces:** {{ .Interfaces }}
+- **Interfaces:** {{ join .Interfaces ", " }}
 - **Duration:** {{ .DurationSecs }} seconds
 - **Packets Captured:** {{ .PacketsCaptured }}
+{{- if .Warnings }}
+
+## Warnings
+{{- range .Warnings }}
+- {{ . }}
+{{- end }}
+{{- end }}
+{{- if .Findings }}
+
+## Findings
+| Finding | Target | Source | Window | Probes | Detail |
+|---------|--------|--------|--------|--------|--------|
+{{- range .Findings }}
+| {{ .Kind }} | {{ .Target }} | {{ if .Source }}{{ .Source }}{{ else }}{{ range $i, $o := .TopSources }}{{ if $i }}, {{ end }}{{ $o.Addr }} ({{ $o.Count }}){{ end }}{{ end }} | {{ .Start.Format "15:04:05" }}–{{ .End.Format "15:04:05" }} | {{ .Probes }} | {{ .Detail }} |
+{{- end }}
+{{- end }}
+
+## Capture Statistics
+| Counter | Value |
+|---------|-------|
+| Received | {{ .CaptureStats.Received }} |
+| Dropped by kernel | {{ .CaptureStats.KernelDropped }} |
+| Dropped by interface | {{ .CaptureStats.InterfaceDropped }} |
+| Dropped by analysis backlog | {{ .CaptureStats.ChannelDropped }} |
+| Drop rate | {{ printf "%.2f" .CaptureStats.DropRate }}% |
 
 ## TCP Handshake Analysis
+| Outcome | Connections |
+|---------|-------------|
+| Connection attempts | {{ .TCPStats.SynSent }} |
+| Completed (SYN → SYN-ACK → ACK) | {{ .TCPStats.Completed }} |
+| SYN-ACKed but never ACKed | {{ .TCPStats.NoAck }} |
+| Unanswered SYN | {{ .TCPStats.Unanswered }} |
+| Reset during handshake | {{ .TCPStats.Reset }} |
+| Still in handshake at end of capture | {{ .TCPStats.Pending }} |
+{{- if .TCPStats.Evicted }}
+| Evicted from the full flow table | {{ .TCPStats.Evicted }} |
+{{- end }}
+
+| Metric | Value |
+|--------|-------|
+| SYN retransmissions | {{ .TCPStats.SynRetransmits }} |
+| SYN-ACK retransmissions | {{ .TCPStats.SynAckRetransmits }} |
+| RST segments | {{ .TCPStats.RstRcvd }} |
+{{- if or .Latency.SynAck.Samples .Latency.Ack.Samples }}
+
+## Handshake Latency
+| Server | SYN → SYN-ACK samples | p50 | p90 | p99 | max | SYN-ACK → ACK samples | p50 | p90 | p99 | max |
+|--------|-----------------------|-----|-----|-----|-----|-----------------------|-----|-----|-----|-----|
+{{- with .Latency }}
+| all | {{ template "latency" .SynAck }} | {{ template "latency" .Ack }} |
+{{- end }}
+{{- range .Latency.PerServer }}
+| {{ .Server }} | {{ template "latency" .SynAck }} | {{ template "latency" .Ack }} |
+{{- end }}
+{{- if .Latency.Skipped }}
+
+{{ .Latency.Skipped }} samples were skipped because the SYN or SYN-ACK was retransmitted.
+{{- end }}
+{{- if .Latency.SynAck.Histogram }}
+
+| SYN → SYN-ACK | Connections |
+|---------------|-------------|
+{{- range .Latency.SynAck.Histogram }}
+| {{ printf "%.3f" .LowMs }}–{{ printf "%.3f" .HighMs }} ms | {{ .Count }} |
+{{- end }}
+{{- end }}
+{{- end }}
+{{- if .DNS.Queries }}
+
+## DNS Resolution
+| Resolver | Queries | Answered | Unanswered | NXDOMAIN | SERVFAIL | REFUSED | Truncated | p50 | p99 |
+|----------|---------|----------|------------|----------|----------|---------|-----------|-----|-----|
+| all | {{ template "dns" .DNS.DNSCounters }} |
+{{- range .DNS.PerResolver }}
+| {{ .Resolver }} | {{ template "dns" .DNSCounters }} |
+{{- end }}
+{{- if .DNS.Pending }}
+
+{{ .DNS.Pending }} queries were still waiting for an answer when the capture ended.
+{{- end }}
+{{- if .DNS.Unmatched }}
+
+{{ .DNS.Unmatched }} responses arrived for queries that were not captured.
+{{- end }}
+{{- if .DNS.PerResolver }}
+
+Most queried names:
+{{- range .DNS.PerResolver }}
+{{- if .TopNames }}
+- **{{ .Resolver }}:** {{ template "counts" .TopNames }}
+{{- end }}
+{{- end }}
+{{- end }}
+{{- end }}
+{{- if .TLS.Handshakes }}
+
+## TLS Handshakes
+| Metric | Value |
+|--------|-------|
+| Handshakes | {{ .TLS.Handshakes }} |
+| Failed | {{ .TLS.Failed }} |
+{{- if .TLS.Pending }}
+| Unanswered when the capture ended | {{ .TLS.Pending }} |
+{{- end }}
+| Expired certificates | {{ .TLS.Expired }} |
+| Offered versions (highest) | {{ template "counts" .TLS.OfferedVersions }} |
+| Negotiated versions | {{ template "counts" .TLS.Versions }} |
+| Cipher suites | {{ template "counts" .TLS.CipherSuites }} |
+{{- if .TLS.ALPN }}
+| ALPN | {{ template "counts" .TLS.ALPN }} |
+{{- end }}
+{{- if .TLS.Alerts }}
+| Alerts | {{ template "counts" .TLS.Alerts }} |
+{{- end }}
+{{- if .TLS.Servers }}
+
+| Server Name | Handshakes | Failed | Failure Reasons | Version | Cipher Suite | Certificate |
+|-------------|------------|--------|-----------------|---------|--------------|-------------|
+{{- range .TLS.Servers }}
+| {{ .Name }} | {{ .Handshakes }} | {{ .Failed }} | {{ template "counts" .Failures }} | {{ .Version }} | {{ .CipherSuite }} | {{ with .Certificates }}{{ with index . 0 }}{{ .Subject }}, issued by {{ .Issuer }}, valid until {{ .NotAfter.Format "2006-01-02" }}{{ end }}{{ end }}{{ if .Expired }} **(expired)**{{ end }} |
+{{- end }}
+{{- end }}
+{{- if .TLS.Fingerprints }}
+
+| JA4 | JA3 | Handshakes | Example SNI |
+|-----|-----|------------|-------------|
+{{- range .TLS.Fingerprints }}
+| {{ .JA4 }} | {{ .JA3 }} | {{ .Count }} | {{ .SNI }} |
+{{- end }}
+{{- end }}
+{{- end }}
+{{- if .QUIC.Connections }}
+
+## QUIC Connections
+| Metric | Value |
+|--------|-------|
+| Connection attempts | {{ .QUIC.Connections }} |
+| Completed | {{ .QUIC.Completed }} |
+| Failed | {{ .QUIC.Failed }} |
+| No response | {{ .QUIC.NoResponse }} |
+{{- if .QUIC.VersionMismatch }}
+| Version mismatch | {{ .QUIC.VersionMismatch }} |
+{{- end }}
+| Still in progress | {{ .QUIC.Pending }} |
+{{- if .QUIC.Retries }}
+| Retry (address validation) | {{ .QUIC.Retries }} |
+{{- end }}
+| Versions | {{ template "counts" .QUIC.Versions }} |
+{{- if .QUIC.ALPN }}
+| ALPN offered | {{ template "counts" .QUIC.ALPN }} |
+{{- end }}
+{{- if .QUIC.Errors }}
+| Close errors | {{ template "counts" .QUIC.Errors }} |
+{{- end }}
+{{- with .QUIC.HandshakeTime }}{{ if .Samples }}
+| Handshake time | p50 {{ printf "%.2f" .P50Ms }} ms, p99 {{ printf "%.2f" .P99Ms }} ms, max {{ printf "%.2f" .MaxMs }} ms |
+{{- end }}{{ end }}
+{{- if .QUIC.Servers }}
+
+| Server Name | Address | Attempts | Completed | Failed | No Response | Version | ALPN | Errors |
+|-------------|---------|----------|-----------|--------|-------------|---------|------|--------|
+{{- range .QUIC.Servers }}
+| {{ .Name }} | {{ .Address }} | {{ .Attempts }} | {{ .Completed }} | {{ .Failed }} | {{ .NoResponse }} | {{ .Version }} | {{ join .ALPN ", " }} | {{ template "counts" .Errors }} |
+{{- end }}
+{{- end }}
+{{- if .QUIC.NoResponse }}
+
+Attempts without any response usually mean UDP port 443 is blocked; clients then fall back to TCP after a delay.
+{{- end }}
+{{- end }}
+{{- if .HTTP.Requests }}
+
+## HTTP Transactions
 | Metric | Value |
 |--------|-------|
-| SYN Sent | {{ .TCPStats.SynSent }} |
-| SYN-ACK Received | {{ .TCPStats.SynAckRcvd }} |
-| RST Received | {{ .TCPStats.RstRcvd }} |
-| SYN-ACK Ratio | {{ printf "%.1f" .TCPStats.SynAckRatio }}% |
+| Connections | {{ .HTTP.Connections }} |
+| Requests | {{ .HTTP.Requests }} |
+| Responses | {{ .HTTP.Responses }} |
+| Unanswered | {{ .HTTP.Unanswered }} |
+{{- if .HTTP.Pending }}
+| Unanswered when the capture ended | {{ .HTTP.Pending }} |
+{{- end }}
+| Requests per connection | {{ printf "%.2f" .HTTP.RequestsPerConnection }} (max {{ .HTTP.MaxRequestsPerConnection }}) |
+| Reused connections | {{ .HTTP.ReusedConnections }} ({{ printf "%.1f" .HTTP.ReuseRate }}%) |
+| Upgraded or tunnelled | {{ .HTTP.Upgrades }} |
+| Status codes | {{ range $i, $s := .HTTP.StatusCodes }}{{ if $i }}, {{ end }}{{ $s.Code }} ({{ $s.Count }}){{ end }} |
+
+| Host | Path | Requests | Responses | 4xx | 5xx | TTFB p50 | TTFB p99 | Response p50 | Response p99 | Response max |
+|------|------|----------|-----------|-----|-----|----------|----------|--------------|--------------|--------------|
+| all | | {{ template "http" .HTTP.HTTPCounters }} |
+{{- range .HTTP.Endpoints }}
+| {{ .Host }} | {{ .Path }} | {{ template "http" .HTTPCounters }} |
+{{- end }}
+{{- if .HTTP.Lost }}
+
+{{ .HTTP.Lost }} connections could not be followed to the end because of capture gaps or malformed messages.
+{{- end }}
+{{- end }}
+{{- if .Teardown.Endings }}
+
+## Connection Teardown
+| Ending | Connections | Median Duration | p99 Duration | Max Duration |
+|--------|-------------|-----------------|--------------|--------------|
+{{- range .Teardown.Endings }}
+| {{ .Ending }} | {{ .Count }} | {{ printf "%.1f" .P50Ms }} ms | {{ printf "%.1f" .P99Ms }} ms | {{ printf "%.1f" .MaxMs }} ms |
+{{- end }}
+{{- if .Teardown.PerServer }}
+
+| Server | Graceful | Half-Closed | Refused | Client RST | Server RST | Idle | Open | Median Duration | p99 Duration |
+|--------|----------|-------------|---------|------------|------------|------|------|-----------------|--------------|
+{{- range .Teardown.PerServer }}
+| {{ .Server }} | {{ .Graceful }} | {{ .HalfClosed }} | {{ .Refused }} | {{ .ClientReset }} | {{ .ServerReset }} | {{ .Idle }} | {{ .Open }} | {{ printf "%.1f" .P50Ms }} ms | {{ printf "%.1f" .P99Ms }} ms |
+{{- end }}
+{{- end }}
+{{- end }}
+{{- if .TCPOptions.Handshakes }}
+
+## TCP Options
+| Option | Offered in SYN | Accepted in SYN-ACK |
+|--------|----------------|---------------------|
+| Window scale | {{ .TCPOptions.WindowScale.Offered }} | {{ .TCPOptions.WindowScale.Accepted }} |
+| SACK permitted | {{ .TCPOptions.SACK.Offered }} | {{ .TCPOptions.SACK.Accepted }} |
+| Timestamps | {{ .TCPOptions.Timestamps.Offered }} | {{ .TCPOptions.Timestamps.Accepted }} |
+| ECN | {{ .TCPOptions.ECN.Offered }} | {{ .TCPOptions.ECN.Accepted }} |
+
+- **Client MSS:** {{ range $i, $m := .TCPOptions.ClientMSS }}{{ if $i }}, {{ end }}{{ $m.MSS }} ({{ $m.Count }}){{ else }}none{{ end }}
+- **Server MSS:** {{ range $i, $m := .TCPOptions.ServerMSS }}{{ if $i }}, {{ end }}{{ $m.MSS }} ({{ $m.Count }}){{ else }}none{{ end }}
+{{- end }}
+{{- if .Middlebox }}
+
+## Middlebox Interference
+SYN-ACKs whose options disagree with the SYN suggest a firewall, NAT or proxy rewriting the handshake.
+
+| Server | Anomaly | Handshakes | SYN MSS | SYN-ACK MSS |
+|--------|---------|------------|---------|-------------|
+{{- range .Middlebox }}
+| {{ .Server }} | {{ .Anomaly }} | {{ .Count }} | {{ .ClientMSS }} | {{ .ServerMSS }} |
+{{- end }}
+{{- end }}
+{{- if or .ICMP.Total .ICMP.BlackHoles }}
+
+## ICMP Errors
+{{- if .ICMP.Total }}
+{{ .ICMP.Total }} error messages: {{ template "counts" .ICMP.Messages }}.
+{{- if .ICMP.Unparsed }} {{ .ICMP.Unparsed }} quoted too little of the original datagram to tell its flow.{{ end }}
+{{- end }}
+{{- if .ICMP.Networks }}
+
+| Destination Network | Messages | Kinds | Flows | Reporters | Min MTU | Black Holes | Example Flow |
+|---------------------|----------|-------|-------|-----------|---------|-------------|--------------|
+{{- range .ICMP.Networks }}
+| {{ .Network }} | {{ .Total }} | {{ template "counts" .Messages }} | {{ .Flows }} | {{ range $i, $r := .Reporters }}{{ if $i }}, {{ end }}{{ $r }}{{ end }} | {{ if .MinMTU }}{{ .MinMTU }}{{ end }} | {{ .BlackHoles }} | {{ .Example }} |
+{{- end }}
+{{- end }}
+{{- if .ICMP.BlackHoles }}
+
+Suspected path MTU black holes (large segments retransmitted without acknowledgement or ICMP feedback):
+{{- range .ICMP.BlackHoles }}
+- **{{ .Sender }} → {{ .Receiver }}:** {{ .Size }}-byte segment retransmitted {{ .Retransmits }} times from {{ .Start.Format "15:04:05.000" }}{{ if .Recovered }}, got through once resent smaller{{ end }}
+{{- end }}
+{{- end }}
+{{- end }}
+{{- if or .Fragmentation.Fragments .Fragmentation.Oversized }}
+
+## IP Fragmentation and MTU
+| Metric | Value |
+|--------|-------|
+| Largest packet | {{ .Fragmentation.MaxSize }} bytes |
+| Fragments | {{ .Fragmentation.Fragments }} |
+| Datagrams reassembled | {{ .Fragmentation.Reassembled }}{{ if .Fragmentation.MaxDatagram }} (largest {{ .Fragmentation.MaxDatagram }} bytes){{ end }} |
+| Incomplete (a fragment never arrived) | {{ .Fragmentation.Incomplete }} |
+| Incomplete at the end of the capture | {{ .Fragmentation.Pending }} |
+| Dropped for overlapping fragments | {{ .Fragmentation.Overlaps }} |
+| Duplicate fragments | {{ .Fragmentation.Duplicates }} |
+| Not reassembled (invalid, or over the memory limit) | {{ .Fragmentation.Unreassembled }} |
+| Packets that may not be fragmented (DF set, or IPv6) | {{ .Fragmentation.DontFragment }} |
+| Of those, above the {{ .Fragmentation.MTU }}-byte MTU | {{ .Fragmentation.Oversized }}{{ if .Fragmentation.MaxOversized }} (largest {{ .Fragmentation.MaxOversized }} bytes){{ end }} |
+{{- if .Fragmentation.Oversized }}
+
+Packets above the MTU that may not be fragmented only cross paths with jumbo frames. Captured on the sending or receiving host they are usually TSO/GSO or GRO aggregates built by the network card rather than packets on the wire; turn tso, gso and gro off with ethtool to see the real sizes.
+{{- end }}
+{{- if .Fragmentation.Paths }}
+
+| Source | Destination | Packets | Largest | Largest DF | Fragmented | Fragments | Above MTU |
+|--------|-------------|---------|---------|------------|------------|-----------|-----------|
+{{- range .Fragmentation.Paths }}
+| {{ .Src }} | {{ .Dst }} | {{ .Packets }} | {{ .MaxSize }} | {{ if .MaxDF }}{{ .MaxDF }}{{ end }} | {{ .Fragmented }} | {{ .Fragments }} | {{ .Oversized }} |
+{{- end }}
+{{- end }}
+{{- end }}
+{{- if .UDP.Flows }}
+
+## UDP Flows
+| Metric | Value |
+|--------|-------|
+| Flows | {{ .UDP.Flows }} |
+| One-way (never answered) | {{ .UDP.OneWay }} ({{ printf "%.1f" .UDP.OneWayRate }}%) |
+| Awaiting an answer at the end | {{ .UDP.Pending }} |
+| Multicast and broadcast | {{ .UDP.Multicast }} |
+| Sent | {{ .UDP.PacketsSent }} datagrams, {{ .UDP.BytesSent }} bytes |
+| Received | {{ .UDP.PacketsReceived }} datagrams, {{ .UDP.BytesReceived }} bytes |
+| Datagram sizes | {{ template "counts" .UDP.Sizes }} |
+{{- if .UDP.Evicted }}
+| Not tracked (table full) | {{ .UDP.Evicted }} |
+{{- end }}
+{{- if .UDP.ConntrackFlows }}
+| Conntrack UDP entries | {{ .UDP.ConntrackFlows }} ({{ .UDP.ConntrackUnreplied }} unreplied) |
+| One-way flows confirmed by conntrack | {{ .UDP.Confirmed }} |
+{{- end }}
+{{- if .UDP.Destinations }}
+
+One-way flows usually mean a firewall drops the datagrams or their answers, or nothing listens on the server.
+
+| Server | Flows | One-Way | Datagrams Sent | Conntrack Unreplied |
+|--------|-------|---------|----------------|---------------------|
+{{- range .UDP.Destinations }}
+| {{ .Server }} | {{ .Flows }} | {{ .OneWay }} | {{ .Packets }} | {{ .ConntrackUnreplied }} |
+{{- end }}
+{{- end }}
+{{- if .UDP.TopFlows }}
+
+| Client | Server | Sent | Received | Max Datagram | Duration | Conntrack |
+|--------|--------|------|----------|--------------|----------|-----------|
+{{- range .UDP.TopFlows }}
+| {{ .Client }} | {{ .Server }} | {{ .PacketsSent }} ({{ .BytesSent }} B) | {{ if .OneWay }}none{{ else }}{{ .PacketsReceived }} ({{ .BytesReceived }} B){{ end }} | {{ .MaxSize }} | {{ printf "%.1f" .DurationMs }} ms | {{ .Conntrack }} |
+{{- end }}
+{{- end }}
+{{- end }}
+{{- if or .Neighbor.ARPRequests .Neighbor.Solicitations .Neighbor.Probes .Neighbor.Gratuitous }}
+
+## ARP and Neighbor Discovery
+| Metric | Value |
+|--------|-------|
+| ARP requests | {{ .Neighbor.ARPRequests }} |
+| Neighbor solicitations | {{ .Neighbor.Solicitations }} |
+| Duplicate address probes | {{ .Neighbor.Probes }} |
+| Answered | {{ .Neighbor.Answered }} |
+| Unanswered | {{ .Neighbor.Unanswered }} |
+{{- if .Neighbor.Unobserved }}
+| Unanswered, answer possibly not captured | {{ .Neighbor.Unobserved }} |
+{{- end }}
+| Gratuitous | {{ .Neighbor.Gratuitous }} |
+{{- if .Neighbor.Storms }}
+| Hosts with gratuitous storms | {{ .Neighbor.Storms }} |
+{{- end }}
+{{- if .Neighbor.Flooding }}
+| Hosts flooding requests | {{ .Neighbor.Flooding }} |
+{{- end }}
+{{- with .Neighbor.ResolveTime }}{{ if .Samples }}
+| Resolution time | p50 {{ printf "%.2f" .P50Ms }} ms, p99 {{ printf "%.2f" .P99Ms }} ms, max {{ printf "%.2f" .MaxMs }} ms |
+{{- end }}{{ end }}
+{{- if .Neighbor.Conflicts }}
+
+| Duplicate Address | MACs | Found By | First Seen |
+|-------------------|------|----------|------------|
+{{- range .Neighbor.Conflicts }}
+| {{ .IP }} | {{ join .MACs ", " }} | {{ if .DAD }}duplicate address detection{{ else }}conflicting claims{{ end }} | {{ .First.Format "15:04:05.000" }} |
+{{- end }}
+
+Several MACs for one address can also be VRRP or HSRP failover, or a router answering by proxy ARP; check those before chasing a duplicate.
+{{- end }}
+{{- if .Neighbor.Hosts }}
+
+| Host | MAC | Requests | Answered | Unanswered | Peak Rate | Gratuitous | Unresolved Targets |
+|------|-----|----------|----------|------------|-----------|------------|--------------------|
+{{- range .Neighbor.Hosts }}
+| {{ .IP }} | {{ .MAC }} | {{ .Requests }} | {{ .Answered }} | {{ .Unanswered }}{{ if not .Visible }} (answers not captured){{ end }} | {{ .PeakRate }}/s{{ if .Flooding }} (flooding){{ end }} | {{ .Gratuitous }}{{ if .Storm }} (storm){{ end }} | {{ join .Targets ", " }} |
+{{- end }}
+{{- end }}
+{{- end }}
+{{- if .DHCP.Exchanges }}
+
+## DHCP
+| Metric | Value |
+|--------|-------|
+| Exchanges | {{ .DHCP.Exchanges }} |
+| Acknowledged | {{ .DHCP.Acked }} |
+| Refused (NAK) | {{ .DHCP.Naks }} |
+| No offer | {{ .DHCP.NoOffer }} |
+| Offered or requested, never acknowledged | {{ .DHCP.NoAck }} |
+| Still in progress | {{ .DHCP.Pending }} |
+{{- if .DHCP.Renewals }}
+| Renewals and reboots | {{ .DHCP.Renewals }} |
+{{- end }}
+{{- if .DHCP.Informs }}
+| Configuration only | {{ .DHCP.Informs }} |
+{{- end }}
+| Client retransmissions | {{ .DHCP.Retransmits }} |
+{{- if .DHCP.Competing }}
+| Offered by more than one server | {{ .DHCP.Competing }} |
+{{- end }}
+{{- if .DHCP.Declines }}
+| Addresses declined as in use | {{ .DHCP.Declines }} |
+{{- end }}
+{{- if .DHCP.Evicted }}
+| Not tracked (table full) | {{ .DHCP.Evicted }} |
+{{- end }}
+| Messages | {{ template "counts" .DHCP.Messages }} |
+{{- if .DHCP.Statuses }}
+| DHCPv6 error statuses | {{ template "counts" .DHCP.Statuses }} |
+{{- end }}
+{{- with .DHCP.OfferTime }}{{ if .Samples }}
+| Time to first offer | p50 {{ printf "%.2f" .P50Ms }} ms, p99 {{ printf "%.2f" .P99Ms }} ms, max {{ printf "%.2f" .MaxMs }} ms |
+{{- end }}{{ end }}
+{{- with .DHCP.AcquireTime }}{{ if .Samples }}
+| Lease acquisition time | p50 {{ printf "%.2f" .P50Ms }} ms, p99 {{ printf "%.2f" .P99Ms }} ms, max {{ printf "%.2f" .MaxMs }} ms |
+{{- end }}{{ end }}
+{{- if .DHCP.Servers }}
+
+| Server | Address | Version | Offers | ACKs | NAKs | Competing | Offered | Subnet | Routers | DNS | Domain | Lease |
+|--------|---------|---------|--------|------|------|-----------|---------|--------|---------|-----|--------|-------|
+{{- range .DHCP.Servers }}
+| {{ .ID }} | {{ .Address }} | {{ .Version }} | {{ .Offers }} | {{ .Acks }} | {{ .Naks }} | {{ .Competing }} | {{ .Offered }} | {{ .Subnet }} | {{ join .Routers ", " }} | {{ join .DNS ", " }} | {{ .Domain }} | {{ if .LeaseSecs }}{{ .LeaseSecs }} s{{ end }} |
+{{- end }}
+{{- end }}
+{{- if .DHCP.Competing }}
+
+More than one server answering the same client is either a failover pair or a rogue server; compare the routers and DNS servers they hand out.
+{{- end }}
+{{- if .DHCP.Clients }}
+
+| Client | Exchanges | Acked | NAK | No Offer | No ACK | Declined | Retransmits | Last | Address |
+|--------|-----------|-------|-----|----------|--------|----------|-------------|------|---------|
+{{- range .DHCP.Clients }}
+| {{ .ID }} | {{ .Exchanges }} | {{ .Acked }} | {{ .Naks }} | {{ .NoOffer }} | {{ .NoAck }} | {{ .Declines }} | {{ .Retransmits }} | {{ .Last }} | {{ .Address }} |
+{{- end }}
+{{- end }}
+{{- end }}
+{{- if .TCPStream.Segments }}
+
+## TCP Data Phase
+| Metric | Value |
+|--------|-------|
+| Segments | {{ .TCPStream.Segments }} |
+| Data segments | {{ .TCPStream.DataSegments }} |
+| Payload bytes | {{ .TCPStream.Bytes }} |
+| Retransmissions | {{ .TCPStream.Retransmissions }} ({{ printf "%.2f" .TCPStream.RetransmissionRate }}%) |
+| Fast retransmissions | {{ .TCPStream.FastRetransmissions }} |
+| Spurious retransmissions | {{ .TCPStream.SpuriousRetransmissions }} |
+| Duplicate ACKs | {{ .TCPStream.DuplicateAcks }} |
+| Out-of-order segments | {{ .TCPStream.OutOfOrder }} |
+| Lost segments (not captured) | {{ .TCPStream.LostSegments }} |
+| Zero window advertisements | {{ .TCPStream.ZeroWindows }} |
+| Zero window probes | {{ .TCPStream.ZeroWindowProbes }} |
+| Window full | {{ .TCPStream.WindowFull }} |
+| Windows reopened | {{ .TCPStream.WindowUpdates }} |
+| Receive window stall time | {{ printf "%.1f" .TCPStream.StallMs }} ms |
+{{- if .TCPStream.Flows }}
+
+| Client | Server | Data Segments | Retransmissions | Fast | Spurious | Dup ACKs | Out-of-Order | Lost |
+|--------|--------|---------------|-----------------|------|----------|----------|--------------|------|
+{{- range .TCPStream.Flows }}
+| {{ .Client }} | {{ .Server }} | {{ .DataSegments }} | {{ .Retransmissions }} | {{ .FastRetransmissions }} | {{ .SpuriousRetransmissions }} | {{ .DuplicateAcks }} | {{ .OutOfOrder }} | {{ .LostSegments }} |
+{{- end }}
+{{- end }}
+{{- if .TCPStream.StalledFlows }}
+
+### Receive Window Stalls
+Zero windows mean the receiving application is not reading fast enough (back-pressure), not packet loss.
+
+| Client | Server | Zero Windows | Probes | Window Full | Stall Time |
+|--------|--------|--------------|--------|-------------|------------|
+{{- range .TCPStream.StalledFlows }}
+| {{ .Client }} | {{ .Server }} | {{ .ZeroWindows }} | {{ .ZeroWindowProbes }} | {{ .WindowFull }} | {{ printf "%.1f" .StallMs }} ms |
+{{- end }}
+{{- end }}
+{{- end }}
+{{- if .Reassembly.Streams }}
+
+## TCP Stream Reassembly
+| Metric | Value |
+|--------|-------|
+| Streams with payload | {{ .Reassembly.Streams }} |
+| Bytes reassembled | {{ .Reassembly.Bytes }} |
+| Gaps (segments never captured) | {{ .Reassembly.Gaps }} ({{ .Reassembly.MissingBytes }} bytes) |
+| Retransmitted bytes already delivered | {{ .Reassembly.OverlapBytes }} |
+{{- if .Reassembly.DumpDir }}
+
+{{ .Reassembly.DumpFiles }} stream files written to {{ .Reassembly.DumpDir }}.
+{{- end }}
+{{- end }}
+{{- if .PerInterface }}
+
+## Per-Interface Statistics
+| Interface | Packets | Bytes | Drop Rate | Attempts | Completed | No ACK | Unanswered | Reset |
+|-----------|---------|-------|-----------|----------|-----------|--------|------------|-------|
+{{- range .PerInterface }}
+| {{ .Name }} | {{ .PacketsCaptured }} | {{ .Bytes }} | {{ printf "%.2f" .CaptureStats.DropRate }}% | {{ .TCPStats.SynSent }} | {{ .TCPStats.Completed }} | {{ .TCPStats.NoAck }} | {{ .TCPStats.Unanswered }} | {{ .TCPStats.Reset }} |
+{{- end }}
+{{- end }}
+{{- if .PerSegment }}
+
+## Per-VLAN and Tunnel Statistics
+Tunnelled traffic is analyzed by its inner headers throughout this report.
+
+| Segment | Tunnel Endpoints | Packets | Bytes | Attempts | Completed | No ACK | Unanswered | Reset |
+|---------|------------------|---------|-------|----------|-----------|--------|------------|-------|
+{{- range .PerSegment }}
+| {{ .Segment }} | {{ if .Endpoints }}{{ join .Endpoints ", " }}{{ else }}-{{ end }} | {{ .PacketsCaptured }} | {{ .Bytes }} | {{ .TCPStats.SynSent }} | {{ .TCPStats.Completed }} | {{ .TCPStats.NoAck }} | {{ .TCPStats.Unanswered }} | {{ .TCPStats.Reset }} |
+{{- end }}
+{{- end }}
 
 ## Connection Tracking
 | State | Count |
@@ -66,6 +1151,14 @@ const markdownTmpl = `# Network Diagnostics Report
 | UNREPLIED | {{ .ConntrackCounters.Unreplied }} |
 | Other | {{ .ConntrackCounters.Other }} |
 
+{{- if .CaptureFiles }}
+
+## Capture Files
+{{- range .CaptureFiles }}
+- {{ . }}
+{{- end }}
+{{- end }}
+
 ## Summary
 {{ .Summary }}
 
@@ -74,11 +1167,14 @@ const markdownTmpl = `# Network Diagnostics Report
 
 ---
 *Generated by network-app*
+{{- define "dns" }}{{ .Queries }} | {{ .Responses }} | {{ .Unanswered }} ({{ printf "%.1f" .UnansweredRate }}%) | {{ .NXDomain }} ({{ printf "%.1f" .NXDomainRate }}%) | {{ .ServFail }} ({{ printf "%.1f" .ServFailRate }}%) | {{ .Refused }} ({{ printf "%.1f" .RefusedRate }}%) | {{ .Truncated }} | {{ printf "%.2f" .Latency.P50Ms }} ms | {{ printf "%.2f" .Latency.P99Ms }} ms{{ end }}
+{{- define "counts" }}{{ range $i, $n := . }}{{ if $i }}, {{ end }}{{ $n.Name }} ({{ $n.Count }}){{ end }}{{ end }}
+{{- define "http" }}{{ .Requests }} | {{ .Responses }} | {{ .ClientErrors }} | {{ .ServerErrors }} | {{ printf "%.2f" .TTFB.P50Ms }} ms | {{ printf "%.2f" .TTFB.P99Ms }} ms | {{ printf "%.2f" .ResponseTime.P50Ms }} ms | {{ printf "%.2f" .ResponseTime.P99Ms }} ms | {{ printf "%.2f" .ResponseTime.MaxMs }} ms{{ end }}
+{{- define "latency" }}{{ .Samples }} | {{ printf "%.2f" .P50Ms }} ms | {{ printf "%.2f" .P90Ms }} ms | {{ printf "%.2f" .P99Ms }} ms | {{ printf "%.2f" .MaxMs }} ms{{ end }}
 `
 
-func init() {
-	template.Must(template.New("report").Funcs(template.FuncMap{"join": stringsJoin}).Parse(markdownTmpl))
-}
+// markdown is the parsed report template
+var markdown = template.Must(template.New("report").Funcs(template.FuncMap{"join": stringsJoin}).Parse(markdownTmpl))
 
 func stringsJoin(a []string, sep string) string {
 	if len(a) == 0 {
@@ -93,14 +1189,10 @@ func stringsJoin(a []string, sep string) string {
 
 // ToMarkdown writes diagnostic result as Markdown
 func ToMarkdown(r *DiagnosticResult, path string) error {
-	tmpl, err := template.New("report").Parse(markdownTmpl)
-	if err != nil {
-		return fmt.Errorf("template.Parse: %w", err)
-	}
 	f, err := os.Create(path)
 	if err != nil {
 		return fmt.Errorf("os.Create: %w", err)
 	}
 	defer f.Close()
-	return tmpl.Execute(f, r)
-}
\ No newline at end of file
+	return markdown.Execute(f, r)
+}
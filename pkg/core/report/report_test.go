@@ -1,8 +1,10 @@
 package report
 
 import (
+	"encoding/json"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 )
@@ -90,13 +92,669 @@ func TestToMarkdown(t *testing.T) {
 	}
 }
 
+// renderMarkdown writes result as Markdown and returns the document
+func renderMarkdown(t *testing.T, result *DiagnosticResult) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "result.md")
+	if err := ToMarkdown(result, path); err != nil {
+		t.Fatalf("ToMarkdown failed: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile failed: %v", err)
+	}
+	return string(data)
+}
+
 func TestDiagnosticResultDefault(t *testing.T) {
 	result := DiagnosticResult{}
 	// Verify zero values are as expected
-	if result.Timestamp.IsZero() {
+	if !result.Timestamp.IsZero() {
 		t.Error("Timestamp should be zero")
 	}
 	if len(result.Interfaces) != 0 {
 		t.Error("Interfaces should be empty")
 	}
-}
\ No newline at end of file
+	if len(result.CaptureFiles) != 0 {
+		t.Error("CaptureFiles should be empty")
+	}
+}
+
+func TestToMarkdownCaptureFiles(t *testing.T) {
+	result := DiagnosticResult{
+		Timestamp:    time.Date(2026, 2, 20, 10, 30, 0, 0, time.UTC),
+		CaptureFiles: []string{"/tmp/cap-00001.pcapng", "/tmp/cap-00002.pcapng"},
+	}
+
+	md := renderMarkdown(t, &result)
+	for _, want := range []string{"## Capture Files", "- /tmp/cap-00001.pcapng", "- /tmp/cap-00002.pcapng"} {
+		if !strings.Contains(md, want) {
+			t.Errorf("Markdown missing %q", want)
+		}
+	}
+}
+
+func TestToMarkdownPerInterface(t *testing.T) {
+	result := DiagnosticResult{
+		Timestamp:  time.Date(2026, 2, 20, 10, 30, 0, 0, time.UTC),
+		Interfaces: []string{"eth0", "eth1"},
+		PerInterface: []InterfaceStats{
+			{Name: "eth0", PacketsCaptured: 10, Bytes: 1500, TCPStats: TCPHandshake{SynSent: 4, SynAckRcvd: 2, SynAckRatio: 50, Completed: 2, Unanswered: 2}},
+			{Name: "eth1", PacketsCaptured: 3, Bytes: 180},
+		},
+	}
+
+	md := renderMarkdown(t, &result)
+	for _, want := range []string{
+		"**Interfaces:** eth0, eth1",
+		"## Per-Interface Statistics",
+		"| eth0 | 10 | 1500 | 0.00% | 4 | 2 | 0 | 2 | 0 |",
+		"| eth1 | 3 | 180 | 0.00% | 0 | 0 | 0 | 0 | 0 |",
+	} {
+		if !strings.Contains(md, want) {
+			t.Errorf("Markdown missing %q", want)
+		}
+	}
+}
+
+func TestToMarkdownDropWarnings(t *testing.T) {
+	result := DiagnosticResult{
+		Timestamp: time.Date(2026, 2, 20, 10, 30, 0, 0, time.UTC),
+		CaptureStats: CaptureStats{
+			Received:      1000,
+			KernelDropped: 120,
+			DropRate:      12,
+		},
+		Warnings: []string{"12.00% of packets were dropped"},
+	}
+
+	md := renderMarkdown(t, &result)
+	for _, want := range []string{
+		"## Warnings",
+		"12.00% of packets were dropped",
+		"| Dropped by kernel | 120 |",
+		"| Drop rate | 12.00% |",
+	} {
+		if !strings.Contains(md, want) {
+			t.Errorf("Markdown missing %q", want)
+		}
+	}
+}
+
+func TestToMarkdownHandshakeOutcomes(t *testing.T) {
+	result := DiagnosticResult{
+		Timestamp: time.Date(2026, 2, 20, 10, 30, 0, 0, time.UTC),
+		TCPStats: TCPHandshake{
+			SynSent:        10,
+			Completed:      6,
+			NoAck:          1,
+			Unanswered:     2,
+			Reset:          1,
+			Evicted:        3,
+			SynRetransmits: 4,
+		},
+	}
+
+	md := renderMarkdown(t, &result)
+	for _, want := range []string{
+		"| Connection attempts | 10 |",
+		"| Completed (SYN → SYN-ACK → ACK) | 6 |",
+		"| SYN-ACKed but never ACKed | 1 |",
+		"| Unanswered SYN | 2 |",
+		"| Reset during handshake | 1 |",
+		"| Evicted from the full flow table | 3 |",
+		"| SYN retransmissions | 4 |",
+	} {
+		if !strings.Contains(md, want) {
+			t.Errorf("Markdown missing %q", want)
+		}
+	}
+}
+
+func TestToMarkdownLatency(t *testing.T) {
+	summary := LatencySummary{Samples: 3, P50Ms: 20, P90Ms: 40, P99Ms: 40, MaxMs: 40,
+		Histogram: []LatencyBucket{{LowMs: 16.384, HighMs: 32.767, Count: 2}, {LowMs: 32.768, HighMs: 65.535, Count: 1}}}
+	result := DiagnosticResult{
+		Timestamp: time.Date(2026, 2, 20, 10, 30, 0, 0, time.UTC),
+		Latency: Latency{
+			SynAck:    summary,
+			Ack:       LatencySummary{Samples: 3, P50Ms: 1, P90Ms: 2, P99Ms: 2, MaxMs: 2},
+			Skipped:   1,
+			PerServer: []ServerLatency{{Server: "93.184.216.34:443", SynAck: summary}},
+		},
+	}
+
+	md := renderMarkdown(t, &result)
+	for _, want := range []string{
+		"## Handshake Latency",
+		"| all | 3 | 20.00 ms | 40.00 ms | 40.00 ms | 40.00 ms | 3 | 1.00 ms | 2.00 ms | 2.00 ms | 2.00 ms |",
+		"| 93.184.216.34:443 | 3 | 20.00 ms | 40.00 ms | 40.00 ms | 40.00 ms | 0 | 0.00 ms |",
+		"1 samples were skipped",
+		"| 16.384–32.767 ms | 2 |",
+	} {
+		if !strings.Contains(md, want) {
+			t.Errorf("Markdown missing %q\n%s", want, md)
+		}
+	}
+
+	// no samples, no section
+	result.Latency = Latency{}
+	if strings.Contains(renderMarkdown(t, &result), "Handshake Latency") {
+		t.Error("latency section rendered without samples")
+	}
+}
+
+func TestToMarkdownDNS(t *testing.T) {
+	counters := DNSCounters{Queries: 11, Responses: 8, Unanswered: 2, UnansweredRate: 20, Pending: 1, NXDomain: 2, NXDomainRate: 25,
+		Latency: LatencySummary{Samples: 8, P50Ms: 12, P99Ms: 80}}
+	result := DiagnosticResult{
+		Timestamp: time.Date(2026, 2, 20, 10, 30, 0, 0, time.UTC),
+		DNS: DNS{
+			DNSCounters: counters,
+			PerResolver: []DNSResolver{{
+				Resolver:    "192.168.1.1:53",
+				DNSCounters: counters,
+				TopNames:    []NameCount{{Name: "example.com", Count: 6}, {Name: "example.org", Count: 4}},
+			}},
+		},
+	}
+
+	md := renderMarkdown(t, &result)
+	for _, want := range []string{
+		"## DNS Resolution",
+		"| all | 11 | 8 | 2 (20.0%) | 2 (25.0%) | 0 (0.0%) | 0 (0.0%) | 0 | 12.00 ms | 80.00 ms |",
+		"| 192.168.1.1:53 | 11 | 8 |",
+		"1 queries were still waiting for an answer when the capture ended.",
+		"- **192.168.1.1:53:** example.com (6), example.org (4)",
+	} {
+		if !strings.Contains(md, want) {
+			t.Errorf("Markdown missing %q", want)
+		}
+	}
+	if i := strings.Index(md, "## DNS Resolution"); i < strings.Index(md, "## TCP Handshake Analysis") || i > strings.Index(md, "## Connection Tracking") {
+		t.Error("DNS should come after the handshake section")
+	}
+}
+
+func TestToMarkdownTLS(t *testing.T) {
+	result := DiagnosticResult{
+		Timestamp: time.Date(2026, 2, 20, 10, 30, 0, 0, time.UTC),
+		TLS: TLS{
+			Handshakes: 5,
+			Failed:     2,
+			Pending:    1,
+			Expired:    1,
+			Versions:   []NameCount{{Name: "TLS 1.3", Count: 3}, {Name: "TLS 1.2", Count: 1}},
+			Alerts:     []NameCount{{Name: "fatal certificate_expired", Count: 1}},
+			Servers: []TLSServer{{
+				Name:        "old.example",
+				Handshakes:  1,
+				Failed:      1,
+				Failures:    []NameCount{{Name: "fatal certificate_expired", Count: 1}},
+				Version:     "TLS 1.2",
+				CipherSuite: "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256",
+				Certificates: []TLSCertificate{{
+					Subject:  "old.example",
+					Issuer:   "Example CA",
+					NotAfter: time.Date(2026, 1, 31, 0, 0, 0, 0, time.UTC),
+				}},
+				Expired: true,
+			}},
+			Fingerprints: []TLSFingerprint{{JA4: "t13d1516h2_8daaf6152771_02713d6af862", JA3: "773906b0efdefa24a7f2b8eb6985bf37", Count: 4, SNI: "example.com"}},
+		},
+	}
+
+	md := renderMarkdown(t, &result)
+	for _, want := range []string{
+		"## TLS Handshakes",
+		"| Failed | 2 |",
+		"| Unanswered when the capture ended | 1 |",
+		"| Negotiated versions | TLS 1.3 (3), TLS 1.2 (1) |",
+		"| Alerts | fatal certificate_expired (1) |",
+		"| old.example | 1 | 1 | fatal certificate_expired (1) | TLS 1.2 | TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 | old.example, issued by Example CA, valid until 2026-01-31 **(expired)** |",
+		"| t13d1516h2_8daaf6152771_02713d6af862 | 773906b0efdefa24a7f2b8eb6985bf37 | 4 | example.com |",
+	} {
+		if !strings.Contains(md, want) {
+			t.Errorf("Markdown missing %q", want)
+		}
+	}
+	if i := strings.Index(md, "## TLS Handshakes"); i < strings.Index(md, "## TCP Handshake Analysis") || i > strings.Index(md, "## Connection Tracking") {
+		t.Error("TLS should come after the handshake section")
+	}
+}
+
+func TestToMarkdownHTTP(t *testing.T) {
+	counters := HTTPCounters{Requests: 12, Responses: 11, ClientErrors: 1, ServerErrors: 2,
+		TTFB:         LatencySummary{Samples: 11, P50Ms: 20, P99Ms: 250},
+		ResponseTime: LatencySummary{Samples: 11, P50Ms: 25, P99Ms: 300, MaxMs: 310}}
+	result := DiagnosticResult{
+		Timestamp: time.Date(2026, 2, 20, 10, 30, 0, 0, time.UTC),
+		HTTP: HTTP{
+			HTTPCounters:             counters,
+			Connections:              4,
+			ReusedConnections:        2,
+			ReuseRate:                50,
+			RequestsPerConnection:    3,
+			MaxRequestsPerConnection: 6,
+			Pending:                  1,
+			Lost:                     1,
+			StatusCodes:              []StatusCount{{Code: 200, Count: 8}, {Code: 503, Count: 2}, {Code: 404, Count: 1}},
+			Endpoints:                []HTTPEndpoint{{Host: "api.internal", Path: "/v1/orders", HTTPCounters: counters}},
+		},
+	}
+
+	md := renderMarkdown(t, &result)
+	for _, want := range []string{
+		"## HTTP Transactions",
+		"| Unanswered | 0 |",
+		"| Unanswered when the capture ended | 1 |",
+		"| Requests per connection | 3.00 (max 6) |",
+		"| Reused connections | 2 (50.0%) |",
+		"| Status codes | 200 (8), 503 (2), 404 (1) |",
+		"| all | | 12 | 11 | 1 | 2 | 20.00 ms | 250.00 ms | 25.00 ms | 300.00 ms | 310.00 ms |",
+		"| api.internal | /v1/orders | 12 |",
+		"1 connections could not be followed to the end",
+	} {
+		if !strings.Contains(md, want) {
+			t.Errorf("Markdown missing %q", want)
+		}
+	}
+}
+
+func TestToMarkdownICMP(t *testing.T) {
+	result := DiagnosticResult{
+		Timestamp: time.Date(2026, 2, 20, 10, 30, 0, 0, time.UTC),
+		ICMP: ICMP{
+			Total:    4,
+			Messages: []NameCount{{Name: "packet too big", Count: 3}, {Name: "port unreachable", Count: 1}},
+			Networks: []ICMPNetwork{{
+				Network:    "93.184.216.0/24",
+				Total:      3,
+				Messages:   []NameCount{{Name: "packet too big", Count: 3}},
+				Flows:      2,
+				Example:    "TCP 192.168.1.10:54321 → 93.184.216.34:443",
+				Reporters:  []string{"10.0.0.1", "10.0.0.2"},
+				MinMTU:     1400,
+				BlackHoles: 1,
+			}},
+			BlackHoles: []BlackHole{{
+				Sender:      "93.184.216.34:443",
+				Receiver:    "192.168.1.10:54321",
+				Size:        1448,
+				Retransmits: 3,
+				Start:       time.Date(2026, 2, 20, 10, 29, 1, 250e6, time.UTC),
+				Recovered:   true,
+			}},
+		},
+	}
+
+	md := renderMarkdown(t, &result)
+	for _, want := range []string{
+		"## ICMP Errors",
+		"4 error messages: packet too big (3), port unreachable (1).",
+		"| 93.184.216.0/24 | 3 | packet too big (3) | 2 | 10.0.0.1, 10.0.0.2 | 1400 | 1 | TCP 192.168.1.10:54321 → 93.184.216.34:443 |",
+		"- **93.184.216.34:443 → 192.168.1.10:54321:** 1448-byte segment retransmitted 3 times from 10:29:01.250, got through once resent smaller",
+	} {
+		if !strings.Contains(md, want) {
+			t.Errorf("Markdown missing %q", want)
+		}
+	}
+}
+
+func TestToMarkdownQUIC(t *testing.T) {
+	result := DiagnosticResult{
+		Timestamp: time.Date(2026, 2, 20, 10, 30, 0, 0, time.UTC),
+		QUIC: QUIC{
+			Connections:   4,
+			Completed:     2,
+			Failed:        1,
+			NoResponse:    1,
+			Versions:      []NameCount{{Name: "QUIC v1", Count: 3}, {Name: "QUIC v2", Count: 1}},
+			ALPN:          []NameCount{{Name: "h3", Count: 4}},
+			Errors:        []NameCount{{Name: "CRYPTO_ERROR (fatal no_application_protocol)", Count: 1}},
+			HandshakeTime: LatencySummary{Samples: 2, P50Ms: 30, P99Ms: 60, MaxMs: 60},
+			Servers: []QUICServer{{
+				Name:     "v2.example",
+				Address:  "203.0.113.5:443",
+				Attempts: 1,
+				Failed:   1,
+				Version:  "QUIC v2",
+				ALPN:     []string{"h3", "h3-29"},
+				Errors:   []NameCount{{Name: "CRYPTO_ERROR (fatal no_application_protocol)", Count: 1}},
+			}},
+		},
+	}
+
+	md := renderMarkdown(t, &result)
+	for _, want := range []string{
+		"## QUIC Connections",
+		"| Versions | QUIC v1 (3), QUIC v2 (1) |",
+		"| Close errors | CRYPTO_ERROR (fatal no_application_protocol) (1) |",
+		"| Handshake time | p50 30.00 ms, p99 60.00 ms, max 60.00 ms |",
+		"| v2.example | 203.0.113.5:443 | 1 | 0 | 1 | 0 | QUIC v2 | h3, h3-29 | CRYPTO_ERROR (fatal no_application_protocol) (1) |",
+		"UDP port 443 is blocked",
+	} {
+		if !strings.Contains(md, want) {
+			t.Errorf("Markdown missing %q", want)
+		}
+	}
+	if strings.Contains(md, "Version mismatch") {
+		t.Error("Markdown lists version mismatches although there were none")
+	}
+}
+
+func TestToMarkdownUDP(t *testing.T) {
+	result := DiagnosticResult{
+		Timestamp: time.Date(2026, 2, 20, 10, 30, 0, 0, time.UTC),
+		UDP: UDP{
+			Flows:              6,
+			OneWay:             2,
+			OneWayRate:         40,
+			Multicast:          1,
+			PacketsSent:        10,
+			BytesSent:          2130,
+			PacketsReceived:    3,
+			BytesReceived:      1748,
+			Sizes:              []NameCount{{Name: "0-63", Count: 5}, {Name: "1473+", Count: 1}},
+			ConntrackFlows:     3,
+			ConntrackUnreplied: 2,
+			Confirmed:          1,
+			Destinations:       []UDPDestination{{Server: "198.51.100.7:51820", Flows: 2, OneWay: 2, Packets: 4, ConntrackUnreplied: 1}},
+			TopFlows: []UDPFlow{
+				{Client: "192.168.1.10:40000", Server: "192.168.1.1:53", PacketsSent: 1, BytesSent: 40, PacketsReceived: 1, BytesReceived: 200, MaxSize: 200, DurationMs: 10, Conntrack: "replied"},
+				{Client: "192.168.1.10:40001", Server: "198.51.100.7:51820", PacketsSent: 3, BytesSent: 444, MaxSize: 148, DurationMs: 10000, OneWay: true, Conntrack: "unreplied"},
+			},
+		},
+	}
+
+	md := renderMarkdown(t, &result)
+	for _, want := range []string{
+		"## UDP Flows",
+		"| One-way (never answered) | 2 (40.0%) |",
+		"| Datagram sizes | 0-63 (5), 1473+ (1) |",
+		"| Conntrack UDP entries | 3 (2 unreplied) |",
+		"| 198.51.100.7:51820 | 2 | 2 | 4 | 1 |",
+		"| 192.168.1.10:40000 | 192.168.1.1:53 | 1 (40 B) | 1 (200 B) | 200 | 10.0 ms | replied |",
+		"| 192.168.1.10:40001 | 198.51.100.7:51820 | 3 (444 B) | none | 148 | 10000.0 ms | unreplied |",
+	} {
+		if !strings.Contains(md, want) {
+			t.Errorf("Markdown missing %q", want)
+		}
+	}
+}
+
+func TestToMarkdownNeighbor(t *testing.T) {
+	result := DiagnosticResult{
+		Timestamp: time.Date(2026, 2, 20, 10, 30, 0, 0, time.UTC),
+		Neighbor: Neighbor{
+			ARPRequests: 67,
+			Probes:      1,
+			Answered:    2,
+			Unanswered:  1,
+			Unobserved:  62,
+			Gratuitous:  25,
+			Storms:      1,
+			Flooding:    1,
+			ResolveTime: LatencySummary{Samples: 2, P50Ms: 1, P99Ms: 2, MaxMs: 2},
+			Conflicts: []NeighborConflict{
+				{IP: "192.168.1.30", MACs: []string{"02:00:00:00:00:1e", "02:00:00:00:00:1f"}, First: time.Date(2026, 2, 20, 10, 0, 4, 1e6, time.UTC)},
+				{IP: "192.168.1.40", MACs: []string{"02:00:00:00:00:28", "02:00:00:00:00:29"}, DAD: true, First: time.Date(2026, 2, 20, 10, 0, 5, 1e6, time.UTC)},
+			},
+			Hosts: []NeighborHost{
+				{IP: "192.168.1.10", MAC: "02:00:00:00:00:0a", Requests: 5, Answered: 2, Unanswered: 1, Targets: []string{"192.168.1.50"}, PeakRate: 1, Visible: true},
+				{IP: "192.168.1.70", MAC: "02:00:00:00:00:46", Requests: 60, Unanswered: 60, PeakRate: 60, Flooding: true},
+			},
+		},
+	}
+
+	md := renderMarkdown(t, &result)
+	for _, want := range []string{
+		"## ARP and Neighbor Discovery",
+		"| Unanswered, answer possibly not captured | 62 |",
+		"| Resolution time | p50 1.00 ms, p99 2.00 ms, max 2.00 ms |",
+		"| 192.168.1.30 | 02:00:00:00:00:1e, 02:00:00:00:00:1f | conflicting claims | 10:00:04.001 |",
+		"| 192.168.1.40 | 02:00:00:00:00:28, 02:00:00:00:00:29 | duplicate address detection | 10:00:05.001 |",
+		"VRRP",
+		"| 192.168.1.10 | 02:00:00:00:00:0a | 5 | 2 | 1 | 1/s | 0 | 192.168.1.50 |",
+		"| 192.168.1.70 | 02:00:00:00:00:46 | 60 | 0 | 60 (answers not captured) | 60/s (flooding) | 0 |  |",
+	} {
+		if !strings.Contains(md, want) {
+			t.Errorf("Markdown missing %q", want)
+		}
+	}
+}
+
+func TestToMarkdownDHCP(t *testing.T) {
+	result := DiagnosticResult{
+		Timestamp: time.Date(2026, 2, 20, 10, 30, 0, 0, time.UTC),
+		DHCP: DHCP{
+			Exchanges:   5,
+			Acked:       1,
+			Naks:        1,
+			NoOffer:     1,
+			NoAck:       1,
+			Pending:     1,
+			Renewals:    1,
+			Retransmits: 3,
+			Competing:   1,
+			Messages:    []NameCount{{Name: "DHCPDISCOVER", Count: 7}, {Name: "DHCPOFFER", Count: 4}},
+			AcquireTime: LatencySummary{Samples: 1, P50Ms: 12, P99Ms: 12, MaxMs: 12},
+			Servers: []DHCPServer{
+				{ID: "10.0.0.1", Address: "10.0.0.1", Version: "DHCPv4", Offers: 2, Acks: 1, Naks: 1, Competing: 1, Offered: "10.0.0.101",
+					Subnet: "10.0.0.0/24", Routers: []string{"10.0.0.1"}, DNS: []string{"10.0.0.53", "1.1.1.1"}, Domain: "example.lan", LeaseSecs: 3600},
+				{ID: "10.0.0.66", Address: "10.0.0.66", Version: "DHCPv4", Offers: 1, Competing: 1, Offered: "192.168.100.50", Routers: []string{"10.0.0.66"}},
+			},
+			Clients: []DHCPClient{
+				{ID: "02:00:00:00:00:14", Exchanges: 1, NoOffer: 1, Retransmits: 2, Last: "no offer"},
+			},
+		},
+	}
+
+	md := renderMarkdown(t, &result)
+	for _, want := range []string{
+		"## DHCP",
+		"| No offer | 1 |",
+		"| Renewals and reboots | 1 |",
+		"| Offered by more than one server | 1 |",
+		"| Messages | DHCPDISCOVER (7), DHCPOFFER (4) |",
+		"| Lease acquisition time | p50 12.00 ms, p99 12.00 ms, max 12.00 ms |",
+		"| 10.0.0.1 | 10.0.0.1 | DHCPv4 | 2 | 1 | 1 | 1 | 10.0.0.101 | 10.0.0.0/24 | 10.0.0.1 | 10.0.0.53, 1.1.1.1 | example.lan | 3600 s |",
+		"| 10.0.0.66 | 10.0.0.66 | DHCPv4 | 1 | 0 | 0 | 1 | 192.168.100.50 |  | 10.0.0.66 |  |  |  |",
+		"rogue server",
+		"| 02:00:00:00:00:14 | 1 | 0 | 0 | 1 | 0 | 0 | 2 | no offer |  |",
+	} {
+		if !strings.Contains(md, want) {
+			t.Errorf("Markdown missing %q", want)
+		}
+	}
+}
+
+func TestToMarkdownFragmentation(t *testing.T) {
+	result := DiagnosticResult{
+		Timestamp: time.Date(2026, 2, 20, 10, 30, 0, 0, time.UTC),
+		Fragmentation: Fragmentation{
+			MTU: 1500, Packets: 120, MaxSize: 9000, Fragments: 7, Reassembled: 2, MaxDatagram: 4028,
+			Incomplete: 1, Overlaps: 1, DontFragment: 100, Oversized: 3, MaxOversized: 9000,
+			Paths: []MTUPath{
+				{Src: "10.0.0.1", Dst: "10.0.0.2", Packets: 50, MaxSize: 9000, MaxDF: 9000, Oversized: 3},
+				{Src: "10.0.0.3", Dst: "10.0.0.4", Packets: 4, MaxSize: 1500, Fragmented: 2, Fragments: 6},
+			},
+		},
+	}
+
+	md := renderMarkdown(t, &result)
+	for _, want := range []string{
+		"## IP Fragmentation and MTU",
+		"| Datagrams reassembled | 2 (largest 4028 bytes) |",
+		"| Incomplete (a fragment never arrived) | 1 |",
+		"| Of those, above the 1500-byte MTU | 3 (largest 9000 bytes) |",
+		"TSO/GSO or GRO aggregates",
+		"| 10.0.0.1 | 10.0.0.2 | 50 | 9000 | 9000 | 0 | 0 | 3 |",
+		"| 10.0.0.3 | 10.0.0.4 | 4 | 1500 |  | 2 | 6 | 0 |",
+	} {
+		if !strings.Contains(md, want) {
+			t.Errorf("Markdown missing %q", want)
+		}
+	}
+}
+
+func TestToMarkdownSegments(t *testing.T) {
+	result := DiagnosticResult{
+		Timestamp: time.Date(2026, 2, 20, 10, 30, 0, 0, time.UTC),
+		PerSegment: []SegmentStats{
+			{Segment: "VLAN 10 / VXLAN 5001", VLAN: 10, Tunnel: "VXLAN", VNI: 5001, Endpoints: []string{"10.0.0.1 ↔ 10.0.0.2"},
+				PacketsCaptured: 40, Bytes: 6000, TCPStats: TCPHandshake{SynSent: 3, Completed: 2, Unanswered: 1}},
+			{Segment: "VLAN 100.20", VLAN: 100, InnerVLAN: 20, PacketsCaptured: 12, Bytes: 900},
+		},
+	}
+
+	md := renderMarkdown(t, &result)
+	for _, want := range []string{
+		"## Per-VLAN and Tunnel Statistics",
+		"| VLAN 10 / VXLAN 5001 | 10.0.0.1 ↔ 10.0.0.2 | 40 | 6000 | 3 | 2 | 0 | 1 | 0 |",
+		"| VLAN 100.20 | - | 12 | 900 | 0 | 0 | 0 | 0 | 0 |",
+	} {
+		if !strings.Contains(md, want) {
+			t.Errorf("Markdown missing %q", want)
+		}
+	}
+}
+
+func TestToMarkdownStream(t *testing.T) {
+	result := DiagnosticResult{
+		Timestamp: time.Date(2026, 2, 20, 10, 30, 0, 0, time.UTC),
+		TCPStream: TCPStream{
+			StreamCounters: StreamCounters{Segments: 16, DataSegments: 9, Retransmissions: 3, FastRetransmissions: 1, DuplicateAcks: 2, RetransmissionRate: 33.33},
+			Flows: []FlowStream{{
+				Client:         "192.168.1.10:54321",
+				Server:         "93.184.216.34:443",
+				StreamCounters: StreamCounters{DataSegments: 9, Retransmissions: 3, FastRetransmissions: 1, DuplicateAcks: 2, LostSegments: 2},
+			}},
+			StalledFlows: []FlowStream{{
+				Client:         "192.168.1.11:40000",
+				Server:         "10.0.0.5:5432",
+				StreamCounters: StreamCounters{ZeroWindows: 5, ZeroWindowProbes: 2, WindowFull: 1, StallMs: 3000},
+			}},
+		},
+	}
+
+	md := renderMarkdown(t, &result)
+	for _, want := range []string{
+		"## TCP Data Phase",
+		"| Retransmissions | 3 (33.33%) |",
+		"| Duplicate ACKs | 2 |",
+		"| 192.168.1.10:54321 | 93.184.216.34:443 | 9 | 3 | 1 | 0 | 2 | 0 | 2 |",
+		"### Receive Window Stalls",
+		"| 192.168.1.11:40000 | 10.0.0.5:5432 | 5 | 2 | 1 | 3000.0 ms |",
+	} {
+		if !strings.Contains(md, want) {
+			t.Errorf("Markdown missing %q", want)
+		}
+	}
+
+	data, err := json.Marshal(&result)
+	if err != nil {
+		t.Fatalf("json.Marshal: %v", err)
+	}
+	if !strings.Contains(string(data), `"fast_retransmissions":1`) {
+		t.Errorf("JSON missing flattened stream counters: %s", data)
+	}
+}
+
+func TestToMarkdownReassembly(t *testing.T) {
+	result := DiagnosticResult{
+		Timestamp:  time.Date(2026, 2, 20, 10, 30, 0, 0, time.UTC),
+		Reassembly: Reassembly{Streams: 3, Bytes: 4096, Gaps: 1, MissingBytes: 1448, OverlapBytes: 10, DumpDir: "streams", DumpFiles: 5},
+	}
+
+	md := renderMarkdown(t, &result)
+	for _, want := range []string{
+		"## TCP Stream Reassembly",
+		"| Streams with payload | 3 |",
+		"| Gaps (segments never captured) | 1 (1448 bytes) |",
+		"5 stream files written to streams.",
+	} {
+		if !strings.Contains(md, want) {
+			t.Errorf("Markdown missing %q", want)
+		}
+	}
+}
+
+func TestToMarkdownOptionsAndMiddlebox(t *testing.T) {
+	result := DiagnosticResult{
+		Timestamp: time.Date(2026, 2, 20, 10, 30, 0, 0, time.UTC),
+		TCPOptions: TCPOptions{
+			Handshakes: 2,
+			SACK:       OptionUsage{Offered: 2, Accepted: 1},
+			ClientMSS:  []MSSCount{{MSS: 1460, Count: 2}},
+			ServerMSS:  []MSSCount{{MSS: 1380, Count: 1}, {MSS: 1360, Count: 1}},
+		},
+		Middlebox: []Middlebox{{Server: "93.184.216.34:443", Anomaly: "MSS clamped", Count: 2, ClientMSS: 1460, ServerMSS: 1380}},
+	}
+
+	md := renderMarkdown(t, &result)
+	for _, want := range []string{
+		"| SACK permitted | 2 | 1 |",
+		"- **Client MSS:** 1460 (2)",
+		"- **Server MSS:** 1380 (1), 1360 (1)",
+		"## Middlebox Interference",
+		"| 93.184.216.34:443 | MSS clamped | 2 | 1460 | 1380 |",
+	} {
+		if !strings.Contains(md, want) {
+			t.Errorf("Markdown missing %q", want)
+		}
+	}
+}
+
+func TestToMarkdownTeardown(t *testing.T) {
+	result := DiagnosticResult{
+		Timestamp: time.Date(2026, 2, 20, 10, 30, 0, 0, time.UTC),
+		Teardown: Teardown{
+			Endings: []ConnectionEnding{
+				{Ending: "graceful close", Count: 10, P50Ms: 120, P99Ms: 950.5, MaxMs: 1000},
+				{Ending: "reset by server", Count: 2, P50Ms: 3000, P99Ms: 3000, MaxMs: 3000},
+			},
+			PerServer: []ServerTeardown{{Server: "10.0.0.5:5432", Graceful: 10, ServerReset: 2, P50Ms: 130, P99Ms: 3000}},
+		},
+	}
+
+	md := renderMarkdown(t, &result)
+	for _, want := range []string{
+		"## Connection Teardown",
+		"| graceful close | 10 | 120.0 ms | 950.5 ms | 1000.0 ms |",
+		"| 10.0.0.5:5432 | 10 | 0 | 0 | 0 | 2 | 0 | 0 | 130.0 ms | 3000.0 ms |",
+	} {
+		if !strings.Contains(md, want) {
+			t.Errorf("Markdown missing %q", want)
+		}
+	}
+}
+
+func TestToMarkdownFindings(t *testing.T) {
+	start := time.Date(2026, 2, 20, 10, 30, 0, 0, time.UTC)
+	result := DiagnosticResult{
+		Timestamp: start,
+		Findings: []Finding{
+			{Kind: "SYN flood", Start: start, End: start.Add(20 * time.Second), Target: "10.0.0.1:80", Probes: 2000,
+				TopSources: []Offender{{Addr: "10.0.1.1", Count: 2}, {Addr: "10.0.1.2", Count: 2}}, Detail: "2000 half-open"},
+			{Kind: "vertical port scan", Start: start, End: start.Add(10 * time.Second), Target: "10.0.0.1", Source: "192.0.2.66", Probes: 100, Detail: "100 ports"},
+		},
+	}
+
+	md := renderMarkdown(t, &result)
+	for _, want := range []string{
+		"## Findings",
+		"| SYN flood | 10.0.0.1:80 | 10.0.1.1 (2), 10.0.1.2 (2) | 10:30:00–10:30:20 | 2000 | 2000 half-open |",
+		"| vertical port scan | 10.0.0.1 | 192.0.2.66 | 10:30:00–10:30:10 | 100 | 100 ports |",
+	} {
+		if !strings.Contains(md, want) {
+			t.Errorf("Markdown missing %q", want)
+		}
+	}
+	if strings.Index(md, "## Findings") > strings.Index(md, "## Capture Statistics") {
+		t.Error("findings should come before the statistics")
+	}
+	if n := strings.Count(md, "## Findings"); n != 1 {
+		t.Errorf("findings section rendered %d times", n)
+	}
+}
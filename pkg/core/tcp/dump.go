@@ -0,0 +1,152 @@
+package tcp
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxOpenDumps bounds the files kept open while dumping streams; the least
+// recently written one is closed and reopened for appending when needed
+const maxOpenDumps = 64
+
+// StreamDumper writes every reassembled stream to a directory, one file per
+// direction. Bytes that were never captured are left out, so a file is
+// shorter than the stream when the reassembler skipped gaps.
+type StreamDumper struct {
+	dir   string
+	open  map[*dumpFile]struct{}
+	clock uint64 // orders writes for closing the least recently used file
+	conns int    // connections dumped, numbering their files
+	files []string
+	err   error
+}
+
+// NewStreamDumper creates dir if needed and returns a dumper writing into it
+func NewStreamDumper(dir string) (*StreamDumper, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &StreamDumper{dir: dir, open: make(map[*dumpFile]struct{})}, nil
+}
+
+// Consumer is a NewConsumer writing the streams of a connection
+func (d *StreamDumper) Consumer(key FlowKey, start time.Time) Consumer {
+	// e.g. 20260220T100000.000000_1_192.168.1.10.54321-93.184.216.34.443.c2s;
+	// the number keeps a connection reusing the ports of an earlier one from
+	// overwriting its files
+	d.conns++
+	base := start.UTC().Format("20060102T150405.000000") + "_" + strconv.Itoa(d.conns) + "_" +
+		dumpName(key.Client) + "-" + dumpName(key.Server)
+	return &dumpStream{d: d, base: filepath.Join(d.dir, base)}
+}
+
+func dumpName(ap netip.AddrPort) string {
+	return strings.ReplaceAll(ap.Addr().String(), ":", "_") + "." + strconv.Itoa(int(ap.Port()))
+}
+
+// Dir returns the directory the streams are written to
+func (d *StreamDumper) Dir() string {
+	return d.dir
+}
+
+// Files returns the paths of the files written so far
+func (d *StreamDumper) Files() []string {
+	return append([]string(nil), d.files...)
+}
+
+// Close closes the files still open and returns the first error met while
+// dumping. Writing stops at the first error.
+func (d *StreamDumper) Close() error {
+	for f := range d.open {
+		d.close(f)
+	}
+	return d.err
+}
+
+// write appends data to f, opening it if needed
+func (d *StreamDumper) write(f *dumpFile, data []byte) {
+	if d.err != nil {
+		return
+	}
+	if f.file == nil {
+		if len(d.open) >= maxOpenDumps {
+			var oldest *dumpFile
+			for g := range d.open {
+				if oldest == nil || g.used < oldest.used {
+					oldest = g
+				}
+			}
+			d.close(oldest)
+		}
+		flags := os.O_WRONLY | os.O_CREATE | os.O_APPEND
+		if !f.created {
+			flags |= os.O_TRUNC
+		}
+		file, err := os.OpenFile(f.path, flags, 0644)
+		if err != nil {
+			d.err = err
+			return
+		}
+		if !f.created {
+			f.created = true
+			d.files = append(d.files, f.path)
+		}
+		f.file = file
+		d.open[f] = struct{}{}
+	}
+	d.clock++
+	f.used = d.clock
+	if _, err := f.file.Write(data); err != nil {
+		d.err = err
+	}
+}
+
+func (d *StreamDumper) close(f *dumpFile) {
+	if f.file == nil {
+		return
+	}
+	if err := f.file.Close(); err != nil && d.err == nil {
+		d.err = err
+	}
+	f.file = nil
+	delete(d.open, f)
+}
+
+// dumpFile is the file of one direction of a stream
+type dumpFile struct {
+	path    string
+	file    *os.File // nil while closed
+	created bool
+	used    uint64
+}
+
+// dumpStream writes one connection, creating the file of a direction when
+// its first bytes arrive
+type dumpStream struct {
+	d     *StreamDumper
+	base  string
+	files [2]*dumpFile // client to server, server to client
+}
+
+func (s *dumpStream) Data(c *Chunk) {
+	i, suffix := 0, ".c2s"
+	if !c.FromClient {
+		i, suffix = 1, ".s2c"
+	}
+	if s.files[i] == nil {
+		s.files[i] = &dumpFile{path: s.base + suffix}
+	}
+	s.d.write(s.files[i], c.Data)
+}
+
+func (s *dumpStream) Close() {
+	for _, f := range s.files {
+		if f != nil {
+			s.d.close(f)
+		}
+	}
+}
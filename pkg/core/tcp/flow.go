@@ -0,0 +1,315 @@
+package tcp
+
+import (
+	"net/netip"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+
+	"network-app/pkg/core/analyzer"
+)
+
+// FlowKey identifies a TCP connection. Client is the endpoint that sent the
+// first SYN.
+type FlowKey struct {
+	Client netip.AddrPort
+	Server netip.AddrPort
+}
+
+// State is the handshake state of a flow
+type State int
+
+const (
+	StateSynSent     State = iota // SYN seen
+	StateSynReceived              // SYN-ACK seen
+	StateEstablished              // final ACK of the handshake seen
+	StateReset                    // reset before the handshake completed
+	StateClosed                   // reset or closed after the handshake
+)
+
+// Outcome classifies how a connection attempt ended
+type Outcome int
+
+const (
+	OutcomeCompleted  Outcome = iota // SYN → SYN-ACK → ACK
+	OutcomeNoAck                     // SYN-ACK never acknowledged
+	OutcomeUnanswered                // SYN never answered
+	OutcomeReset                     // reset during the handshake
+	OutcomePending                   // capture ended within the handshake timeout
+	OutcomeEvicted                   // dropped in the handshake to make room in a full table
+)
+
+// Ending classifies how a connection left the flow table
+type Ending int
+
+const (
+	EndOpen        Ending = iota // still open when the capture ended
+	EndGraceful                  // FIN in both directions
+	EndHalfClosed                // FIN in one direction only
+	EndRefused                   // RST answering the SYN, e.g. port closed
+	EndClientReset               // aborted by an RST from the client
+	EndServerReset               // aborted by an RST from the server
+	EndIdle                      // silent for the idle timeout
+	numEndings
+)
+
+func (e Ending) String() string {
+	switch e {
+	case EndOpen:
+		return "open"
+	case EndGraceful:
+		return "graceful close"
+	case EndHalfClosed:
+		return "half-closed"
+	case EndRefused:
+		return "refused"
+	case EndClientReset:
+		return "reset by client"
+	case EndServerReset:
+		return "reset by server"
+	case EndIdle:
+		return "idle timeout"
+	}
+	return "unknown"
+}
+
+// Flow tracks a single TCP connection
+type Flow struct {
+	Key       FlowKey
+	State     State
+	ClientISN uint32
+	ServerISN uint32
+
+	FirstSeen   time.Time // first SYN, or first segment of a mid-stream flow
+	SynAckSeen  time.Time
+	Established time.Time
+	LastSeen    time.Time
+	ClientFin   time.Time
+	ServerFin   time.Time
+	Closed      time.Time // second FIN or first RST
+
+	MidStream bool   // already established when the capture started
+	Ending    Ending // set once the connection closed or was reset
+	expired   bool   // evicted by a timeout
+
+	prev, next *Flow // neighbours in the table's list, least recently seen first
+
+	SynRetransmits    int
+	SynAckRetransmits int
+
+	ClientOptions Options // from the SYN
+	ServerOptions Options // from the SYN-ACK
+
+	// data phase state, maintained by StreamAnalyzer when the Analyzer
+	// tracking the flow feeds one
+	Stream StreamStats
+	dirs   [2]direction // client to server, server to client
+}
+
+// outcome classifies the flow once it leaves the table
+func (f *Flow) outcome() Outcome {
+	switch f.State {
+	case StateEstablished:
+		return OutcomeCompleted
+	case StateClosed:
+		if f.Established.IsZero() {
+			return OutcomeReset
+		}
+		return OutcomeCompleted
+	case StateSynReceived:
+		return OutcomeNoAck
+	case StateReset:
+		return OutcomeReset
+	}
+	return OutcomeUnanswered
+}
+
+// done reports whether the connection was closed or reset
+func (f *Flow) done() bool {
+	return !f.Closed.IsZero()
+}
+
+// inHandshake reports whether the flow has not yet completed or failed
+func (f *Flow) inHandshake() bool {
+	return f.State == StateSynSent || f.State == StateSynReceived
+}
+
+// Config holds flow table limits
+type Config struct {
+	HandshakeTimeout time.Duration // flows stuck in the handshake are evicted after this
+	IdleTimeout      time.Duration // established flows are evicted after this much silence
+	MaxFlows         int           // the least recently seen flow is evicted beyond this
+}
+
+// closeLinger is how long a closed or reset flow stays in the table so that
+// trailing ACKs and retransmitted FINs are not taken for a new connection
+const closeLinger = 5 * time.Second
+
+// DefaultConfig matches the Linux SYN retry budget and conntrack-like idle limits
+var DefaultConfig = Config{
+	HandshakeTimeout: 30 * time.Second,
+	IdleTimeout:      5 * time.Minute,
+	MaxFlows:         100000,
+}
+
+// FlowTable holds the connections currently being tracked. Time is driven
+// by packet timestamps, so captures replayed from files expire flows exactly
+// as a live capture would.
+type FlowTable struct {
+	cfg      Config
+	flows    map[FlowKey]*Flow
+	oldest   *Flow // least recently seen flow, evicted first when full
+	newest   *Flow
+	clock    analyzer.Clock
+	evict    func(*Flow, Outcome)
+	overflow int // flows evicted to respect MaxFlows
+}
+
+// NewFlowTable creates an empty table. evict is called for every flow that
+// leaves the table together with its outcome.
+func NewFlowTable(cfg Config, evict func(*Flow, Outcome)) *FlowTable {
+	return &FlowTable{cfg: cfg, flows: make(map[FlowKey]*Flow), evict: evict}
+}
+
+// Len returns the number of tracked flows
+func (t *FlowTable) Len() int {
+	return len(t.flows)
+}
+
+// lookup finds the flow a segment from src to dst belongs to
+func (t *FlowTable) lookup(src, dst netip.AddrPort) (f *Flow, fromClient bool) {
+	if f := t.flows[FlowKey{Client: src, Server: dst}]; f != nil {
+		return f, true
+	}
+	if f := t.flows[FlowKey{Client: dst, Server: src}]; f != nil {
+		return f, false
+	}
+	return nil, false
+}
+
+// full reports whether inserting a flow would evict another
+func (t *FlowTable) full() bool {
+	return t.cfg.MaxFlows > 0 && len(t.flows) >= t.cfg.MaxFlows
+}
+
+// insert starts tracking a new flow, making room if the table is full.
+// Handshakes evicted this way are reported as OutcomeEvicted: a full table
+// says nothing about whether the server would have answered.
+func (t *FlowTable) insert(f *Flow) {
+	if t.full() {
+		o := t.oldest
+		if o.inHandshake() {
+			t.removeAs(o, OutcomeEvicted)
+		} else {
+			t.remove(o)
+		}
+		t.overflow++
+	}
+	t.flows[f.Key] = f
+	t.push(f)
+}
+
+// touch records a segment of f seen at ts, making it the newest flow
+func (t *FlowTable) touch(f *Flow, ts time.Time) {
+	f.LastSeen = ts
+	if t.newest != f {
+		t.unlink(f)
+		t.push(f)
+	}
+}
+
+// push appends f to the list as the newest flow
+func (t *FlowTable) push(f *Flow) {
+	f.prev, f.next = t.newest, nil
+	if t.newest != nil {
+		t.newest.next = f
+	} else {
+		t.oldest = f
+	}
+	t.newest = f
+}
+
+// unlink takes f out of the list
+func (t *FlowTable) unlink(f *Flow) {
+	if f.prev != nil {
+		f.prev.next = f.next
+	} else {
+		t.oldest = f.next
+	}
+	if f.next != nil {
+		f.next.prev = f.prev
+	} else {
+		t.newest = f.prev
+	}
+	f.prev, f.next = nil, nil
+}
+
+// remove stops tracking f and reports its outcome
+func (t *FlowTable) remove(f *Flow) {
+	t.removeAs(f, f.outcome())
+}
+
+func (t *FlowTable) removeAs(f *Flow, o Outcome) {
+	delete(t.flows, f.Key)
+	t.unlink(f)
+	if t.evict != nil {
+		t.evict(f, o)
+	}
+}
+
+// advance moves the table clock and expires timed out flows about once per
+// second of capture time
+func (t *FlowTable) advance(ts time.Time) {
+	if !t.clock.Advance(ts) {
+		return
+	}
+	now := t.clock.Now()
+	for _, f := range t.flows {
+		idle := now.Sub(f.LastSeen)
+		switch {
+		case f.done() && idle >= closeLinger:
+			t.remove(f)
+		case f.inHandshake() && now.Sub(f.FirstSeen) >= t.cfg.HandshakeTimeout ||
+			t.cfg.IdleTimeout > 0 && idle >= t.cfg.IdleTimeout:
+			f.expired = true
+			t.remove(f)
+		}
+	}
+}
+
+// flush removes every remaining flow. Handshakes younger than the timeout
+// are reported as pending rather than failed.
+func (t *FlowTable) flush() {
+	for _, f := range t.flows {
+		if f.inHandshake() && t.clock.Now().Sub(f.FirstSeen) < t.cfg.HandshakeTimeout {
+			t.removeAs(f, OutcomePending)
+			continue
+		}
+		t.remove(f)
+	}
+}
+
+// midStreamKey guesses the roles of a flow joined after its handshake: the
+// endpoint with the lower port is taken as the server
+func midStreamKey(src, dst netip.AddrPort) (key FlowKey, fromClient bool) {
+	if src.Port() < dst.Port() {
+		return FlowKey{Client: dst, Server: src}, false
+	}
+	return FlowKey{Client: src, Server: dst}, true
+}
+
+// endpoints extracts the TCP layer and both endpoints of a packet
+func endpoints(pkt gopacket.Packet) (tcp *layers.TCP, src, dst netip.AddrPort, ok bool) {
+	tcp, ok = pkt.Layer(layers.LayerTypeTCP).(*layers.TCP)
+	if !ok {
+		return nil, src, dst, false
+	}
+	srcIP, dstIP, ok := analyzer.Addrs(pkt.NetworkLayer())
+	if !ok {
+		return nil, src, dst, false
+	}
+	src = netip.AddrPortFrom(srcIP, uint16(tcp.SrcPort))
+	dst = netip.AddrPortFrom(dstIP, uint16(tcp.DstPort))
+	return tcp, src, dst, true
+}
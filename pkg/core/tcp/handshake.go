@@ -2,52 +2,260 @@
 package tcp
 
 import (
+	"time"
+
 	"github.com/google/gopacket"
-	"github.com/google/gopacket/layers"
 )
 
-// HandshakeStats holds SYN/SYN-ACK/RST counters
+// HandshakeStats holds per-connection handshake outcomes. SYN and SYN-ACK
+// counts are per connection, so retransmissions do not inflate them.
 type HandshakeStats struct {
-	SynSent     int
-	SynAckRcvd  int
-	RstRcvd     int
+	SynSent     int     // connection attempts
+	SynAckRcvd  int     // attempts answered with a SYN-ACK
+	RstRcvd     int     // RST segments seen
 	SynAckRatio float64 // (SynAckRcvd / SynSent) * 100
+
+	Completed  int // SYN → SYN-ACK → ACK
+	NoAck      int // SYN-ACK sent but never acknowledged
+	Unanswered int // SYN never answered
+	Reset      int // reset during the handshake
+	Pending    int // still in the handshake when the capture ended
+
+	SynRetransmits    int
+	SynAckRetransmits int
+	LatencySkipped    int // latency samples skipped after a retransmission
+	Evicted           int // flows dropped because the table was full
+	EvictedHandshakes int // of those, still in the handshake; not counted as any outcome
+}
+
+// Analyzer follows TCP connections through a flow table
+type Analyzer struct {
+	flows   *FlowTable
+	stats   HandshakeStats
+	latency latencyTable
+	options optionTable
+	life    lifecycleTable
+	streams *StreamAnalyzer // nil unless the data phase is followed
+}
+
+// NewAnalyzer creates an analyzer with the given flow table limits
+func NewAnalyzer(cfg Config) *Analyzer {
+	a := &Analyzer{}
+	a.flows = NewFlowTable(cfg, a.evicted)
+	return a
+}
+
+// Streams makes the analyzer follow the data phase of the flows it tracks
+// and returns the StreamAnalyzer that collects it. Call it before the first
+// packet.
+func (a *Analyzer) Streams() *StreamAnalyzer {
+	if a.streams == nil {
+		a.streams = &StreamAnalyzer{}
+	}
+	return a.streams
 }
 
 // AnalyzeHandshake processes packets and tracks handshake state
 func AnalyzeHandshake(packets <-chan interface{}) HandshakeStats {
-	var stats HandshakeStats
+	a := NewAnalyzer(DefaultConfig)
 	for raw := range packets {
 		pkt, ok := raw.(gopacket.Packet)
 		if !ok {
 			continue
 		}
-		stats.process(pkt)
+		a.Process(pkt)
 	}
-	if stats.SynSent > 0 {
-		stats.SynAckRatio = float64(stats.SynAckRcvd) / float64(stats.SynSent) * 100
-	}
-	return stats
+	a.Finish()
+	return a.Stats()
 }
 
-func (s *HandshakeStats) process(pkt gopacket.Packet) {
-	tcpLayer := pkt.Layer(layers.LayerTypeTCP)
-	if tcpLayer == nil {
-		return
-	}
-	tcp, ok := tcpLayer.(*layers.TCP)
+// Process updates the flow table with a single packet
+func (a *Analyzer) Process(pkt gopacket.Packet) {
+	tcp, src, dst, ok := endpoints(pkt)
 	if !ok {
 		return
 	}
+	ts := pkt.Metadata().Timestamp
+	a.flows.advance(ts)
 
-	switch tcp.SYN {
-	case true:
-		if tcp.ACK {
-			s.SynAckRcvd++
-		} else {
-			s.SynSent++
+	if tcp.RST {
+		a.stats.RstRcvd++
+	}
+	f, fromClient := a.flows.lookup(src, dst)
+	if f == nil && !tcp.SYN && !tcp.RST && !a.flows.full() {
+		// joined mid-stream: follow the connection to see how it ends, but
+		// never at the cost of a handshake being tracked
+		var key FlowKey
+		key, fromClient = midStreamKey(src, dst)
+		f = &Flow{Key: key, State: StateEstablished, FirstSeen: ts, MidStream: true}
+		a.flows.insert(f)
+	}
+	if f != nil {
+		a.flows.touch(f, ts)
+		if tcp.FIN {
+			a.fin(f, fromClient, ts)
+		}
+	}
+
+	switch {
+	case tcp.SYN && !tcp.ACK:
+		if f != nil && fromClient && f.inHandshake() && tcp.Seq == f.ClientISN {
+			f.SynRetransmits++
+			break
+		}
+		if f != nil {
+			// a new SYN on a known tuple starts a new connection
+			a.flows.remove(f)
+		}
+		f, fromClient = &Flow{
+			Key:       FlowKey{Client: src, Server: dst},
+			State:     StateSynSent,
+			ClientISN: tcp.Seq,
+			FirstSeen: ts,
+			LastSeen:  ts,
+
+			ClientOptions: parseOptions(tcp),
+		}, true
+		a.flows.insert(f)
+		a.stats.SynSent++
+
+	case tcp.SYN && tcp.ACK:
+		if f == nil || fromClient || tcp.Ack != f.ClientISN+1 {
+			break
+		}
+		switch f.State {
+		case StateSynSent:
+			f.State = StateSynReceived
+			f.ServerISN = tcp.Seq
+			f.SynAckSeen = ts
+			f.ServerOptions = parseOptions(tcp)
+			a.stats.SynAckRcvd++
+			a.latency.synAcked(f)
+			a.options.record(f)
+		case StateSynReceived, StateEstablished:
+			f.SynAckRetransmits++
 		}
+
 	case tcp.RST:
-		s.RstRcvd++
+		if f == nil || f.done() {
+			// resets after the close answer stray segments
+			break
+		}
+		switch {
+		case f.State == StateSynSent && !fromClient:
+			f.Ending = EndRefused
+		case f.inHandshake():
+			// a failed handshake, counted by the handshake outcomes rather
+			// than as an aborted connection
+		case fromClient:
+			f.Ending = EndClientReset
+		default:
+			f.Ending = EndServerReset
+		}
+		if f.inHandshake() {
+			f.State = StateReset
+		} else {
+			f.State = StateClosed
+		}
+		f.Closed = ts
+
+	case tcp.ACK:
+		if f != nil && fromClient && f.State == StateSynReceived && tcp.Ack == f.ServerISN+1 {
+			f.State = StateEstablished
+			f.Established = ts
+			a.latency.established(f)
+		}
+	}
+	if f != nil && a.streams != nil {
+		a.streams.process(f, fromClient, tcp, ts)
+	}
+}
+
+// fin records a FIN; the second direction to send one closes the connection
+func (a *Analyzer) fin(f *Flow, fromClient bool, ts time.Time) {
+	if fromClient && f.ClientFin.IsZero() {
+		f.ClientFin = ts
+	} else if !fromClient && f.ServerFin.IsZero() {
+		f.ServerFin = ts
 	}
-}
\ No newline at end of file
+	if !f.done() && !f.ClientFin.IsZero() && !f.ServerFin.IsZero() {
+		f.Ending = EndGraceful
+		f.State = StateClosed
+		f.Closed = ts
+	}
+}
+
+// Finish flushes the flow table and computes the derived ratio once all
+// packets have been processed
+func (a *Analyzer) Finish() {
+	a.flows.flush()
+	a.stats.Evicted = a.flows.overflow
+	a.stats.LatencySkipped = a.latency.skipped
+	a.stats.computeRatio()
+}
+
+// FinishAt is Finish for an analyzer that saw only part of a capture ending
+// at end, such as one interface or VLAN. Handshakes that went quiet before
+// the timeout are then failed rather than left pending.
+func (a *Analyzer) FinishAt(end time.Time) {
+	a.flows.clock.Advance(end)
+	a.Finish()
+}
+
+// Stats returns the handshake statistics collected so far
+func (a *Analyzer) Stats() HandshakeStats {
+	return a.stats
+}
+
+// Latency returns the handshake latencies over all servers and per server,
+// the server with the most samples first
+func (a *Analyzer) Latency() (total *Latency, perServer []*Latency) {
+	return &a.latency.total, a.latency.sorted()
+}
+
+// Options returns the aggregated handshake options and the middlebox
+// findings, most frequent first
+func (a *Analyzer) Options() (OptionStats, []Finding) {
+	return a.options.stats, a.options.sorted()
+}
+
+// Lifecycle returns how connections ended over all servers and per server,
+// servers with the most aborted or refused connections first
+func (a *Analyzer) Lifecycle() (total *Lifecycle, perServer []*Lifecycle) {
+	return &a.life.total, a.life.sorted()
+}
+
+// evicted folds a flow leaving the table into the statistics
+func (a *Analyzer) evicted(f *Flow, o Outcome) {
+	a.life.record(f)
+	if a.streams != nil {
+		a.streams.evicted(f)
+	}
+	if f.MidStream {
+		// no handshake to account for
+		return
+	}
+	a.stats.SynRetransmits += f.SynRetransmits
+	a.stats.SynAckRetransmits += f.SynAckRetransmits
+	switch o {
+	case OutcomeCompleted:
+		a.stats.Completed++
+	case OutcomeNoAck:
+		a.stats.NoAck++
+	case OutcomeUnanswered:
+		a.stats.Unanswered++
+	case OutcomeReset:
+		a.stats.Reset++
+	case OutcomePending:
+		a.stats.Pending++
+	case OutcomeEvicted:
+		a.stats.EvictedHandshakes++
+	}
+}
+
+// computeRatio derives SynAckRatio from the counters
+func (s *HandshakeStats) computeRatio() {
+	if s.SynSent > 0 {
+		s.SynAckRatio = float64(s.SynAckRcvd) / float64(s.SynSent) * 100
+	}
+}
@@ -1,7 +1,10 @@
 package tcp
 
 import (
+	"net"
+	"net/netip"
 	"testing"
+	"time"
 
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
@@ -12,13 +15,13 @@ func TestAnalyzeHandshake(t *testing.T) {
 
 	go func() {
 		// SYN packet
-		packets <- createTCPPacket(false, false, false)
+		packets <- seg{src: client, dst: server, flags: "S", seq: 100}.packet(t)
 		// SYN-ACK packet
-		packets <- createTCPPacket(true, false, false)
+		packets <- seg{src: server, dst: client, flags: "SA", seq: 500, ack: 101}.packet(t)
 		// Another SYN
-		packets <- createTCPPacket(false, false, false)
+		packets <- seg{src: client2, dst: server, flags: "S", seq: 900}.packet(t)
 		// RST packet
-		packets <- createTCPPacket(false, false, true)
+		packets <- seg{src: server, dst: client2, flags: "RA", ack: 901}.packet(t)
 		close(packets)
 	}()
 
@@ -52,9 +55,9 @@ func TestEmptyPackets(t *testing.T) {
 
 func TestHandshakeStatsString(t *testing.T) {
 	stats := HandshakeStats{
-		SynSent:    10,
-		SynAckRcvd: 8,
-		RstRcvd:    2,
+		SynSent:     10,
+		SynAckRcvd:  8,
+		RstRcvd:     2,
 		SynAckRatio: 80.0,
 	}
 	// Verify values
@@ -66,20 +69,259 @@ func TestHandshakeStatsString(t *testing.T) {
 	}
 }
 
-// createTCPPacket creates a minimal TCP layer for testing
-// Note: We only test the TCP flags, not full packet construction
-func createTCPPacket(synAck, ack, rst bool) gopacket.Packet {
-	tcpLayer := &layers.TCP{
-		SrcPort: 54321,
-		DstPort: 443,
-		SYN:     synAck,
-		ACK:     ack,
-		RST:     rst,
-	}
-	// Create a minimal packet with just the TCP layer
-	return gopacket.NewPacket(
-		[]byte{},
-		layers.LayerTypeTCP,
-		gopacket.Default,
-	)
-}
\ No newline at end of file
+func TestHandshakeOutcomes(t *testing.T) {
+	a := NewAnalyzer(DefaultConfig)
+	for _, s := range []seg{
+		// completed, with a retransmitted SYN
+		{src: client, dst: server, flags: "S", seq: 100},
+		{src: client, dst: server, flags: "S", seq: 100, at: time.Second},
+		{src: server, dst: client, flags: "SA", seq: 500, ack: 101, at: 1010 * time.Millisecond},
+		{src: client, dst: server, flags: "A", seq: 101, ack: 501, at: 1011 * time.Millisecond},
+		// SYN-ACKed but never ACKed
+		{src: client2, dst: server, flags: "S", seq: 200},
+		{src: server, dst: client2, flags: "SA", seq: 700, ack: 201},
+		{src: server, dst: client2, flags: "SA", seq: 700, ack: 201, at: time.Second},
+		// unanswered
+		{src: client3, dst: server, flags: "S", seq: 300},
+		// reset during the handshake (port closed)
+		{src: client6, dst: server6, flags: "S", seq: 400},
+		{src: server6, dst: client6, flags: "RA", ack: 401},
+		// SYN-ACK for a SYN we never saw is ignored
+		{src: server, dst: client4, flags: "SA", seq: 1, ack: 2},
+		// the clock moves past the handshake timeout
+		{src: client5, dst: server, flags: "S", seq: 1, at: time.Minute},
+	} {
+		a.Process(s.packet(t))
+	}
+	a.Finish()
+	stats := a.Stats()
+
+	want := HandshakeStats{
+		SynSent:           5,
+		SynAckRcvd:        2,
+		RstRcvd:           1,
+		SynAckRatio:       40,
+		Completed:         1,
+		NoAck:             1,
+		Unanswered:        1,
+		Reset:             1,
+		Pending:           1,
+		SynRetransmits:    1,
+		SynAckRetransmits: 1,
+		LatencySkipped:    1,
+	}
+	if stats != want {
+		t.Errorf("stats = %+v\nwant    %+v", stats, want)
+	}
+}
+
+func TestHandshakeFinishAt(t *testing.T) {
+	for _, tt := range []struct {
+		name                string
+		end                 time.Duration
+		unanswered, pending int
+	}{
+		// the rest of the capture went to other analyzers
+		{"capture went on", time.Minute, 1, 0},
+		{"capture ended", time.Second, 0, 1},
+		{"end before the last packet", -time.Minute, 0, 1},
+	} {
+		a := NewAnalyzer(DefaultConfig)
+		a.Process(seg{src: client, dst: server, flags: "S", seq: 100}.packet(t))
+		a.FinishAt(testEpoch.Add(tt.end))
+		if stats := a.Stats(); stats.Unanswered != tt.unanswered || stats.Pending != tt.pending {
+			t.Errorf("%s: stats = %+v, want %d unanswered, %d pending", tt.name, stats, tt.unanswered, tt.pending)
+		}
+	}
+}
+
+func TestHandshakePortReuse(t *testing.T) {
+	a := NewAnalyzer(DefaultConfig)
+	for _, s := range []seg{
+		{src: client, dst: server, flags: "S", seq: 100},
+		{src: server, dst: client, flags: "SA", seq: 500, ack: 101},
+		{src: client, dst: server, flags: "A", seq: 101, ack: 501},
+		// same tuple, new ISN: a new connection
+		{src: client, dst: server, flags: "S", seq: 90000, at: time.Second},
+	} {
+		a.Process(s.packet(t))
+	}
+	a.Finish()
+	stats := a.Stats()
+	if stats.SynSent != 2 || stats.Completed != 1 || stats.Pending != 1 || stats.SynRetransmits != 0 {
+		t.Errorf("stats = %+v, want 2 attempts, 1 completed, 1 pending", stats)
+	}
+}
+
+func TestHandshakeLatency(t *testing.T) {
+	a := NewAnalyzer(DefaultConfig)
+	for _, s := range []seg{
+		{src: client, dst: server, flags: "S", seq: 100},
+		{src: server, dst: client, flags: "SA", seq: 500, ack: 101, at: 20 * time.Millisecond},
+		{src: client, dst: server, flags: "A", seq: 101, ack: 501, at: 21 * time.Millisecond},
+		{src: client2, dst: server, flags: "S", seq: 200, at: time.Second},
+		{src: server, dst: client2, flags: "SA", seq: 700, ack: 201, at: 1040 * time.Millisecond},
+		{src: client2, dst: server, flags: "A", seq: 201, ack: 701, at: 1042 * time.Millisecond},
+		// retransmitted SYN: the SYN-ACK cannot be matched to a transmission
+		{src: client6, dst: server6, flags: "S", seq: 300, at: 2 * time.Second},
+		{src: client6, dst: server6, flags: "S", seq: 300, at: 3 * time.Second},
+		{src: server6, dst: client6, flags: "SA", seq: 900, ack: 301, at: 3010 * time.Millisecond},
+		{src: client6, dst: server6, flags: "A", seq: 301, ack: 901, at: 3015 * time.Millisecond},
+	} {
+		a.Process(s.packet(t))
+	}
+	a.Finish()
+
+	total, perServer := a.Latency()
+	if total.SynAck.Count() != 2 || total.Ack.Count() != 3 {
+		t.Fatalf("samples = %d SYN-ACK, %d ACK, want 2 and 3", total.SynAck.Count(), total.Ack.Count())
+	}
+	if total.SynAck.Min() != 20*time.Millisecond || total.SynAck.Max() != 40*time.Millisecond {
+		t.Errorf("SYN-ACK range = %v..%v, want 20ms..40ms", total.SynAck.Min(), total.SynAck.Max())
+	}
+	if a.Stats().LatencySkipped != 1 {
+		t.Errorf("LatencySkipped = %d, want 1", a.Stats().LatencySkipped)
+	}
+	if len(perServer) != 2 || perServer[0].Server.String() != server {
+		t.Fatalf("perServer = %v, want %s first", perServer, server)
+	}
+	if perServer[0].SynAck.Count() != 2 || perServer[1].SynAck.Count() != 0 || perServer[1].Ack.Count() != 1 {
+		t.Errorf("per server samples wrong: %+v", perServer)
+	}
+}
+
+func TestFlowTableMaxFlows(t *testing.T) {
+	cfg := DefaultConfig
+	cfg.MaxFlows = 2
+	a := NewAnalyzer(cfg)
+	ms := time.Millisecond
+	for _, s := range []seg{
+		{src: client, dst: server, flags: "S", seq: 1},
+		{src: client2, dst: server, flags: "S", seq: 1, at: ms},
+		// a retransmission makes the first SYN the most recently seen
+		{src: client, dst: server, flags: "S", seq: 1, at: 2 * ms},
+		{src: client3, dst: server, flags: "S", seq: 1, at: 3 * ms},
+		// a full table has no room for flows joined mid-stream
+		{src: client4, dst: server, flags: "PA", seq: 1, ack: 1, payload: data(10), at: 4 * ms},
+	} {
+		a.Process(s.packet(t))
+	}
+	if a.flows.Len() != 2 {
+		t.Errorf("table holds %d flows, want 2", a.flows.Len())
+	}
+	if f, _ := a.flows.lookup(netip.MustParseAddrPort(client2), netip.MustParseAddrPort(server)); f != nil {
+		t.Error("the least recently seen flow was not evicted")
+	}
+	if f, _ := a.flows.lookup(netip.MustParseAddrPort(client4), netip.MustParseAddrPort(server)); f != nil {
+		t.Error("a mid-stream flow evicted a handshake")
+	}
+	a.Finish()
+	// the evicted handshake is not taken for an unanswered one
+	if stats := a.Stats(); stats.Evicted != 1 || stats.EvictedHandshakes != 1 || stats.Pending != 2 || stats.Unanswered != 0 {
+		t.Errorf("stats = %+v, want 1 evicted and 2 pending", stats)
+	}
+}
+
+// Endpoints used by the tests
+const (
+	client  = "192.168.1.10:54321"
+	client2 = "192.168.1.10:54322"
+	client3 = "192.168.1.11:40000"
+	client4 = "192.168.1.12:40000"
+	client5 = "192.168.1.13:40000"
+	server  = "93.184.216.34:443"
+	client6 = "[2001:db8::10]:50000"
+	server6 = "[2001:db8::1]:80"
+)
+
+// testEpoch is the capture time of a segment with at == 0
+var testEpoch = time.Date(2026, 2, 20, 10, 0, 0, 0, time.UTC)
+
+// seg describes a TCP segment to build for a test
+type seg struct {
+	src, dst string // "ip:port", IPv6 in brackets
+	flags    string // any of S, A, R, F, P, E (ECE), C (CWR)
+	seq, ack uint32
+	win      uint16        // 0 picks a default, see zeroWin
+	zeroWin  bool          // advertise a zero window
+	at       time.Duration // offset from testEpoch
+	payload  []byte
+	opts     []layers.TCPOption
+}
+
+// packet serializes the segment into an Ethernet frame and decodes it again
+func (s seg) packet(t *testing.T) gopacket.Packet {
+	t.Helper()
+	src := netip.MustParseAddrPort(s.src)
+	dst := netip.MustParseAddrPort(s.dst)
+
+	eth := &layers.Ethernet{
+		SrcMAC: net.HardwareAddr{0x02, 0, 0, 0, 0, 1},
+		DstMAC: net.HardwareAddr{0x02, 0, 0, 0, 0, 2},
+	}
+	var ip gopacket.NetworkLayer
+	if src.Addr().Is4() {
+		eth.EthernetType = layers.EthernetTypeIPv4
+		ip = &layers.IPv4{
+			Version:  4,
+			TTL:      64,
+			Protocol: layers.IPProtocolTCP,
+			SrcIP:    src.Addr().AsSlice(),
+			DstIP:    dst.Addr().AsSlice(),
+		}
+	} else {
+		eth.EthernetType = layers.EthernetTypeIPv6
+		ip = &layers.IPv6{
+			Version:    6,
+			HopLimit:   64,
+			NextHeader: layers.IPProtocolTCP,
+			SrcIP:      src.Addr().AsSlice(),
+			DstIP:      dst.Addr().AsSlice(),
+		}
+	}
+
+	win := s.win
+	if win == 0 && !s.zeroWin {
+		win = 64240
+	}
+	tcp := &layers.TCP{
+		SrcPort: layers.TCPPort(src.Port()),
+		DstPort: layers.TCPPort(dst.Port()),
+		Seq:     s.seq,
+		Ack:     s.ack,
+		Window:  win,
+		Options: s.opts,
+	}
+	for _, c := range s.flags {
+		switch c {
+		case 'S':
+			tcp.SYN = true
+		case 'A':
+			tcp.ACK = true
+		case 'R':
+			tcp.RST = true
+		case 'F':
+			tcp.FIN = true
+		case 'P':
+			tcp.PSH = true
+		case 'E':
+			tcp.ECE = true
+		case 'C':
+			tcp.CWR = true
+		}
+	}
+	tcp.SetNetworkLayerForChecksum(ip)
+
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	err := gopacket.SerializeLayers(buf, opts, eth, ip.(gopacket.SerializableLayer), tcp, gopacket.Payload(s.payload))
+	if err != nil {
+		t.Fatalf("SerializeLayers: %v", err)
+	}
+	pkt := gopacket.NewPacket(buf.Bytes(), layers.LinkTypeEthernet, gopacket.Default)
+	md := pkt.Metadata()
+	md.Timestamp = testEpoch.Add(s.at)
+	md.CaptureLength = len(buf.Bytes())
+	md.Length = len(buf.Bytes())
+	return pkt
+}
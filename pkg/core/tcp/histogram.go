@@ -0,0 +1,175 @@
+package tcp
+
+import (
+	"math"
+	"math/bits"
+	"time"
+)
+
+// histSubBits sets the precision of a Histogram: every power of two range is
+// split into 1<<histSubBits linear buckets, bounding the relative error of
+// a recorded value to under 1%
+const histSubBits = 7
+
+// Histogram is an HDR-style log-linear histogram of durations with
+// microsecond resolution. The zero value is ready to use.
+type Histogram struct {
+	counts []int
+	total  int
+	sum    time.Duration
+	min    time.Duration
+	max    time.Duration
+}
+
+// Bucket is a range of a histogram and the number of values recorded in it
+type Bucket struct {
+	Low   time.Duration // inclusive
+	High  time.Duration // inclusive
+	Count int
+}
+
+// Record adds a duration to the histogram. Negative durations, which only
+// appear with reordered capture timestamps, are ignored.
+func (h *Histogram) Record(d time.Duration) {
+	if d < 0 {
+		return
+	}
+	i := bucketIndex(uint64(d / time.Microsecond))
+	if i >= len(h.counts) {
+		h.counts = append(h.counts, make([]int, i+1-len(h.counts))...)
+	}
+	h.counts[i]++
+	if h.total == 0 || d < h.min {
+		h.min = d
+	}
+	if d > h.max {
+		h.max = d
+	}
+	h.total++
+	h.sum += d
+}
+
+// Merge adds every value recorded in o
+func (h *Histogram) Merge(o *Histogram) {
+	if o.total == 0 {
+		return
+	}
+	if len(o.counts) > len(h.counts) {
+		h.counts = append(h.counts, make([]int, len(o.counts)-len(h.counts))...)
+	}
+	for i, c := range o.counts {
+		h.counts[i] += c
+	}
+	if h.total == 0 || o.min < h.min {
+		h.min = o.min
+	}
+	if o.max > h.max {
+		h.max = o.max
+	}
+	h.total += o.total
+	h.sum += o.sum
+}
+
+// Count returns the number of recorded values
+func (h *Histogram) Count() int {
+	return h.total
+}
+
+// Min returns the smallest recorded value
+func (h *Histogram) Min() time.Duration {
+	return h.min
+}
+
+// Max returns the largest recorded value
+func (h *Histogram) Max() time.Duration {
+	return h.max
+}
+
+// Mean returns the average of the recorded values
+func (h *Histogram) Mean() time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	return h.sum / time.Duration(h.total)
+}
+
+// Quantile returns the value below which the fraction q of the recorded
+// values fall, e.g. Quantile(0.99) for the 99th percentile. Like HDR
+// histograms it reports the upper end of the bucket holding that value.
+func (h *Histogram) Quantile(q float64) time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(q * float64(h.total)))
+	if rank < 1 {
+		rank = 1
+	}
+	seen := 0
+	for i, c := range h.counts {
+		seen += c
+		if seen >= rank {
+			_, high := bucketRange(i)
+			d := time.Duration(high) * time.Microsecond
+			if d > h.max {
+				d = h.max
+			}
+			if d < h.min {
+				d = h.min
+			}
+			return d
+		}
+	}
+	return h.max
+}
+
+// Distribution returns the non-empty power of two ranges of the histogram
+// in ascending order, a coarser view suited to reports
+func (h *Histogram) Distribution() []Bucket {
+	var out []Bucket
+	for i, c := range h.counts {
+		if c == 0 {
+			continue
+		}
+		low, _ := bucketRange(i)
+		lo, hi := uint64(0), uint64(0)
+		if low > 0 {
+			lo = 1 << (bits.Len64(low) - 1)
+			hi = lo<<1 - 1
+		}
+		b := Bucket{
+			Low:   time.Duration(lo) * time.Microsecond,
+			High:  time.Duration(hi) * time.Microsecond,
+			Count: c,
+		}
+		if n := len(out); n > 0 && out[n-1].Low == b.Low {
+			out[n-1].Count += c
+			continue
+		}
+		out = append(out, b)
+	}
+	return out
+}
+
+// bucketIndex maps a value to its bucket. Values below 2<<histSubBits get a
+// bucket each; above that every power of two range is split into
+// 1<<histSubBits buckets.
+func bucketIndex(v uint64) int {
+	const sub = 1 << histSubBits
+	n := bits.Len64(v)
+	if n <= histSubBits+1 {
+		return int(v)
+	}
+	shift := n - histSubBits - 1
+	return (shift+1)*sub + int(v>>shift) - sub
+}
+
+// bucketRange returns the lowest and highest value mapped to bucket i
+func bucketRange(i int) (low, high uint64) {
+	const sub = 1 << histSubBits
+	if i < 2*sub {
+		return uint64(i), uint64(i)
+	}
+	shift := i/sub - 1
+	m := uint64(i%sub + sub)
+	return m << shift, (m+1)<<shift - 1
+}
@@ -0,0 +1,85 @@
+package tcp
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBucketIndexRange(t *testing.T) {
+	prev := -1
+	for _, v := range []uint64{0, 1, 127, 255, 256, 257, 511, 512, 1000, 1 << 20, 1<<40 + 12345} {
+		i := bucketIndex(v)
+		if i < prev {
+			t.Errorf("bucketIndex(%d) = %d, not monotonic", v, i)
+		}
+		prev = i
+		low, high := bucketRange(i)
+		if v < low || v > high {
+			t.Errorf("value %d maps to bucket %d covering [%d, %d]", v, i, low, high)
+		}
+		if low > 0 && float64(high-low)/float64(low) > 0.01 {
+			t.Errorf("bucket %d [%d, %d] is wider than 1%%", i, low, high)
+		}
+	}
+}
+
+func TestHistogramQuantiles(t *testing.T) {
+	var h Histogram
+	for i := 1; i <= 100; i++ {
+		h.Record(time.Duration(i) * time.Millisecond)
+	}
+	h.Record(-time.Second) // ignored
+
+	if h.Count() != 100 {
+		t.Fatalf("Count = %d, want 100", h.Count())
+	}
+	if h.Min() != time.Millisecond || h.Max() != 100*time.Millisecond {
+		t.Errorf("Min, Max = %v, %v, want 1ms, 100ms", h.Min(), h.Max())
+	}
+	if h.Mean() != 50500*time.Microsecond {
+		t.Errorf("Mean = %v, want 50.5ms", h.Mean())
+	}
+	for _, tc := range []struct {
+		q    float64
+		want time.Duration
+	}{
+		{0.5, 50 * time.Millisecond},
+		{0.9, 90 * time.Millisecond},
+		{0.99, 99 * time.Millisecond},
+		{1, 100 * time.Millisecond},
+	} {
+		got := h.Quantile(tc.q)
+		if got < tc.want || float64(got-tc.want) > 0.01*float64(tc.want) {
+			t.Errorf("Quantile(%v) = %v, want %v within 1%%", tc.q, got, tc.want)
+		}
+	}
+}
+
+func TestHistogramMergeAndDistribution(t *testing.T) {
+	var a, b Histogram
+	a.Record(3 * time.Millisecond)
+	a.Record(3500 * time.Microsecond)
+	b.Record(100 * time.Millisecond)
+	a.Merge(&b)
+
+	if a.Count() != 3 || a.Max() != 100*time.Millisecond {
+		t.Errorf("merged Count, Max = %d, %v", a.Count(), a.Max())
+	}
+	dist := a.Distribution()
+	if len(dist) != 2 {
+		t.Fatalf("Distribution = %+v, want 2 buckets", dist)
+	}
+	if dist[0].Count != 2 || dist[0].Low > 3*time.Millisecond || dist[0].High < 3500*time.Microsecond {
+		t.Errorf("first bucket = %+v", dist[0])
+	}
+	if dist[1].Count != 1 || dist[1].Low > 100*time.Millisecond || dist[1].High < 100*time.Millisecond {
+		t.Errorf("second bucket = %+v", dist[1])
+	}
+}
+
+func TestHistogramEmpty(t *testing.T) {
+	var h Histogram
+	if h.Quantile(0.99) != 0 || h.Mean() != 0 || len(h.Distribution()) != 0 {
+		t.Errorf("empty histogram should report zeros")
+	}
+}
@@ -0,0 +1,80 @@
+package tcp
+
+import (
+	"net/netip"
+	"sort"
+)
+
+// Latency holds the handshake latencies measured towards one server. Both
+// legs are timed at the capture point: SynAck is the time from the client's
+// SYN to the server's SYN-ACK, Ack the time from the SYN-ACK to the client's
+// ACK. Legs whose opening segment was retransmitted are skipped, since the
+// answer cannot be matched to a transmission (Karn's algorithm).
+type Latency struct {
+	Server netip.AddrPort // invalid for the aggregate over all servers
+	SynAck Histogram
+	Ack    Histogram
+}
+
+// Merge adds the samples of o
+func (l *Latency) Merge(o *Latency) {
+	l.SynAck.Merge(&o.SynAck)
+	l.Ack.Merge(&o.Ack)
+}
+
+// latencyTable collects handshake latencies in total and per server
+type latencyTable struct {
+	total     Latency
+	perServer map[netip.AddrPort]*Latency
+	skipped   int
+}
+
+func (t *latencyTable) server(addr netip.AddrPort) *Latency {
+	if t.perServer == nil {
+		t.perServer = make(map[netip.AddrPort]*Latency)
+	}
+	l := t.perServer[addr]
+	if l == nil {
+		l = &Latency{Server: addr}
+		t.perServer[addr] = l
+	}
+	return l
+}
+
+// synAcked records the SYN → SYN-ACK leg of f
+func (t *latencyTable) synAcked(f *Flow) {
+	if f.SynRetransmits > 0 {
+		t.skipped++
+		return
+	}
+	d := f.SynAckSeen.Sub(f.FirstSeen)
+	t.total.SynAck.Record(d)
+	t.server(f.Key.Server).SynAck.Record(d)
+}
+
+// established records the SYN-ACK → ACK leg of f
+func (t *latencyTable) established(f *Flow) {
+	if f.SynAckRetransmits > 0 {
+		t.skipped++
+		return
+	}
+	d := f.Established.Sub(f.SynAckSeen)
+	t.total.Ack.Record(d)
+	t.server(f.Key.Server).Ack.Record(d)
+}
+
+// sorted returns the per-server latencies, busiest server first
+func (t *latencyTable) sorted() []*Latency {
+	out := make([]*Latency, 0, len(t.perServer))
+	for _, l := range t.perServer {
+		out = append(out, l)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		ni, nj := out[i].SynAck.Count(), out[j].SynAck.Count()
+		if ni != nj {
+			return ni > nj
+		}
+		return out[i].Server.String() < out[j].Server.String()
+	})
+	return out
+}
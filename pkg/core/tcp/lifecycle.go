@@ -0,0 +1,113 @@
+package tcp
+
+import (
+	"net/netip"
+	"sort"
+)
+
+// Lifecycle counts how connections ended, in total or towards one server,
+// and how long they lasted
+type Lifecycle struct {
+	Server    netip.AddrPort // invalid for the aggregate over all servers
+	Endings   [numEndings]int
+	Durations [numEndings]Histogram // from first SYN (or segment) to close
+}
+
+// Count returns the number of connections that ended with e
+func (l *Lifecycle) Count(e Ending) int {
+	return l.Endings[e]
+}
+
+// Total returns the number of connections counted
+func (l *Lifecycle) Total() int {
+	n := 0
+	for _, c := range l.Endings {
+		n += c
+	}
+	return n
+}
+
+// Duration returns the durations of every connection counted, whatever its
+// ending
+func (l *Lifecycle) Duration() Histogram {
+	var h Histogram
+	for i := range l.Durations {
+		h.Merge(&l.Durations[i])
+	}
+	return h
+}
+
+// Aborted returns the connections reset after they had been accepted
+func (l *Lifecycle) Aborted() int {
+	return l.Endings[EndClientReset] + l.Endings[EndServerReset]
+}
+
+// lifecycleTable collects connection endings in total and per server
+type lifecycleTable struct {
+	total     Lifecycle
+	perServer map[netip.AddrPort]*Lifecycle
+}
+
+// ending decides how a flow leaving the table ended. Handshakes that failed,
+// other than by being refused, never became connections and are not counted.
+func ending(f *Flow) (Ending, bool) {
+	switch {
+	case f.State == StateReset && f.Ending != EndRefused:
+		return 0, false
+	case f.done():
+		return f.Ending, true
+	case f.Established.IsZero() && !f.MidStream:
+		return 0, false
+	case !f.ClientFin.IsZero() || !f.ServerFin.IsZero():
+		return EndHalfClosed, true
+	case f.expired:
+		return EndIdle, true
+	}
+	return EndOpen, true
+}
+
+// record counts a flow leaving the table
+func (t *lifecycleTable) record(f *Flow) {
+	e, ok := ending(f)
+	if !ok {
+		return
+	}
+	end := f.Closed
+	if end.IsZero() {
+		end = f.LastSeen
+	}
+	d := end.Sub(f.FirstSeen)
+
+	if t.perServer == nil {
+		t.perServer = make(map[netip.AddrPort]*Lifecycle)
+	}
+	l := t.perServer[f.Key.Server]
+	if l == nil {
+		l = &Lifecycle{Server: f.Key.Server}
+		t.perServer[f.Key.Server] = l
+	}
+	for _, l := range []*Lifecycle{&t.total, l} {
+		l.Endings[e]++
+		l.Durations[e].Record(d)
+	}
+}
+
+// sorted returns the per-server lifecycles, most aborted and refused first
+func (t *lifecycleTable) sorted() []*Lifecycle {
+	out := make([]*Lifecycle, 0, len(t.perServer))
+	for _, l := range t.perServer {
+		out = append(out, l)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		bi := out[i].Aborted() + out[i].Count(EndRefused)
+		bj := out[j].Aborted() + out[j].Count(EndRefused)
+		if bi != bj {
+			return bi > bj
+		}
+		if out[i].Total() != out[j].Total() {
+			return out[i].Total() > out[j].Total()
+		}
+		return out[i].Server.String() < out[j].Server.String()
+	})
+	return out
+}
@@ -0,0 +1,93 @@
+package tcp
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLifecycleEndings(t *testing.T) {
+	s := time.Second
+	a := NewAnalyzer(DefaultConfig)
+	for _, sg := range []seg{
+		// graceful close after 2 seconds, the trailing ACK is not a new flow
+		{src: client, dst: server, flags: "S", seq: 100},
+		{src: server, dst: client, flags: "SA", seq: 500, ack: 101},
+		{src: client, dst: server, flags: "A", seq: 101, ack: 501},
+		{src: client, dst: server, flags: "FA", seq: 101, ack: 501, at: 2 * s},
+		{src: server, dst: client, flags: "FA", seq: 501, ack: 102, at: 2 * s},
+		{src: client, dst: server, flags: "A", seq: 102, ack: 502, at: 2 * s},
+		// port closed
+		{src: client2, dst: server, flags: "S", seq: 200},
+		{src: server, dst: client2, flags: "RA", ack: 201},
+		// reset in the handshake: a failed handshake, not an abort
+		{src: client2, dst: server, flags: "S", seq: 250, at: s},
+		{src: server, dst: client2, flags: "SA", seq: 800, ack: 251, at: s},
+		{src: server, dst: client2, flags: "R", seq: 801, at: s},
+		// aborted by the server after the handshake
+		{src: client3, dst: server, flags: "S", seq: 300},
+		{src: server, dst: client3, flags: "SA", seq: 700, ack: 301},
+		{src: client3, dst: server, flags: "A", seq: 301, ack: 701},
+		{src: server, dst: client3, flags: "R", seq: 701, at: 3 * s},
+		// mid-stream flow aborted by the client, the second RST is ignored
+		{src: client4, dst: server, flags: "PA", seq: 1000, ack: 2000, payload: data(10)},
+		{src: client4, dst: server, flags: "R", seq: 1010, at: s},
+		{src: client4, dst: server, flags: "R", seq: 1010, at: s},
+		// half-closed: only the client sent a FIN
+		{src: client5, dst: server, flags: "S", seq: 400},
+		{src: server, dst: client5, flags: "SA", seq: 900, ack: 401},
+		{src: client5, dst: server, flags: "A", seq: 401, ack: 901},
+		{src: client5, dst: server, flags: "FA", seq: 401, ack: 901, at: s},
+		// still open at the end of the capture
+		{src: client6, dst: server6, flags: "S", seq: 1},
+		{src: server6, dst: client6, flags: "SA", seq: 1, ack: 2},
+		{src: client6, dst: server6, flags: "A", seq: 2, ack: 2, at: 4 * s},
+	} {
+		a.Process(sg.packet(t))
+	}
+	a.Finish()
+
+	total, perServer := a.Lifecycle()
+	want := [numEndings]int{
+		EndOpen:        1,
+		EndGraceful:    1,
+		EndHalfClosed:  1,
+		EndRefused:     1,
+		EndClientReset: 1,
+		EndServerReset: 1,
+	}
+	if total.Endings != want {
+		t.Errorf("Endings = %v, want %v", total.Endings, want)
+	}
+	if d := total.Durations[EndGraceful].Max(); d != 2*s {
+		t.Errorf("graceful duration = %v, want 2s", d)
+	}
+	if d := total.Durations[EndServerReset].Max(); d != 3*s {
+		t.Errorf("server reset duration = %v, want 3s", d)
+	}
+	if len(perServer) != 2 || perServer[0].Server.String() != server || perServer[0].Aborted() != 2 {
+		t.Errorf("perServer[0] = %+v, want %s with 2 aborted", perServer[0], server)
+	} else if h := perServer[0].Duration(); h.Count() != 5 || h.Max() != 3*s {
+		t.Errorf("perServer[0] durations: %d, max %v; want 5, max 3s", h.Count(), h.Max())
+	}
+	if st := a.Stats(); st.SynSent != 6 || st.Completed != 4 || st.Reset != 2 || st.RstRcvd != 5 {
+		t.Errorf("handshake stats = %+v; mid-stream flows must not count", st)
+	}
+}
+
+func TestLifecycleIdle(t *testing.T) {
+	cfg := DefaultConfig
+	cfg.IdleTimeout = time.Minute
+	a := NewAnalyzer(cfg)
+	for _, sg := range []seg{
+		{src: client, dst: server, flags: "A", seq: 1, ack: 1},
+		{src: client2, dst: server, flags: "A", seq: 1, ack: 1, at: 2 * time.Minute},
+	} {
+		a.Process(sg.packet(t))
+	}
+	a.Finish()
+
+	total, _ := a.Lifecycle()
+	if total.Count(EndIdle) != 1 || total.Count(EndOpen) != 1 {
+		t.Errorf("Endings = %v, want 1 idle and 1 open", total.Endings)
+	}
+}
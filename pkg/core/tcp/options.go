@@ -0,0 +1,197 @@
+package tcp
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"sort"
+
+	"github.com/google/gopacket/layers"
+)
+
+// Options holds the TCP options and ECN flags of a SYN or SYN-ACK
+type Options struct {
+	MSS           uint16 // 0 when absent
+	WindowScale   int    // shift count, -1 when absent
+	SACKPermitted bool
+	Timestamps    bool
+	ECE, CWR      bool
+}
+
+// parseOptions extracts the handshake options of a segment
+func parseOptions(tcp *layers.TCP) Options {
+	o := Options{WindowScale: -1, ECE: tcp.ECE, CWR: tcp.CWR}
+	for _, opt := range tcp.Options {
+		switch opt.OptionType {
+		case layers.TCPOptionKindMSS:
+			if len(opt.OptionData) == 2 {
+				o.MSS = binary.BigEndian.Uint16(opt.OptionData)
+			}
+		case layers.TCPOptionKindWindowScale:
+			if len(opt.OptionData) == 1 {
+				o.WindowScale = int(min(opt.OptionData[0], 14))
+			}
+		case layers.TCPOptionKindSACKPermitted:
+			o.SACKPermitted = true
+		case layers.TCPOptionKindTimestamps:
+			o.Timestamps = true
+		}
+	}
+	return o
+}
+
+// extensions reports whether any of window scale, SACK or timestamps is set
+func (o Options) extensions() bool {
+	return o.WindowScale >= 0 || o.SACKPermitted || o.Timestamps
+}
+
+// Anomaly is a disagreement between SYN and SYN-ACK options that points at a
+// middlebox rewriting the handshake
+type Anomaly int
+
+const (
+	AnomalyMSSClamped      Anomaly = iota // SYN-ACK MSS below the client's and the link's usual MSS
+	AnomalyMSSMissing                     // SYN carried an MSS, the SYN-ACK did not
+	AnomalyOptionsStripped                // SYN offered extensions, the SYN-ACK carried none
+	AnomalyOptionsInjected                // SYN-ACK carried extensions the SYN did not offer
+	AnomalyECNInvalid                     // SYN-ACK ECN flags not allowed by RFC 3168
+)
+
+func (a Anomaly) String() string {
+	switch a {
+	case AnomalyMSSClamped:
+		return "MSS clamped"
+	case AnomalyMSSMissing:
+		return "MSS missing in SYN-ACK"
+	case AnomalyOptionsStripped:
+		return "options stripped"
+	case AnomalyOptionsInjected:
+		return "options not offered in SYN"
+	case AnomalyECNInvalid:
+		return "invalid ECN negotiation"
+	}
+	return "unknown"
+}
+
+// anomalies compares the options of a SYN (c) and its SYN-ACK (s)
+func anomalies(server netip.AddrPort, c, s Options) []Anomaly {
+	var out []Anomaly
+	// the usual MSS of an Ethernet path for the address family
+	typical := uint16(1460)
+	if server.Addr().Is6() && !server.Addr().Is4In6() {
+		typical = 1440
+	}
+	switch {
+	case c.MSS > 0 && s.MSS == 0:
+		out = append(out, AnomalyMSSMissing)
+	case s.MSS > 0 && s.MSS < c.MSS && s.MSS < typical:
+		out = append(out, AnomalyMSSClamped)
+	}
+	if c.extensions() && !s.extensions() {
+		out = append(out, AnomalyOptionsStripped)
+	}
+	if s.WindowScale >= 0 && c.WindowScale < 0 || s.SACKPermitted && !c.SACKPermitted || s.Timestamps && !c.Timestamps {
+		out = append(out, AnomalyOptionsInjected)
+	}
+	if s.CWR || s.ECE && !(c.ECE && c.CWR) {
+		out = append(out, AnomalyECNInvalid)
+	}
+	return out
+}
+
+// Usage counts how often an option was offered in SYNs and accepted in the
+// matching SYN-ACKs
+type Usage struct {
+	Offered  int
+	Accepted int
+}
+
+func (u *Usage) add(offered, accepted bool) {
+	if offered {
+		u.Offered++
+		if accepted {
+			u.Accepted++
+		}
+	}
+}
+
+// OptionStats aggregates the options of every answered handshake
+type OptionStats struct {
+	Handshakes  int // SYN and SYN-ACK pairs compared
+	WindowScale Usage
+	SACK        Usage
+	Timestamps  Usage
+	ECN         Usage
+	ClientMSS   map[uint16]int // MSS offered in SYNs, by value
+	ServerMSS   map[uint16]int // MSS offered in SYN-ACKs, by value
+}
+
+// Finding counts one anomaly towards one server
+type Finding struct {
+	Server    netip.AddrPort
+	Anomaly   Anomaly
+	Count     int
+	ClientMSS uint16 // MSS of the last SYN
+	ServerMSS uint16 // MSS of the last SYN-ACK
+}
+
+type findingKey struct {
+	server  netip.AddrPort
+	anomaly Anomaly
+}
+
+// optionTable collects option statistics and middlebox findings
+type optionTable struct {
+	stats    OptionStats
+	findings map[findingKey]*Finding
+}
+
+// record compares the handshake options of a flow whose SYN-ACK was seen
+func (t *optionTable) record(f *Flow) {
+	c, s := f.ClientOptions, f.ServerOptions
+	if t.stats.ClientMSS == nil {
+		t.stats.ClientMSS = make(map[uint16]int)
+		t.stats.ServerMSS = make(map[uint16]int)
+		t.findings = make(map[findingKey]*Finding)
+	}
+	t.stats.Handshakes++
+	t.stats.WindowScale.add(c.WindowScale >= 0, s.WindowScale >= 0)
+	t.stats.SACK.add(c.SACKPermitted, s.SACKPermitted)
+	t.stats.Timestamps.add(c.Timestamps, s.Timestamps)
+	t.stats.ECN.add(c.ECE && c.CWR, s.ECE && !s.CWR)
+	if c.MSS > 0 {
+		t.stats.ClientMSS[c.MSS]++
+	}
+	if s.MSS > 0 {
+		t.stats.ServerMSS[s.MSS]++
+	}
+
+	for _, a := range anomalies(f.Key.Server, c, s) {
+		k := findingKey{f.Key.Server, a}
+		fd := t.findings[k]
+		if fd == nil {
+			fd = &Finding{Server: f.Key.Server, Anomaly: a}
+			t.findings[k] = fd
+		}
+		fd.Count++
+		fd.ClientMSS = c.MSS
+		fd.ServerMSS = s.MSS
+	}
+}
+
+// sorted returns the findings, most frequent first
+func (t *optionTable) sorted() []Finding {
+	out := make([]Finding, 0, len(t.findings))
+	for _, fd := range t.findings {
+		out = append(out, *fd)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Count != out[j].Count {
+			return out[i].Count > out[j].Count
+		}
+		if out[i].Server != out[j].Server {
+			return out[i].Server.String() < out[j].Server.String()
+		}
+		return out[i].Anomaly < out[j].Anomaly
+	})
+	return out
+}
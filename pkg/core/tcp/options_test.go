@@ -0,0 +1,105 @@
+package tcp
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+
+	"github.com/google/gopacket/layers"
+)
+
+// synOpts builds handshake options: an MSS (0 omits it) and any of
+// "ws", "sack" and "ts"
+func synOpts(mss uint16, ext ...string) []layers.TCPOption {
+	var opts []layers.TCPOption
+	if mss > 0 {
+		opts = append(opts, layers.TCPOption{OptionType: layers.TCPOptionKindMSS, OptionLength: 4, OptionData: []byte{byte(mss >> 8), byte(mss)}})
+	}
+	for _, e := range ext {
+		switch e {
+		case "ws":
+			opts = append(opts, layers.TCPOption{OptionType: layers.TCPOptionKindWindowScale, OptionLength: 3, OptionData: []byte{7}})
+		case "sack":
+			opts = append(opts, layers.TCPOption{OptionType: layers.TCPOptionKindSACKPermitted, OptionLength: 2})
+		case "ts":
+			opts = append(opts, layers.TCPOption{OptionType: layers.TCPOptionKindTimestamps, OptionLength: 10, OptionData: make([]byte, 8)})
+		}
+	}
+	return opts
+}
+
+func TestParseOptions(t *testing.T) {
+	pkt := seg{src: client, dst: server, flags: "SEC", seq: 1, opts: synOpts(1460, "ws", "sack", "ts")}.packet(t)
+	tcp, _, _, _ := endpoints(pkt)
+	got := parseOptions(tcp)
+	want := Options{MSS: 1460, WindowScale: 7, SACKPermitted: true, Timestamps: true, ECE: true, CWR: true}
+	if got != want {
+		t.Errorf("parseOptions = %+v, want %+v", got, want)
+	}
+
+	pkt = seg{src: client, dst: server, flags: "S", seq: 1}.packet(t)
+	tcp, _, _, _ = endpoints(pkt)
+	if got := parseOptions(tcp); got != (Options{WindowScale: -1}) {
+		t.Errorf("parseOptions without options = %+v", got)
+	}
+}
+
+func TestAnomalies(t *testing.T) {
+	full := Options{MSS: 1460, WindowScale: 7, SACKPermitted: true, Timestamps: true}
+	v4 := netip.MustParseAddrPort(server)
+	v6 := netip.MustParseAddrPort(server6)
+	for _, tc := range []struct {
+		name   string
+		server netip.AddrPort
+		c, s   Options
+		want   []Anomaly
+	}{
+		{"clean", v4, full, full, nil},
+		{"clamped", v4, full, Options{MSS: 1360, WindowScale: 7, SACKPermitted: true, Timestamps: true}, []Anomaly{AnomalyMSSClamped}},
+		{"ipv6 mss is not clamped", v6, full, Options{MSS: 1440, WindowScale: 7, SACKPermitted: true}, nil},
+		{"smaller client mss", v4, Options{MSS: 1200, WindowScale: -1}, Options{MSS: 1200, WindowScale: -1}, nil},
+		{"stripped", v4, full, Options{MSS: 1460, WindowScale: -1}, []Anomaly{AnomalyOptionsStripped}},
+		{"mss missing", v4, full, Options{WindowScale: 7, SACKPermitted: true}, []Anomaly{AnomalyMSSMissing}},
+		{"injected", v4, Options{MSS: 1460, WindowScale: -1}, full, []Anomaly{AnomalyOptionsInjected}},
+		{"ecn accepted", v4, Options{MSS: 1460, WindowScale: -1, ECE: true, CWR: true}, Options{MSS: 1460, WindowScale: -1, ECE: true}, nil},
+		{"ecn reflected", v4, Options{MSS: 1460, WindowScale: -1, ECE: true, CWR: true}, Options{MSS: 1460, WindowScale: -1, ECE: true, CWR: true}, []Anomaly{AnomalyECNInvalid}},
+		{"ecn unrequested", v4, Options{MSS: 1460, WindowScale: -1}, Options{MSS: 1460, WindowScale: -1, ECE: true}, []Anomaly{AnomalyECNInvalid}},
+	} {
+		if got := anomalies(tc.server, tc.c, tc.s); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: anomalies = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestAnalyzerOptions(t *testing.T) {
+	a := NewAnalyzer(DefaultConfig)
+	for _, s := range []seg{
+		{src: client, dst: server, flags: "SEC", seq: 100, opts: synOpts(1460, "ws", "sack", "ts")},
+		{src: server, dst: client, flags: "SAE", seq: 500, ack: 101, opts: synOpts(1380, "ws", "sack", "ts")},
+		{src: client2, dst: server, flags: "S", seq: 200, opts: synOpts(1460, "ws", "sack", "ts")},
+		{src: server, dst: client2, flags: "SA", seq: 700, ack: 201, opts: synOpts(1380)},
+		// unanswered SYNs are not compared
+		{src: client3, dst: server, flags: "S", seq: 300, opts: synOpts(1460, "sack")},
+	} {
+		a.Process(s.packet(t))
+	}
+	a.Finish()
+
+	st, findings := a.Options()
+	if st.Handshakes != 2 {
+		t.Errorf("Handshakes = %d, want 2", st.Handshakes)
+	}
+	if st.SACK != (Usage{Offered: 2, Accepted: 1}) || st.ECN != (Usage{Offered: 1, Accepted: 1}) {
+		t.Errorf("SACK = %+v, ECN = %+v", st.SACK, st.ECN)
+	}
+	if st.ClientMSS[1460] != 2 || st.ServerMSS[1380] != 2 {
+		t.Errorf("MSS = %v / %v", st.ClientMSS, st.ServerMSS)
+	}
+	want := []Finding{
+		{Server: netip.MustParseAddrPort(server), Anomaly: AnomalyMSSClamped, Count: 2, ClientMSS: 1460, ServerMSS: 1380},
+		{Server: netip.MustParseAddrPort(server), Anomaly: AnomalyOptionsStripped, Count: 1, ClientMSS: 1460, ServerMSS: 1380},
+	}
+	if !reflect.DeepEqual(findings, want) {
+		t.Errorf("findings = %+v\nwant       %+v", findings, want)
+	}
+}
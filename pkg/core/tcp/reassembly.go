@@ -0,0 +1,224 @@
+package tcp
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/reassembly"
+
+	"network-app/pkg/core/analyzer"
+)
+
+// ReassemblyConfig bounds the memory used to reassemble TCP streams
+type ReassemblyConfig struct {
+	MaxPages        int           // out-of-order pages (1900 bytes each) buffered over all connections, 0 for no limit
+	MaxPagesPerFlow int           // out-of-order pages buffered for one connection, 0 for no limit
+	GapTimeout      time.Duration // stop waiting for a missing segment after this long
+	IdleTimeout     time.Duration // close streams without packets for this long
+}
+
+// DefaultReassemblyConfig buffers at most about 30 MiB of out-of-order data
+// and 2 MiB per connection
+var DefaultReassemblyConfig = ReassemblyConfig{
+	MaxPages:        16384,
+	MaxPagesPerFlow: 1024,
+	GapTimeout:      5 * time.Second,
+	IdleTimeout:     5 * time.Minute,
+}
+
+// ReassemblyStats counts the bytes delivered by the reassembler
+type ReassemblyStats struct {
+	Streams      int    // connections that carried payload
+	Bytes        uint64 // payload bytes delivered in order
+	Gaps         int    // holes skipped because a segment was never captured
+	MissingBytes uint64 // bytes in those holes
+	OverlapBytes uint64 // retransmitted bytes that had been delivered already
+}
+
+// Chunk is the next piece of one direction of a reassembled stream
+type Chunk struct {
+	Data       []byte    // only valid during the Consumer.Data call
+	FromClient bool      // sent by the client rather than the server
+	Skipped    int       // bytes missing right before Data
+	Time       time.Time // capture time of the first segment in Data
+}
+
+// Consumer receives the reassembled byte streams of one connection, in
+// order and per direction
+type Consumer interface {
+	Data(c *Chunk)
+	// Close is called once both directions ended or timed out
+	Close()
+}
+
+// NewConsumer creates a consumer for a connection when its first payload is
+// reassembled. It may return nil to ignore the connection.
+type NewConsumer func(key FlowKey, start time.Time) Consumer
+
+// Reassembler rebuilds the byte streams of every TCP connection, including
+// ones already established when the capture started, and hands them to
+// consumers. Like the flow table it is driven by packet timestamps.
+type Reassembler struct {
+	cfg       ReassemblyConfig
+	assembler *reassembly.Assembler
+	consumers []NewConsumer
+	stats     ReassemblyStats
+	clock     analyzer.Clock
+	contexts  []captureContext // unused part of the current slab
+}
+
+// NewReassembler creates a reassembler passing every stream to consumers
+func NewReassembler(cfg ReassemblyConfig, consumers ...NewConsumer) *Reassembler {
+	r := &Reassembler{cfg: cfg, consumers: consumers}
+	r.assembler = reassembly.NewAssembler(reassembly.NewStreamPool(r))
+	r.assembler.MaxBufferedPagesTotal = cfg.MaxPages
+	r.assembler.MaxBufferedPagesPerConnection = cfg.MaxPagesPerFlow
+	return r
+}
+
+// contextSlab is the number of packets sharing one allocation of capture
+// contexts
+const contextSlab = 64
+
+// captureContext passes the capture time of a packet to the assembler
+type captureContext time.Time
+
+func (c *captureContext) GetCaptureInfo() gopacket.CaptureInfo {
+	return gopacket.CaptureInfo{Timestamp: time.Time(*c)}
+}
+
+// context returns a capture context for a packet taken at ts. The assembler
+// keeps the context of an out-of-order segment until the segment is
+// delivered, so a context is never reused; they are carved from slabs
+// instead of being allocated one per packet.
+func (r *Reassembler) context(ts time.Time) *captureContext {
+	if len(r.contexts) == 0 {
+		r.contexts = make([]captureContext, contextSlab)
+	}
+	c := &r.contexts[0]
+	r.contexts = r.contexts[1:]
+	*c = captureContext(ts)
+	return c
+}
+
+// Process adds a single packet to its stream
+func (r *Reassembler) Process(pkt gopacket.Packet) {
+	tcp, _, _, ok := endpoints(pkt)
+	if !ok {
+		return
+	}
+	ts := pkt.Metadata().Timestamp
+	r.assembler.AssembleWithContext(pkt.NetworkLayer().NetworkFlow(), tcp, r.context(ts))
+	r.advance(ts)
+}
+
+// advance moves the clock and, about once per second of capture time, skips
+// gaps older than GapTimeout and closes streams idle for IdleTimeout
+func (r *Reassembler) advance(ts time.Time) {
+	if !r.clock.Advance(ts) {
+		return
+	}
+	now := r.clock.Now()
+	r.assembler.FlushWithOptions(reassembly.FlushOptions{
+		T:  now.Add(-r.cfg.GapTimeout),
+		TC: now.Add(-r.cfg.IdleTimeout),
+	})
+}
+
+// Finish delivers whatever is still buffered and closes every stream
+func (r *Reassembler) Finish() {
+	r.assembler.FlushAll()
+}
+
+// Stats returns the counters over all streams
+func (r *Reassembler) Stats() ReassemblyStats {
+	return r.stats
+}
+
+// New implements reassembly.StreamFactory
+func (r *Reassembler) New(netFlow, tcpFlow gopacket.Flow, tcp *layers.TCP, ac reassembly.AssemblerContext) reassembly.Stream {
+	srcIP, _ := netip.AddrFromSlice(netFlow.Src().Raw())
+	dstIP, _ := netip.AddrFromSlice(netFlow.Dst().Raw())
+	src := netip.AddrPortFrom(srcIP, binary.BigEndian.Uint16(tcpFlow.Src().Raw()))
+	dst := netip.AddrPortFrom(dstIP, binary.BigEndian.Uint16(tcpFlow.Dst().Raw()))
+
+	s := &stream{r: r, start: ac.GetCaptureInfo().Timestamp}
+	var fromClient bool
+	switch {
+	case tcp.SYN && !tcp.ACK:
+		s.key, fromClient = FlowKey{Client: src, Server: dst}, true
+	case tcp.SYN:
+		s.key = FlowKey{Client: dst, Server: src}
+	default:
+		s.key, fromClient = midStreamKey(src, dst)
+	}
+	// the assembler calls the sender of the first packet the client
+	s.flipped = !fromClient
+	return s
+}
+
+// stream is one connection as seen by the assembler
+type stream struct {
+	r         *Reassembler
+	key       FlowKey
+	start     time.Time
+	flipped   bool
+	started   bool // consumers were created
+	consumers []Consumer
+}
+
+// Accept takes every segment. Streams without a captured SYN start at the
+// first segment seen.
+func (s *stream) Accept(tcp *layers.TCP, ci gopacket.CaptureInfo, dir reassembly.TCPFlowDirection, nextSeq reassembly.Sequence, start *bool, ac reassembly.AssemblerContext) bool {
+	*start = true
+	return true
+}
+
+// ReassembledSG hands the next in-order bytes of one direction to the
+// consumers
+func (s *stream) ReassembledSG(sg reassembly.ScatterGather, ac reassembly.AssemblerContext) {
+	dir, _, _, skip := sg.Info()
+	length, _ := sg.Lengths()
+	st := &s.r.stats
+	st.OverlapBytes += uint64(sg.Stats().OverlapBytes)
+	if length == 0 {
+		return
+	}
+	if skip > 0 {
+		st.Gaps++
+		st.MissingBytes += uint64(skip)
+	}
+	st.Bytes += uint64(length)
+
+	if !s.started {
+		s.started = true
+		st.Streams++
+		for _, nc := range s.r.consumers {
+			if c := nc(s.key, s.start); c != nil {
+				s.consumers = append(s.consumers, c)
+			}
+		}
+	}
+	c := Chunk{
+		Data:       sg.Fetch(length),
+		FromClient: (dir == reassembly.TCPDirClientToServer) != s.flipped,
+		Skipped:    max(skip, 0),
+		Time:       sg.CaptureInfo(0).Timestamp,
+	}
+	for _, cons := range s.consumers {
+		cons.Data(&c)
+	}
+}
+
+// ReassemblyComplete closes the consumers and lets the assembler forget the
+// connection
+func (s *stream) ReassemblyComplete(ac reassembly.AssemblerContext) bool {
+	for _, c := range s.consumers {
+		c.Close()
+	}
+	s.consumers = nil
+	return true
+}
@@ -0,0 +1,143 @@
+package tcp
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// recorder collects the reassembled streams of every connection
+type recorder struct {
+	streams map[FlowKey]*recorded
+}
+
+type recorded struct {
+	client, server string
+	skipped        int
+	closed         bool
+}
+
+func (r *recorder) consumer(key FlowKey, start time.Time) Consumer {
+	if r.streams == nil {
+		r.streams = make(map[FlowKey]*recorded)
+	}
+	s := &recorded{}
+	r.streams[key] = s
+	return s
+}
+
+func (s *recorded) Data(c *Chunk) {
+	if c.FromClient {
+		s.client += string(c.Data)
+	} else {
+		s.server += string(c.Data)
+	}
+	s.skipped += c.Skipped
+}
+
+func (s *recorded) Close() { s.closed = true }
+
+func TestReassembly(t *testing.T) {
+	ms := time.Millisecond
+	var rec recorder
+	r := NewReassembler(DefaultReassemblyConfig, rec.consumer)
+	for _, s := range []seg{
+		{src: client, dst: server, flags: "S", seq: 100},
+		{src: server, dst: client, flags: "SA", seq: 500, ack: 101, at: 1 * ms},
+		{src: client, dst: server, flags: "A", seq: 101, ack: 501, at: 2 * ms},
+		{src: client, dst: server, flags: "PA", seq: 101, ack: 501, at: 3 * ms, payload: []byte("GET / ")},
+		// "HTTP/1.1\r\n" overtakes "HTTP/"
+		{src: client, dst: server, flags: "PA", seq: 112, ack: 501, at: 4 * ms, payload: []byte("1.1\r\n")},
+		{src: client, dst: server, flags: "PA", seq: 107, ack: 501, at: 5 * ms, payload: []byte("HTTP/")},
+		// retransmission overlapping delivered bytes
+		{src: client, dst: server, flags: "PA", seq: 107, ack: 501, at: 300 * ms, payload: []byte("HTTP/1.1\r\n")},
+		{src: server, dst: client, flags: "PA", seq: 501, ack: 117, at: 301 * ms, payload: []byte("HTTP/1.1 200 OK\r\n")},
+		{src: client, dst: server, flags: "FA", seq: 117, ack: 518, at: 302 * ms},
+		{src: server, dst: client, flags: "FA", seq: 518, ack: 118, at: 303 * ms},
+		{src: client, dst: server, flags: "A", seq: 118, ack: 519, at: 304 * ms},
+	} {
+		r.Process(s.packet(t))
+	}
+	r.Finish()
+
+	s := rec.streams[FlowKey{Client: netip.MustParseAddrPort(client), Server: netip.MustParseAddrPort(server)}]
+	if s == nil {
+		t.Fatalf("streams = %+v, want one for %s", rec.streams, client)
+	}
+	if s.client != "GET / HTTP/1.1\r\n" || s.server != "HTTP/1.1 200 OK\r\n" || !s.closed {
+		t.Errorf("stream = %+v", s)
+	}
+	st := r.Stats()
+	if st.Streams != 1 || st.Bytes != 33 || st.Gaps != 0 || st.OverlapBytes != 10 {
+		t.Errorf("stats = %+v", st)
+	}
+}
+
+func TestReassemblyGapAndMidStream(t *testing.T) {
+	var rec recorder
+	r := NewReassembler(DefaultReassemblyConfig, rec.consumer)
+	for _, s := range []seg{
+		// capture starts with the server sending; 1100-1199 is never captured
+		{src: server, dst: client, flags: "PA", seq: 1000, ack: 1, payload: data(100)},
+		{src: server, dst: client, flags: "PA", seq: 1200, ack: 1, at: time.Millisecond, payload: data(50)},
+		{src: client, dst: server, flags: "PA", seq: 1, ack: 1100, at: 2 * time.Millisecond, payload: []byte("more")},
+		// the gap is skipped once it is older than GapTimeout
+		{src: client, dst: server, flags: "A", seq: 5, ack: 1100, at: 10 * time.Second},
+	} {
+		r.Process(s.packet(t))
+	}
+	s := rec.streams[FlowKey{Client: netip.MustParseAddrPort(client), Server: netip.MustParseAddrPort(server)}]
+	if s == nil {
+		t.Fatalf("streams = %+v, want the lower port as server", rec.streams)
+	}
+	if len(s.server) != 150 || s.client != "more" || s.skipped != 100 {
+		t.Errorf("stream = %d server bytes, client %q, %d skipped", len(s.server), s.client, s.skipped)
+	}
+	r.Finish()
+	if st := r.Stats(); st.Gaps != 1 || st.MissingBytes != 100 || !s.closed {
+		t.Errorf("stats = %+v, closed = %v", st, s.closed)
+	}
+}
+
+func TestStreamDumper(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "streams")
+	d, err := NewStreamDumper(dir)
+	if err != nil {
+		t.Fatalf("NewStreamDumper: %v", err)
+	}
+	r := NewReassembler(DefaultReassemblyConfig, d.Consumer)
+	// the second connection reuses the ports of the first
+	for range 2 {
+		for _, s := range []seg{
+			{src: client6, dst: server6, flags: "S", seq: 100},
+			{src: server6, dst: client6, flags: "SA", seq: 500, ack: 101},
+			{src: client6, dst: server6, flags: "PA", seq: 101, ack: 501, payload: []byte("ping")},
+			{src: client6, dst: server6, flags: "PA", seq: 105, ack: 501, payload: []byte("ping")},
+			{src: server6, dst: client6, flags: "PA", seq: 501, ack: 109, payload: []byte("pong")},
+		} {
+			r.Process(s.packet(t))
+		}
+		r.Finish()
+	}
+	if err := d.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	base := filepath.Join(dir, "20260220T100000.000000_1_2001_db8__10.50000-2001_db8__1.80")
+	again := filepath.Join(dir, "20260220T100000.000000_2_2001_db8__10.50000-2001_db8__1.80")
+	files := d.Files()
+	if len(files) != 4 || files[0] != base+".c2s" || files[1] != base+".s2c" || files[2] != again+".c2s" || files[3] != again+".s2c" {
+		t.Fatalf("Files = %v", files)
+	}
+	for path, want := range map[string]string{
+		base + ".c2s": "pingping", base + ".s2c": "pong",
+		again + ".c2s": "pingping", again + ".s2c": "pong",
+	} {
+		got, err := os.ReadFile(path)
+		if err != nil || string(got) != want {
+			t.Errorf("%s = %q, %v; want %q", path, got, err, want)
+		}
+	}
+}
@@ -0,0 +1,303 @@
+package tcp
+
+import (
+	"sort"
+	"time"
+
+	"github.com/google/gopacket/layers"
+)
+
+// reorderWindow is how soon after the sequence space advanced a segment
+// filling a hole counts as reordered rather than retransmitted. Wireshark
+// uses the same default when the RTT is unknown.
+const reorderWindow = 3 * time.Millisecond
+
+// flowsKept is the number of per-flow breakdowns a StreamAnalyzer keeps,
+// worst flows first
+const flowsKept = 100
+
+// StreamStats counts data phase events of one flow or of a whole capture.
+// Retransmissions includes the fast and spurious ones.
+type StreamStats struct {
+	Segments                int
+	DataSegments            int    // segments carrying payload
+	Bytes                   uint64 // payload bytes
+	Retransmissions         int
+	FastRetransmissions     int // retransmitted after duplicate ACKs
+	SpuriousRetransmissions int // retransmitted data the receiver had already ACKed
+	DuplicateAcks           int
+	OutOfOrder              int
+	LostSegments            int // gaps in the sequence space, i.e. segments never captured
+
+	ZeroWindows      int           // zero window advertisements
+	ZeroWindowProbes int           // probes sent into a zero window
+	WindowFull       int           // data segments that filled the receive window
+	WindowUpdates    int           // zero windows reopened by the receiver
+	StallTime        time.Duration // total time a receiver advertised a zero window
+}
+
+// Issues returns the number of events that indicate an unhealthy path
+func (s *StreamStats) Issues() int {
+	return s.Retransmissions + s.DuplicateAcks + s.OutOfOrder + s.LostSegments
+}
+
+// RetransmissionRate returns retransmitted data segments in percent
+func (s *StreamStats) RetransmissionRate() float64 {
+	if s.DataSegments == 0 {
+		return 0
+	}
+	return float64(s.Retransmissions) / float64(s.DataSegments) * 100
+}
+
+func (s *StreamStats) add(o *StreamStats) {
+	s.Segments += o.Segments
+	s.DataSegments += o.DataSegments
+	s.Bytes += o.Bytes
+	s.Retransmissions += o.Retransmissions
+	s.FastRetransmissions += o.FastRetransmissions
+	s.SpuriousRetransmissions += o.SpuriousRetransmissions
+	s.DuplicateAcks += o.DuplicateAcks
+	s.OutOfOrder += o.OutOfOrder
+	s.LostSegments += o.LostSegments
+	s.ZeroWindows += o.ZeroWindows
+	s.ZeroWindowProbes += o.ZeroWindowProbes
+	s.WindowFull += o.WindowFull
+	s.WindowUpdates += o.WindowUpdates
+	s.StallTime += o.StallTime
+}
+
+// FlowStream is the data phase breakdown of a single flow
+type FlowStream struct {
+	Key       FlowKey
+	FirstSeen time.Time
+	LastSeen  time.Time
+	StreamStats
+}
+
+// direction is the sequence state of one side of a connection
+type direction struct {
+	seen     bool
+	nextSeq  uint32    // highest sequence number sent plus one
+	advanced time.Time // when nextSeq last moved forward
+	acked    bool      // an ACK has been sent
+	lastAck  uint32    // highest acknowledgment sent
+	lastWin  uint16
+	dupAcks  int // consecutive duplicate ACKs for lastAck
+
+	synSeen   bool
+	wscale    uint8     // window scale offered in the SYN
+	window    uint32    // last advertised receive window, scaled
+	zeroSince time.Time // when the window closed, zero while open
+}
+
+// shift returns the window scale that applies to windows advertised by d,
+// and whether it is known. Scaling is only in effect when both SYNs carried
+// the option, so flows joined mid-stream have an unknown scale.
+func (f *Flow) shift(d *direction) (uint8, bool) {
+	c, s := &f.dirs[0], &f.dirs[1]
+	if !c.synSeen || !s.synSeen {
+		return 0, false
+	}
+	if c.wscale == noScale || s.wscale == noScale {
+		return 0, true
+	}
+	return d.wscale, true
+}
+
+// noScale marks a SYN without the window scale option
+const noScale = 0xff
+
+// StreamAnalyzer follows the sequence and acknowledgment numbers of every
+// TCP connection, including ones already established when the capture
+// started, and classifies retransmissions, duplicate ACKs, reordering and
+// lost segments. It keeps its state on the flows of the Analyzer that
+// feeds it; see Analyzer.Streams.
+type StreamAnalyzer struct {
+	totals  StreamStats
+	worst   []FlowStream // most issues first
+	stalled []FlowStream // longest stall first
+}
+
+// process updates the stream state of f with a segment
+func (a *StreamAnalyzer) process(f *Flow, fromClient bool, tcp *layers.TCP, ts time.Time) {
+	d, o := &f.dirs[0], &f.dirs[1]
+	if !fromClient {
+		d, o = o, d
+	}
+	f.Stream.Segments++
+	if tcp.SYN {
+		d.synSeen = true
+		d.wscale = noScale
+		if ws := parseOptions(tcp).WindowScale; ws >= 0 {
+			d.wscale = uint8(ws)
+		}
+	}
+	a.segment(f, d, o, tcp, ts)
+	if tcp.ACK {
+		a.ack(f, d, o, tcp)
+	}
+	a.window(f, d, tcp, ts)
+}
+
+// segment classifies the sequence space consumed by a segment
+func (a *StreamAnalyzer) segment(f *Flow, d, o *direction, tcp *layers.TCP, ts time.Time) {
+	n := uint32(len(tcp.Payload))
+	if n > 0 {
+		f.Stream.DataSegments++
+		f.Stream.Bytes += uint64(n)
+	}
+	length := n
+	if tcp.SYN {
+		length++
+	}
+	if tcp.FIN {
+		length++
+	}
+	end := tcp.Seq + length
+
+	switch {
+	case !d.seen || tcp.SYN:
+		// handshake retransmissions are counted by the handshake analyzer
+		d.seen = true
+		d.nextSeq = end
+		d.advanced = ts
+		return
+	case n <= 1 && !tcp.FIN && o.closed() && (tcp.Seq == d.nextSeq-1 || n == 1 && tcp.Seq == d.nextSeq):
+		// probes either repeat the last byte or send one byte past the window
+		f.Stream.ZeroWindowProbes++
+	case length == 0:
+		return
+	case seqGT(tcp.Seq, d.nextSeq):
+		f.Stream.LostSegments++
+	case seqLT(tcp.Seq, d.nextSeq):
+		switch {
+		case n <= 1 && !tcp.FIN && tcp.Seq == d.nextSeq-1:
+			// keep-alive probe
+		case o.acked && seqLE(end, o.lastAck):
+			f.Stream.Retransmissions++
+			f.Stream.SpuriousRetransmissions++
+		case ts.Sub(d.advanced) < reorderWindow:
+			f.Stream.OutOfOrder++
+		case o.dupAcks >= 2 && tcp.Seq == o.lastAck:
+			f.Stream.Retransmissions++
+			f.Stream.FastRetransmissions++
+		default:
+			f.Stream.Retransmissions++
+		}
+	}
+	if seqGT(end, d.nextSeq) {
+		d.nextSeq = end
+		d.advanced = ts
+	}
+	if _, known := f.shift(o); known && n > 0 && o.acked && o.window > 0 && !seqLT(end, o.lastAck+o.window) {
+		f.Stream.WindowFull++
+	}
+}
+
+// window tracks the receive window advertised by d, timing how long it
+// stays closed
+func (a *StreamAnalyzer) window(f *Flow, d *direction, tcp *layers.TCP, ts time.Time) {
+	if tcp.RST {
+		// resets carry no meaningful window
+		return
+	}
+	win := uint32(tcp.Window)
+	if shift, _ := f.shift(d); !tcp.SYN {
+		win <<= shift
+	}
+	switch {
+	case win == 0 && !tcp.SYN:
+		f.Stream.ZeroWindows++
+		if d.zeroSince.IsZero() {
+			d.zeroSince = ts
+		}
+	case win > 0 && !d.zeroSince.IsZero():
+		f.Stream.WindowUpdates++
+		f.Stream.StallTime += ts.Sub(d.zeroSince)
+		d.zeroSince = time.Time{}
+	}
+	d.window = win
+}
+
+// closed reports whether d last advertised a zero window
+func (d *direction) closed() bool {
+	return !d.zeroSince.IsZero()
+}
+
+// ack tracks the acknowledgments sent by d and counts duplicates
+func (a *StreamAnalyzer) ack(f *Flow, d, o *direction, tcp *layers.TCP) {
+	pure := len(tcp.Payload) == 0 && !tcp.SYN && !tcp.FIN && !tcp.RST
+	switch {
+	case !d.acked || seqGT(tcp.Ack, d.lastAck):
+		d.acked = true
+		d.lastAck = tcp.Ack
+		d.dupAcks = 0
+	case pure && tcp.Ack == d.lastAck && tcp.Window == d.lastWin && tcp.Window != 0 && o.seen && seqGT(o.nextSeq, tcp.Ack):
+		// answers to zero window probes are not duplicates
+		d.dupAcks++
+		f.Stream.DuplicateAcks++
+	}
+	d.lastWin = tcp.Window
+}
+
+// Stats returns the totals over all flows that left the table
+func (a *StreamAnalyzer) Stats() StreamStats {
+	return a.totals
+}
+
+// Flows returns the flows with the most issues, worst first
+func (a *StreamAnalyzer) Flows() []FlowStream {
+	a.prune()
+	return append([]FlowStream(nil), a.worst...)
+}
+
+// Stalled returns the flows that spent the longest time with a closed
+// receive window, worst first
+func (a *StreamAnalyzer) Stalled() []FlowStream {
+	a.prune()
+	return append([]FlowStream(nil), a.stalled...)
+}
+
+// evicted folds a flow leaving the table into the totals
+func (a *StreamAnalyzer) evicted(f *Flow) {
+	// a window still closed at the end stalled until the last packet
+	for i := range f.dirs {
+		if d := &f.dirs[i]; d.closed() {
+			f.Stream.StallTime += f.LastSeen.Sub(d.zeroSince)
+			d.zeroSince = time.Time{}
+		}
+	}
+	a.totals.add(&f.Stream)
+	fs := FlowStream{Key: f.Key, FirstSeen: f.FirstSeen, LastSeen: f.LastSeen, StreamStats: f.Stream}
+	if f.Stream.Issues() > 0 {
+		a.worst = append(a.worst, fs)
+	}
+	if f.Stream.ZeroWindows > 0 {
+		a.stalled = append(a.stalled, fs)
+	}
+	if len(a.worst) >= 2*flowsKept || len(a.stalled) >= 2*flowsKept {
+		a.prune()
+	}
+}
+
+// prune sorts the kept flows and drops all but the worst flowsKept
+func (a *StreamAnalyzer) prune() {
+	a.worst = keepWorst(a.worst, func(f *FlowStream) int64 { return int64(f.Issues()) })
+	a.stalled = keepWorst(a.stalled, func(f *FlowStream) int64 { return int64(f.StallTime) })
+}
+
+// keepWorst sorts flows by descending score and keeps the first flowsKept
+func keepWorst(flows []FlowStream, score func(*FlowStream) int64) []FlowStream {
+	sort.SliceStable(flows, func(i, j int) bool {
+		return score(&flows[i]) > score(&flows[j])
+	})
+	if len(flows) > flowsKept {
+		flows = flows[:flowsKept]
+	}
+	return flows
+}
+
+// Sequence number comparisons modulo 2^32 (RFC 1982)
+func seqLT(a, b uint32) bool { return int32(a-b) < 0 }
+func seqGT(a, b uint32) bool { return int32(a-b) > 0 }
+func seqLE(a, b uint32) bool { return int32(a-b) <= 0 }
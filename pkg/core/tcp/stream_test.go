@@ -0,0 +1,161 @@
+package tcp
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket/layers"
+)
+
+// data returns n bytes of payload
+func data(n int) []byte {
+	return bytes.Repeat([]byte{'x'}, n)
+}
+
+func TestStreamClassification(t *testing.T) {
+	ms := time.Millisecond
+	h := NewAnalyzer(DefaultConfig)
+	a := h.Streams()
+	for _, s := range []seg{
+		{src: client, dst: server, flags: "S", seq: 100},
+		{src: server, dst: client, flags: "SA", seq: 500, ack: 101, at: 1 * ms},
+		{src: client, dst: server, flags: "A", seq: 101, ack: 501, at: 2 * ms},
+		{src: client, dst: server, flags: "PA", seq: 101, ack: 501, at: 10 * ms, payload: data(100)},
+		// 201 is never captured
+		{src: client, dst: server, flags: "PA", seq: 301, ack: 501, at: 11 * ms, payload: data(100)},
+		{src: server, dst: client, flags: "A", seq: 501, ack: 201, at: 12 * ms},
+		{src: server, dst: client, flags: "A", seq: 501, ack: 201, at: 13 * ms},
+		{src: server, dst: client, flags: "A", seq: 501, ack: 201, at: 14 * ms},
+		// fast retransmission after two duplicate ACKs
+		{src: client, dst: server, flags: "PA", seq: 201, ack: 501, at: 20 * ms, payload: data(100)},
+		{src: server, dst: client, flags: "A", seq: 501, ack: 401, at: 21 * ms},
+		// spurious: already acknowledged
+		{src: client, dst: server, flags: "PA", seq: 101, ack: 501, at: 300 * ms, payload: data(100)},
+		{src: client, dst: server, flags: "PA", seq: 401, ack: 501, at: 400 * ms, payload: data(100)},
+		// 501 overtaken by 601
+		{src: client, dst: server, flags: "PA", seq: 601, ack: 501, at: 401 * ms, payload: data(100)},
+		{src: client, dst: server, flags: "PA", seq: 501, ack: 501, at: 402 * ms, payload: data(100)},
+		// timeout retransmission
+		{src: client, dst: server, flags: "PA", seq: 601, ack: 501, at: time.Second, payload: data(100)},
+		// keep-alive probe
+		{src: client, dst: server, flags: "A", seq: 700, ack: 501, at: 2 * time.Second, payload: data(1)},
+	} {
+		h.Process(s.packet(t))
+	}
+	h.Finish()
+
+	want := StreamStats{
+		Segments:                16,
+		DataSegments:            9,
+		Bytes:                   801,
+		Retransmissions:         3,
+		FastRetransmissions:     1,
+		SpuriousRetransmissions: 1,
+		DuplicateAcks:           2,
+		OutOfOrder:              1,
+		LostSegments:            2,
+	}
+	if got := a.Stats(); got != want {
+		t.Errorf("stats = %+v\nwant    %+v", got, want)
+	}
+	flows := a.Flows()
+	if len(flows) != 1 || flows[0].Key.Client.String() != client || flows[0].Issues() != 8 {
+		t.Errorf("flows = %+v", flows)
+	}
+}
+
+func TestStreamMidStream(t *testing.T) {
+	h := NewAnalyzer(DefaultConfig)
+	a := h.Streams()
+	for _, s := range []seg{
+		// capture starts with the server sending, near the end of sequence space
+		{src: server, dst: client, flags: "PA", seq: 0xffffffc0, ack: 1000, payload: data(100)},
+		{src: server, dst: client, flags: "PA", seq: 0x24, ack: 1000, at: time.Millisecond, payload: data(100)},
+		{src: client, dst: server, flags: "A", seq: 1000, ack: 0x88, at: 2 * time.Millisecond},
+		{src: server, dst: client, flags: "PA", seq: 0x24, ack: 1000, at: time.Second, payload: data(100)},
+	} {
+		h.Process(s.packet(t))
+	}
+	h.Finish()
+
+	st := a.Stats()
+	if st.LostSegments != 0 || st.Retransmissions != 1 || st.SpuriousRetransmissions != 1 {
+		t.Errorf("stats = %+v, want a single spurious retransmission", st)
+	}
+	flows := a.Flows()
+	if len(flows) != 1 || flows[0].Key.Client.String() != client || flows[0].Key.Server.String() != server {
+		t.Errorf("flows = %+v, want the lower port as server", flows)
+	}
+}
+
+func TestStreamStatsRate(t *testing.T) {
+	s := StreamStats{DataSegments: 200, Retransmissions: 3}
+	if s.RetransmissionRate() != 1.5 {
+		t.Errorf("RetransmissionRate = %v, want 1.5", s.RetransmissionRate())
+	}
+	var empty StreamStats
+	if empty.RetransmissionRate() != 0 {
+		t.Errorf("RetransmissionRate of no data = %v, want 0", empty.RetransmissionRate())
+	}
+}
+
+func TestStreamZeroWindow(t *testing.T) {
+	ms := time.Millisecond
+	wscale := []layers.TCPOption{{OptionType: layers.TCPOptionKindWindowScale, OptionLength: 3, OptionData: []byte{7}}}
+	h := NewAnalyzer(DefaultConfig)
+	a := h.Streams()
+	for _, s := range []seg{
+		{src: client, dst: server, flags: "S", seq: 100, opts: wscale},
+		{src: server, dst: client, flags: "SA", seq: 500, ack: 101, win: 65535, at: 1 * ms, opts: wscale},
+		// 2 << 7 = 256 bytes of window
+		{src: client, dst: server, flags: "A", seq: 101, ack: 501, at: 2 * ms},
+		{src: server, dst: client, flags: "A", seq: 501, ack: 101, win: 2, at: 3 * ms},
+		{src: client, dst: server, flags: "PA", seq: 101, ack: 501, at: 10 * ms, payload: data(256)},
+		{src: server, dst: client, flags: "A", seq: 501, ack: 357, zeroWin: true, at: 20 * ms},
+		// probe below the window edge, answered with the same zero window
+		{src: client, dst: server, flags: "A", seq: 356, ack: 501, at: 220 * ms},
+		{src: server, dst: client, flags: "A", seq: 501, ack: 357, zeroWin: true, at: 221 * ms},
+		{src: client, dst: server, flags: "A", seq: 357, ack: 501, at: 620 * ms, payload: data(1)},
+		{src: server, dst: client, flags: "A", seq: 501, ack: 357, zeroWin: true, at: 621 * ms},
+		// the application reads, the window reopens after two seconds
+		{src: server, dst: client, flags: "A", seq: 501, ack: 357, win: 4, at: 2020 * ms},
+		// and closes again until the capture ends
+		{src: server, dst: client, flags: "A", seq: 501, ack: 357, zeroWin: true, at: 3 * time.Second},
+		{src: server, dst: client, flags: "A", seq: 501, ack: 357, zeroWin: true, at: 4 * time.Second},
+	} {
+		h.Process(s.packet(t))
+	}
+	h.Finish()
+
+	st := a.Stats()
+	if st.WindowFull != 1 || st.ZeroWindows != 5 || st.ZeroWindowProbes != 2 || st.WindowUpdates != 1 {
+		t.Errorf("stats = %+v, want 1 window full, 5 zero windows, 2 probes, 1 update", st)
+	}
+	if st.DuplicateAcks != 0 || st.Retransmissions != 0 {
+		t.Errorf("probes misclassified: %+v", st)
+	}
+	if st.StallTime != 3*time.Second {
+		t.Errorf("StallTime = %v, want 3s", st.StallTime)
+	}
+	stalled := a.Stalled()
+	if len(stalled) != 1 || stalled[0].StallTime != 3*time.Second {
+		t.Errorf("Stalled = %+v", stalled)
+	}
+}
+
+func TestStreamWindowScaleUnknownMidStream(t *testing.T) {
+	h := NewAnalyzer(DefaultConfig)
+	a := h.Streams()
+	for _, s := range []seg{
+		// a scaled window of 2 would look full without the handshake
+		{src: server, dst: client, flags: "A", seq: 501, ack: 101, win: 2},
+		{src: client, dst: server, flags: "PA", seq: 101, ack: 501, at: time.Millisecond, payload: data(100)},
+	} {
+		h.Process(s.packet(t))
+	}
+	h.Finish()
+	if st := a.Stats(); st.WindowFull != 0 {
+		t.Errorf("WindowFull = %d without a known window scale", st.WindowFull)
+	}
+}
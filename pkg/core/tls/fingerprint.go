@@ -0,0 +1,124 @@
+package tls
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ja3 returns the JA3 string of a ClientHello and its MD5 hash: version,
+// ciphers, extensions, groups and point formats in wire order, GREASE
+// removed
+func ja3(h *clientHello) (raw, hash string) {
+	list := func(vs []uint16) string {
+		var parts []string
+		for _, v := range vs {
+			if !grease(v) {
+				parts = append(parts, strconv.Itoa(int(v)))
+			}
+		}
+		return strings.Join(parts, "-")
+	}
+	var formats []string
+	for _, f := range h.pointFormats {
+		formats = append(formats, strconv.Itoa(int(f)))
+	}
+	raw = strings.Join([]string{
+		strconv.Itoa(int(h.version)),
+		list(h.ciphers),
+		list(h.extensions),
+		list(h.groups),
+		strings.Join(formats, "-"),
+	}, ",")
+	sum := md5.Sum([]byte(raw))
+	return raw, hex.EncodeToString(sum[:])
+}
+
+// ja4 returns the JA4 fingerprint of a ClientHello received over TCP:
+// version, SNI, counts and ALPN in the clear, then truncated hashes of the
+// sorted ciphers and of the sorted extensions with the signature algorithms
+func ja4(h *clientHello) string {
+	var ciphers, exts []string
+	for _, c := range h.ciphers {
+		if !grease(c) {
+			ciphers = append(ciphers, fmt.Sprintf("%04x", c))
+		}
+	}
+	nexts := 0
+	for _, e := range h.extensions {
+		if grease(e) {
+			continue
+		}
+		nexts++
+		if e != extServerName && e != extALPN {
+			exts = append(exts, fmt.Sprintf("%04x", e))
+		}
+	}
+	sni := "i"
+	if h.sni != "" {
+		sni = "d"
+	}
+	a := fmt.Sprintf("t%s%s%02d%02d%s", ja4Version(h.maxVersion()), sni, min(len(ciphers), 99), min(nexts, 99), ja4ALPN(h.alpn))
+
+	sort.Strings(ciphers)
+	sort.Strings(exts)
+	c := strings.Join(exts, ",")
+	var sigs []string
+	for _, s := range h.sigAlgs {
+		if !grease(s) {
+			sigs = append(sigs, fmt.Sprintf("%04x", s))
+		}
+	}
+	if len(sigs) > 0 {
+		c += "_" + strings.Join(sigs, ",")
+	}
+	return a + "_" + truncatedHash(strings.Join(ciphers, ","), len(ciphers) == 0) + "_" + truncatedHash(c, len(exts) == 0)
+}
+
+func ja4Version(v uint16) string {
+	switch v {
+	case 0x0304:
+		return "13"
+	case 0x0303:
+		return "12"
+	case 0x0302:
+		return "11"
+	case 0x0301:
+		return "10"
+	case 0x0300:
+		return "s3"
+	}
+	return "00"
+}
+
+// ja4ALPN returns the first and last character of the first ALPN value, or
+// of its hex form when those are not alphanumeric
+func ja4ALPN(alpn []string) string {
+	if len(alpn) == 0 || alpn[0] == "" {
+		return "00"
+	}
+	p := alpn[0]
+	if alnum(p[0]) && alnum(p[len(p)-1]) {
+		return string([]byte{p[0], p[len(p)-1]})
+	}
+	x := hex.EncodeToString([]byte(p))
+	return string([]byte{x[0], x[len(x)-1]})
+}
+
+func alnum(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// truncatedHash returns the first 12 hex digits of the SHA-256 of s, or
+// zeros for an empty list
+func truncatedHash(s string, empty bool) string {
+	if empty {
+		return "000000000000"
+	}
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])[:12]
+}
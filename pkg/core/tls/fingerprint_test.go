@@ -0,0 +1,36 @@
+package tls
+
+import "testing"
+
+func TestFingerprints(t *testing.T) {
+	h := &clientHello{
+		version:      0x0303,
+		ciphers:      []uint16{0x1a1a, 0x1301, 0x1302, 0xc02b},
+		extensions:   []uint16{0x2a2a, extServerName, extSupportedGroups, extPointFormats, extSignatureAlgorithms, extALPN, extSupportedVersions},
+		sni:          "example.com",
+		groups:       []uint16{0x3a3a, 0x001d, 0x0017},
+		pointFormats: []uint8{0},
+		sigAlgs:      []uint16{0x0403, 0x0804},
+		alpn:         []string{"h2", "http/1.1"},
+		versions:     []uint16{0x4a4a, 0x0304, 0x0303},
+	}
+	raw, hash := ja3(h)
+	if raw != "771,4865-4866-49195,0-10-11-13-16-43,29-23,0" {
+		t.Errorf("JA3 = %q", raw)
+	}
+	if len(hash) != 32 {
+		t.Errorf("JA3 hash = %q", hash)
+	}
+
+	// part c hashes "000a,000b,000d,002b_0403,0804"
+	want := "t13d0306h2_" + truncatedHash("1301,1302,c02b", false) + "_" + truncatedHash("000a,000b,000d,002b_0403,0804", false)
+	if got := ja4(h); got != want {
+		t.Errorf("JA4 = %q, want %q", got, want)
+	}
+
+	h.sni, h.alpn, h.versions = "", nil, nil
+	h.extensions = []uint16{extSupportedGroups}
+	if got := ja4(h); got[:11] != "t12i030100_" {
+		t.Errorf("JA4 without SNI and ALPN = %q", got)
+	}
+}
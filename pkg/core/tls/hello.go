@@ -0,0 +1,304 @@
+package tls
+
+import (
+	"crypto/x509"
+	"strings"
+)
+
+// Record content types and handshake message types (RFC 8446)
+const (
+	recordChangeCipherSpec = 20
+	recordAlert            = 21
+	recordHandshake        = 22
+	recordApplicationData  = 23
+
+	msgClientHello = 1
+	msgServerHello = 2
+	msgCertificate = 11
+)
+
+// Extensions looked at by the parser and the fingerprints
+const (
+	extServerName          = 0x0000
+	extSupportedGroups     = 0x000a
+	extPointFormats        = 0x000b
+	extSignatureAlgorithms = 0x000d
+	extALPN                = 0x0010
+	extSupportedVersions   = 0x002b
+)
+
+// maxRecord is the largest record allowed on the wire (RFC 8446 section
+// 5.2); anything longer means the stream is not TLS
+const maxRecord = 1<<14 + 2048
+
+// reader consumes big-endian fields and length-prefixed vectors
+type reader []byte
+
+func (r *reader) u8() (uint8, bool) {
+	if len(*r) < 1 {
+		return 0, false
+	}
+	v := (*r)[0]
+	*r = (*r)[1:]
+	return v, true
+}
+
+func (r *reader) u16() (uint16, bool) {
+	if len(*r) < 2 {
+		return 0, false
+	}
+	v := uint16((*r)[0])<<8 | uint16((*r)[1])
+	*r = (*r)[2:]
+	return v, true
+}
+
+func (r *reader) u24() (int, bool) {
+	if len(*r) < 3 {
+		return 0, false
+	}
+	v := int((*r)[0])<<16 | int((*r)[1])<<8 | int((*r)[2])
+	*r = (*r)[3:]
+	return v, true
+}
+
+func (r *reader) bytes(n int) ([]byte, bool) {
+	if len(*r) < n {
+		return nil, false
+	}
+	v := (*r)[:n]
+	*r = (*r)[n:]
+	return v, true
+}
+
+// vec reads a vector whose length prefix is size bytes long
+func (r *reader) vec(size int) (reader, bool) {
+	var n int
+	var ok bool
+	switch size {
+	case 1:
+		var v uint8
+		v, ok = r.u8()
+		n = int(v)
+	case 2:
+		var v uint16
+		v, ok = r.u16()
+		n = int(v)
+	case 3:
+		n, ok = r.u24()
+	}
+	if !ok {
+		return nil, false
+	}
+	b, ok := r.bytes(n)
+	return reader(b), ok
+}
+
+// u16s reads a vector of 16-bit values
+func (r *reader) u16s(size int) ([]uint16, bool) {
+	v, ok := r.vec(size)
+	if !ok || len(v)%2 != 0 {
+		return nil, false
+	}
+	out := make([]uint16, 0, len(v)/2)
+	for len(v) > 0 {
+		x, _ := v.u16()
+		out = append(out, x)
+	}
+	return out, true
+}
+
+// clientHello holds the fields of a ClientHello used for reporting and
+// fingerprinting, in wire order
+type clientHello struct {
+	version      uint16
+	ciphers      []uint16
+	extensions   []uint16
+	sni          string
+	groups       []uint16
+	pointFormats []uint8
+	sigAlgs      []uint16
+	alpn         []string
+	versions     []uint16 // supported_versions
+}
+
+func parseClientHello(body []byte) (*clientHello, bool) {
+	r := reader(body)
+	h := &clientHello{}
+	var ok bool
+	if h.version, ok = r.u16(); !ok {
+		return nil, false
+	}
+	if _, ok = r.bytes(32); !ok { // random
+		return nil, false
+	}
+	if _, ok = r.vec(1); !ok { // session id
+		return nil, false
+	}
+	if h.ciphers, ok = r.u16s(2); !ok {
+		return nil, false
+	}
+	if _, ok = r.vec(1); !ok { // compression methods
+		return nil, false
+	}
+	if len(r) == 0 {
+		return h, true
+	}
+	exts, ok := r.vec(2)
+	if !ok {
+		return nil, false
+	}
+	for len(exts) > 0 {
+		typ, ok1 := exts.u16()
+		data, ok2 := exts.vec(2)
+		if !ok1 || !ok2 {
+			return nil, false
+		}
+		h.extensions = append(h.extensions, typ)
+		switch typ {
+		case extServerName:
+			names, _ := data.vec(2)
+			for len(names) > 0 {
+				kind, _ := names.u8()
+				name, ok := names.vec(2)
+				if !ok {
+					break
+				}
+				if kind == 0 {
+					h.sni = strings.ToLower(string(name))
+				}
+			}
+		case extSupportedGroups:
+			h.groups, _ = data.u16s(2)
+		case extPointFormats:
+			pf, _ := data.vec(1)
+			h.pointFormats = append([]uint8(nil), pf...)
+		case extSignatureAlgorithms:
+			h.sigAlgs, _ = data.u16s(2)
+		case extALPN:
+			list, _ := data.vec(2)
+			for len(list) > 0 {
+				proto, ok := list.vec(1)
+				if !ok {
+					break
+				}
+				h.alpn = append(h.alpn, string(proto))
+			}
+		case extSupportedVersions:
+			h.versions, _ = data.u16s(1)
+		}
+	}
+	return h, true
+}
+
+// ClientHello is the part of a ClientHello reported for protocols that
+// carry the handshake outside of TLS records, such as QUIC
+type ClientHello struct {
+	SNI     string
+	ALPN    []string
+	Version uint16 // highest version offered
+}
+
+// ParseClientHello decodes a complete ClientHello handshake message,
+// starting with its 4-byte message header
+func ParseClientHello(msg []byte) (*ClientHello, bool) {
+	if len(msg) < 4 || msg[0] != msgClientHello {
+		return nil, false
+	}
+	n := int(msg[1])<<16 | int(msg[2])<<8 | int(msg[3])
+	if len(msg)-4 < n {
+		return nil, false
+	}
+	h, ok := parseClientHello(msg[4 : 4+n])
+	if !ok {
+		return nil, false
+	}
+	return &ClientHello{SNI: h.sni, ALPN: h.alpn, Version: h.maxVersion()}, true
+}
+
+// maxVersion returns the highest version offered, ignoring GREASE
+func (h *clientHello) maxVersion() uint16 {
+	if len(h.versions) == 0 {
+		return h.version
+	}
+	var v uint16
+	for _, x := range h.versions {
+		if !grease(x) && x > v {
+			v = x
+		}
+	}
+	return v
+}
+
+// serverHello holds the parameters the server selected
+type serverHello struct {
+	version     uint16 // from supported_versions in TLS 1.3
+	cipherSuite uint16
+	alpn        string
+}
+
+func parseServerHello(body []byte) (*serverHello, bool) {
+	r := reader(body)
+	h := &serverHello{}
+	var ok bool
+	if h.version, ok = r.u16(); !ok {
+		return nil, false
+	}
+	if _, ok = r.bytes(32); !ok {
+		return nil, false
+	}
+	if _, ok = r.vec(1); !ok {
+		return nil, false
+	}
+	if h.cipherSuite, ok = r.u16(); !ok {
+		return nil, false
+	}
+	if _, ok = r.u8(); !ok {
+		return nil, false
+	}
+	exts, _ := r.vec(2)
+	for len(exts) > 0 {
+		typ, ok1 := exts.u16()
+		data, ok2 := exts.vec(2)
+		if !ok1 || !ok2 {
+			return nil, false
+		}
+		switch typ {
+		case extSupportedVersions:
+			if v, ok := data.u16(); ok {
+				h.version = v
+			}
+		case extALPN:
+			list, _ := data.vec(2)
+			if proto, ok := list.vec(1); ok {
+				h.alpn = string(proto)
+			}
+		}
+	}
+	return h, true
+}
+
+// parseCertificates decodes a TLS 1.2 Certificate message, leaf first.
+// Certificates that do not parse are skipped.
+func parseCertificates(body []byte) []*x509.Certificate {
+	r := reader(body)
+	list, ok := r.vec(3)
+	if !ok {
+		return nil
+	}
+	var out []*x509.Certificate
+	for len(list) > 0 {
+		der, ok := list.vec(3)
+		if !ok {
+			break
+		}
+		if c, err := x509.ParseCertificate(der); err == nil {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// grease reports whether v is a GREASE value (RFC 8701)
+func grease(v uint16) bool {
+	return v&0x0f0f == 0x0a0a && v>>8 == v&0xff
+}
@@ -0,0 +1,533 @@
+// Package tls inspects TLS handshakes in reassembled TCP streams: SNI,
+// versions, cipher suites, ALPN, JA3/JA4 client fingerprints, certificates
+// sent in the clear and alerts
+package tls
+
+import (
+	cryptotls "crypto/tls"
+	"crypto/x509"
+	"net/netip"
+	"sort"
+	"strconv"
+	"time"
+
+	"network-app/pkg/core/analyzer"
+	"network-app/pkg/core/tcp"
+)
+
+// Config holds the table limits
+type Config struct {
+	MaxServers      int           // server names tracked; handshakes beyond count in the totals only
+	MaxFingerprints int           // distinct client fingerprints tracked
+	ResponseTimeout time.Duration // ClientHellos unanswered for less than this when the capture ended are pending
+}
+
+// DefaultConfig tracks 10000 server names and 1000 fingerprints, and gives
+// servers 3 seconds to answer
+var DefaultConfig = Config{
+	MaxServers:      10000,
+	MaxFingerprints: 1000,
+	ResponseTimeout: 3 * time.Second,
+}
+
+// maxHandshakeMessage bounds a buffered handshake message; certificate
+// chains rarely exceed a few KiB
+const maxHandshakeMessage = 64 << 10
+
+// VersionName returns the name of a TLS version, e.g. "TLS 1.3"
+func VersionName(v uint16) string {
+	return cryptotls.VersionName(v)
+}
+
+// CipherSuiteName returns the IANA name of a cipher suite
+func CipherSuiteName(id uint16) string {
+	return cryptotls.CipherSuiteName(id)
+}
+
+// Alert is a TLS alert. Alerts sent after ChangeCipherSpec are encrypted
+// and only their presence is known.
+type Alert struct {
+	Level       uint8
+	Description uint8
+	Encrypted   bool
+}
+
+var alertNames = map[uint8]string{
+	0:   "close_notify",
+	10:  "unexpected_message",
+	20:  "bad_record_mac",
+	21:  "decryption_failed",
+	22:  "record_overflow",
+	30:  "decompression_failure",
+	40:  "handshake_failure",
+	41:  "no_certificate",
+	42:  "bad_certificate",
+	43:  "unsupported_certificate",
+	44:  "certificate_revoked",
+	45:  "certificate_expired",
+	46:  "certificate_unknown",
+	47:  "illegal_parameter",
+	48:  "unknown_ca",
+	49:  "access_denied",
+	50:  "decode_error",
+	51:  "decrypt_error",
+	60:  "export_restriction",
+	70:  "protocol_version",
+	71:  "insufficient_security",
+	80:  "internal_error",
+	86:  "inappropriate_fallback",
+	90:  "user_canceled",
+	100: "no_renegotiation",
+	109: "missing_extension",
+	110: "unsupported_extension",
+	112: "unrecognized_name",
+	113: "bad_certificate_status_response",
+	115: "unknown_psk_identity",
+	116: "certificate_required",
+	120: "no_application_protocol",
+}
+
+// Fatal reports whether the alert ends the connection
+func (a Alert) Fatal() bool {
+	return a.Level == 2
+}
+
+func (a Alert) String() string {
+	if a.Encrypted {
+		return "encrypted alert"
+	}
+	name := alertNames[a.Description]
+	if name == "" {
+		name = "alert " + strconv.Itoa(int(a.Description))
+	}
+	if a.Fatal() {
+		return "fatal " + name
+	}
+	return name
+}
+
+// Certificate is one certificate of a chain sent in the clear (TLS 1.2 and
+// older)
+type Certificate struct {
+	Subject   string
+	Issuer    string
+	NotBefore time.Time
+	NotAfter  time.Time
+}
+
+func certificate(c *x509.Certificate) Certificate {
+	name := func(cn, full string) string {
+		if cn != "" {
+			return cn
+		}
+		return full
+	}
+	return Certificate{
+		Subject:   name(c.Subject.CommonName, c.Subject.String()),
+		Issuer:    name(c.Issuer.CommonName, c.Issuer.String()),
+		NotBefore: c.NotBefore,
+		NotAfter:  c.NotAfter,
+	}
+}
+
+// Handshake describes one TLS handshake as far as it was visible in the
+// clear
+type Handshake struct {
+	Client, Server netip.AddrPort
+	Start          time.Time
+	SNI            string
+	OfferedVersion uint16 // highest version the client offered
+	OfferedALPN    []string
+	JA3            string // MD5 of the JA3 string
+	JA4            string
+	ServerHello    bool
+	Version        uint16 // negotiated
+	CipherSuite    uint16
+	ALPN           string        // negotiated; encrypted in TLS 1.3
+	Certificates   []Certificate // encrypted in TLS 1.3
+	Alerts         []Alert
+}
+
+// Failure returns why the handshake failed, or "" when it did not visibly
+// fail. A fatal alert in the clear wins over a missing ServerHello.
+func (h *Handshake) Failure() string {
+	for _, a := range h.Alerts {
+		if a.Fatal() && !a.Encrypted {
+			return a.String()
+		}
+	}
+	if !h.ServerHello {
+		return "no ServerHello"
+	}
+	return ""
+}
+
+// Expired reports whether the server certificate was outside its validity
+// period when the handshake started
+func (h *Handshake) Expired() bool {
+	if len(h.Certificates) == 0 {
+		return false
+	}
+	leaf := h.Certificates[0]
+	return h.Start.After(leaf.NotAfter) || h.Start.Before(leaf.NotBefore)
+}
+
+// Stats aggregates every handshake
+type Stats struct {
+	Handshakes      int
+	Failed          int
+	Pending         int            // ClientHellos the server had not answered yet when the capture ended; not failed
+	Expired         int            // handshakes presenting an expired or not yet valid certificate
+	OfferedVersions map[uint16]int // highest version offered by clients
+	Versions        map[uint16]int // negotiated
+	CipherSuites    map[uint16]int
+	ALPN            map[string]int // negotiated, TLS 1.2 and older
+	Alerts          map[string]int // by Alert.String
+}
+
+// Server aggregates the handshakes towards one server name
+type Server struct {
+	Name         string // SNI, or the server address when the client sent none
+	Handshakes   int
+	Failed       int
+	Failures     map[string]int // by Handshake.Failure
+	Version      uint16         // last negotiated
+	CipherSuite  uint16
+	Certificates []Certificate // last chain seen in the clear
+	Expired      bool          // the last chain was outside its validity period
+}
+
+// Fingerprint counts the handshakes of one kind of client
+type Fingerprint struct {
+	JA3   string
+	JA4   string
+	Count int
+	SNI   string // first server name seen with it
+}
+
+// Analyzer collects TLS handshakes from the streams of a tcp.Reassembler
+type Analyzer struct {
+	cfg          Config
+	stats        Stats
+	servers      map[string]*Server
+	fingerprints map[string]*Fingerprint
+	clock        analyzer.Clock
+	waiting      []*Handshake // closed without a byte from the server, too recently to call failed
+}
+
+// NewAnalyzer creates a TLS analyzer
+func NewAnalyzer(cfg Config) *Analyzer {
+	return &Analyzer{
+		cfg: cfg,
+		stats: Stats{
+			OfferedVersions: make(map[uint16]int),
+			Versions:        make(map[uint16]int),
+			CipherSuites:    make(map[uint16]int),
+			ALPN:            make(map[string]int),
+			Alerts:          make(map[string]int),
+		},
+		servers:      make(map[string]*Server),
+		fingerprints: make(map[string]*Fingerprint),
+	}
+}
+
+// Consumer is a tcp.NewConsumer looking for a TLS handshake at the start of
+// every connection, whatever the port
+func (a *Analyzer) Consumer(key tcp.FlowKey, start time.Time) tcp.Consumer {
+	a.advance(start)
+	return &stream{a: a, h: Handshake{Client: key.Client, Server: key.Server, Start: start}}
+}
+
+// advance moves the clock and, about once per second of capture time, fails
+// the unanswered handshakes older than the response timeout
+func (a *Analyzer) advance(ts time.Time) {
+	if !a.clock.Advance(ts) {
+		return
+	}
+	kept := a.waiting[:0]
+	for _, h := range a.waiting {
+		if a.answerDue(h) {
+			kept = append(kept, h)
+			continue
+		}
+		a.record(h, false)
+	}
+	clear(a.waiting[len(kept):])
+	a.waiting = kept
+}
+
+// answerDue reports whether the server of an unanswered handshake may still
+// answer, i.e. the ClientHello is younger than the response timeout
+func (a *Analyzer) answerDue(h *Handshake) bool {
+	return a.clock.Now().Sub(h.Start) < a.cfg.ResponseTimeout
+}
+
+// Finish counts the handshakes still waiting for their server as pending.
+// Call it once the reassembler has closed every stream.
+func (a *Analyzer) Finish() {
+	for _, h := range a.waiting {
+		a.record(h, a.answerDue(h))
+	}
+	a.waiting = nil
+}
+
+// record folds a finished handshake into the statistics. A pending
+// handshake is not failed: the capture ended before the server could answer.
+func (a *Analyzer) record(h *Handshake, pending bool) {
+	st := &a.stats
+	st.Handshakes++
+	st.OfferedVersions[h.OfferedVersion]++
+	failure := h.Failure()
+	if pending {
+		failure = ""
+		st.Pending++
+	}
+	if failure != "" {
+		st.Failed++
+	}
+	if h.ServerHello {
+		st.Versions[h.Version]++
+		st.CipherSuites[h.CipherSuite]++
+		if h.ALPN != "" {
+			st.ALPN[h.ALPN]++
+		}
+	}
+	for _, al := range h.Alerts {
+		st.Alerts[al.String()]++
+	}
+	expired := h.Expired()
+	if expired {
+		st.Expired++
+	}
+
+	name := h.SNI
+	if name == "" {
+		name = h.Server.String()
+	}
+	srv := a.servers[name]
+	if srv == nil && len(a.servers) < a.cfg.MaxServers {
+		srv = &Server{Name: name, Failures: make(map[string]int)}
+		a.servers[name] = srv
+	}
+	if srv != nil {
+		srv.Handshakes++
+		if failure != "" {
+			srv.Failed++
+			srv.Failures[failure]++
+		}
+		if h.ServerHello {
+			srv.Version = h.Version
+			srv.CipherSuite = h.CipherSuite
+		}
+		if len(h.Certificates) > 0 {
+			srv.Certificates = h.Certificates
+			srv.Expired = expired
+		}
+	}
+
+	k := h.JA4 + "|" + h.JA3
+	fp := a.fingerprints[k]
+	if fp == nil && len(a.fingerprints) < a.cfg.MaxFingerprints {
+		fp = &Fingerprint{JA3: h.JA3, JA4: h.JA4, SNI: h.SNI}
+		a.fingerprints[k] = fp
+	}
+	if fp != nil {
+		fp.Count++
+	}
+}
+
+// Stats returns the totals over all handshakes
+func (a *Analyzer) Stats() Stats {
+	return a.stats
+}
+
+// Servers returns the per-name statistics, most failures first, then the
+// busiest
+func (a *Analyzer) Servers() []*Server {
+	out := make([]*Server, 0, len(a.servers))
+	for _, s := range a.servers {
+		out = append(out, s)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Failed != out[j].Failed {
+			return out[i].Failed > out[j].Failed
+		}
+		if out[i].Handshakes != out[j].Handshakes {
+			return out[i].Handshakes > out[j].Handshakes
+		}
+		return out[i].Name < out[j].Name
+	})
+	return out
+}
+
+// Fingerprints returns the client fingerprints, most common first
+func (a *Analyzer) Fingerprints() []Fingerprint {
+	out := make([]Fingerprint, 0, len(a.fingerprints))
+	for _, fp := range a.fingerprints {
+		out = append(out, *fp)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Count != out[j].Count {
+			return out[i].Count > out[j].Count
+		}
+		return out[i].JA4 < out[j].JA4
+	})
+	return out
+}
+
+// stream parses the records of one connection until both directions are
+// encrypted
+type stream struct {
+	a        *Analyzer
+	h        Handshake
+	hello    bool // ClientHello seen
+	answered bool // the server sent anything at all
+	done     bool
+	dirs     [2]direction // client, server
+}
+
+// direction is the record layer state of one side
+type direction struct {
+	buf       []byte // partial record
+	hs        []byte // partial handshake message
+	skip      int    // bytes left of a record that is not inspected
+	started   bool
+	encrypted bool
+}
+
+func (s *stream) Data(c *tcp.Chunk) {
+	s.a.advance(c.Time)
+	if !c.FromClient {
+		s.answered = true
+	}
+	if s.done {
+		return
+	}
+	i := 0
+	if !c.FromClient {
+		i = 1
+	}
+	d := &s.dirs[i]
+	if c.Skipped > 0 {
+		// record boundaries are lost
+		s.stop()
+		return
+	}
+	data := c.Data
+	if d.skip > 0 {
+		n := min(d.skip, len(data))
+		d.skip -= n
+		data = data[n:]
+	}
+	d.buf = append(d.buf, data...)
+	for len(d.buf) >= 5 && !s.done {
+		typ, n := d.buf[0], int(d.buf[3])<<8|int(d.buf[4])
+		if d.buf[1] != 3 || n > maxRecord || !d.started && typ != recordHandshake && typ != recordAlert {
+			// not TLS
+			s.stop()
+			return
+		}
+		d.started = true
+		if typ == recordApplicationData {
+			d.encrypted = true
+			skipped := min(n, len(d.buf)-5)
+			d.skip = n - skipped
+			d.buf = d.buf[5+skipped:]
+			continue
+		}
+		if len(d.buf) < 5+n {
+			break
+		}
+		s.record(d, typ, d.buf[5:5+n], c.FromClient)
+		if s.done {
+			// the record stopped parsing and released the buffers
+			return
+		}
+		d.buf = d.buf[5+n:]
+	}
+	d.buf = append(d.buf[:0:0], d.buf...)
+	if s.h.ServerHello && s.h.Version == cryptotls.VersionTLS13 || s.dirs[0].encrypted && s.dirs[1].encrypted {
+		s.stop()
+	}
+}
+
+// stop ends parsing and releases the buffers
+func (s *stream) stop() {
+	s.done = true
+	s.dirs = [2]direction{}
+}
+
+// record handles one complete record
+func (s *stream) record(d *direction, typ uint8, body []byte, fromClient bool) {
+	switch typ {
+	case recordChangeCipherSpec:
+		d.encrypted = true
+	case recordAlert:
+		if d.encrypted || len(body) != 2 {
+			s.h.Alerts = append(s.h.Alerts, Alert{Encrypted: true})
+		} else {
+			s.h.Alerts = append(s.h.Alerts, Alert{Level: body[0], Description: body[1]})
+		}
+	case recordHandshake:
+		if d.encrypted {
+			return
+		}
+		d.hs = append(d.hs, body...)
+		for len(d.hs) >= 4 {
+			n := int(d.hs[1])<<16 | int(d.hs[2])<<8 | int(d.hs[3])
+			if n > maxHandshakeMessage {
+				s.stop()
+				return
+			}
+			if len(d.hs) < 4+n {
+				break
+			}
+			s.message(d.hs[0], d.hs[4:4+n], fromClient)
+			d.hs = d.hs[4+n:]
+		}
+		d.hs = append(d.hs[:0:0], d.hs...)
+	}
+}
+
+// message handles one complete handshake message
+func (s *stream) message(typ uint8, body []byte, fromClient bool) {
+	h := &s.h
+	switch {
+	case typ == msgClientHello && fromClient && !s.hello:
+		ch, ok := parseClientHello(body)
+		if !ok {
+			return
+		}
+		s.hello = true
+		h.SNI = ch.sni
+		h.OfferedVersion = ch.maxVersion()
+		h.OfferedALPN = ch.alpn
+		_, h.JA3 = ja3(ch)
+		h.JA4 = ja4(ch)
+	case typ == msgServerHello && !fromClient:
+		sh, ok := parseServerHello(body)
+		if !ok {
+			return
+		}
+		h.ServerHello = true
+		h.Version = sh.version
+		h.CipherSuite = sh.cipherSuite
+		h.ALPN = sh.alpn
+	case typ == msgCertificate && !fromClient:
+		h.Certificates = h.Certificates[:0]
+		for _, c := range parseCertificates(body) {
+			h.Certificates = append(h.Certificates, certificate(c))
+		}
+	}
+}
+
+func (s *stream) Close() {
+	switch {
+	case !s.hello:
+	case !s.answered && s.a.answerDue(&s.h):
+		// the capture may end before the server answers
+		s.a.waiting = append(s.a.waiting, &s.h)
+	default:
+		s.a.record(&s.h, false)
+	}
+}
@@ -0,0 +1,234 @@
+package tls
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	cryptotls "crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"network-app/pkg/core/tcp"
+)
+
+var key = tcp.FlowKey{
+	Client: netip.MustParseAddrPort("192.168.1.10:54321"),
+	Server: netip.MustParseAddrPort("93.184.216.34:443"),
+}
+
+// selfSigned creates a certificate for name valid from notBefore to notAfter
+func selfSigned(t *testing.T, name string, notBefore, notAfter time.Time) (cryptotls.Certificate, *x509.Certificate) {
+	t.Helper()
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		DNSNames:              []string{name},
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &priv.PublicKey, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cryptotls.Certificate{Certificate: [][]byte{der}, PrivateKey: priv, Leaf: leaf}, leaf
+}
+
+// recorder logs what each side of a net.Pipe writes, in order
+type recorder struct {
+	mu     sync.Mutex
+	chunks []tcp.Chunk
+}
+
+type loggedConn struct {
+	net.Conn
+	r          *recorder
+	fromClient bool
+}
+
+func (c *loggedConn) Write(b []byte) (int, error) {
+	c.r.mu.Lock()
+	c.r.chunks = append(c.r.chunks, tcp.Chunk{Data: append([]byte(nil), b...), FromClient: c.fromClient})
+	c.r.mu.Unlock()
+	return c.Conn.Write(b)
+}
+
+// handshake runs a crypto/tls handshake between client and server and
+// returns the bytes both sides wrote
+func handshake(client, server *cryptotls.Config) []tcp.Chunk {
+	r := &recorder{}
+	c, s := net.Pipe()
+	cc := cryptotls.Client(&loggedConn{Conn: c, r: r, fromClient: true}, client)
+	sc := cryptotls.Server(&loggedConn{Conn: s, r: r}, server)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		sc.Handshake()
+		s.Close()
+	}()
+	cc.Handshake()
+	c.Close()
+	wg.Wait()
+	return r.chunks
+}
+
+// analyze feeds the chunks to a fresh analyzer, one byte at a time for the
+// first chunk to exercise record reassembly
+func analyze(start time.Time, chunks []tcp.Chunk) *Analyzer {
+	a := NewAnalyzer(DefaultConfig)
+	s := a.Consumer(key, start)
+	for i, c := range chunks {
+		c.Time = start
+		if i == 0 {
+			for j := range c.Data {
+				s.Data(&tcp.Chunk{Data: c.Data[j : j+1], FromClient: c.FromClient, Time: start})
+			}
+			continue
+		}
+		s.Data(&c)
+	}
+	s.Close()
+	a.Finish()
+	return a
+}
+
+func TestTLS13(t *testing.T) {
+	now := time.Now()
+	cert, leaf := selfSigned(t, "example.com", now.Add(-time.Hour), now.Add(time.Hour))
+	roots := x509.NewCertPool()
+	roots.AddCert(leaf)
+	chunks := handshake(
+		&cryptotls.Config{ServerName: "Example.com", RootCAs: roots, NextProtos: []string{"h2", "http/1.1"}},
+		&cryptotls.Config{Certificates: []cryptotls.Certificate{cert}, NextProtos: []string{"h2"}},
+	)
+	a := analyze(now, chunks)
+
+	st := a.Stats()
+	if st.Handshakes != 1 || st.Failed != 0 || st.Versions[cryptotls.VersionTLS13] != 1 || len(st.ALPN) != 0 {
+		t.Errorf("stats = %+v", st)
+	}
+	servers := a.Servers()
+	if len(servers) != 1 || servers[0].Name != "example.com" || servers[0].Version != cryptotls.VersionTLS13 {
+		t.Fatalf("servers = %+v", servers)
+	}
+	if len(servers[0].Certificates) != 0 {
+		t.Errorf("TLS 1.3 certificates are encrypted, got %+v", servers[0].Certificates)
+	}
+	if CipherSuiteName(servers[0].CipherSuite) != "TLS_AES_128_GCM_SHA256" {
+		t.Errorf("cipher suite = %s", CipherSuiteName(servers[0].CipherSuite))
+	}
+	fps := a.Fingerprints()
+	if len(fps) != 1 || !strings.HasPrefix(fps[0].JA4, "t13d") || !strings.Contains(fps[0].JA4, "h2_") || len(fps[0].JA3) != 32 {
+		t.Errorf("fingerprints = %+v", fps)
+	}
+}
+
+func TestTLS12ExpiredCertificate(t *testing.T) {
+	now := time.Now()
+	cert, leaf := selfSigned(t, "old.example", now.Add(-48*time.Hour), now.Add(-24*time.Hour))
+	roots := x509.NewCertPool()
+	roots.AddCert(leaf)
+	chunks := handshake(
+		&cryptotls.Config{ServerName: "old.example", RootCAs: roots, MaxVersion: cryptotls.VersionTLS12, NextProtos: []string{"http/1.1"}},
+		&cryptotls.Config{Certificates: []cryptotls.Certificate{cert}, NextProtos: []string{"http/1.1"}},
+	)
+	a := analyze(now, chunks)
+
+	st := a.Stats()
+	if st.Handshakes != 1 || st.Failed != 1 || st.Expired != 1 || st.Versions[cryptotls.VersionTLS12] != 1 || st.ALPN["http/1.1"] != 1 {
+		t.Errorf("stats = %+v", st)
+	}
+	srv := a.Servers()[0]
+	if srv.Failures["fatal bad_certificate"] != 1 && srv.Failures["fatal certificate_expired"] != 1 {
+		t.Errorf("failures = %v", srv.Failures)
+	}
+	if !srv.Expired || len(srv.Certificates) != 1 || srv.Certificates[0].Subject != "old.example" {
+		t.Errorf("certificates = %+v, expired %v", srv.Certificates, srv.Expired)
+	}
+}
+
+func TestVersionMismatch(t *testing.T) {
+	now := time.Now()
+	cert, _ := selfSigned(t, "new.example", now.Add(-time.Hour), now.Add(time.Hour))
+	chunks := handshake(
+		&cryptotls.Config{ServerName: "new.example", InsecureSkipVerify: true, MaxVersion: cryptotls.VersionTLS12},
+		&cryptotls.Config{Certificates: []cryptotls.Certificate{cert}, MinVersion: cryptotls.VersionTLS13},
+	)
+	a := analyze(now, chunks)
+
+	st := a.Stats()
+	if st.Handshakes != 1 || st.Failed != 1 || st.OfferedVersions[cryptotls.VersionTLS12] != 1 || st.Alerts["fatal protocol_version"] != 1 {
+		t.Errorf("stats = %+v", st)
+	}
+	if f := a.Servers()[0].Failures; f["fatal protocol_version"] != 1 {
+		t.Errorf("failures = %v", f)
+	}
+}
+
+func TestNoServerHello(t *testing.T) {
+	now := time.Now()
+	cert, _ := selfSigned(t, "slow.example", now.Add(-time.Hour), now.Add(time.Hour))
+	hello := handshake(
+		&cryptotls.Config{ServerName: "slow.example", InsecureSkipVerify: true},
+		&cryptotls.Config{Certificates: []cryptotls.Certificate{cert}},
+	)[0]
+	a := NewAnalyzer(DefaultConfig)
+	// the first server never answers; the second ClientHello was sent
+	// just before the capture ended
+	for _, start := range []time.Time{now, now.Add(10 * time.Second)} {
+		s := a.Consumer(key, start)
+		s.Data(&tcp.Chunk{Data: hello.Data, FromClient: true, Time: start})
+		s.Close()
+	}
+	a.Finish()
+
+	if st := a.Stats(); st.Handshakes != 2 || st.Failed != 1 || st.Pending != 1 {
+		t.Errorf("stats = %+v, want 1 failed and 1 pending", st)
+	}
+	if srv := a.Servers()[0]; srv.Failed != 1 || srv.Failures["no ServerHello"] != 1 {
+		t.Errorf("server = %+v, want 1 failure", srv)
+	}
+}
+
+func TestOversizedHandshakeMessage(t *testing.T) {
+	a := NewAnalyzer(DefaultConfig)
+	s := a.Consumer(key, time.Now())
+	// a ClientHello header claiming 16 MiB stops parsing rather than panic
+	s.Data(&tcp.Chunk{Data: []byte{0x16, 0x03, 0x01, 0x00, 0x04, 0x01, 0xff, 0xff, 0xff}, FromClient: true})
+	s.Data(&tcp.Chunk{Data: []byte{0x16, 0x03, 0x01, 0x00, 0x00}, FromClient: true})
+	s.Close()
+	a.Finish()
+	if st := a.Stats(); st.Handshakes != 0 {
+		t.Errorf("stats = %+v", st)
+	}
+}
+
+func TestNotTLS(t *testing.T) {
+	a := NewAnalyzer(DefaultConfig)
+	s := a.Consumer(key, time.Now())
+	s.Data(&tcp.Chunk{Data: []byte("GET / HTTP/1.1\r\nHost: example.com\r\n\r\n"), FromClient: true})
+	s.Data(&tcp.Chunk{Data: []byte("HTTP/1.1 200 OK\r\n\r\n")})
+	s.Close()
+	if st := a.Stats(); st.Handshakes != 0 {
+		t.Errorf("stats = %+v", st)
+	}
+}
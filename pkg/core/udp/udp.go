@@ -0,0 +1,373 @@
+// Package udp tracks UDP flows, their volume and datagram sizes in each
+// direction, and flags one-way flows that never got a response
+package udp
+
+import (
+	"net/netip"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+
+	"network-app/pkg/core/analyzer"
+	"network-app/pkg/core/conntrack"
+)
+
+// Config holds the flow timeouts and table limits
+type Config struct {
+	IdleTimeout     time.Duration // flows silent for this long are over
+	ResponseTimeout time.Duration // one-way flows younger than this at the end of the capture are pending
+	MaxFlows        int           // flows tracked at once; new ones are dropped and counted once full
+	TopFlows        int           // flows kept for the report, by volume
+	TopDestinations int           // destinations of one-way flows kept for the report
+}
+
+// DefaultConfig ends flows after 30 seconds of silence, like conntrack's
+// UDP timeout, and expects responses within 5 seconds
+var DefaultConfig = Config{
+	IdleTimeout:     30 * time.Second,
+	ResponseTimeout: 5 * time.Second,
+	MaxFlows:        100000,
+	TopFlows:        20,
+	TopDestinations: 20,
+}
+
+// SizeBuckets are the upper bounds of the datagram size distribution, in
+// payload bytes. The last bucket is open-ended: such datagrams do not fit
+// a 1500 byte MTU and get fragmented.
+var SizeBuckets = []int{63, 127, 255, 511, 1023, 1472}
+
+// Counters counts the datagrams of one direction
+type Counters struct {
+	Packets uint64
+	Bytes   uint64 // UDP payload
+	MaxSize int
+}
+
+func (c *Counters) add(size int) {
+	c.Packets++
+	c.Bytes += uint64(size)
+	c.MaxSize = max(c.MaxSize, size)
+}
+
+// Conntrack is what the conntrack table said about a flow
+type Conntrack int
+
+const (
+	ConntrackUnknown   Conntrack = iota // no entry, or not correlated
+	ConntrackReplied                    // the entry saw replies
+	ConntrackUnreplied                  // the entry is marked [UNREPLIED]
+)
+
+func (c Conntrack) String() string {
+	switch c {
+	case ConntrackReplied:
+		return "replied"
+	case ConntrackUnreplied:
+		return "unreplied"
+	}
+	return ""
+}
+
+// Flow is a UDP flow. The client is the endpoint that sent first.
+type Flow struct {
+	Client, Server netip.AddrPort
+	Start, Last    time.Time
+	Sent           Counters // client to server
+	Received       Counters // server to client
+	Conntrack      Conntrack
+}
+
+// OneWay reports whether the server never answered
+func (f *Flow) OneWay() bool {
+	return f.Received.Packets == 0
+}
+
+// Destination aggregates the one-way flows towards one server
+type Destination struct {
+	Server             netip.AddrPort
+	Flows              int // all flows towards the server
+	OneWay             int
+	Packets            uint64 // sent in one-way flows
+	ConntrackUnreplied int    // [UNREPLIED] conntrack entries towards the server
+}
+
+// Stats counts every flow
+type Stats struct {
+	Flows     int
+	OneWay    int // flows whose server never answered
+	Pending   int // one-way flows younger than the response timeout when the capture ended
+	Multicast int // flows to multicast or broadcast addresses, which expect no answer
+	Evicted   int // flows not tracked because the table was full
+	Sent      Counters
+	Received  Counters
+	Sizes     []int // datagrams per SizeBuckets entry, plus one for larger ones
+	// Conntrack correlation, see Correlate
+	ConntrackFlows     int // UDP entries in the conntrack table
+	ConntrackUnreplied int // of those, marked [UNREPLIED]
+	Confirmed          int // one-way flows open at the end that conntrack also holds unreplied
+}
+
+// OneWayRate returns the one-way flows as a percentage of the flows that
+// could have been answered
+func (s *Stats) OneWayRate() float64 {
+	if n := s.Flows - s.Multicast - s.Pending; n > 0 {
+		return float64(s.OneWay) / float64(n) * 100
+	}
+	return 0
+}
+
+type flowKey struct {
+	a, b netip.AddrPort // the lower endpoint first
+}
+
+func keyOf(x, y netip.AddrPort) flowKey {
+	if x.Compare(y) > 0 {
+		x, y = y, x
+	}
+	return flowKey{x, y}
+}
+
+// Analyzer tracks UDP flows. Time is driven by packet timestamps.
+type Analyzer struct {
+	cfg          Config
+	stats        Stats
+	flows        map[flowKey]*Flow
+	top          []*Flow   // finished flows, largest kept
+	open         []flowKey // one-way flows still open at the end of the capture
+	destinations map[netip.AddrPort]*Destination
+	clock        analyzer.Clock
+}
+
+// NewAnalyzer creates a UDP flow tracker
+func NewAnalyzer(cfg Config) *Analyzer {
+	return &Analyzer{
+		cfg:          cfg,
+		stats:        Stats{Sizes: make([]int, len(SizeBuckets)+1)},
+		flows:        make(map[flowKey]*Flow),
+		destinations: make(map[netip.AddrPort]*Destination),
+	}
+}
+
+// Process handles a single packet
+func (a *Analyzer) Process(pkt gopacket.Packet) {
+	udp, ok := pkt.Layer(layers.LayerTypeUDP).(*layers.UDP)
+	if !ok {
+		return
+	}
+	srcIP, dstIP, ok := analyzer.Addrs(pkt.NetworkLayer())
+	if !ok {
+		return
+	}
+	ts := pkt.Metadata().Timestamp
+	a.advance(ts)
+	src := netip.AddrPortFrom(srcIP, uint16(udp.SrcPort))
+	dst := netip.AddrPortFrom(dstIP, uint16(udp.DstPort))
+	size := len(udp.Payload)
+
+	k := keyOf(src, dst)
+	f := a.flows[k]
+	if f == nil {
+		if len(a.flows) >= a.cfg.MaxFlows {
+			a.stats.Evicted++
+			return
+		}
+		f = &Flow{Client: src, Server: dst, Start: ts}
+		if src.Port() < 1024 && dst.Port() >= 1024 {
+			// the capture started with the response of a well-known service
+			f.Client, f.Server = dst, src
+		}
+		a.flows[k] = f
+	}
+	f.Last = ts
+	if src == f.Client {
+		f.Sent.add(size)
+		a.stats.Sent.add(size)
+	} else {
+		f.Received.add(size)
+		a.stats.Received.add(size)
+	}
+	a.stats.Sizes[sizeBucket(size)]++
+}
+
+func sizeBucket(size int) int {
+	for i, b := range SizeBuckets {
+		if size <= b {
+			return i
+		}
+	}
+	return len(SizeBuckets)
+}
+
+// advance moves the clock and, about once per second of capture time,
+// finishes idle flows
+func (a *Analyzer) advance(ts time.Time) {
+	if !a.clock.Advance(ts) {
+		return
+	}
+	for k, f := range a.flows {
+		if a.clock.Now().Sub(f.Last) >= a.cfg.IdleTimeout {
+			delete(a.flows, k)
+			a.finish(f, false)
+		}
+	}
+}
+
+// finish counts a flow that is over, or still open at the end of the
+// capture when atEnd is set
+func (a *Analyzer) finish(f *Flow, atEnd bool) {
+	st := &a.stats
+	st.Flows++
+	d := a.destination(f.Server)
+	if d != nil {
+		d.Flows++
+	}
+	switch {
+	case multicast(f.Server.Addr()):
+		st.Multicast++
+	case !f.OneWay():
+	case atEnd && a.clock.Now().Sub(f.Start) < a.cfg.ResponseTimeout:
+		st.Pending++
+	default:
+		st.OneWay++
+		if d != nil {
+			d.OneWay++
+			d.Packets += f.Sent.Packets
+		}
+	}
+	a.top = append(a.top, f)
+	if len(a.top) >= 2*a.cfg.TopFlows+64 {
+		a.trimTop()
+	}
+}
+
+// multicast reports whether addr expects no unicast answer
+func multicast(addr netip.Addr) bool {
+	return addr.IsMulticast() || addr == netip.AddrFrom4([4]byte{255, 255, 255, 255})
+}
+
+// maxDestinations bounds the servers remembered for the one-way summary
+const maxDestinations = 10000
+
+func (a *Analyzer) destination(server netip.AddrPort) *Destination {
+	d := a.destinations[server]
+	if d == nil && len(a.destinations) < maxDestinations {
+		d = &Destination{Server: server}
+		a.destinations[server] = d
+	}
+	return d
+}
+
+// trimTop keeps the flows with the most traffic
+func (a *Analyzer) trimTop() {
+	sort.Slice(a.top, func(i, j int) bool {
+		x, y := a.top[i], a.top[j]
+		if vx, vy := x.Sent.Bytes+x.Received.Bytes, y.Sent.Bytes+y.Received.Bytes; vx != vy {
+			return vx > vy
+		}
+		return x.Start.Before(y.Start)
+	})
+	if len(a.top) > a.cfg.TopFlows {
+		a.top = a.top[:a.cfg.TopFlows]
+	}
+}
+
+// Finish counts the flows still open
+func (a *Analyzer) Finish() {
+	for k, f := range a.flows {
+		delete(a.flows, k)
+		a.finish(f, true)
+		if f.OneWay() && !multicast(f.Server.Addr()) {
+			a.open = append(a.open, k)
+		}
+	}
+	a.trimTop()
+}
+
+// Correlate matches the UDP entries of the conntrack table, read right
+// after the capture, with the flows seen. Only valid after Finish.
+func (a *Analyzer) Correlate(entries []conntrack.Entry) {
+	byKey := make(map[flowKey]*conntrack.Entry)
+	for i := range entries {
+		e := &entries[i]
+		if e.Proto != "udp" {
+			continue
+		}
+		src, ok1 := addrPort(e.SrcIP, e.SrcPort)
+		dst, ok2 := addrPort(e.DstIP, e.DstPort)
+		if !ok1 || !ok2 {
+			continue
+		}
+		a.stats.ConntrackFlows++
+		if e.Unreplied {
+			a.stats.ConntrackUnreplied++
+			if d := a.destinations[dst]; d != nil {
+				d.ConntrackUnreplied++
+			}
+		}
+		byKey[keyOf(src, dst)] = e
+	}
+	for _, f := range a.top {
+		if e := byKey[keyOf(f.Client, f.Server)]; e != nil {
+			f.Conntrack = ConntrackReplied
+			if e.Unreplied {
+				f.Conntrack = ConntrackUnreplied
+			}
+		}
+	}
+	// flows that ended during the capture are gone from conntrack too
+	for _, k := range a.open {
+		if e := byKey[k]; e != nil && e.Unreplied {
+			a.stats.Confirmed++
+		}
+	}
+}
+
+func addrPort(ip, port string) (netip.AddrPort, bool) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return netip.AddrPort{}, false
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return netip.AddrPort{}, false
+	}
+	return netip.AddrPortFrom(addr, uint16(p)), true
+}
+
+// Stats returns the totals over all flows. Only valid after Finish.
+func (a *Analyzer) Stats() Stats {
+	return a.stats
+}
+
+// TopFlows returns the flows with the most traffic, largest first. Only
+// valid after Finish.
+func (a *Analyzer) TopFlows() []Flow {
+	out := make([]Flow, 0, len(a.top))
+	for _, f := range a.top {
+		out = append(out, *f)
+	}
+	return out
+}
+
+// Destinations returns the servers with one-way flows, most first
+func (a *Analyzer) Destinations() []*Destination {
+	var out []*Destination
+	for _, d := range a.destinations {
+		if d.OneWay > 0 {
+			out = append(out, d)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].OneWay != out[j].OneWay {
+			return out[i].OneWay > out[j].OneWay
+		}
+		return out[i].Server.String() < out[j].Server.String()
+	})
+	if len(out) > a.cfg.TopDestinations {
+		out = out[:a.cfg.TopDestinations]
+	}
+	return out
+}
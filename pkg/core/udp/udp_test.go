@@ -0,0 +1,125 @@
+package udp
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+
+	"network-app/pkg/core/conntrack"
+	"network-app/pkg/core/internal/packettest"
+)
+
+// datagram builds a UDP datagram from src to dst with size bytes of payload
+func datagram(t *testing.T, src, dst string, at time.Duration, size int) gopacket.Packet {
+	t.Helper()
+	return packettest.UDP(t, netip.MustParseAddrPort(src), netip.MustParseAddrPort(dst), at, make([]byte, size))
+}
+
+func TestFlows(t *testing.T) {
+	s := time.Second
+	a := NewAnalyzer(DefaultConfig)
+	for _, p := range []gopacket.Packet{
+		// DNS query and answer
+		datagram(t, "192.168.1.10:40000", "192.168.1.1:53", 0, 40),
+		datagram(t, "192.168.1.1:53", "192.168.1.10:40000", 10*time.Millisecond, 200),
+		// the capture starts with a response: the well-known port is the server
+		datagram(t, "10.0.0.53:123", "192.168.1.10:50000", s, 48),
+		datagram(t, "192.168.1.10:50000", "10.0.0.53:123", 2*s, 48),
+		// blocked: three tries, never answered, over by the end of the capture
+		datagram(t, "192.168.1.10:40001", "198.51.100.7:51820", 3*s, 148),
+		datagram(t, "192.168.1.10:40001", "198.51.100.7:51820", 8*s, 148),
+		datagram(t, "192.168.1.10:40001", "198.51.100.7:51820", 13*s, 148),
+		// discovery to a multicast group expects no answer
+		datagram(t, "192.168.1.10:5353", "224.0.0.251:5353", 4*s, 80),
+		// a large datagram, answered
+		datagram(t, "[2001:db8::10]:41000", "[2001:db8:1::1]:443", 5*s, 1350),
+		datagram(t, "[2001:db8:1::1]:443", "[2001:db8::10]:41000", 5*s+20*time.Millisecond, 1500),
+		// sent just before the end: too early to call
+		datagram(t, "192.168.1.10:40002", "198.51.100.7:51820", 58*s, 148),
+		// an idle flow ends 30 seconds after its last datagram
+		datagram(t, "192.168.1.10:40003", "203.0.113.9:9999", 10*s, 10),
+		datagram(t, "192.168.1.10:40004", "203.0.113.9:9999", 60*s, 10),
+	} {
+		a.Process(p)
+	}
+	a.Finish()
+
+	st := a.Stats()
+	if st.Flows != 8 || st.OneWay != 2 || st.Pending != 2 || st.Multicast != 1 || st.Evicted != 0 {
+		t.Errorf("flows = %+v", st)
+	}
+	if st.Sent.Packets != 10 || st.Received.Packets != 3 || st.Received.MaxSize != 1500 || st.Received.Bytes != 1748 {
+		t.Errorf("counters sent %+v received %+v", st.Sent, st.Received)
+	}
+	if st.Sizes[0] != 5 || st.Sizes[1] != 1 || st.Sizes[2] != 5 || st.Sizes[5] != 1 || st.Sizes[6] != 1 {
+		t.Errorf("sizes = %v", st.Sizes)
+	}
+	if r := st.OneWayRate(); r != 40 {
+		t.Errorf("one-way rate = %v", r)
+	}
+
+	top := a.TopFlows()
+	if len(top) != 8 || top[0].Server.String() != "[2001:db8:1::1]:443" || top[0].OneWay() {
+		t.Fatalf("top flows = %+v", top)
+	}
+	ntp := findFlow(t, top, "10.0.0.53:123")
+	if ntp.Client.String() != "192.168.1.10:50000" || ntp.Sent.Packets != 1 || ntp.Received.Packets != 1 {
+		t.Errorf("NTP flow = %+v", ntp)
+	}
+
+	dests := a.Destinations()
+	if len(dests) != 2 {
+		t.Fatalf("destinations = %+v", dests)
+	}
+	if d := dests[0]; d.Server.String() != "198.51.100.7:51820" || d.Flows != 2 || d.OneWay != 1 || d.Packets != 3 {
+		t.Errorf("destination = %+v", d)
+	}
+}
+
+func TestCorrelate(t *testing.T) {
+	s := time.Second
+	a := NewAnalyzer(DefaultConfig)
+	for _, p := range []gopacket.Packet{
+		datagram(t, "192.168.1.10:40001", "198.51.100.7:51820", 0, 148),
+		datagram(t, "192.168.1.10:40001", "198.51.100.7:51820", 6*s, 148),
+		datagram(t, "192.168.1.10:40000", "192.168.1.1:53", 7*s, 40),
+		datagram(t, "192.168.1.1:53", "192.168.1.10:40000", 7*s, 200),
+	} {
+		a.Process(p)
+	}
+	a.Finish()
+	a.Correlate([]conntrack.Entry{
+		{Proto: "udp", SrcIP: "192.168.1.10", SrcPort: "40001", DstIP: "198.51.100.7", DstPort: "51820", Unreplied: true},
+		{Proto: "udp", SrcIP: "192.168.1.10", SrcPort: "40000", DstIP: "192.168.1.1", DstPort: "53"},
+		{Proto: "udp", SrcIP: "192.168.1.10", SrcPort: "40009", DstIP: "198.51.100.7", DstPort: "51820", Unreplied: true},
+		{Proto: "tcp", SrcIP: "192.168.1.10", SrcPort: "40001", DstIP: "198.51.100.7", DstPort: "51820", Unreplied: true},
+	})
+
+	st := a.Stats()
+	if st.OneWay != 1 || st.ConntrackFlows != 3 || st.ConntrackUnreplied != 2 || st.Confirmed != 1 {
+		t.Errorf("stats = %+v", st)
+	}
+	top := a.TopFlows()
+	if f := findFlow(t, top, "198.51.100.7:51820"); f.Conntrack != ConntrackUnreplied {
+		t.Errorf("blocked flow = %+v", f)
+	}
+	if f := findFlow(t, top, "192.168.1.1:53"); f.Conntrack != ConntrackReplied {
+		t.Errorf("DNS flow = %+v", f)
+	}
+	if d := a.Destinations(); len(d) != 1 || d[0].ConntrackUnreplied != 2 {
+		t.Errorf("destinations = %+v", d)
+	}
+}
+
+func findFlow(t *testing.T, flows []Flow, server string) Flow {
+	t.Helper()
+	for _, f := range flows {
+		if f.Server.String() == server {
+			return f
+		}
+	}
+	t.Fatalf("no flow to %s in %+v", server, flows)
+	return Flow{}
+}